@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/MoulieshN/Go-JWT-Project.git/server"
+	"github.com/namsral/flag"
+)
+
+const adminsUsage = `usage: admins <command> -email EMAIL
+
+commands:
+  grant     give the user the ADMIN role
+  revoke    take the ADMIN role away`
+
+// runAdminsCommand assigns the ADMIN role, which signing up never gives. It
+// is how the first admin is made, later ones can be assigned through the API
+// as well. The user's tokens change on their next login or refresh.
+func runAdminsCommand(args []string) error {
+	if len(args) == 0 {
+		return errors.New(adminsUsage)
+	}
+
+	fs := flag.NewFlagSet("admins "+args[0], flag.ContinueOnError)
+	email := fs.String("email", "", "Email address of the user")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New(adminsUsage)
+	}
+
+	db, err := server.OpenDatabase(config.GetConfig())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	stores := repository.NewStores(db)
+	user, err := stores.Users.GetUserByEmail(*email)
+	if err != nil {
+		return fmt.Errorf("user %s: %w", *email, err)
+	}
+
+	var done string
+	switch args[0] {
+	case "grant":
+		err, done = stores.Roles.AssignRole(user.UserId, repository.RoleAdmin), "is now an admin"
+	case "revoke":
+		err, done = stores.Roles.UnassignRole(user.UserId, repository.RoleAdmin), "is no longer an admin"
+	default:
+		return errors.New(adminsUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Println(*email, done)
+	return nil
+}
@@ -2,7 +2,10 @@ package config
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
+	"github.com/MoulieshN/Go-JWT-Project.git/ratelimit"
 	"github.com/spf13/viper"
 )
 
@@ -21,8 +24,172 @@ type MySQLConfig struct {
 	MaxIdleConnections int
 }
 
+type PostgresConfig struct {
+	Hostname string
+	DBName   string
+	Port     int
+	Username string
+	Password string
+	SSLMode  string
+}
+
+type SQLiteConfig struct {
+	// Path is the database file, or ":memory:" for a throwaway database
+	Path string
+}
+
+// PasswordConfig picks the algorithm new password hashes are made with.
+// Hashes made with other settings are upgraded when their user logs in.
+type PasswordConfig struct {
+	// Hasher is "argon2id" or "bcrypt"
+	Hasher     string
+	BcryptCost int
+	// Argon2Memory is in KiB
+	Argon2Memory      uint32
+	Argon2Iterations  uint32
+	Argon2Parallelism uint8
+}
+
+// PasswordPolicyConfig holds the rules new passwords have to follow, see
+// passwordpolicy.Policy.
+type PasswordPolicyConfig struct {
+	MinLength          int
+	RequireUpper       bool
+	RequireLower       bool
+	RequireDigit       bool
+	RequireSymbol      bool
+	RejectPersonalInfo bool
+	HistoryDepth       int
+	// BreachedFile lists SHA-1 hashes of breached passwords, empty skips
+	// the check
+	BreachedFile string
+	// BreachedDir holds one range file per hash prefix, read on demand. It
+	// takes precedence over BreachedFile
+	BreachedDir string
+}
+
+// LockoutConfig holds the thresholds for failed logins, see lockout.Policy.
+type LockoutConfig struct {
+	BackoffAfter    int
+	BaseDelay       time.Duration
+	LockoutAfter    int
+	LockoutDuration time.Duration
+}
+
+// RateLimitConfig holds the request rate limits. An empty limit lets
+// everything through.
+type RateLimitConfig struct {
+	// SignupIP limits signups per client IP
+	SignupIP ratelimit.Limit
+	// LoginIP and LoginEmail limit logins per client IP and per email. MFA
+	// codes count against LoginIP as well.
+	LoginIP    ratelimit.Limit
+	LoginEmail ratelimit.Limit
+	// NotifyIP and NotifyEmail limit the requests that send links by email
+	NotifyIP    ratelimit.Limit
+	NotifyEmail ratelimit.Limit
+	// TokenIP limits the other unauthenticated token endpoints per client IP
+	TokenIP ratelimit.Limit
+	// APIUser limits authenticated requests per user
+	APIUser ratelimit.Limit
+}
+
+type JWTConfig struct {
+	Algorithm      string
+	KeyID          string
+	Secret         string
+	PrivateKeyFile string
+
+	KeyringFile           string
+	KeyringReloadInterval time.Duration
+	KeyRetirementDelay    time.Duration
+
+	// Audience identifies this service in the aud claim. Tokens meant for
+	// other audiences are refused.
+	Audience string
+	// Issuer is the iss claim of ID tokens, and where OpenID Connect clients
+	// find the discovery document
+	Issuer string
+}
+
+// TLSConfig turns on HTTPS when CertFile and KeyFile are set. With a
+// ClientCAFile, clients may present certificates signed by it as well:
+// service accounts can authenticate with them, and tokens issued over them
+// are bound to them.
+type TLSConfig struct {
+	CertFile     string
+	KeyFile      string
+	ClientCAFile string
+}
+
+type NotifierConfig struct {
+	// Kind is "log", or "file" to append messages to File as JSON lines
+	Kind string
+	File string
+}
+
 type ApplicationConfig struct {
-	MySQL *MySQLConfig
+	// DBDriver picks the database, "mysql", "postgres" or "sqlite". Only
+	// the matching connection settings are used.
+	DBDriver string
+	MySQL    *MySQLConfig
+	Postgres *PostgresConfig
+	SQLite   *SQLiteConfig
+	JWT      *JWTConfig
+	// RevocationStore is either "database", shared by every replica, or
+	// "memory" for single instance setups. "mysql" is accepted for
+	// "database" as well.
+	RevocationStore string
+	// MigrateOnStart applies pending schema migrations when the server boots
+	MigrateOnStart bool
+	Notifier       *NotifierConfig
+	// PasswordResetTTL is how long a password reset token stays valid
+	PasswordResetTTL time.Duration
+	// EmailVerificationTTL is how long an email verification token stays valid
+	EmailVerificationTTL time.Duration
+	// InvitationTTL is how long users have to accept an invitation to an
+	// organization
+	InvitationTTL time.Duration
+	// UnverifiedLogin decides what happens when a user who hasn't verified
+	// their email logs in: "allow" them, "deny" the login, or "restrict"
+	// them to tokens without permissions
+	UnverifiedLogin string
+	// PublicURL is where clients reach the server, used for links in
+	// notifications
+	PublicURL string
+	// MFAIssuer names the service in authenticator apps
+	MFAIssuer string
+	// MFAPendingTTL is how long users have to enter their MFA code after
+	// the password
+	MFAPendingTTL time.Duration
+	// LoginAttemptStore is either "database" or "memory", like
+	// RevocationStore
+	LoginAttemptStore string
+	// AccountLockout applies to failed logins per email address, IPLockout
+	// per client IP
+	AccountLockout *LockoutConfig
+	IPLockout      *LockoutConfig
+	RateLimits     *RateLimitConfig
+	Password       *PasswordConfig
+	PasswordPolicy *PasswordPolicyConfig
+	// ClientTokenTTL is how long tokens from the client credentials grant
+	// stay valid
+	ClientTokenTTL time.Duration
+	// AuthorizationCodeTTL is how long codes from the authorization endpoint
+	// can be exchanged for tokens
+	AuthorizationCodeTTL time.Duration
+	// DeviceCodeTTL is how long users have to approve a device, and
+	// DevicePollInterval how often the device may ask whether they have
+	DeviceCodeTTL      time.Duration
+	DevicePollInterval time.Duration
+	// DPoPProofStore is either "database" or "memory", like
+	// RevocationStore
+	DPoPProofStore string
+	TLS            *TLSConfig
+	// TrustedProxies are the IPs and CIDRs of the proxies whose
+	// X-Forwarded-For is believed. With none, the client IP is the address
+	// the request came from.
+	TrustedProxies []string
 }
 
 func GetConfig() ApplicationConfig {
@@ -51,8 +218,90 @@ func InitConfig() error {
 
 func loadConfiguration() error {
 	viper.AutomaticEnv()
+	viper.SetDefault("DB_DRIVER", "mysql")
+	viper.SetDefault("POSTGRES_PORT", 5432)
+	viper.SetDefault("POSTGRES_SSL_MODE", "disable")
+	viper.SetDefault("SQLITE_PATH", "account.db")
+	viper.SetDefault("JWT_ALGORITHM", "HS256")
+	viper.SetDefault("JWT_KEYRING_RELOAD_INTERVAL", time.Minute)
+	// Must outlive the longest-lived token, the 168h refresh token
+	viper.SetDefault("JWT_KEY_RETIREMENT_DELAY", 169*time.Hour)
+	viper.SetDefault("REVOCATION_STORE", "database")
+	viper.SetDefault("MIGRATE_ON_START", true)
+	viper.SetDefault("NOTIFIER", "log")
+	viper.SetDefault("PASSWORD_RESET_TTL", time.Hour)
+	viper.SetDefault("EMAIL_VERIFICATION_TTL", 24*time.Hour)
+	viper.SetDefault("INVITATION_TTL", 7*24*time.Hour)
+	viper.SetDefault("UNVERIFIED_LOGIN", "allow")
+	viper.SetDefault("PUBLIC_URL", "http://localhost:3000")
+	viper.SetDefault("MFA_ISSUER", "Go-JWT-Project")
+	viper.SetDefault("MFA_PENDING_TTL", 5*time.Minute)
+	viper.SetDefault("LOGIN_ATTEMPT_STORE", "database")
+	viper.SetDefault("LOCKOUT_BACKOFF_AFTER", 3)
+	viper.SetDefault("LOCKOUT_BASE_DELAY", time.Second)
+	viper.SetDefault("LOCKOUT_AFTER", 10)
+	viper.SetDefault("LOCKOUT_DURATION", 15*time.Minute)
+	// Many users may share an IP behind NAT, so it gets more leeway
+	viper.SetDefault("LOCKOUT_IP_BACKOFF_AFTER", 20)
+	viper.SetDefault("LOCKOUT_IP_AFTER", 100)
+	// OWASP's recommended argon2id parameters
+	viper.SetDefault("PASSWORD_HASHER", "argon2id")
+	viper.SetDefault("BCRYPT_COST", 14)
+	viper.SetDefault("ARGON2_MEMORY", 19*1024)
+	viper.SetDefault("ARGON2_ITERATIONS", 2)
+	viper.SetDefault("ARGON2_PARALLELISM", 1)
+	viper.SetDefault("PASSWORD_MIN_LENGTH", 8)
+	viper.SetDefault("PASSWORD_REJECT_PERSONAL_INFO", true)
+	viper.SetDefault("PASSWORD_HISTORY_DEPTH", 5)
+	viper.SetDefault("RATE_LIMIT_SIGNUP_IP", "10/1h")
+	viper.SetDefault("RATE_LIMIT_LOGIN_IP", "30/1m")
+	viper.SetDefault("RATE_LIMIT_LOGIN_EMAIL", "10/1m")
+	viper.SetDefault("RATE_LIMIT_NOTIFY_IP", "20/1h")
+	viper.SetDefault("RATE_LIMIT_NOTIFY_EMAIL", "5/1h")
+	viper.SetDefault("RATE_LIMIT_TOKEN_IP", "60/1m")
+	viper.SetDefault("RATE_LIMIT_API_USER", "600/1m")
+	viper.SetDefault("CLIENT_TOKEN_TTL", time.Hour)
+	viper.SetDefault("AUTHORIZATION_CODE_TTL", time.Minute)
+	viper.SetDefault("DEVICE_CODE_TTL", 10*time.Minute)
+	viper.SetDefault("DEVICE_POLL_INTERVAL", 5*time.Second)
+	viper.SetDefault("DPOP_PROOF_STORE", "database")
+
+	rateLimits := &RateLimitConfig{}
+	for key, limit := range map[string]*ratelimit.Limit{
+		"RATE_LIMIT_SIGNUP_IP":    &rateLimits.SignupIP,
+		"RATE_LIMIT_LOGIN_IP":     &rateLimits.LoginIP,
+		"RATE_LIMIT_LOGIN_EMAIL":  &rateLimits.LoginEmail,
+		"RATE_LIMIT_NOTIFY_IP":    &rateLimits.NotifyIP,
+		"RATE_LIMIT_NOTIFY_EMAIL": &rateLimits.NotifyEmail,
+		"RATE_LIMIT_TOKEN_IP":     &rateLimits.TokenIP,
+		"RATE_LIMIT_API_USER":     &rateLimits.APIUser,
+	} {
+		parsed, err := ratelimit.ParseLimit(viper.GetString(key))
+		if err != nil {
+			return fmt.Errorf("%s: %w", key, err)
+		}
+		*limit = parsed
+	}
+
+	var trustedProxies []string
+	for _, proxy := range strings.Split(viper.GetString("TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy != "" {
+			trustedProxies = append(trustedProxies, proxy)
+		}
+	}
+
+	publicURL := strings.TrimSuffix(viper.GetString("PUBLIC_URL"), "/")
+	audience := viper.GetString("JWT_AUDIENCE")
+	if audience == "" {
+		audience = publicURL
+	}
+	issuer := strings.TrimSuffix(viper.GetString("JWT_ISSUER"), "/")
+	if issuer == "" {
+		issuer = publicURL
+	}
 
 	config := &ApplicationConfig{
+		DBDriver: viper.GetString("DB_DRIVER"),
 		MySQL: &MySQLConfig{
 			Hostname:  viper.GetString("MYSQL_HOSTNAME"),
 			DBName:    viper.GetString("MYSQL_DBNAME"),
@@ -61,6 +310,86 @@ func loadConfiguration() error {
 			Password:  viper.GetString("MYSQL_PASSWORD"),
 			ParseTime: viper.GetBool("MYSQL_PARSE_TIME"),
 		},
+		Postgres: &PostgresConfig{
+			Hostname: viper.GetString("POSTGRES_HOSTNAME"),
+			DBName:   viper.GetString("POSTGRES_DBNAME"),
+			Port:     viper.GetInt("POSTGRES_PORT"),
+			Username: viper.GetString("POSTGRES_USERNAME"),
+			Password: viper.GetString("POSTGRES_PASSWORD"),
+			SSLMode:  viper.GetString("POSTGRES_SSL_MODE"),
+		},
+		SQLite: &SQLiteConfig{
+			Path: viper.GetString("SQLITE_PATH"),
+		},
+		JWT: &JWTConfig{
+			Algorithm:      viper.GetString("JWT_ALGORITHM"),
+			KeyID:          viper.GetString("JWT_KEY_ID"),
+			Secret:         viper.GetString("SECRET_KEY"),
+			PrivateKeyFile: viper.GetString("JWT_PRIVATE_KEY_FILE"),
+
+			KeyringFile:           viper.GetString("JWT_KEYRING_FILE"),
+			KeyringReloadInterval: viper.GetDuration("JWT_KEYRING_RELOAD_INTERVAL"),
+			KeyRetirementDelay:    viper.GetDuration("JWT_KEY_RETIREMENT_DELAY"),
+
+			Audience: audience,
+			Issuer:   issuer,
+		},
+		RevocationStore: viper.GetString("REVOCATION_STORE"),
+		MigrateOnStart:  viper.GetBool("MIGRATE_ON_START"),
+		Notifier: &NotifierConfig{
+			Kind: viper.GetString("NOTIFIER"),
+			File: viper.GetString("NOTIFIER_FILE"),
+		},
+		PasswordResetTTL:     viper.GetDuration("PASSWORD_RESET_TTL"),
+		EmailVerificationTTL: viper.GetDuration("EMAIL_VERIFICATION_TTL"),
+		InvitationTTL:        viper.GetDuration("INVITATION_TTL"),
+		UnverifiedLogin:      viper.GetString("UNVERIFIED_LOGIN"),
+		PublicURL:            publicURL,
+		MFAIssuer:            viper.GetString("MFA_ISSUER"),
+		MFAPendingTTL:        viper.GetDuration("MFA_PENDING_TTL"),
+		LoginAttemptStore:    viper.GetString("LOGIN_ATTEMPT_STORE"),
+		AccountLockout: &LockoutConfig{
+			BackoffAfter:    viper.GetInt("LOCKOUT_BACKOFF_AFTER"),
+			BaseDelay:       viper.GetDuration("LOCKOUT_BASE_DELAY"),
+			LockoutAfter:    viper.GetInt("LOCKOUT_AFTER"),
+			LockoutDuration: viper.GetDuration("LOCKOUT_DURATION"),
+		},
+		IPLockout: &LockoutConfig{
+			BackoffAfter:    viper.GetInt("LOCKOUT_IP_BACKOFF_AFTER"),
+			BaseDelay:       viper.GetDuration("LOCKOUT_BASE_DELAY"),
+			LockoutAfter:    viper.GetInt("LOCKOUT_IP_AFTER"),
+			LockoutDuration: viper.GetDuration("LOCKOUT_DURATION"),
+		},
+		RateLimits: rateLimits,
+		Password: &PasswordConfig{
+			Hasher:            viper.GetString("PASSWORD_HASHER"),
+			BcryptCost:        viper.GetInt("BCRYPT_COST"),
+			Argon2Memory:      viper.GetUint32("ARGON2_MEMORY"),
+			Argon2Iterations:  viper.GetUint32("ARGON2_ITERATIONS"),
+			Argon2Parallelism: uint8(viper.GetUint("ARGON2_PARALLELISM")),
+		},
+		PasswordPolicy: &PasswordPolicyConfig{
+			MinLength:          viper.GetInt("PASSWORD_MIN_LENGTH"),
+			RequireUpper:       viper.GetBool("PASSWORD_REQUIRE_UPPER"),
+			RequireLower:       viper.GetBool("PASSWORD_REQUIRE_LOWER"),
+			RequireDigit:       viper.GetBool("PASSWORD_REQUIRE_DIGIT"),
+			RequireSymbol:      viper.GetBool("PASSWORD_REQUIRE_SYMBOL"),
+			RejectPersonalInfo: viper.GetBool("PASSWORD_REJECT_PERSONAL_INFO"),
+			HistoryDepth:       viper.GetInt("PASSWORD_HISTORY_DEPTH"),
+			BreachedFile:       viper.GetString("PASSWORD_BREACHED_FILE"),
+			BreachedDir:        viper.GetString("PASSWORD_BREACHED_DIR"),
+		},
+		ClientTokenTTL:       viper.GetDuration("CLIENT_TOKEN_TTL"),
+		AuthorizationCodeTTL: viper.GetDuration("AUTHORIZATION_CODE_TTL"),
+		DeviceCodeTTL:        viper.GetDuration("DEVICE_CODE_TTL"),
+		DevicePollInterval:   viper.GetDuration("DEVICE_POLL_INTERVAL"),
+		DPoPProofStore:       viper.GetString("DPOP_PROOF_STORE"),
+		TLS: &TLSConfig{
+			CertFile:     viper.GetString("TLS_CERT_FILE"),
+			KeyFile:      viper.GetString("TLS_KEY_FILE"),
+			ClientCAFile: viper.GetString("TLS_CLIENT_CA_FILE"),
+		},
+		TrustedProxies: trustedProxies,
 	}
 
 	Config = config
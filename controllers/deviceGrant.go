@@ -0,0 +1,260 @@
+package controllers
+
+import (
+	"database/sql"
+	_ "embed"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// deviceCodeGrantType is the grant_type devices poll Token with (RFC 8628).
+const deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+
+//go:embed templates/device.html
+var devicePageSource string
+
+var deviceTemplate = template.Must(template.New("device").Parse(devicePageSource))
+
+// DeviceAuthorization is the device authorization endpoint (RFC 8628), for
+// OAuth clients on devices that can't show the authorization endpoint's
+// page. The device shows the user code, the user approves it on the
+// verification page elsewhere, and meanwhile the device polls Token with the
+// device code.
+func (o OAuthController) DeviceAuthorization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+
+		client, ok := o.authenticateOAuthClient(c)
+		if !ok {
+			return
+		}
+
+		deviceCode, deviceCodeHash, err := helpers.NewSecretToken()
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		// User codes are short enough to be taken now and then, another one
+		// is bound to be free
+		var userCode string
+		for attempt := 0; attempt < 3; attempt++ {
+			userCode, err = helpers.NewUserCode()
+			if err != nil {
+				break
+			}
+			err = o.devices.CreateDeviceCode(models.DeviceCode{
+				ClientId:  client.ClientId,
+				UserCode:  userCode,
+				Scopes:    requestedScopes(c.PostForm("scope")),
+				ExpiresAt: time.Now().Add(o.settings.DeviceCodeTTL),
+			}, deviceCodeHash)
+			if !errors.Is(err, repository.ErrDuplicateUserCode) {
+				break
+			}
+		}
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"device_code":               deviceCode,
+			"user_code":                 helpers.FormatUserCode(userCode),
+			"verification_uri":          o.settings.VerificationURI,
+			"verification_uri_complete": o.settings.VerificationURI + "?" + url.Values{"user_code": {helpers.FormatUserCode(userCode)}}.Encode(),
+			"expires_in":                int(o.settings.DeviceCodeTTL.Seconds()),
+			"interval":                  int(o.settings.DevicePollInterval.Seconds()),
+		})
+	}
+}
+
+// deviceCodeGrant answers a device polling for the tokens the user approved.
+// Until then it is told authorization_pending, or slow_down when it polls
+// more often than DevicePollInterval.
+func (o OAuthController) deviceCodeGrant(c *gin.Context, cnf *helpers.Confirmation) {
+	client, ok := o.authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	deviceCode := c.PostForm("device_code")
+	if deviceCode == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "device_code is required")
+		return
+	}
+
+	now := time.Now()
+	deviceCodeHash := helpers.HashSecretToken(deviceCode)
+	device, err := o.devices.PollDeviceCode(deviceCodeHash, now)
+	if errors.Is(err, sql.ErrNoRows) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "the device code is invalid or already used")
+		return
+	}
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	if device.ClientId != client.ClientId {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "the device code was issued to another client")
+		return
+	}
+	if !now.Before(device.ExpiresAt) {
+		oauthError(c, http.StatusBadRequest, "expired_token", "the device code has expired")
+		return
+	}
+
+	switch device.Status {
+	case models.DeviceCodeDenied:
+		oauthError(c, http.StatusBadRequest, "access_denied", "the user denied access")
+		return
+	case models.DeviceCodePending:
+		if device.LastPolledAt != nil && now.Sub(*device.LastPolledAt) < o.settings.DevicePollInterval {
+			oauthError(c, http.StatusBadRequest, "slow_down", "the device polls too often")
+			return
+		}
+		oauthError(c, http.StatusBadRequest, "authorization_pending", "the user hasn't approved the device yet")
+		return
+	}
+
+	err = o.devices.ConsumeDeviceCode(deviceCodeHash)
+	if errors.Is(err, sql.ErrNoRows) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "the device code is invalid or already used")
+		return
+	}
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	o.issueUserTokens(c, client, device.UserId, device.Scopes, "", device.AuthTime, cnf)
+}
+
+// devicePage is what the device verification page shows. ClientName is only
+// known once the user code is.
+type devicePage struct {
+	UserCode   string
+	ClientName string
+	Reveals    string
+	Email      string
+	Error      string
+	// Done is what to tell users once they have decided
+	Done string
+}
+
+func renderDevicePage(c *gin.Context, status int, page devicePage) {
+	renderLoginPage(c, status, deviceTemplate, page)
+}
+
+// pendingDevice looks up the request with userCode and the client that made
+// it. Unknown, expired and decided ones give sql.ErrNoRows.
+func (o OAuthController) pendingDevice(userCode string) (models.DeviceCode, models.OAuthClient, error) {
+	device, err := o.devices.GetDeviceCode(userCode)
+	if err != nil {
+		return models.DeviceCode{}, models.OAuthClient{}, err
+	}
+	client, err := o.clients.GetOAuthClient(device.ClientId)
+	if err != nil {
+		return models.DeviceCode{}, models.OAuthClient{}, err
+	}
+	return device, client, nil
+}
+
+// Device is the device verification page. Devices send users to it with the
+// user code filled in, or users type it in themselves. It posts to
+// DeviceLogin.
+func (o OAuthController) Device() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userCode := helpers.NormalizeUserCode(c.Query("user_code"))
+		page := devicePage{UserCode: helpers.FormatUserCode(userCode)}
+		if userCode == "" {
+			renderDevicePage(c, http.StatusOK, page)
+			return
+		}
+
+		device, client, err := o.pendingDevice(userCode)
+		if errors.Is(err, sql.ErrNoRows) {
+			page.Error = "The code is invalid or has expired, check the one your device shows."
+			renderDevicePage(c, http.StatusNotFound, page)
+			return
+		}
+		if err != nil {
+			log.Printf("Error %s when getting device code", err)
+			page.Error = "Something went wrong, try again later."
+			renderDevicePage(c, http.StatusInternalServerError, page)
+			return
+		}
+
+		page.ClientName, page.Reveals = client.Name, revealedBy(device.Scopes)
+		renderDevicePage(c, http.StatusOK, page)
+	}
+}
+
+// DeviceLogin takes the device verification page. Users sign in, with their
+// MFA code if they have MFA, to allow or deny the device, so only the
+// account holder can decide for it. Once allowed, the device gets its tokens
+// on its next poll.
+func (o OAuthController) DeviceLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userCode := helpers.NormalizeUserCode(c.PostForm("user_code"))
+		page := devicePage{UserCode: helpers.FormatUserCode(userCode), Email: c.PostForm("email")}
+		retry := func(status int, message string) {
+			page.Error = message
+			renderDevicePage(c, status, page)
+		}
+
+		device, client, err := o.pendingDevice(userCode)
+		if errors.Is(err, sql.ErrNoRows) {
+			retry(http.StatusBadRequest, "The code is invalid or has expired, check the one your device shows.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error %s when getting device code", err)
+			retry(http.StatusInternalServerError, "Something went wrong, try again later.")
+			return
+		}
+		page.ClientName, page.Reveals = client.Name, revealedBy(device.Scopes)
+
+		authTime := time.Now()
+		user, status, message := o.signIn(c, page.Email)
+		if status != 0 {
+			retry(status, message)
+			return
+		}
+
+		if c.PostForm("decision") != "allow" {
+			if err := o.devices.DenyDeviceCode(userCode); err != nil && !errors.Is(err, sql.ErrNoRows) {
+				log.Printf("Error %s when denying device code", err)
+				retry(http.StatusInternalServerError, "Something went wrong, try again later.")
+				return
+			}
+			page.Done = "You denied " + client.Name + " access. You can close this page."
+			renderDevicePage(c, http.StatusOK, page)
+			return
+		}
+
+		err = o.devices.ApproveDeviceCode(userCode, user.UserId, authTime)
+		if errors.Is(err, sql.ErrNoRows) {
+			retry(http.StatusBadRequest, "The code has expired, start over on your device.")
+			return
+		}
+		if err != nil {
+			log.Printf("Error %s when approving device code", err)
+			retry(http.StatusInternalServerError, "Something went wrong, try again later.")
+			return
+		}
+
+		page.Done = "You're signed in to " + client.Name + ". Go back to your device to continue."
+		renderDevicePage(c, http.StatusOK, page)
+	}
+}
@@ -0,0 +1,109 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type verificationTest struct {
+	stores       repository.Stores
+	verification EmailVerificationController
+	users        *UserController
+	sent         *sentMessages
+	userId       string
+}
+
+func newVerificationTest(t *testing.T, tokenTTL time.Duration, unverifiedLogin string) verificationTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if err := helpers.InitSigning(&config.JWTConfig{Algorithm: "HS256", Secret: "test-secret"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stores := newTestStores(t)
+	sent := &sentMessages{}
+	verification := NewEmailVerificationController(stores.Users, stores.Verifications, sent, tokenTTL, "https://auth.example.com")
+	users := &UserController{userRepo: stores.Users, roles: stores.Roles, orgs: stores.Organizations, revocations: stores.Revocations, families: stores.RefreshTokens, mfa: stores.MFA, emailVerification: verification, guard: newTestGuard(), settings: LoginSettings{UnverifiedLogin: unverifiedLogin}}
+	userId := createTestUser(t, stores.Users, "ann@example.com", bcryptHash(t, "correct horse"))
+
+	user, err := stores.Users.GetUser(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verification.sendVerification(user); err != nil {
+		t.Fatal(err)
+	}
+	return verificationTest{stores: stores, verification: verification, users: users, sent: sent, userId: userId}
+}
+
+func (v verificationTest) verify(token string) int {
+	return serveAs(v.verification.VerifyEmail(), nil, "GET", "/verify-email", "/verify-email?token="+token, nil).Code
+}
+
+func (v verificationTest) login() int {
+	return serveAs(v.users.Login(), nil, "POST", "/login", "/login", loginRequest{Email: "ann@example.com", Password: "correct horse"}).Code
+}
+
+func TestVerificationTokenIsSingleUse(t *testing.T) {
+	v := newVerificationTest(t, time.Hour, UnverifiedLoginDeny)
+	token := v.sent.lastToken(t)
+
+	if code := v.login(); code != http.StatusForbidden {
+		t.Errorf("login before verifying returned %d, want %d", code, http.StatusForbidden)
+	}
+	if code := v.verify(token); code != http.StatusOK {
+		t.Fatalf("VerifyEmail returned %d, want %d", code, http.StatusOK)
+	}
+	if user, _ := v.stores.Users.GetUser(v.userId); user.EmailVerifiedAt == nil {
+		t.Error("the email address isn't marked verified")
+	}
+	if code := v.login(); code != http.StatusOK {
+		t.Errorf("login after verifying returned %d, want %d", code, http.StatusOK)
+	}
+
+	if code := v.verify(token); code != http.StatusBadRequest {
+		t.Errorf("reusing the token returned %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestExpiredVerificationToken(t *testing.T) {
+	v := newVerificationTest(t, -time.Minute, UnverifiedLoginDeny)
+	if code := v.verify(v.sent.lastToken(t)); code != http.StatusBadRequest {
+		t.Errorf("an expired token returned %d, want %d", code, http.StatusBadRequest)
+	}
+	if user, _ := v.stores.Users.GetUser(v.userId); user.EmailVerifiedAt != nil {
+		t.Error("an expired token verified the email address")
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	v := newVerificationTest(t, time.Hour, UnverifiedLoginAllow)
+	resend := func(email string) {
+		t.Helper()
+		w := serveAs(v.verification.ResendVerification(), nil, "POST", "/resend-verification", "/resend-verification", resendVerificationRequest{Email: email})
+		if w.Code != http.StatusOK {
+			t.Fatalf("ResendVerification(%s) returned %d", email, w.Code)
+		}
+	}
+
+	resend("ann@example.com")
+	if len(v.sent.messages) != 2 {
+		t.Fatalf("sent %d messages, want 2", len(v.sent.messages))
+	}
+	if code := v.verify(v.sent.lastToken(t)); code != http.StatusOK {
+		t.Fatalf("VerifyEmail of the resent token returned %d", code)
+	}
+
+	// Verified and unknown addresses answer the same, without a message
+	resend("ann@example.com")
+	resend("bob@example.com")
+	if len(v.sent.messages) != 2 {
+		t.Errorf("sent %d messages, want 2", len(v.sent.messages))
+	}
+}
@@ -0,0 +1,16 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// JWKS publishes the public keys our tokens can be verified with.
+func JWKS() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, helpers.JWKS())
+	}
+}
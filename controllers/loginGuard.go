@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/lockout"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// LoginGuard slows down password guessing. It counts failed logins per
+// account and per client IP, and holds off further attempts the way their
+// lockout policies say. Accounts are keyed by the email that was tried,
+// whether or not a user has it, so held off logins look the same for every
+// email.
+type LoginGuard struct {
+	attempts repository.LoginAttemptStore
+	account  lockout.Policy
+	ip       lockout.Policy
+}
+
+func NewLoginGuard(attempts repository.LoginAttemptStore, account lockout.Policy, ip lockout.Policy) LoginGuard {
+	return LoginGuard{attempts: attempts, account: account, ip: ip}
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(email)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// retryAfter returns how long to wait before the next attempt for the email
+// from the client IP, zero if it may be made right away.
+func (g LoginGuard) retryAfter(email string, ip string) (time.Duration, error) {
+	now := time.Now()
+	var wait time.Duration
+	for _, check := range []struct {
+		key    string
+		policy lockout.Policy
+	}{{accountKey(email), g.account}, {ipKey(ip), g.ip}} {
+		failures, err := g.attempts.GetLoginFailures(check.key)
+		if err != nil {
+			return 0, err
+		}
+		if d := check.policy.BlockedUntil(failures.Count, failures.LastFailureAt).Sub(now); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// recordFailure counts a failed attempt against the email and the client IP.
+func (g LoginGuard) recordFailure(email string, ip string) error {
+	now := time.Now()
+	if _, err := g.attempts.RecordLoginFailure(accountKey(email), now, now.Add(-g.account.LockoutDuration)); err != nil {
+		return err
+	}
+	_, err := g.attempts.RecordLoginFailure(ipKey(ip), now, now.Add(-g.ip.LockoutDuration))
+	return err
+}
+
+// unlock forgets the failed attempts against the email. The client IP keeps
+// its count, a successful login must not wipe out guesses at other accounts.
+func (g LoginGuard) unlock(email string) error {
+	return g.attempts.ClearLoginFailures(accountKey(email))
+}
+
+// loggedIn unlocks the account of a user who got through every step of
+// logging in, the MFA code too if they have MFA. A right password alone
+// doesn't, or guessed passwords would reset the count codes are guessed
+// against. Failures are only logged.
+func (g LoginGuard) loggedIn(user models.User) {
+	if err := g.unlock(*user.Email); err != nil {
+		log.Printf("Error %s when clearing failed logins of user %s", err, user.UserId)
+	}
+}
+
+// checkMFACode verifies a TOTP or recovery code of the user, under the same
+// limits as passwords. Attempts the guard holds off get how long to wait,
+// without checking the code at all. Wrong codes count as failed logins.
+func (g LoginGuard) checkMFACode(store repository.MFAStore, user models.User, mfa models.MFA, code string, ip string) (bool, time.Duration, error) {
+	wait, err := g.retryAfter(*user.Email, ip)
+	if err != nil || wait > 0 {
+		return false, wait, err
+	}
+
+	ok, err := verifyMFACode(store, user.UserId, mfa, code)
+	if err != nil || ok {
+		return ok, 0, err
+	}
+	if err := g.recordFailure(*user.Email, ip); err != nil {
+		log.Printf("Error %s when recording failed MFA code", err)
+	}
+	return false, 0, nil
+}
+
+// tooManyAttempts answers attempts the guard holds off.
+func tooManyAttempts(c *gin.Context, wait time.Duration) {
+	setRetryAfter(c, wait)
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": "too many failed login attempts, try again later"})
+}
+
+// setRetryAfter tells the client how long to wait, in whole seconds.
+func setRetryAfter(c *gin.Context, wait time.Duration) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+}
@@ -0,0 +1,145 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/lockout"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/MoulieshN/Go-JWT-Project.git/totp"
+)
+
+func newTestGuard() LoginGuard {
+	return NewLoginGuard(repository.NewMemoryLoginAttemptStore(),
+		lockout.Policy{BackoffAfter: 3, BaseDelay: time.Minute, LockoutAfter: 5, LockoutDuration: time.Hour},
+		lockout.Policy{BackoffAfter: 10, BaseDelay: time.Minute, LockoutAfter: 20, LockoutDuration: time.Hour})
+}
+
+func failTimes(t *testing.T, g LoginGuard, email string, ip string, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := g.recordFailure(email, ip); err != nil {
+			t.Fatalf("recordFailure: %v", err)
+		}
+	}
+}
+
+func assertWait(t *testing.T, g LoginGuard, email string, ip string, min time.Duration, max time.Duration) {
+	t.Helper()
+	wait, err := g.retryAfter(email, ip)
+	if err != nil {
+		t.Fatalf("retryAfter: %v", err)
+	}
+	if wait < min || wait > max {
+		t.Errorf("retryAfter(%s, %s) = %v, want between %v and %v", email, ip, wait, min, max)
+	}
+}
+
+func TestLoginGuardBackoffAndLockout(t *testing.T) {
+	g := newTestGuard()
+	const ip = "192.0.2.1"
+
+	failTimes(t, g, "ann@example.com", ip, 2)
+	assertWait(t, g, "ann@example.com", ip, 0, 0)
+
+	failTimes(t, g, "ann@example.com", ip, 1)
+	assertWait(t, g, "ann@example.com", ip, 59*time.Second, time.Minute)
+
+	failTimes(t, g, "ann@example.com", ip, 1)
+	assertWait(t, g, "ann@example.com", ip, 119*time.Second, 2*time.Minute)
+
+	failTimes(t, g, "ann@example.com", ip, 1)
+	assertWait(t, g, "ann@example.com", ip, 59*time.Minute, time.Hour)
+
+	// Accounts are keyed by email in any case, other accounts aren't held off
+	assertWait(t, g, "ANN@example.com", "198.51.100.1", 59*time.Minute, time.Hour)
+	assertWait(t, g, "bob@example.com", "198.51.100.1", 0, 0)
+}
+
+func TestLoginGuardIPLimits(t *testing.T) {
+	g := newTestGuard()
+	const ip = "192.0.2.1"
+
+	// Guesses spread over many emails still add up for the client IP
+	for i := 0; i < 10; i++ {
+		failTimes(t, g, string(rune('a'+i))+"@example.com", ip, 1)
+	}
+	assertWait(t, g, "new@example.com", ip, 59*time.Second, time.Minute)
+	assertWait(t, g, "new@example.com", "198.51.100.1", 0, 0)
+}
+
+func TestLoginGuardUnlock(t *testing.T) {
+	g := newTestGuard()
+	const ip = "192.0.2.1"
+
+	failTimes(t, g, "ann@example.com", ip, 5)
+	failTimes(t, g, "bob@example.com", ip, 5)
+	email := "ann@example.com"
+	g.loggedIn(models.User{UserId: "u1", Email: &email})
+
+	assertWait(t, g, "ann@example.com", "198.51.100.1", 0, 0)
+	assertWait(t, g, "bob@example.com", "198.51.100.1", 59*time.Minute, time.Hour)
+
+	// The IP keeps its count, ten failures hold it off
+	assertWait(t, g, "ann@example.com", ip, 59*time.Second, time.Minute)
+}
+
+// stubMFAStore takes every TOTP step and no recovery codes.
+type stubMFAStore struct {
+	repository.MFAStore
+	checked int
+}
+
+func (s *stubMFAStore) UseTOTPStep(userId string, step int64) (bool, error) {
+	s.checked++
+	return true, nil
+}
+
+func (s *stubMFAStore) UseRecoveryCode(userId string, codeHash []byte) (bool, error) {
+	s.checked++
+	return false, nil
+}
+
+func TestLoginGuardCheckMFACode(t *testing.T) {
+	g := newTestGuard()
+	const ip = "192.0.2.1"
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	email := "ann@example.com"
+	user := models.User{UserId: "u1", Email: &email}
+	mfa := models.MFA{Secret: secret}
+	store := &stubMFAStore{}
+
+	// Wrong codes count like wrong passwords
+	for i := 0; i < 3; i++ {
+		ok, wait, err := g.checkMFACode(store, user, mfa, "AAAA-BBBB-CCCC-DDDD", ip)
+		if ok || wait != 0 || err != nil {
+			t.Fatalf("wrong code %d = (%v, %v, %v), want a plain failure", i, ok, wait, err)
+		}
+	}
+	assertWait(t, g, "ann@example.com", "198.51.100.1", 59*time.Second, time.Minute)
+
+	// Held off attempts aren't checked at all, not even right codes
+	code, err := totp.Code(secret, totp.Step(time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checked := store.checked
+	ok, wait, err := g.checkMFACode(store, user, mfa, code, ip)
+	if ok || wait <= 0 || err != nil {
+		t.Errorf("held off code = (%v, %v, %v), want a wait", ok, wait, err)
+	}
+	if store.checked != checked {
+		t.Error("held off code was checked against the store")
+	}
+
+	// Once the account is unlocked right codes go through
+	g.loggedIn(user)
+	ok, wait, err = g.checkMFACode(store, user, mfa, code, ip)
+	if !ok || wait != 0 || err != nil {
+		t.Errorf("right code = (%v, %v, %v), want ok", ok, wait, err)
+	}
+}
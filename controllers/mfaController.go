@@ -0,0 +1,233 @@
+package controllers
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/MoulieshN/Go-JWT-Project.git/totp"
+	"github.com/gin-gonic/gin"
+)
+
+const recoveryCodeCount = 10
+
+var totpCode = regexp.MustCompile(`^\d{6}$`)
+
+type MFAController struct {
+	userRepo repository.UserRepository
+	mfa      repository.MFAStore
+	guard    LoginGuard
+	issuer   string
+}
+
+func NewMFAController(repo repository.UserRepository, mfa repository.MFAStore, guard LoginGuard, issuer string) MFAController {
+	return MFAController{userRepo: repo, mfa: mfa, guard: guard, issuer: issuer}
+}
+
+// Enroll starts a TOTP enrollment for the caller. It has to be confirmed with
+// a code before it protects logins.
+func (m MFAController) Enroll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		user, err := m.userRepo.GetUser(caller.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		// Re-enrolling would switch off the second factor without proving
+		// possession of it, that is what Disable is for
+		existing, err := m.mfa.GetMFA(user.UserId)
+		if err == nil && existing.ConfirmedAt != nil {
+			c.JSON(http.StatusConflict, gin.H{"error": "MFA is already enabled"})
+			return
+		}
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		secret, err := totp.GenerateSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := m.mfa.EnrollMFA(user.UserId, secret); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{
+			"secret":      secret,
+			"otpauth_uri": totp.URI(m.issuer, *user.Email, secret),
+		}})
+	}
+}
+
+type mfaCodeRequest struct {
+	Code string `json:"code" validate:"required"`
+}
+
+// Confirm switches MFA on once the caller proves their authenticator works,
+// and returns the recovery codes. They are shown only this once.
+func (m MFAController) Confirm() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req mfaCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		caller, _ := helpers.GetClaims(c)
+		user, err := m.userRepo.GetUser(caller.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		pending, err := m.mfa.GetMFA(caller.Uid)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && pending.ConfirmedAt != nil) {
+			c.JSON(http.StatusConflict, gin.H{"error": "there is no pending MFA enrollment"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Only TOTP codes here, there are no recovery codes yet
+		if !totpCode.MatchString(strings.TrimSpace(req.Code)) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid MFA code"})
+			return
+		}
+		ok, wait, err := m.guard.checkMFACode(m.mfa, user, pending, req.Code, c.ClientIP())
+		if wait > 0 {
+			tooManyAttempts(c, wait)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid MFA code"})
+			return
+		}
+
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := m.mfa.ConfirmMFA(caller.Uid, hashes); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"recovery_codes": codes}})
+	}
+}
+
+// Disable switches MFA off. It takes a current TOTP or recovery code, so a
+// stolen access token alone can't remove the second factor, and wrong codes
+// count against the same limits as at login.
+func (m MFAController) Disable() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req mfaCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		caller, _ := helpers.GetClaims(c)
+		user, err := m.userRepo.GetUser(caller.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+		enrolled, err := m.mfa.GetMFA(caller.Uid)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && enrolled.ConfirmedAt == nil) {
+			c.JSON(http.StatusConflict, gin.H{"error": "MFA is not enabled"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		ok, wait, err := m.guard.checkMFACode(m.mfa, user, enrolled, req.Code, c.ClientIP())
+		if wait > 0 {
+			tooManyAttempts(c, wait)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid MFA code"})
+			return
+		}
+
+		if err := m.mfa.DeleteMFA(caller.Uid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": "MFA disabled"})
+	}
+}
+
+// verifyMFACode checks a TOTP code, or failing that a recovery code, and
+// uses it up.
+func verifyMFACode(store repository.MFAStore, userId string, mfa models.MFA, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if totpCode.MatchString(code) {
+		step, ok := totp.Validate(mfa.Secret, code, time.Now())
+		if !ok {
+			return false, nil
+		}
+		return store.UseTOTPStep(userId, step)
+	}
+	return store.UseRecoveryCode(userId, helpers.HashSecretToken(normalizeRecoveryCode(code)))
+}
+
+// newRecoveryCodes returns fresh recovery codes, formatted like
+// ABCD-EFGH-IJKL-MNOP, and the hashes to store for them.
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0, recoveryCodeCount)
+	hashes := make([][]byte, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		raw := make([]byte, 10)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, nil, err
+		}
+
+		code := base32.StdEncoding.EncodeToString(raw)
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:16])
+		hashes = append(hashes, helpers.HashSecretToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode accepts codes typed in lower case or without dashes.
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
@@ -0,0 +1,147 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type OAuthClientController struct {
+	clients repository.OAuthClientStore
+}
+
+func NewOAuthClientController(clients repository.OAuthClientStore) OAuthClientController {
+	return OAuthClientController{clients: clients}
+}
+
+// createdOAuthClient is the only response that ever holds the client secret.
+type createdOAuthClient struct {
+	models.OAuthClient
+	ClientSecret string `json:"client_secret,omitempty"`
+}
+
+// CreateClient registers an app that signs users in through the
+// authorization endpoint or the device authorization grant. Confidential
+// clients get a secret, public ones don't.
+func (o OAuthClientController) CreateClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var client models.OAuthClient
+		if err := c.ShouldBindJSON(&client); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(client); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if client.RedirectURIs == nil {
+			client.RedirectURIs = []string{}
+		}
+		for _, uri := range client.RedirectURIs {
+			if err := checkRedirectURI(uri); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		var secret string
+		var err error
+		client.SecretHash = nil
+		if !client.Public {
+			secret, client.SecretHash, err = helpers.NewSecretToken()
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		client.CreatedAt = time.Now().UTC().Truncate(time.Second)
+		client.ClientId, err = o.clients.CreateOAuthClient(client)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"data": createdOAuthClient{OAuthClient: client, ClientSecret: secret}})
+	}
+}
+
+// checkRedirectURI accepts https URIs, http ones on the loopback interface
+// and private-use schemes like com.example.app:/callback, the ones web and
+// native apps use (RFC 8252).
+func checkRedirectURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil || strings.ContainsAny(uri, " \t\r\n") {
+		return fmt.Errorf("redirect URI %s is not a valid URI", uri)
+	}
+	if parsed.Fragment != "" || strings.Contains(uri, "#") {
+		return fmt.Errorf("redirect URI %s must not have a fragment", uri)
+	}
+
+	switch parsed.Scheme {
+	case "https":
+		if parsed.Host == "" {
+			return fmt.Errorf("redirect URI %s has no host", uri)
+		}
+	case "http":
+		if !isLoopback(parsed.Hostname()) {
+			return fmt.Errorf("redirect URI %s must use https, plain http is only for loopback addresses", uri)
+		}
+	default:
+		// Reverse domain names keep private-use schemes apart from the
+		// likes of javascript: and data:
+		if !strings.Contains(parsed.Scheme, ".") {
+			return fmt.Errorf("redirect URI %s must use https or a private-use scheme like com.example.app", uri)
+		}
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// GetClients lists the registered clients, without their secrets.
+func (o OAuthClientController) GetClients() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clients, err := o.clients.GetOAuthClients()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": clients})
+	}
+}
+
+// DeleteClient removes a client. Codes it hasn't exchanged yet go with it,
+// tokens it already got stay valid until they expire or are revoked.
+func (o OAuthClientController) DeleteClient() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		err := o.clients.DeleteOAuthClient(c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "client not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": c.Param("id")})
+	}
+}
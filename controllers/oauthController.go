@@ -0,0 +1,780 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"crypto/x509"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+// OAuthSettings tune the OAuth 2.0 endpoints.
+type OAuthSettings struct {
+	// ClientTokenTTL is how long client credentials tokens last
+	ClientTokenTTL time.Duration
+	// AuthorizationCodeTTL is how long codes can be exchanged for tokens
+	AuthorizationCodeTTL time.Duration
+	// DeviceCodeTTL is how long users have to approve a device, which
+	// polls for its tokens at most every DevicePollInterval
+	DeviceCodeTTL      time.Duration
+	DevicePollInterval time.Duration
+	// VerificationURI is the address of the device verification page
+	VerificationURI string
+}
+
+// OAuthController implements the OAuth 2.0 endpoints. They take form posts
+// and answer errors the way RFC 6749 asks, not like the rest of the API.
+// Users sign in at the authorization endpoint and on the device verification
+// page through users, with the same checks as Login.
+type OAuthController struct {
+	serviceAccounts repository.ServiceAccountStore
+	clients         repository.OAuthClientStore
+	codes           repository.AuthorizationCodeStore
+	devices         repository.DeviceCodeStore
+	personalTokens  PersonalAccessTokenController
+	users           *UserController
+	settings        OAuthSettings
+}
+
+func NewOAuthController(serviceAccounts repository.ServiceAccountStore, clients repository.OAuthClientStore, codes repository.AuthorizationCodeStore, devices repository.DeviceCodeStore, personalTokens PersonalAccessTokenController, users *UserController, settings OAuthSettings) OAuthController {
+	return OAuthController{serviceAccounts: serviceAccounts, clients: clients, codes: codes, devices: devices, personalTokens: personalTokens, users: users, settings: settings}
+}
+
+//go:embed templates/authorize.html
+var authorizePageSource string
+
+var authorizeTemplate = template.Must(template.New("authorize").Parse(authorizePageSource))
+
+// PKCE (RFC 7636) code verifiers, and the S256 challenges made from them
+var (
+	codeVerifier  = regexp.MustCompile(`^[A-Za-z0-9._~-]{43,128}$`)
+	codeChallenge = regexp.MustCompile(`^[A-Za-z0-9_-]{43}$`)
+)
+
+// oauthError answers with an RFC 6749 error response.
+func oauthError(c *gin.Context, status int, code string, description string) {
+	c.JSON(status, gin.H{"error": code, "error_description": description})
+}
+
+// clientCredentials reads the client's ID and secret from HTTP Basic
+// authentication, or else from the form. basic tells which it was.
+func clientCredentials(c *gin.Context) (clientId string, secret string, basic bool) {
+	if id, pass, ok := c.Request.BasicAuth(); ok {
+		// RFC 6749 has clients form-encode both before Basic encoding them
+		clientId, idErr := url.QueryUnescape(id)
+		secret, secretErr := url.QueryUnescape(pass)
+		if idErr != nil || secretErr != nil {
+			return "", "", true
+		}
+		return clientId, secret, true
+	}
+	return c.PostForm("client_id"), c.PostForm("client_secret"), false
+}
+
+// clientAuthFailed answers a request whose client credentials are wrong.
+func clientAuthFailed(c *gin.Context, basic bool) {
+	if basic {
+		c.Header("WWW-Authenticate", `Basic realm="oauth"`)
+	}
+	oauthError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+}
+
+// authenticateServiceAccount checks the client credentials of the request.
+// When they are wrong it answers with invalid_client and reports false.
+// Without a secret, the client certificate of the request may stand in
+// for them (RFC 8705).
+func (o OAuthController) authenticateServiceAccount(c *gin.Context) (models.ServiceAccount, bool) {
+	clientId, secret, basic := clientCredentials(c)
+	if secret == "" {
+		if cert := helpers.ClientCertificate(c.Request); cert != nil {
+			return o.authenticateServiceAccountCertificate(c, cert, clientId)
+		}
+	}
+	if validate.Var(clientId, "required,uuid") != nil || secret == "" {
+		clientAuthFailed(c, basic)
+		return models.ServiceAccount{}, false
+	}
+
+	account, err := o.serviceAccounts.GetServiceAccount(clientId)
+	if errors.Is(err, sql.ErrNoRows) {
+		clientAuthFailed(c, basic)
+		return models.ServiceAccount{}, false
+	}
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return models.ServiceAccount{}, false
+	}
+	if subtle.ConstantTimeCompare(helpers.HashSecretToken(secret), account.SecretHash) != 1 {
+		clientAuthFailed(c, basic)
+		return models.ServiceAccount{}, false
+	}
+	return account, true
+}
+
+// authenticateServiceAccountCertificate finds the service account with an
+// identity of cert. Identities are only unique within an organization, so
+// clientId picks the account when given. Without it, a certificate whose
+// identities belong to more than one account authenticates none of them.
+func (o OAuthController) authenticateServiceAccountCertificate(c *gin.Context, cert *x509.Certificate, clientId string) (models.ServiceAccount, bool) {
+	var account models.ServiceAccount
+	for _, identity := range helpers.CertificateIdentities(cert) {
+		found, err := o.serviceAccounts.GetServiceAccountsByCertificate(identity)
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return models.ServiceAccount{}, false
+		}
+		for _, candidate := range found {
+			if clientId != "" && candidate.ClientId != clientId {
+				continue
+			}
+			if account.ClientId != "" && account.ClientId != candidate.ClientId {
+				clientAuthFailed(c, false)
+				return models.ServiceAccount{}, false
+			}
+			account = candidate
+		}
+	}
+
+	if account.ClientId == "" {
+		clientAuthFailed(c, false)
+		return models.ServiceAccount{}, false
+	}
+	return account, true
+}
+
+// authenticateOAuthClient checks the client of the request like
+// authenticateServiceAccount. Public clients only send their client ID.
+func (o OAuthController) authenticateOAuthClient(c *gin.Context) (models.OAuthClient, bool) {
+	clientId, secret, basic := clientCredentials(c)
+	if validate.Var(clientId, "required,uuid") != nil {
+		clientAuthFailed(c, basic)
+		return models.OAuthClient{}, false
+	}
+
+	client, err := o.clients.GetOAuthClient(clientId)
+	if errors.Is(err, sql.ErrNoRows) {
+		clientAuthFailed(c, basic)
+		return models.OAuthClient{}, false
+	}
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return models.OAuthClient{}, false
+	}
+	if client.Public {
+		if secret != "" {
+			clientAuthFailed(c, basic)
+			return models.OAuthClient{}, false
+		}
+		return client, true
+	}
+	if secret == "" || subtle.ConstantTimeCompare(helpers.HashSecretToken(secret), client.SecretHash) != 1 {
+		clientAuthFailed(c, basic)
+		return models.OAuthClient{}, false
+	}
+	return client, true
+}
+
+// authenticateClient checks the credentials of a service account or of an
+// OAuth client, whichever the client ID belongs to, like
+// authenticateServiceAccount, and returns the client ID.
+func (o OAuthController) authenticateClient(c *gin.Context) (string, bool) {
+	clientId, _, _ := clientCredentials(c)
+	if validate.Var(clientId, "required,uuid") == nil {
+		_, err := o.serviceAccounts.GetServiceAccount(clientId)
+		if err == nil {
+			account, ok := o.authenticateServiceAccount(c)
+			return account.ClientId, ok
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return "", false
+		}
+	}
+	client, ok := o.authenticateOAuthClient(c)
+	return client.ClientId, ok
+}
+
+// Token is the token endpoint. Service accounts use the client credentials
+// grant, OAuth clients exchange codes from Authorize or poll with device
+// codes from DeviceAuthorization. Clients that send a DPoP proof get tokens
+// bound to its key, and clients with a certificate tokens bound to it,
+// whatever the grant.
+func (o OAuthController) Token() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Responses carry tokens, so nothing on the way may keep them
+		c.Header("Cache-Control", "no-store")
+		c.Header("Pragma", "no-cache")
+
+		cnf, msg := o.users.confirmation(c)
+		if msg != "" {
+			oauthError(c, http.StatusBadRequest, "invalid_dpop_proof", msg)
+			return
+		}
+
+		switch grantType := c.PostForm("grant_type"); grantType {
+		case "client_credentials":
+			o.clientCredentialsGrant(c, cnf)
+		case "authorization_code":
+			o.authorizationCodeGrant(c, cnf)
+		case deviceCodeGrantType:
+			o.deviceCodeGrant(c, cnf)
+		case "":
+			oauthError(c, http.StatusBadRequest, "invalid_request", "grant_type is required")
+		default:
+			oauthError(c, http.StatusBadRequest, "unsupported_grant_type", "grant_type "+grantType+" is not supported")
+		}
+	}
+}
+
+// tokenType is the token_type of tokens bound by cnf (RFC 9449).
+// Certificate-bound tokens are still sent as Bearer tokens.
+func tokenType(cnf *helpers.Confirmation) string {
+	if cnf != nil && cnf.JKT != "" {
+		return "DPoP"
+	}
+	return "Bearer"
+}
+
+// clientCredentialsGrant issues a token with the scopes the client asks for,
+// or with all of its scopes when it asks for none.
+func (o OAuthController) clientCredentialsGrant(c *gin.Context, cnf *helpers.Confirmation) {
+	account, ok := o.authenticateServiceAccount(c)
+	if !ok {
+		return
+	}
+
+	scopes := account.Scopes
+	if requested := strings.Fields(c.PostForm("scope")); len(requested) > 0 {
+		allowed := map[string]bool{}
+		for _, scope := range account.Scopes {
+			allowed[scope] = true
+		}
+		scopes = []string{}
+		granted := map[string]bool{}
+		for _, scope := range requested {
+			if !allowed[scope] {
+				oauthError(c, http.StatusBadRequest, "invalid_scope", "scope "+scope+" is not granted to the client")
+				return
+			}
+			if !granted[scope] {
+				granted[scope] = true
+				scopes = append(scopes, scope)
+			}
+		}
+	}
+
+	token, err := helpers.GenerateClientToken(helpers.ClientSubject{
+		ClientId:     account.ClientId,
+		OrgId:        account.OrgId,
+		Scopes:       scopes,
+		Audience:     account.Audience,
+		Confirmation: cnf,
+	}, o.settings.ClientTokenTTL)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"access_token": token,
+		"token_type":   tokenType(cnf),
+		"expires_in":   int(o.settings.ClientTokenTTL.Seconds()),
+		"scope":        strings.Join(scopes, " "),
+	})
+}
+
+// authorizationCodeGrant exchanges a code from Authorize, and the PKCE code
+// verifier it was requested with, for the same tokens Login issues. Codes
+// granted the openid scope come with an ID token too.
+func (o OAuthController) authorizationCodeGrant(c *gin.Context, cnf *helpers.Confirmation) {
+	client, ok := o.authenticateOAuthClient(c)
+	if !ok {
+		return
+	}
+
+	code, redirectURI := c.PostForm("code"), c.PostForm("redirect_uri")
+	if code == "" || redirectURI == "" {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "code and redirect_uri are required")
+		return
+	}
+	verifier := c.PostForm("code_verifier")
+	if !codeVerifier.MatchString(verifier) {
+		oauthError(c, http.StatusBadRequest, "invalid_request", "code_verifier is missing or malformed")
+		return
+	}
+
+	// The code is used up even when the checks below fail, so a stolen one
+	// can't be tried again
+	stored, err := o.codes.ConsumeAuthorizationCode(helpers.HashSecretToken(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "the code is invalid, expired or already used")
+		return
+	}
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	if stored.ClientId != client.ClientId {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "the code was issued to another client")
+		return
+	}
+	if stored.RedirectURI != redirectURI {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "redirect_uri doesn't match the authorization request")
+		return
+	}
+	if !helpers.VerifyCodeChallenge(verifier, stored.CodeChallenge) {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "code_verifier doesn't match the code challenge")
+		return
+	}
+
+	o.issueUserTokens(c, client, stored.UserId, stored.Scopes, stored.Nonce, stored.AuthTime, cnf)
+}
+
+// issueUserTokens answers a grant the user gave client with the same tokens
+// Login issues, and an ID token when they granted the openid scope.
+func (o OAuthController) issueUserTokens(c *gin.Context, client models.OAuthClient, userId string, scopes []string, nonce string, authTime time.Time, cnf *helpers.Confirmation) {
+	user, err := o.users.userRepo.GetUser(userId)
+	if err != nil {
+		oauthError(c, http.StatusBadRequest, "invalid_grant", "user not found")
+		return
+	}
+	scope := strings.Join(scopes, " ")
+	// A session like Login's, so the refresh token rotates the usual way
+	token, refreshToken, err := o.users.startSession(user, "", client.ClientId, scope, cnf)
+	if err != nil {
+		oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+
+	response := gin.H{
+		"access_token":  token,
+		"token_type":    tokenType(cnf),
+		"expires_in":    int(helpers.AccessTokenTTL.Seconds()),
+		"refresh_token": refreshToken,
+	}
+	if scope != "" {
+		response["scope"] = scope
+	}
+	if hasScope(scopes, scopeOpenID) {
+		response["id_token"], err = helpers.GenerateIDToken(helpers.IDTokenSubject{
+			UserId:     user.UserId,
+			ClientId:   client.ClientId,
+			Nonce:      nonce,
+			AuthTime:   authTime,
+			UserClaims: userClaims(user, scopes),
+		})
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+	}
+	c.JSON(http.StatusOK, response)
+}
+
+// authorizationRequest is what clients send users to Authorize with. The
+// login form posts it back along with the user's answer.
+type authorizationRequest struct {
+	ResponseType        string `form:"response_type"`
+	ClientId            string `form:"client_id"`
+	RedirectURI         string `form:"redirect_uri"`
+	State               string `form:"state"`
+	CodeChallenge       string `form:"code_challenge"`
+	CodeChallengeMethod string `form:"code_challenge_method"`
+	// Scope and Nonce are for OpenID Connect clients
+	Scope string `form:"scope"`
+	Nonce string `form:"nonce"`
+}
+
+// authorizePage is what the login and consent page shows. Fatal pages only
+// show the error, there is no client to go back to.
+type authorizePage struct {
+	ClientName string
+	Request    authorizationRequest
+	// Reveals says what the client gets to know about the user
+	Reveals string
+	Email   string
+	Error   string
+	Fatal   bool
+}
+
+// revealedBy describes what the user shares by granting scopes.
+func revealedBy(scopes []string) string {
+	var reveals []string
+	if hasScope(scopes, scopeProfile) {
+		reveals = append(reveals, "name")
+	}
+	if hasScope(scopes, scopeEmail) {
+		reveals = append(reveals, "email address")
+	}
+	return strings.Join(reveals, " and ")
+}
+
+// renderLoginPage renders one of the OAuth pages users sign in on.
+func renderLoginPage(c *gin.Context, status int, tmpl *template.Template, data interface{}) {
+	// The pages take passwords, so they must not be framed or kept around
+	c.Header("Cache-Control", "no-store")
+	c.Header("X-Frame-Options", "DENY")
+	c.Header("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors 'none'")
+	c.Render(status, render.HTML{Template: tmpl, Data: data})
+}
+
+func renderAuthorizePage(c *gin.Context, status int, page authorizePage) {
+	renderLoginPage(c, status, authorizeTemplate, page)
+}
+
+// registeredRedirectURI reports whether uri is one of the client's redirect
+// URIs. Loopback ones match on any port, since native apps listen on
+// whichever port is free (RFC 8252).
+func registeredRedirectURI(client models.OAuthClient, uri string) bool {
+	requested, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	for _, registered := range client.RedirectURIs {
+		if registered == uri {
+			return true
+		}
+		parsed, err := url.Parse(registered)
+		if err == nil && parsed.Scheme == "http" && requested.Scheme == "http" && isLoopback(parsed.Hostname()) &&
+			parsed.Hostname() == requested.Hostname() && parsed.EscapedPath() == requested.EscapedPath() &&
+			parsed.RawQuery == requested.RawQuery && requested.Fragment == "" && requested.User == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// redirectToClient sends the user back to the client with params added to
+// the redirect URI.
+func redirectToClient(c *gin.Context, req authorizationRequest, params url.Values) {
+	target, _ := url.Parse(req.RedirectURI)
+	query := target.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	if req.State != "" {
+		query.Set("state", req.State)
+	}
+	target.RawQuery = query.Encode()
+
+	// 303 turns the login form's POST into a GET
+	status := http.StatusFound
+	if c.Request.Method == http.MethodPost {
+		status = http.StatusSeeOther
+	}
+	c.Redirect(status, target.String())
+}
+
+// authorizationError sends an RFC 6749 error back to the client.
+func authorizationError(c *gin.Context, req authorizationRequest, code string, description string) {
+	redirectToClient(c, req, url.Values{"error": {code}, "error_description": {description}})
+}
+
+// readAuthorizationRequest reads and checks the request to Authorize. A bad
+// client or redirect URI is only shown to the user, sending them on could
+// hand codes to anyone. Other problems go back to the client. It reports
+// false once it has answered.
+func (o OAuthController) readAuthorizationRequest(c *gin.Context) (authorizationRequest, models.OAuthClient, bool) {
+	var req authorizationRequest
+	if err := c.ShouldBind(&req); err != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, authorizePage{Error: err.Error(), Fatal: true})
+		return req, models.OAuthClient{}, false
+	}
+
+	if validate.Var(req.ClientId, "required,uuid") != nil {
+		renderAuthorizePage(c, http.StatusBadRequest, authorizePage{Error: "The app didn't say who it is.", Fatal: true})
+		return req, models.OAuthClient{}, false
+	}
+	client, err := o.clients.GetOAuthClient(req.ClientId)
+	if errors.Is(err, sql.ErrNoRows) {
+		renderAuthorizePage(c, http.StatusBadRequest, authorizePage{Error: "The app isn't registered.", Fatal: true})
+		return req, models.OAuthClient{}, false
+	}
+	if err != nil {
+		log.Printf("Error %s when getting oauth client %s", err, req.ClientId)
+		renderAuthorizePage(c, http.StatusInternalServerError, authorizePage{Error: "Something went wrong, try again later.", Fatal: true})
+		return req, models.OAuthClient{}, false
+	}
+	if !registeredRedirectURI(client, req.RedirectURI) {
+		renderAuthorizePage(c, http.StatusBadRequest, authorizePage{Error: "The app asked to go back to an address it hasn't registered.", Fatal: true})
+		return req, models.OAuthClient{}, false
+	}
+
+	if req.ResponseType != "code" {
+		authorizationError(c, req, "unsupported_response_type", "response_type must be code")
+		return req, models.OAuthClient{}, false
+	}
+	if req.CodeChallengeMethod != "S256" || !codeChallenge.MatchString(req.CodeChallenge) {
+		authorizationError(c, req, "invalid_request", "PKCE is required, with a code_challenge and code_challenge_method S256")
+		return req, models.OAuthClient{}, false
+	}
+	if len(req.Nonce) > 255 {
+		authorizationError(c, req, "invalid_request", "nonce must be at most 255 characters")
+		return req, models.OAuthClient{}, false
+	}
+	return req, client, true
+}
+
+// Authorize is the authorization endpoint. It shows the login and consent
+// page, which posts to AuthorizeLogin.
+func (o OAuthController) Authorize() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, client, ok := o.readAuthorizationRequest(c)
+		if !ok {
+			return
+		}
+		renderAuthorizePage(c, http.StatusOK, authorizePage{ClientName: client.Name, Request: req, Reveals: revealedBy(requestedScopes(req.Scope))})
+	}
+}
+
+// signIn checks the email, password and MFA code posted to one of the OAuth
+// pages, with the same checks as Login. When they fail it returns the status
+// and the message to show the user instead.
+func (o OAuthController) signIn(c *gin.Context, email string) (models.User, int, string) {
+	password := c.PostForm("password")
+	if validate.Var(email, "required,email") != nil || password == "" {
+		return models.User{}, http.StatusBadRequest, "Enter your email and password."
+	}
+
+	ip := c.ClientIP()
+	user, wait, err := o.users.checkPassword(email, password, ip)
+	if wait > 0 {
+		setRetryAfter(c, wait)
+		return models.User{}, http.StatusTooManyRequests, "Too many failed attempts, try again later."
+	}
+	if errors.Is(err, errBadCredentials) {
+		return models.User{}, http.StatusUnauthorized, "The email or password is incorrect."
+	}
+	if err != nil {
+		log.Printf("Error %s when checking the password on an OAuth page", err)
+		return models.User{}, http.StatusInternalServerError, "Something went wrong, try again later."
+	}
+
+	if user.EmailVerifiedAt == nil && o.users.settings.UnverifiedLogin == UnverifiedLoginDeny {
+		return models.User{}, http.StatusForbidden, "Verify your email address before signing in."
+	}
+
+	mfa, err := o.users.mfa.GetMFA(user.UserId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		log.Printf("Error %s when getting MFA of user %s", err, user.UserId)
+		return models.User{}, http.StatusInternalServerError, "Something went wrong, try again later."
+	}
+	if err == nil && mfa.ConfirmedAt != nil {
+		if strings.TrimSpace(c.PostForm("code")) == "" {
+			return models.User{}, http.StatusUnauthorized, "Enter the code from your authenticator app."
+		}
+		ok, wait, err := o.users.guard.checkMFACode(o.users.mfa, user, mfa, c.PostForm("code"), ip)
+		if wait > 0 {
+			setRetryAfter(c, wait)
+			return models.User{}, http.StatusTooManyRequests, "Too many failed attempts, try again later."
+		}
+		if err != nil {
+			log.Printf("Error %s when verifying MFA code of user %s", err, user.UserId)
+			return models.User{}, http.StatusInternalServerError, "Something went wrong, try again later."
+		}
+		if !ok {
+			return models.User{}, http.StatusUnauthorized, "The authentication code is incorrect."
+		}
+	}
+	o.users.guard.loggedIn(user)
+	return user, 0, ""
+}
+
+// AuthorizeLogin takes the login and consent page. Users who allow the
+// client and sign in, with their MFA code if they have MFA, are sent back to
+// it with a code.
+func (o OAuthController) AuthorizeLogin() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		req, client, ok := o.readAuthorizationRequest(c)
+		if !ok {
+			return
+		}
+		if c.PostForm("decision") != "allow" {
+			authorizationError(c, req, "access_denied", "the user denied access")
+			return
+		}
+
+		scopes := requestedScopes(req.Scope)
+		page := authorizePage{ClientName: client.Name, Request: req, Reveals: revealedBy(scopes), Email: c.PostForm("email")}
+		retry := func(status int, message string) {
+			page.Error = message
+			renderAuthorizePage(c, status, page)
+		}
+
+		authTime := time.Now()
+		user, status, message := o.signIn(c, page.Email)
+		if status != 0 {
+			retry(status, message)
+			return
+		}
+
+		code, codeHash, err := helpers.NewSecretToken()
+		if err == nil {
+			err = o.codes.CreateAuthorizationCode(models.AuthorizationCode{
+				ClientId:      client.ClientId,
+				UserId:        user.UserId,
+				RedirectURI:   req.RedirectURI,
+				CodeChallenge: req.CodeChallenge,
+				Scopes:        scopes,
+				Nonce:         req.Nonce,
+				AuthTime:      authTime,
+				ExpiresAt:     time.Now().Add(o.settings.AuthorizationCodeTTL),
+			}, codeHash)
+		}
+		if err != nil {
+			log.Printf("Error %s when creating authorization code", err)
+			authorizationError(c, req, "server_error", "the code could not be issued")
+			return
+		}
+
+		redirectToClient(c, req, url.Values{"code": {code}})
+	}
+}
+
+// activeClaims returns the claims of a token the API would accept right now,
+// be it an access, refresh or personal access token, or nil. Refresh tokens
+// also have to be the current one of their family, like RefreshToken wants
+// them.
+func (o OAuthController) activeClaims(token string) *helpers.SignedDetails {
+	var claims *helpers.SignedDetails
+	var msg string
+	if helpers.IsPersonalAccessToken(token) {
+		claims, msg = o.personalTokens.ResolvePersonalToken(token)
+	} else {
+		claims, msg = helpers.ValidateToken(token)
+		if msg != "" {
+			claims, msg = helpers.ValidateRefreshToken(token)
+		}
+	}
+	if msg == "" {
+		msg = helpers.CheckRevoked(o.users.revocations, claims)
+	}
+	if msg != "" {
+		return nil
+	}
+
+	if claims.TokenType == helpers.RefreshTokenType {
+		if claims.FamilyId == "" {
+			return nil
+		}
+		current, err := o.users.families.IsCurrentRefreshToken(claims.FamilyId, helpers.HashSecretToken(token))
+		if err != nil {
+			log.Printf("Error %s when checking refresh token family %s", err, claims.FamilyId)
+			return nil
+		}
+		if !current {
+			return nil
+		}
+	}
+	return claims
+}
+
+// Introspect is the introspection endpoint (RFC 7662), for resource servers
+// that can't verify tokens themselves. Only service accounts granted
+// tokens:introspect may ask, since the claims tell about users.
+func (o OAuthController) Introspect() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "no-store")
+
+		account, ok := o.authenticateServiceAccount(c)
+		if !ok {
+			return
+		}
+		if !hasScope(account.Scopes, repository.PermissionTokensIntrospect) {
+			oauthError(c, http.StatusForbidden, "unauthorized_client", "the client may not introspect tokens")
+			return
+		}
+
+		token := c.PostForm("token")
+		if token == "" {
+			oauthError(c, http.StatusBadRequest, "invalid_request", "token is required")
+			return
+		}
+
+		// Why a token isn't active is none of the caller's business
+		claims := o.activeClaims(token)
+		if claims == nil {
+			c.JSON(http.StatusOK, gin.H{"active": false})
+			return
+		}
+
+		raw, err := json.Marshal(claims)
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		response := map[string]interface{}{}
+		if err := json.Unmarshal(raw, &response); err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		response["active"] = true
+		if _, ok := response["sub"]; !ok {
+			response["sub"] = claims.Principal()
+		}
+		if claims.Email != "" {
+			response["username"] = claims.Email
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// Revoke is the revocation endpoint (RFC 7009) for access and refresh tokens.
+// Clients may only revoke the tokens issued to them. Revoking a refresh token
+// ends its whole family, the access tokens issued with it included.
+func (o OAuthController) Revoke() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clientId, ok := o.authenticateClient(c)
+		if !ok {
+			return
+		}
+
+		token := c.PostForm("token")
+		if token == "" {
+			oauthError(c, http.StatusBadRequest, "invalid_request", "token is required")
+			return
+		}
+		if helpers.IsPersonalAccessToken(token) {
+			oauthError(c, http.StatusBadRequest, "unsupported_token_type", "personal access tokens are revoked through the API")
+			return
+		}
+
+		// Invalid and expired tokens need no revoking, and get the same
+		// answer so nobody learns which tokens are good
+		claims := o.activeClaims(token)
+		if claims == nil {
+			c.Status(http.StatusOK)
+			return
+		}
+		if claims.IssuedTo() != clientId {
+			oauthError(c, http.StatusBadRequest, "unauthorized_client", "the token was not issued to the client")
+			return
+		}
+
+		var err error
+		if claims.TokenType == helpers.RefreshTokenType {
+			err = endTokenFamily(o.users.families, o.users.revocations, claims.FamilyId)
+		} else {
+			err = o.users.revocations.RevokeToken(claims.ID, claims.ExpiresAt.Time)
+		}
+		if err != nil {
+			oauthError(c, http.StatusInternalServerError, "server_error", err.Error())
+			return
+		}
+		c.Status(http.StatusOK)
+	}
+}
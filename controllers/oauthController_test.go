@@ -0,0 +1,793 @@
+package controllers
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/middleware"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// testCA issues client certificates at test time, so none have to be
+// checked in.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newTestCA(t *testing.T) testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test Client CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return testCA{cert: cert, key: key, pool: pool}
+}
+
+// issue makes a client certificate for subject and the DNS names.
+func (ca testCA) issue(t *testing.T, subject pkix.Name, dnsNames ...string) tls.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      subject,
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+// mtlsServer serves the token endpoint and an authenticated route over TLS,
+// asking clients for certificates of ca the way the server does.
+type mtlsServer struct {
+	*httptest.Server
+	repo *repository.MemoryRepository
+}
+
+func newMTLSServer(t *testing.T, ca testCA) mtlsServer {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if err := helpers.InitSigning(&config.JWTConfig{Algorithm: "HS256", Secret: "test-secret", Audience: "https://auth.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := repository.NewMemoryRepository()
+	revocations := repository.NewMemoryRevocationStore()
+	dpop := helpers.NewDPoPVerifier(repository.NewMemoryDPoPProofStore(), "https://auth.example.com")
+	users := &UserController{userRepo: repo, revocations: revocations, dpop: dpop}
+	oauth := NewOAuthController(repo, repo, repo, repo, PersonalAccessTokenController{}, users, OAuthSettings{ClientTokenTTL: time.Hour})
+
+	router := gin.New()
+	router.POST("/oauth/token", oauth.Token())
+	router.GET("/api/v1/whoami", middleware.Authenticate(revocations, dpop), func(c *gin.Context) {
+		claims, _ := helpers.GetClaims(c)
+		c.JSON(http.StatusOK, gin.H{"data": claims.ClientId})
+	})
+
+	server := httptest.NewUnstartedServer(router)
+	server.TLS = &tls.Config{ClientCAs: ca.pool, ClientAuth: tls.VerifyClientCertIfGiven}
+	// Failed handshakes are expected, not worth logging
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return mtlsServer{Server: server, repo: repo}
+}
+
+// client connects with cert, or without a certificate when it is nil.
+func (s mtlsServer) client(cert *tls.Certificate) *http.Client {
+	// A transport of its own, so no connection made with another
+	// certificate is reused
+	transport := s.Server.Client().Transport.(*http.Transport).Clone()
+	if cert != nil {
+		transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+	}
+	return &http.Client{Transport: transport}
+}
+
+func (s mtlsServer) createAccount(t *testing.T, identity string, secret string) string {
+	t.Helper()
+	return s.createOrgAccount(t, "", identity, secret)
+}
+
+// createOrgAccount creates an account of the organization.
+func (s mtlsServer) createOrgAccount(t *testing.T, orgId string, identity string, secret string) string {
+	t.Helper()
+	account := models.ServiceAccount{OrgId: orgId, Name: "test", Scopes: []string{"users:read"}, CertificateIdentity: identity}
+	if secret != "" {
+		account.SecretHash = helpers.HashSecretToken(secret)
+	}
+	clientId, err := s.repo.CreateServiceAccount(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientId
+}
+
+// createOrganization creates an organization with an owner of its own.
+func (s mtlsServer) createOrganization(t *testing.T, name string) string {
+	t.Helper()
+	ownerId := createTestUser(t, s.repo, strings.ToLower(name)+"@example.com", "x")
+	orgId, err := s.repo.CreateOrganization(models.Organization{Name: name}, ownerId, repository.RoleOrgAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return orgId
+}
+
+// requestToken asks for a client credentials token with form and returns
+// the status and the decoded answer.
+func (s mtlsServer) requestToken(t *testing.T, client *http.Client, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+	form.Set("grant_type", "client_credentials")
+	res, err := client.PostForm(s.URL+"/oauth/token", form)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body := map[string]interface{}{}
+	if err := json.NewDecoder(res.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, body
+}
+
+func (s mtlsServer) whoami(t *testing.T, client *http.Client, token string) (int, string) {
+	t.Helper()
+	req, _ := http.NewRequest("GET", s.URL+"/api/v1/whoami", nil)
+	req.Header.Set("Authorization", "Bearer "+token)
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, string(body)
+}
+
+func TestTokenEndpointCertificateIdentity(t *testing.T) {
+	ca := newTestCA(t)
+	s := newMTLSServer(t, ca)
+	billing := s.createAccount(t, "dns:billing.internal", "")
+	reports := s.createAccount(t, "CN=reports,O=Example", "")
+	other := s.createAccount(t, "", "other-secret")
+
+	billingCert := ca.issue(t, pkix.Name{CommonName: "billing"}, "billing.internal")
+	reportsCert := ca.issue(t, pkix.Name{CommonName: "reports", Organization: []string{"Example"}})
+	unknownCert := ca.issue(t, pkix.Name{CommonName: "unknown"}, "unknown.internal")
+
+	for _, tt := range []struct {
+		name     string
+		cert     *tls.Certificate
+		form     url.Values
+		clientId string
+	}{
+		{"SAN identity", &billingCert, url.Values{}, billing},
+		{"SAN identity with its client_id", &billingCert, url.Values{"client_id": {billing}}, billing},
+		{"subject DN identity", &reportsCert, url.Values{}, reports},
+	} {
+		status, body := s.requestToken(t, s.client(tt.cert), tt.form)
+		if status != http.StatusOK {
+			t.Errorf("%s: status = %d %v, want 200", tt.name, status, body)
+			continue
+		}
+		claims, msg := helpers.ValidateToken(body["access_token"].(string))
+		if msg != "" {
+			t.Fatalf("%s: ValidateToken: %s", tt.name, msg)
+		}
+		if claims.ClientId != tt.clientId {
+			t.Errorf("%s: token is for %s, want %s", tt.name, claims.ClientId, tt.clientId)
+		}
+		if want := helpers.CertificateThumbprint(tt.cert.Leaf); claims.BoundCertificate() != want {
+			t.Errorf("%s: cnf.x5t#S256 = %q, want %q", tt.name, claims.BoundCertificate(), want)
+		}
+		if body["token_type"] != "Bearer" {
+			t.Errorf("%s: token_type = %v, want Bearer", tt.name, body["token_type"])
+		}
+	}
+
+	for _, tt := range []struct {
+		name string
+		cert *tls.Certificate
+		form url.Values
+	}{
+		{"certificate of no account", &unknownCert, url.Values{}},
+		{"client_id of another account", &billingCert, url.Values{"client_id": {other}}},
+		{"no certificate and no secret", nil, url.Values{"client_id": {billing}}},
+	} {
+		status, body := s.requestToken(t, s.client(tt.cert), tt.form)
+		if status != http.StatusUnauthorized || body["error"] != "invalid_client" {
+			t.Errorf("%s: = %d %v, want 401 invalid_client", tt.name, status, body)
+		}
+	}
+
+	// A secret still works over a certificate, and the token is bound to it
+	status, body := s.requestToken(t, s.client(&unknownCert), url.Values{"client_id": {other}, "client_secret": {"other-secret"}})
+	if status != http.StatusOK {
+		t.Fatalf("secret over a certificate: status = %d %v, want 200", status, body)
+	}
+	claims, _ := helpers.ValidateToken(body["access_token"].(string))
+	if claims == nil || claims.BoundCertificate() != helpers.CertificateThumbprint(unknownCert.Leaf) {
+		t.Error("secret over a certificate: token isn't bound to the certificate")
+	}
+}
+
+func TestCertificateBoundToken(t *testing.T) {
+	ca := newTestCA(t)
+	s := newMTLSServer(t, ca)
+	billing := s.createAccount(t, "dns:billing.internal", "")
+	s.createAccount(t, "", "other-secret")
+
+	billingCert := ca.issue(t, pkix.Name{CommonName: "billing"}, "billing.internal")
+	_, body := s.requestToken(t, s.client(&billingCert), url.Values{})
+	token, _ := body["access_token"].(string)
+
+	if status, got := s.whoami(t, s.client(&billingCert), token); status != http.StatusOK || !strings.Contains(got, billing) {
+		t.Errorf("over its certificate = %d %s, want 200", status, got)
+	}
+
+	// Another certificate of the same CA, even for the same identity, isn't
+	// the one the token is bound to
+	again := ca.issue(t, pkix.Name{CommonName: "billing"}, "billing.internal")
+	for name, cert := range map[string]*tls.Certificate{"another certificate": &again, "no certificate": nil} {
+		status, got := s.whoami(t, s.client(cert), token)
+		if status != http.StatusUnauthorized || !strings.Contains(got, "another client certificate") {
+			t.Errorf("over %s = %d %s, want 401", name, status, got)
+		}
+	}
+
+	// Tokens issued without a certificate work without one
+	status, body := s.requestToken(t, s.client(nil), url.Values{"client_id": {s.createAccount(t, "", "secret")}, "client_secret": {"secret"}})
+	if status != http.StatusOK {
+		t.Fatalf("secret = %d %v, want 200", status, body)
+	}
+	if status, got := s.whoami(t, s.client(nil), body["access_token"].(string)); status != http.StatusOK {
+		t.Errorf("unbound token = %d %s, want 200", status, got)
+	}
+}
+
+func TestCertificateIdentityOfSeveralOrganizations(t *testing.T) {
+	ca := newTestCA(t)
+	s := newMTLSServer(t, ca)
+	acme := s.createOrgAccount(t, s.createOrganization(t, "Acme"), "dns:billing.internal", "")
+	globex := s.createOrgAccount(t, s.createOrganization(t, "Globex"), "dns:billing.internal", "")
+	cert := ca.issue(t, pkix.Name{CommonName: "billing"}, "billing.internal")
+	client := s.client(&cert)
+
+	// Which of them the certificate is meant for only the client_id says
+	if status, body := s.requestToken(t, client, url.Values{}); status != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Errorf("without client_id = %d %v, want 401 invalid_client", status, body)
+	}
+	for _, clientId := range []string{acme, globex} {
+		status, body := s.requestToken(t, client, url.Values{"client_id": {clientId}})
+		if status != http.StatusOK {
+			t.Errorf("with client_id %s = %d %v, want 200", clientId, status, body)
+			continue
+		}
+		if claims, _ := helpers.ValidateToken(body["access_token"].(string)); claims == nil || claims.ClientId != clientId {
+			t.Errorf("with client_id %s: token is for %+v", clientId, claims)
+		}
+	}
+}
+
+func TestUntrustedClientCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	s := newMTLSServer(t, ca)
+	s.createAccount(t, "dns:billing.internal", "")
+
+	// Certificates of other CAs never make it past the handshake
+	forged := newTestCA(t).issue(t, pkix.Name{CommonName: "billing"}, "billing.internal")
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if res, err := s.client(&forged).PostForm(s.URL+"/oauth/token", form); err == nil {
+		res.Body.Close()
+		t.Errorf("certificate of another CA = %d, want the handshake to fail", res.StatusCode)
+	}
+}
+
+// oauthTest serves the OAuth endpoints from a scratch database.
+type oauthTest struct {
+	stores repository.Stores
+	users  *UserController
+	oauth  OAuthController
+	router *gin.Engine
+}
+
+func newOAuthTest(t *testing.T) oauthTest {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	if err := helpers.InitSigning(&config.JWTConfig{Algorithm: "HS256", Secret: "test-secret", Audience: "https://auth.example.com", Issuer: "https://auth.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	stores := newTestStores(t)
+	dpop := helpers.NewDPoPVerifier(repository.NewMemoryDPoPProofStore(), "https://auth.example.com")
+	users := &UserController{userRepo: stores.Users, roles: stores.Roles, orgs: stores.Organizations, revocations: stores.Revocations, families: stores.RefreshTokens, dpop: dpop, mfa: stores.MFA, guard: newTestGuard(), settings: LoginSettings{UnverifiedLogin: UnverifiedLoginAllow}}
+	oauth := NewOAuthController(stores.ServiceAccounts, stores.OAuthClients, stores.AuthorizationCodes, stores.DeviceCodes, PersonalAccessTokenController{}, users, OAuthSettings{
+		ClientTokenTTL:       time.Hour,
+		AuthorizationCodeTTL: time.Minute,
+		DeviceCodeTTL:        10 * time.Minute,
+		DevicePollInterval:   5 * time.Second,
+		VerificationURI:      "https://auth.example.com/oauth/device",
+	})
+
+	router := gin.New()
+	router.POST("/oauth/token", oauth.Token())
+	router.POST("/oauth/authorize", oauth.AuthorizeLogin())
+	router.POST("/oauth/introspect", oauth.Introspect())
+	router.POST("/oauth/revoke", oauth.Revoke())
+	router.POST("/oauth/device_authorization", oauth.DeviceAuthorization())
+	router.POST("/oauth/device", oauth.DeviceLogin())
+	router.POST("/refresh", users.RefreshToken())
+	return oauthTest{stores: stores, users: users, oauth: oauth, router: router}
+}
+
+// post sends form to path as the client, and returns the status and the
+// decoded answer, if any.
+func (o oauthTest) post(t *testing.T, path string, clientId string, secret string, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if clientId != "" {
+		req.SetBasicAuth(url.QueryEscape(clientId), url.QueryEscape(secret))
+	}
+	w := httptest.NewRecorder()
+	o.router.ServeHTTP(w, req)
+
+	body := map[string]interface{}{}
+	if w.Body.Len() > 0 {
+		if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+			t.Fatalf("%s answered %d %s", path, w.Code, w.Body.String())
+		}
+	}
+	return w.Code, body
+}
+
+// submit posts form to one of the OAuth pages, as the user's browser would.
+func (o oauthTest) submit(path string, form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", path, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	o.router.ServeHTTP(w, req)
+	return w
+}
+
+// createClient registers a confidential OAuth client with the secret.
+func (o oauthTest) createClient(t *testing.T, secret string, redirectURIs ...string) string {
+	t.Helper()
+	clientId, err := o.stores.OAuthClients.CreateOAuthClient(models.OAuthClient{Name: "test", SecretHash: helpers.HashSecretToken(secret), RedirectURIs: redirectURIs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientId
+}
+
+// createServiceAccount registers a service account granted scopes.
+func (o oauthTest) createServiceAccount(t *testing.T, secret string, scopes ...string) string {
+	t.Helper()
+	clientId, err := o.stores.ServiceAccounts.CreateServiceAccount(models.ServiceAccount{Name: "test", SecretHash: helpers.HashSecretToken(secret), Scopes: scopes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return clientId
+}
+
+// createUser stores a verified user whose password is "correct horse".
+func (o oauthTest) createUser(t *testing.T, email string) models.User {
+	t.Helper()
+	userId := createTestUser(t, o.stores.Users, email, bcryptHash(t, "correct horse"))
+	if err := o.stores.Users.MarkEmailVerified(userId); err != nil {
+		t.Fatal(err)
+	}
+	user, err := o.stores.Users.GetUser(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// introspector returns a service account that may introspect tokens, and
+// a function telling whether it finds a token active.
+func (o oauthTest) introspector(t *testing.T) func(token string) bool {
+	t.Helper()
+	clientId := o.createServiceAccount(t, "introspect-secret", repository.PermissionTokensIntrospect)
+	return func(token string) bool {
+		t.Helper()
+		status, body := o.post(t, "/oauth/introspect", clientId, "introspect-secret", url.Values{"token": {token}})
+		if status != http.StatusOK {
+			t.Fatalf("introspect = %d %v", status, body)
+		}
+		return body["active"] == true
+	}
+}
+
+// refresh rotates the refresh token through RefreshToken.
+func (o oauthTest) refresh(t *testing.T, refreshToken string) (string, string) {
+	t.Helper()
+	payload, _ := json.Marshal(refreshTokenRequest{RefreshToken: refreshToken})
+	req := httptest.NewRequest("POST", "/refresh", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	o.router.ServeHTTP(w, req)
+	var res struct {
+		Data struct {
+			Token        string `json:"token"`
+			RefreshToken string `json:"refresh_token"`
+		} `json:"data"`
+	}
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil {
+		t.Fatalf("refresh = %d %s", w.Code, w.Body.String())
+	}
+	return res.Data.Token, res.Data.RefreshToken
+}
+
+func TestIntrospectRotatedRefreshToken(t *testing.T) {
+	o := newOAuthTest(t)
+	active := o.introspector(t)
+	clientId := o.createClient(t, "client-secret", "https://app.example.com/callback")
+	user := o.createUser(t, "ann@example.com")
+
+	access, refresh, err := o.users.startSession(user, "", clientId, "openid", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !active(access) || !active(refresh) {
+		t.Fatal("fresh tokens aren't active")
+	}
+
+	_, rotated := o.refresh(t, refresh)
+	if active(refresh) {
+		t.Error("a rotated-out refresh token is active")
+	}
+	if !active(rotated) {
+		t.Error("the current refresh token isn't active")
+	}
+	if !active(access) {
+		t.Error("refreshing ended the access token issued before")
+	}
+}
+
+func TestRevokeOnlyTokensOfTheClient(t *testing.T) {
+	o := newOAuthTest(t)
+	active := o.introspector(t)
+	clientId := o.createClient(t, "client-secret", "https://app.example.com/callback")
+	otherId := o.createClient(t, "other-secret", "https://other.example.com/callback")
+	user := o.createUser(t, "ann@example.com")
+
+	access, refresh, err := o.users.startSession(user, "", clientId, "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, _, err := o.users.startSession(user, "", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, tt := range map[string]struct{ clientId, secret, token string }{
+		"another client's token": {otherId, "other-secret", refresh},
+		"a token from Login":     {clientId, "client-secret", login},
+	} {
+		status, body := o.post(t, "/oauth/revoke", tt.clientId, tt.secret, url.Values{"token": {tt.token}})
+		if status != http.StatusBadRequest || body["error"] != "unauthorized_client" {
+			t.Errorf("revoking %s = %d %v, want 400 unauthorized_client", name, status, body)
+		}
+		if !active(tt.token) {
+			t.Errorf("revoking %s took it down", name)
+		}
+	}
+
+	// Revoking the refresh token ends its family, the access token included
+	status, body := o.post(t, "/oauth/revoke", clientId, "client-secret", url.Values{"token": {refresh}})
+	if status != http.StatusOK {
+		t.Fatalf("revoking the client's refresh token = %d %v", status, body)
+	}
+	if active(refresh) || active(access) {
+		t.Error("tokens of a revoked family are still active")
+	}
+	if !active(login) {
+		t.Error("revoking a family took down another session")
+	}
+
+	// Invalid tokens get the same answer
+	if status, body := o.post(t, "/oauth/revoke", clientId, "client-secret", url.Values{"token": {"not-a-token"}}); status != http.StatusOK {
+		t.Errorf("revoking an invalid token = %d %v, want 200", status, body)
+	}
+	if status, body := o.post(t, "/oauth/revoke", clientId, "wrong-secret", url.Values{"token": {refresh}}); status != http.StatusUnauthorized {
+		t.Errorf("revoking with a wrong secret = %d %v, want 401", status, body)
+	}
+}
+
+// startDevice asks for a device code for the client, and returns it with
+// the user code.
+func (o oauthTest) startDevice(t *testing.T, clientId string, secret string) (string, string) {
+	t.Helper()
+	status, body := o.post(t, "/oauth/device_authorization", clientId, secret, url.Values{"scope": {"openid"}})
+	if status != http.StatusOK {
+		t.Fatalf("device authorization = %d %v", status, body)
+	}
+	return body["device_code"].(string), body["user_code"].(string)
+}
+
+func TestDeviceFlow(t *testing.T) {
+	o := newOAuthTest(t)
+	clientId := o.createClient(t, "client-secret")
+	o.createUser(t, "ann@example.com")
+	deviceCode, userCode := o.startDevice(t, clientId, "client-secret")
+
+	poll := func() (int, map[string]interface{}) {
+		t.Helper()
+		return o.post(t, "/oauth/token", clientId, "client-secret", url.Values{"grant_type": {deviceCodeGrantType}, "device_code": {deviceCode}})
+	}
+	if status, body := poll(); status != http.StatusBadRequest || body["error"] != "authorization_pending" {
+		t.Errorf("polling before approval = %d %v, want authorization_pending", status, body)
+	}
+	if _, body := poll(); body["error"] != "slow_down" {
+		t.Errorf("polling again right away = %v, want slow_down", body)
+	}
+	if status, body := o.post(t, "/oauth/token", o.createClient(t, "other-secret"), "other-secret", url.Values{"grant_type": {deviceCodeGrantType}, "device_code": {deviceCode}}); body["error"] != "invalid_grant" {
+		t.Errorf("polling as another client = %d %v, want invalid_grant", status, body)
+	}
+
+	approve := url.Values{"user_code": {userCode}, "email": {"ann@example.com"}, "password": {"wrong"}, "decision": {"allow"}}
+	if w := o.submit("/oauth/device", approve); w.Code != http.StatusUnauthorized {
+		t.Errorf("allowing with a wrong password = %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	approve.Set("password", "correct horse")
+	if w := o.submit("/oauth/device", approve); w.Code != http.StatusOK {
+		t.Fatalf("allowing the device = %d %s", w.Code, w.Body.String())
+	}
+
+	status, body := poll()
+	if status != http.StatusOK || body["access_token"] == nil || body["refresh_token"] == nil {
+		t.Fatalf("polling after approval = %d %v, want tokens", status, body)
+	}
+	if _, body := poll(); body["error"] != "invalid_grant" {
+		t.Errorf("polling after the tokens were issued = %v, want invalid_grant", body)
+	}
+}
+
+func TestDeviceDenialTakesSignIn(t *testing.T) {
+	o := newOAuthTest(t)
+	clientId := o.createClient(t, "client-secret")
+	o.createUser(t, "ann@example.com")
+	deviceCode, userCode := o.startDevice(t, clientId, "client-secret")
+	poll := func() map[string]interface{} {
+		t.Helper()
+		_, body := o.post(t, "/oauth/token", clientId, "client-secret", url.Values{"grant_type": {deviceCodeGrantType}, "device_code": {deviceCode}})
+		return body
+	}
+
+	// Anyone who reads the user code off the screen could deny it otherwise
+	for name, form := range map[string]url.Values{
+		"without signing in":    {"user_code": {userCode}, "decision": {"deny"}},
+		"with a wrong password": {"user_code": {userCode}, "email": {"ann@example.com"}, "password": {"wrong"}, "decision": {"deny"}},
+	} {
+		if w := o.submit("/oauth/device", form); w.Code == http.StatusOK {
+			t.Errorf("denying %s = %d, want an error", name, w.Code)
+		}
+	}
+	if body := poll(); body["error"] != "authorization_pending" {
+		t.Fatalf("polling after refused denials = %v, want authorization_pending", body)
+	}
+
+	deny := url.Values{"user_code": {userCode}, "email": {"ann@example.com"}, "password": {"correct horse"}, "decision": {"deny"}}
+	if w := o.submit("/oauth/device", deny); w.Code != http.StatusOK {
+		t.Fatalf("denying the device = %d %s", w.Code, w.Body.String())
+	}
+	if body := poll(); body["error"] != "access_denied" {
+		t.Errorf("polling after the denial = %v, want access_denied", body)
+	}
+}
+
+func TestClientCredentialsScopes(t *testing.T) {
+	o := newOAuthTest(t)
+	clientId := o.createServiceAccount(t, "client-secret", repository.PermissionUsersRead, repository.PermissionUsersWrite)
+	request := func(secret string, scope string) (int, map[string]interface{}) {
+		t.Helper()
+		form := url.Values{"grant_type": {"client_credentials"}}
+		if scope != "" {
+			form.Set("scope", scope)
+		}
+		return o.post(t, "/oauth/token", clientId, secret, form)
+	}
+
+	// Without a scope the client gets all of its scopes
+	status, body := request("client-secret", "")
+	if status != http.StatusOK || body["scope"] != repository.PermissionUsersRead+" "+repository.PermissionUsersWrite {
+		t.Fatalf("token without a scope = %d %v", status, body)
+	}
+	claims, msg := helpers.ValidateToken(body["access_token"].(string))
+	if msg != "" || claims.ClientId != clientId || claims.Uid != "" {
+		t.Errorf("claims of the client token = %+v, %s", claims, msg)
+	}
+
+	if status, body := request("client-secret", repository.PermissionUsersRead+" "+repository.PermissionUsersRead); status != http.StatusOK || body["scope"] != repository.PermissionUsersRead {
+		t.Errorf("token for one of its scopes = %d %v", status, body)
+	}
+	if status, body := request("client-secret", repository.PermissionUsersRead+" "+repository.PermissionTokensIntrospect); status != http.StatusBadRequest || body["error"] != "invalid_scope" {
+		t.Errorf("token for a scope it wasn't granted = %d %v, want invalid_scope", status, body)
+	}
+	if status, body := request("wrong-secret", ""); status != http.StatusUnauthorized || body["error"] != "invalid_client" {
+		t.Errorf("token with a wrong secret = %d %v, want invalid_client", status, body)
+	}
+}
+
+// authorize signs in as Ann on the login and consent page and allows the
+// client, returning the code it is sent back with.
+func (o oauthTest) authorize(t *testing.T, clientId string, redirectURI string, verifier string, scope string, nonce string) string {
+	t.Helper()
+	hash := sha256.Sum256([]byte(verifier))
+	w := o.submit("/oauth/authorize", url.Values{
+		"response_type":         {"code"},
+		"client_id":             {clientId},
+		"redirect_uri":          {redirectURI},
+		"state":                 {"xyz"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(hash[:])},
+		"code_challenge_method": {"S256"},
+		"scope":                 {scope},
+		"nonce":                 {nonce},
+		"email":                 {"ann@example.com"},
+		"password":              {"correct horse"},
+		"decision":              {"allow"},
+	})
+	location, err := url.Parse(w.Header().Get("Location"))
+	if w.Code != http.StatusSeeOther || err != nil || location.Query().Get("code") == "" {
+		t.Fatalf("authorize = %d %s, want a redirect with a code", w.Code, w.Header().Get("Location"))
+	}
+	if location.Query().Get("state") != "xyz" {
+		t.Errorf("redirect %s doesn't carry the state", location)
+	}
+	return location.Query().Get("code")
+}
+
+func TestAuthorizationCodeExchange(t *testing.T) {
+	o := newOAuthTest(t)
+	redirectURI := "https://app.example.com/callback"
+	clientId := o.createClient(t, "client-secret", redirectURI)
+	o.createUser(t, "ann@example.com")
+	verifier := strings.Repeat("v", 43)
+	exchange := func(code string, redirectURI string, verifier string) (int, map[string]interface{}) {
+		t.Helper()
+		return o.post(t, "/oauth/token", clientId, "client-secret", url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {verifier}})
+	}
+
+	code := o.authorize(t, clientId, redirectURI, verifier, "", "")
+	if status, body := exchange(code, redirectURI, verifier); status != http.StatusOK || body["access_token"] == nil || body["refresh_token"] == nil || body["id_token"] != nil {
+		t.Fatalf("exchanging the code = %d %v, want tokens", status, body)
+	}
+	if _, body := exchange(code, redirectURI, verifier); body["error"] != "invalid_grant" {
+		t.Errorf("exchanging the code again = %v, want invalid_grant", body)
+	}
+
+	// A failed exchange uses the code up too
+	code = o.authorize(t, clientId, redirectURI, verifier, "", "")
+	if _, body := exchange(code, redirectURI, strings.Repeat("w", 43)); body["error"] != "invalid_grant" {
+		t.Errorf("exchanging with another verifier = %v, want invalid_grant", body)
+	}
+	if _, body := exchange(code, redirectURI, verifier); body["error"] != "invalid_grant" {
+		t.Errorf("exchanging after a wrong verifier = %v, want invalid_grant", body)
+	}
+
+	code = o.authorize(t, clientId, redirectURI, verifier, "", "")
+	if _, body := exchange(code, "https://app.example.com/other", verifier); body["error"] != "invalid_grant" {
+		t.Errorf("exchanging with another redirect_uri = %v, want invalid_grant", body)
+	}
+}
+
+func TestIDTokenAndUserInfo(t *testing.T) {
+	o := newOAuthTest(t)
+	redirectURI := "https://app.example.com/callback"
+	clientId := o.createClient(t, "client-secret", redirectURI)
+	user := o.createUser(t, "ann@example.com")
+	verifier := strings.Repeat("v", 43)
+
+	signedIn := time.Now().Truncate(time.Second)
+	code := o.authorize(t, clientId, redirectURI, verifier, "openid email", "n-0S6_WzA2Mj")
+	status, body := o.post(t, "/oauth/token", clientId, "client-secret", url.Values{"grant_type": {"authorization_code"}, "code": {code}, "redirect_uri": {redirectURI}, "code_verifier": {verifier}})
+	if status != http.StatusOK || body["id_token"] == nil || body["scope"] != "openid email" {
+		t.Fatalf("exchanging the code = %d %v, want an ID token", status, body)
+	}
+
+	idToken := &helpers.IDTokenClaims{}
+	_, err := jwt.ParseWithClaims(body["id_token"].(string), idToken, func(*jwt.Token) (interface{}, error) {
+		return []byte("test-secret"), nil
+	})
+	if err != nil {
+		t.Fatalf("parsing the ID token: %v", err)
+	}
+	if idToken.Subject != user.UserId || len(idToken.Audience) != 1 || idToken.Audience[0] != clientId || idToken.Issuer != "https://auth.example.com" {
+		t.Errorf("ID token sub %s, aud %v, iss %s", idToken.Subject, idToken.Audience, idToken.Issuer)
+	}
+	if idToken.Nonce != "n-0S6_WzA2Mj" {
+		t.Errorf("ID token nonce = %q", idToken.Nonce)
+	}
+	if idToken.AuthTime == nil || idToken.AuthTime.Before(signedIn) || idToken.AuthTime.After(time.Now()) {
+		t.Errorf("ID token auth_time = %v, want the sign-in", idToken.AuthTime)
+	}
+	// The email scope reveals the email address, not the name
+	if idToken.Email != "ann@example.com" || idToken.EmailVerified == nil || !*idToken.EmailVerified || idToken.Name != "" {
+		t.Errorf("ID token user claims = %+v", idToken.UserClaims)
+	}
+
+	openID := NewOpenIDController(o.stores.Users, "https://auth.example.com", false)
+	access, msg := helpers.ValidateToken(body["access_token"].(string))
+	if msg != "" {
+		t.Fatal(msg)
+	}
+	w := serveAs(openID.UserInfo(), access, "GET", "/userinfo", "/userinfo", nil)
+	var info userInfo
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &info) != nil || info.Subject != user.UserId || info.Email != "ann@example.com" || info.Name != "" {
+		t.Errorf("userinfo = %d %s", w.Code, w.Body.String())
+	}
+
+	// Tokens of a plain login weren't granted openid
+	login, _, err := o.users.startSession(user, "", "", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loginClaims, _ := helpers.ValidateToken(login)
+	if w := serveAs(openID.UserInfo(), loginClaims, "GET", "/userinfo", "/userinfo", nil); w.Code != http.StatusForbidden {
+		t.Errorf("userinfo without the openid scope = %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
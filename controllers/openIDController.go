@@ -0,0 +1,148 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// The OpenID Connect scopes clients can ask for at the authorization
+// endpoint
+const (
+	scopeOpenID  = "openid"
+	scopeProfile = "profile"
+	scopeEmail   = "email"
+)
+
+var supportedScopes = []string{scopeOpenID, scopeProfile, scopeEmail}
+
+// OpenIDController implements the OpenID Connect parts on top of the OAuth
+// 2.0 endpoints: discovery and userinfo. ID tokens come from the token
+// endpoint.
+type OpenIDController struct {
+	userRepo repository.UserRepository
+	issuer   string
+	// clientCertificates tells whether the server asks clients for TLS
+	// certificates
+	clientCertificates bool
+}
+
+func NewOpenIDController(repo repository.UserRepository, issuer string, clientCertificates bool) OpenIDController {
+	return OpenIDController{userRepo: repo, issuer: issuer, clientCertificates: clientCertificates}
+}
+
+// requestedScopes returns the supported scopes out of a scope parameter,
+// without duplicates. Others are ignored, like the offline_access some
+// client libraries ask for by default.
+func requestedScopes(scope string) []string {
+	scopes := []string{}
+	for _, requested := range strings.Fields(scope) {
+		if hasScope(supportedScopes, requested) && !hasScope(scopes, requested) {
+			scopes = append(scopes, requested)
+		}
+	}
+	return scopes
+}
+
+func hasScope(scopes []string, scope string) bool {
+	for _, s := range scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// userClaims returns what scopes reveal about the user.
+func userClaims(user models.User, scopes []string) helpers.UserClaims {
+	var claims helpers.UserClaims
+	if hasScope(scopes, scopeEmail) && user.Email != nil {
+		verified := user.EmailVerifiedAt != nil
+		claims.Email, claims.EmailVerified = *user.Email, &verified
+	}
+	if hasScope(scopes, scopeProfile) {
+		if user.FirstName != nil {
+			claims.GivenName = *user.FirstName
+		}
+		if user.LastName != nil {
+			claims.FamilyName = *user.LastName
+		}
+		claims.Name = strings.TrimSpace(claims.GivenName + " " + claims.FamilyName)
+	}
+	return claims
+}
+
+// Discovery serves the OpenID Provider metadata client libraries configure
+// themselves from.
+func (o OpenIDController) Discovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Asked for every time, keys may be rotated to another algorithm
+		alg, err := helpers.SigningAlgorithm()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		authMethods := []string{"client_secret_basic", "client_secret_post", "none"}
+		if o.clientCertificates {
+			authMethods = append(authMethods, "tls_client_auth")
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"issuer":                                     o.issuer,
+			"authorization_endpoint":                     o.issuer + "/oauth/authorize",
+			"token_endpoint":                             o.issuer + "/oauth/token",
+			"userinfo_endpoint":                          o.issuer + "/userinfo",
+			"jwks_uri":                                   o.issuer + "/.well-known/jwks.json",
+			"introspection_endpoint":                     o.issuer + "/oauth/introspect",
+			"revocation_endpoint":                        o.issuer + "/oauth/revoke",
+			"device_authorization_endpoint":              o.issuer + "/oauth/device_authorization",
+			"scopes_supported":                           supportedScopes,
+			"response_types_supported":                   []string{"code"},
+			"grant_types_supported":                      []string{"authorization_code", "client_credentials", deviceCodeGrantType},
+			"subject_types_supported":                    []string{"public"},
+			"id_token_signing_alg_values_supported":      []string{alg},
+			"token_endpoint_auth_methods_supported":      authMethods,
+			"code_challenge_methods_supported":           []string{"S256"},
+			"dpop_signing_alg_values_supported":          helpers.DPoPAlgorithms,
+			"tls_client_certificate_bound_access_tokens": o.clientCertificates,
+			"claims_supported": []string{
+				"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce",
+				"email", "email_verified", "name", "given_name", "family_name",
+			},
+		})
+	}
+}
+
+// userInfo is the userinfo response, the same claims as in ID tokens.
+type userInfo struct {
+	Subject string `json:"sub"`
+	helpers.UserClaims
+}
+
+// UserInfo returns what the scopes of the caller's token reveal about its
+// user. Only tokens granted the openid scope may ask.
+func (o OpenIDController) UserInfo() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, _ := helpers.GetClaims(c)
+		scopes := strings.Fields(claims.Scope)
+		if claims.Uid == "" || !hasScope(scopes, scopeOpenID) {
+			c.Header("WWW-Authenticate", `Bearer error="insufficient_scope", scope="openid"`)
+			oauthError(c, http.StatusForbidden, "insufficient_scope", "the token wasn't granted the openid scope")
+			return
+		}
+
+		user, err := o.userRepo.GetUser(claims.Uid)
+		if err != nil {
+			c.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			oauthError(c, http.StatusUnauthorized, "invalid_token", "user not found")
+			return
+		}
+
+		c.JSON(http.StatusOK, userInfo{Subject: user.UserId, UserClaims: userClaims(user, scopes)})
+	}
+}
@@ -0,0 +1,352 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/notifier"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type OrganizationController struct {
+	orgs          repository.OrganizationStore
+	roles         repository.RoleStore
+	users         repository.UserRepository
+	revocations   repository.RevocationStore
+	notifier      notifier.Notifier
+	invitationTTL time.Duration
+}
+
+func NewOrganizationController(orgs repository.OrganizationStore, roles repository.RoleStore, users repository.UserRepository, revocations repository.RevocationStore, notifier notifier.Notifier, invitationTTL time.Duration) OrganizationController {
+	return OrganizationController{orgs: orgs, roles: roles, users: users, revocations: revocations, notifier: notifier, invitationTTL: invitationTTL}
+}
+
+var errLastOrgAdmin = errors.New("an organization needs at least one " + repository.RoleOrgAdmin)
+
+// checkNotLastAdmin refuses to take the role away from the organization's
+// last ORG_ADMIN, which would leave nobody to administer it.
+func (o OrganizationController) checkNotLastAdmin(c *gin.Context, membership models.Membership) bool {
+	if membership.Role != repository.RoleOrgAdmin {
+		return true
+	}
+	admins, err := o.orgs.CountMembers(membership.OrgId, repository.RoleOrgAdmin)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	if admins <= 1 {
+		c.JSON(http.StatusConflict, gin.H{"error": errLastOrgAdmin.Error()})
+		return false
+	}
+	return true
+}
+
+// checkCanGrant refuses roles with permissions the caller doesn't hold.
+func checkCanGrant(c *gin.Context, caller *helpers.SignedDetails, role models.Role, msg string) bool {
+	for _, permission := range role.Permissions {
+		if !caller.HasPermission(permission) {
+			c.JSON(http.StatusForbidden, gin.H{"error": msg})
+			return false
+		}
+	}
+	return true
+}
+
+// revokeMemberTokens rejects the tokens the user got for the organization
+// so far, so a role change or removal takes effect right away.
+func (o OrganizationController) revokeMemberTokens(c *gin.Context, orgId string, userId string) bool {
+	if err := o.revocations.RevokeMemberTokens(orgId, userId, time.Now()); err != nil {
+		log.Printf("Error %s when revoking the tokens of member %s of %s", err, userId, orgId)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// GetOrganizations lists the organizations the caller belongs to.
+func (o OrganizationController) GetOrganizations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		memberships, err := o.orgs.GetUserMemberships(caller.Uid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": memberships})
+	}
+}
+
+// CreateOrganization creates an organization administered by the caller. The
+// caller's current tokens stay in their old organization until they switch.
+func (o OrganizationController) CreateOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var org models.Organization
+		if err := c.ShouldBindJSON(&org); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(org); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		caller, _ := helpers.GetClaims(c)
+		orgId, err := o.orgs.CreateOrganization(org, caller.Uid, repository.RoleOrgAdmin)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		org.OrgId = orgId
+		c.JSON(http.StatusCreated, gin.H{"data": org})
+	}
+}
+
+// GetCurrentOrganization returns the organization the caller's token acts in.
+func (o OrganizationController) GetCurrentOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		if caller.OrgId == "" {
+			c.JSON(http.StatusNotFound, gin.H{"error": "not acting in an organization"})
+			return
+		}
+
+		membership, err := o.orgs.GetMembership(caller.OrgId, caller.Uid)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusForbidden, gin.H{"error": errNotMember.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": membership})
+	}
+}
+
+type addMemberRequest struct {
+	UserId string `json:"user_id" validate:"required,uuid"`
+	Role   string `json:"role" validate:"required"`
+}
+
+// AddMember changes the role of a member of the caller's organization, or
+// invites a user who isn't one yet. Nobody is made a member of an
+// organization without accepting. Callers can only hand out roles whose
+// permissions they hold themselves, so nobody can raise anyone above their
+// own level. Changing a member's role takes the permissions of both the
+// old and the new role, and revokes the member's tokens for the
+// organization.
+func (o OrganizationController) AddMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req addMemberRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		caller, _ := helpers.GetClaims(c)
+		if caller.OrgId == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not acting in an organization"})
+			return
+		}
+
+		role, err := o.roles.GetRole(req.Role)
+		if err != nil {
+			roleError(c, err)
+			return
+		}
+		if !checkCanGrant(c, caller, role, "can't grant a role with permissions you don't hold") {
+			return
+		}
+
+		membership, err := o.orgs.GetMembership(caller.OrgId, req.UserId)
+		if errors.Is(err, sql.ErrNoRows) {
+			o.invite(c, caller.OrgId, req)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		current, err := o.roles.GetRole(membership.Role)
+		if err != nil {
+			roleError(c, err)
+			return
+		}
+		if !checkCanGrant(c, caller, current, "can't change the role of a member with permissions you don't hold") {
+			return
+		}
+		if req.Role != repository.RoleOrgAdmin && !o.checkNotLastAdmin(c, membership) {
+			return
+		}
+
+		if err := o.orgs.AddMember(caller.OrgId, req.UserId, req.Role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !o.revokeMemberTokens(c, caller.OrgId, req.UserId) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": req})
+	}
+}
+
+// invite offers the user req.Role in the organization and lets them know.
+func (o OrganizationController) invite(c *gin.Context, orgId string, req addMemberRequest) {
+	user, err := o.users.GetUser(req.UserId)
+	if errors.Is(err, sql.ErrNoRows) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	org, err := o.orgs.GetOrganization(orgId)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	invitation := models.Invitation{OrgId: orgId, Name: org.Name, UserId: req.UserId, Role: req.Role, ExpiresAt: time.Now().Add(o.invitationTTL)}
+	if err := o.orgs.InviteMember(invitation); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	err = o.notifier.Notify(notifier.Message{
+		Kind:    "organization_invitation",
+		To:      *user.Email,
+		Subject: "You have been invited to " + org.Name,
+		Body:    fmt.Sprintf("You have been invited to join %s as %s. The invitation is waiting for you among your invitations until %s.", org.Name, req.Role, invitation.ExpiresAt.UTC().Format(time.RFC1123)),
+	})
+	if err != nil {
+		log.Printf("Error %s when notifying user %s of an invitation", err, req.UserId)
+	}
+	c.JSON(http.StatusAccepted, gin.H{"data": invitation})
+}
+
+// WithdrawInvitation takes back an invitation to the caller's organization
+// that hasn't been accepted yet.
+func (o OrganizationController) WithdrawInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		err := o.orgs.DeleteInvitation(caller.OrgId, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": c.Param("id")})
+	}
+}
+
+// GetInvitations lists the organizations the caller has been invited to.
+func (o OrganizationController) GetInvitations() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		invitations, err := o.orgs.GetUserInvitations(caller.Uid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": invitations})
+	}
+}
+
+// AcceptInvitation makes the caller a member of the organization that
+// invited them. Like with CreateOrganization, their current tokens stay in
+// their old organization until they switch.
+func (o OrganizationController) AcceptInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		err := o.orgs.AcceptInvitation(c.Param("org_id"), caller.Uid)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		membership, err := o.orgs.GetMembership(c.Param("org_id"), caller.Uid)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": membership})
+	}
+}
+
+// DeclineInvitation turns down an invitation of the caller's.
+func (o OrganizationController) DeclineInvitation() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		err := o.orgs.DeleteInvitation(c.Param("org_id"), caller.Uid)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "invitation not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": c.Param("org_id")})
+	}
+}
+
+// RemoveMember takes a member out of the caller's organization and revokes
+// their tokens for it. The last ORG_ADMIN can't be removed.
+func (o OrganizationController) RemoveMember() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		if caller.OrgId == "" {
+			c.JSON(http.StatusForbidden, gin.H{"error": "not acting in an organization"})
+			return
+		}
+
+		membership, err := o.orgs.GetMembership(caller.OrgId, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !o.checkNotLastAdmin(c, membership) {
+			return
+		}
+
+		err = o.orgs.RemoveMember(caller.OrgId, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if !o.revokeMemberTokens(c, caller.OrgId, c.Param("id")) {
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": c.Param("id")})
+	}
+}
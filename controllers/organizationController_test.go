@@ -0,0 +1,244 @@
+package controllers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/migrations"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/notifier"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// newTestStores migrates a scratch SQLite database. The memory repository
+// has no role store, which the permission checks need.
+func newTestStores(t *testing.T) repository.Stores {
+	t.Helper()
+	db, err := repository.Open(repository.SQLite, "file:"+filepath.Join(t.TempDir(), "account.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return repository.NewStores(db)
+}
+
+// serveAs runs handler for a request made with the caller's token.
+func serveAs(handler gin.HandlerFunc, caller *helpers.SignedDetails, method string, route string, path string, body interface{}) *httptest.ResponseRecorder {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Handle(method, route, func(c *gin.Context) {
+		helpers.SetClaims(c, caller)
+	}, handler)
+
+	var payload []byte
+	if body != nil {
+		payload, _ = json.Marshal(body)
+	}
+	req := httptest.NewRequest(method, path, bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// memberClaims are the claims of a token the user got for the organization
+// a minute ago.
+func memberClaims(userId string, orgId string, permissions ...string) *helpers.SignedDetails {
+	issuedAt := time.Now().Add(-time.Minute)
+	return &helpers.SignedDetails{
+		Uid:           userId,
+		OrgId:         orgId,
+		Permissions:   permissions,
+		IssuedAtMicro: issuedAt.UnixMicro(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       uuid.NewString(),
+			IssuedAt: jwt.NewNumericDate(issuedAt),
+		},
+	}
+}
+
+type orgTest struct {
+	stores  repository.Stores
+	orgs    OrganizationController
+	orgId   string
+	ownerId string
+}
+
+func newOrgTest(t *testing.T) orgTest {
+	t.Helper()
+	stores := newTestStores(t)
+	ownerId := createTestUser(t, stores.Users, "owner@example.com", "x")
+	orgId, err := stores.Organizations.CreateOrganization(models.Organization{Name: "Acme"}, ownerId, repository.RoleOrgAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	orgs := NewOrganizationController(stores.Organizations, stores.Roles, stores.Users, stores.Revocations, notifier.NewLogNotifier(), time.Hour)
+	return orgTest{stores: stores, orgs: orgs, orgId: orgId, ownerId: ownerId}
+}
+
+func (o orgTest) addMember(t *testing.T, email string, role string) string {
+	t.Helper()
+	userId := createTestUser(t, o.stores.Users, email, "x")
+	if err := o.stores.Organizations.AddMember(o.orgId, userId, role); err != nil {
+		t.Fatal(err)
+	}
+	return userId
+}
+
+func (o orgTest) setRole(caller *helpers.SignedDetails, userId string, role string) int {
+	w := serveAs(o.orgs.AddMember(), caller, "POST", "/members", "/members", addMemberRequest{UserId: userId, Role: role})
+	return w.Code
+}
+
+func (o orgTest) remove(caller *helpers.SignedDetails, userId string) int {
+	w := serveAs(o.orgs.RemoveMember(), caller, "DELETE", "/members/:id", "/members/"+userId, nil)
+	return w.Code
+}
+
+func (o orgTest) role(t *testing.T, userId string) string {
+	t.Helper()
+	membership, err := o.stores.Organizations.GetMembership(o.orgId, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return membership.Role
+}
+
+func TestAddMemberChangesRoles(t *testing.T) {
+	o := newOrgTest(t)
+	memberId := o.addMember(t, "member@example.com", repository.RoleUser)
+	owner := memberClaims(o.ownerId, o.orgId, repository.PermissionUsersRead, repository.PermissionUsersWrite, repository.PermissionClientsRead, repository.PermissionClientsWrite)
+
+	// The only ORG_ADMIN can't step down
+	if code := o.setRole(owner, o.ownerId, repository.RoleUser); code != http.StatusConflict {
+		t.Errorf("demoting the last ORG_ADMIN returned %d, want %d", code, http.StatusConflict)
+	}
+
+	memberToken := memberClaims(memberId, o.orgId)
+	if code := o.setRole(owner, memberId, repository.RoleOrgAdmin); code != http.StatusOK {
+		t.Fatalf("promoting a member returned %d", code)
+	}
+	if o.role(t, memberId) != repository.RoleOrgAdmin {
+		t.Errorf("the member wasn't promoted")
+	}
+	if msg := helpers.CheckRevoked(o.stores.Revocations, memberToken); msg == "" {
+		t.Errorf("the member's token from before the role change is still accepted")
+	}
+
+	// Changing an ORG_ADMIN takes ORG_ADMIN's permissions, even to make them
+	// a USER, which the caller could grant
+	userAdmin := memberClaims(uuid.NewString(), o.orgId, repository.PermissionUsersRead, repository.PermissionUsersWrite)
+	if code := o.setRole(userAdmin, memberId, repository.RoleUser); code != http.StatusForbidden {
+		t.Errorf("demoting an ORG_ADMIN without their permissions returned %d, want %d", code, http.StatusForbidden)
+	}
+
+	// With a second ORG_ADMIN, the owner can step down
+	if code := o.setRole(owner, o.ownerId, repository.RoleUser); code != http.StatusOK {
+		t.Errorf("demoting one of two ORG_ADMINs returned %d", code)
+	}
+	if helpers.CheckRevoked(o.stores.Revocations, owner) == "" {
+		t.Errorf("the owner's token from before stepping down is still accepted")
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	o := newOrgTest(t)
+	memberId := o.addMember(t, "member@example.com", repository.RoleUser)
+	owner := memberClaims(o.ownerId, o.orgId, repository.PermissionUsersRead, repository.PermissionUsersWrite)
+
+	if code := o.remove(memberClaims(o.ownerId, "", repository.PermissionUsersWrite), memberId); code != http.StatusForbidden {
+		t.Errorf("removing without acting in an organization returned %d, want %d", code, http.StatusForbidden)
+	}
+	if code := o.remove(owner, o.ownerId); code != http.StatusConflict {
+		t.Errorf("removing the last ORG_ADMIN returned %d, want %d", code, http.StatusConflict)
+	}
+
+	otherOrgId, err := o.stores.Organizations.CreateOrganization(models.Organization{Name: "Globex"}, memberId, repository.RoleOrgAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acmeToken, globexToken := memberClaims(memberId, o.orgId), memberClaims(memberId, otherOrgId)
+	if code := o.remove(owner, memberId); code != http.StatusOK {
+		t.Fatalf("removing a member returned %d", code)
+	}
+	if _, err := o.stores.Organizations.GetMembership(o.orgId, memberId); err == nil {
+		t.Errorf("the member is still a member")
+	}
+	if helpers.CheckRevoked(o.stores.Revocations, acmeToken) == "" {
+		t.Errorf("the removed member's token for the organization is still accepted")
+	}
+	if msg := helpers.CheckRevoked(o.stores.Revocations, globexToken); msg != "" {
+		t.Errorf("the removed member's token for another organization was revoked: %s", msg)
+	}
+	if code := o.remove(owner, memberId); code != http.StatusNotFound {
+		t.Errorf("removing a non-member returned %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestUsersOfAnotherOrganizationStayHidden(t *testing.T) {
+	o := newOrgTest(t)
+	memberId := o.addMember(t, "member@example.com", repository.RoleUser)
+	outsiderId := createTestUser(t, o.stores.Users, "outsider@example.com", "x")
+	if _, err := o.stores.Organizations.CreateOrganization(models.Organization{Name: "Globex"}, outsiderId, repository.RoleOrgAdmin); err != nil {
+		t.Fatal(err)
+	}
+	users := UserController{userRepo: o.stores.Users}
+
+	listed := func(caller *helpers.SignedDetails) map[string]bool {
+		t.Helper()
+		w := serveAs(users.GetUsers(), caller, "GET", "/users", "/users", nil)
+		var res struct {
+			Data []models.User `json:"data"`
+		}
+		if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &res) != nil {
+			t.Fatalf("GetUsers = %d %s", w.Code, w.Body.String())
+		}
+		ids := map[string]bool{}
+		for _, user := range res.Data {
+			if user.Password != nil {
+				t.Errorf("GetUsers returned the password of %s", user.UserId)
+			}
+			ids[user.UserId] = true
+		}
+		return ids
+	}
+
+	owner := memberClaims(o.ownerId, o.orgId, repository.PermissionUsersRead)
+	if ids := listed(owner); len(ids) != 2 || !ids[o.ownerId] || !ids[memberId] {
+		t.Errorf("GetUsers in the organization = %v, want its two members", ids)
+	}
+	if ids := listed(memberClaims(o.ownerId, "", repository.PermissionUsersRead)); len(ids) != 0 {
+		t.Errorf("GetUsers outside any organization = %v, want nobody", ids)
+	}
+
+	get := func(caller *helpers.SignedDetails, userId string) int {
+		return serveAs(users.GetUser(), caller, "GET", "/users/:id", "/users/"+userId, nil).Code
+	}
+	if code := get(owner, memberId); code != http.StatusOK {
+		t.Errorf("GetUser of a member = %d, want %d", code, http.StatusOK)
+	}
+	if code := get(owner, outsiderId); code != http.StatusNotFound {
+		t.Errorf("GetUser of another organization's user = %d, want %d", code, http.StatusNotFound)
+	}
+	if code := get(memberClaims(outsiderId, ""), outsiderId); code != http.StatusOK {
+		t.Errorf("GetUser of oneself outside any organization = %d, want %d", code, http.StatusOK)
+	}
+}
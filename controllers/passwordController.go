@@ -0,0 +1,222 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/notifier"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// forgotPasswordResponse is the same whether or not the email belongs to a
+// user, so the endpoint can't be used to find out who has an account.
+const forgotPasswordResponse = "if the email belongs to an account, a reset token has been sent to it"
+
+type PasswordController struct {
+	userRepo    repository.UserRepository
+	resets      repository.PasswordResetStore
+	revocations repository.RevocationStore
+	families    repository.RefreshTokenStore
+	notifier    notifier.Notifier
+	rules       PasswordRules
+	resetTTL    time.Duration
+}
+
+func NewPasswordController(repo repository.UserRepository, resets repository.PasswordResetStore, revocations repository.RevocationStore, families repository.RefreshTokenStore, notifier notifier.Notifier, rules PasswordRules, resetTTL time.Duration) PasswordController {
+	return PasswordController{userRepo: repo, resets: resets, revocations: revocations, families: families, notifier: notifier, rules: rules, resetTTL: resetTTL}
+}
+
+type forgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+// ForgotPassword sends a single use reset token to the user.
+func (p PasswordController) ForgotPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req forgotPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := p.userRepo.GetUserByEmail(req.Email)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusOK, gin.H{"data": forgotPasswordResponse})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		token, tokenHash, err := helpers.NewSecretToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err := p.resets.CreatePasswordReset(user.UserId, tokenHash, time.Now().Add(p.resetTTL)); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		err = p.notifier.Notify(notifier.Message{
+			Kind:    "password_reset",
+			To:      *user.Email,
+			Subject: "Reset your password",
+			Body:    fmt.Sprintf("Use this token to reset your password: %s\nIt expires in %s. If you didn't ask for it, ignore this message.", token, p.resetTTL),
+			Token:   token,
+		})
+		if err != nil {
+			// Still answer like for any other email
+			log.Printf("Error %s when sending password reset to user %s", err, user.UserId)
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": forgotPasswordResponse})
+	}
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+// ResetPassword sets a new password with a token from ForgotPassword, and
+// signs the user out everywhere.
+func (p PasswordController) ResetPassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req resetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The token is only used up once the new password is accepted, so
+		// users can try again after a policy violation
+		tokenHash := helpers.HashSecretToken(req.Token)
+		userId, err := p.resets.GetPasswordReset(tokenHash)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		user, err := p.userRepo.GetUser(userId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		hashedPassword, ok := p.acceptPassword(c, req.Password, user)
+		if !ok {
+			return
+		}
+
+		if _, err := p.resets.ConsumePasswordReset(tokenHash); errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid or expired reset token"})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		p.replacePassword(c, user, hashedPassword, "password has been reset")
+	}
+}
+
+type changePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required"`
+}
+
+// ChangePassword sets a new password for the caller, who has to know the
+// current one, and signs them out everywhere.
+func (p PasswordController) ChangePassword() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req changePasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		caller, _ := helpers.GetClaims(c)
+		user, err := p.userRepo.GetUser(caller.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		if ok, _, _ := helpers.VerifyPassword(*user.Password, req.CurrentPassword); !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "current password is incorrect"})
+			return
+		}
+
+		hashedPassword, ok := p.acceptPassword(c, req.NewPassword, user)
+		if !ok {
+			return
+		}
+		p.replacePassword(c, user, hashedPassword, "password has been changed")
+	}
+}
+
+// acceptPassword checks password against the rules and hashes it. When it
+// can't be used the response has been written.
+func (p PasswordController) acceptPassword(c *gin.Context, password string, user models.User) (string, bool) {
+	violations, err := p.rules.check(password, user)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	if len(violations) > 0 {
+		rejectPassword(c, violations)
+		return "", false
+	}
+
+	hashedPassword, err := helpers.HashPassword(password)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return "", false
+	}
+	return hashedPassword, true
+}
+
+// replacePassword stores the new hash, remembers the old one and signs the
+// user out everywhere.
+func (p PasswordController) replacePassword(c *gin.Context, user models.User, hashedPassword string, message string) {
+	if err := p.userRepo.UpdatePassword(user.UserId, hashedPassword); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	p.rules.remember(user.UserId, user.Password)
+
+	// Whoever knew the old password may still hold tokens
+	if err := revokeAllTokens(p.families, p.revocations, user.UserId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": message})
+}
@@ -0,0 +1,103 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/notifier"
+	"github.com/MoulieshN/Go-JWT-Project.git/passwordpolicy"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+)
+
+// sentMessages keeps what was sent, instead of sending it.
+type sentMessages struct {
+	messages []notifier.Message
+}
+
+func (s *sentMessages) Notify(message notifier.Message) error {
+	s.messages = append(s.messages, message)
+	return nil
+}
+
+// lastToken returns the token of the last message sent.
+func (s *sentMessages) lastToken(t *testing.T) string {
+	t.Helper()
+	if len(s.messages) == 0 {
+		t.Fatal("no message was sent")
+	}
+	return s.messages[len(s.messages)-1].Token
+}
+
+type resetTest struct {
+	repo      *repository.MemoryRepository
+	passwords PasswordController
+	sent      *sentMessages
+	userId    string
+}
+
+func newResetTest(t *testing.T, resetTTL time.Duration) resetTest {
+	t.Helper()
+	repo := repository.NewMemoryRepository()
+	sent := &sentMessages{}
+	rules := NewPasswordRules(passwordpolicy.Policy{MinLength: 12}, repo)
+	passwords := NewPasswordController(repo, repo, repository.NewMemoryRevocationStore(), repo, sent, rules, resetTTL)
+	userId := createTestUser(t, repo, "ann@example.com", bcryptHash(t, "correct horse"))
+	return resetTest{repo: repo, passwords: passwords, sent: sent, userId: userId}
+}
+
+// forgot asks for a reset token for the user and returns it.
+func (r resetTest) forgot(t *testing.T) string {
+	t.Helper()
+	w := serveAs(r.passwords.ForgotPassword(), nil, "POST", "/forgot-password", "/forgot-password", forgotPasswordRequest{Email: "ann@example.com"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("ForgotPassword returned %d: %s", w.Code, w.Body)
+	}
+	return r.sent.lastToken(t)
+}
+
+func (r resetTest) reset(token string, password string) int {
+	return serveAs(r.passwords.ResetPassword(), nil, "POST", "/reset-password", "/reset-password", resetPasswordRequest{Token: token, Password: password}).Code
+}
+
+func TestResetTokenIsSingleUse(t *testing.T) {
+	r := newResetTest(t, time.Hour)
+	token := r.forgot(t)
+
+	// A refused password leaves the token for another try
+	if code := r.reset(token, "short"); code != http.StatusBadRequest {
+		t.Errorf("reset to a too short password returned %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := r.reset(token, "battery staple"); code != http.StatusOK {
+		t.Fatalf("reset returned %d, want %d", code, http.StatusOK)
+	}
+	if ok, _, _ := helpers.VerifyPassword(storedHash(t, r.repo, r.userId), "battery staple"); !ok {
+		t.Error("the new password doesn't verify")
+	}
+
+	if code := r.reset(token, "another battery"); code != http.StatusBadRequest {
+		t.Errorf("reusing the token returned %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := r.reset(token+"x", "another battery"); code != http.StatusBadRequest {
+		t.Errorf("an unknown token returned %d, want %d", code, http.StatusBadRequest)
+	}
+}
+
+func TestExpiredResetToken(t *testing.T) {
+	r := newResetTest(t, -time.Minute)
+	if code := r.reset(r.forgot(t), "battery staple"); code != http.StatusBadRequest {
+		t.Errorf("an expired token returned %d, want %d", code, http.StatusBadRequest)
+	}
+	if ok, _, _ := helpers.VerifyPassword(storedHash(t, r.repo, r.userId), "correct horse"); !ok {
+		t.Error("an expired token changed the password")
+	}
+}
+
+func TestForgotPasswordOfUnknownEmail(t *testing.T) {
+	r := newResetTest(t, time.Hour)
+	w := serveAs(r.passwords.ForgotPassword(), nil, "POST", "/forgot-password", "/forgot-password", forgotPasswordRequest{Email: "bob@example.com"})
+	if w.Code != http.StatusOK || len(r.sent.messages) != 0 {
+		t.Errorf("ForgotPassword of an unknown email returned %d and sent %d messages", w.Code, len(r.sent.messages))
+	}
+}
@@ -0,0 +1,76 @@
+package controllers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/passwordpolicy"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+// PasswordRules checks new passwords against the password policy and the
+// user's earlier passwords.
+type PasswordRules struct {
+	policy  passwordpolicy.Policy
+	history repository.PasswordHistoryStore
+}
+
+func NewPasswordRules(policy passwordpolicy.Policy, history repository.PasswordHistoryStore) PasswordRules {
+	return PasswordRules{policy: policy, history: history}
+}
+
+// check returns the rules password breaks as the new password of user. Users
+// who are still signing up have no UserId, and so no history.
+func (r PasswordRules) check(password string, user models.User) ([]passwordpolicy.Violation, error) {
+	var personalInfo []string
+	for _, info := range []*string{user.Email, user.FirstName, user.LastName} {
+		if info != nil {
+			personalInfo = append(personalInfo, *info)
+		}
+	}
+	violations, err := r.policy.Check(password, personalInfo...)
+	if err != nil {
+		return nil, err
+	}
+	if user.UserId == "" || r.policy.HistoryDepth < 1 {
+		return violations, nil
+	}
+
+	var hashes []string
+	if user.Password != nil {
+		hashes = append(hashes, *user.Password)
+	}
+	if r.policy.HistoryDepth > 1 {
+		earlier, err := r.history.GetPasswordHistory(user.UserId, r.policy.HistoryDepth-1)
+		if err != nil {
+			return nil, err
+		}
+		hashes = append(hashes, earlier...)
+	}
+	for _, hash := range hashes {
+		if reused, _, _ := helpers.VerifyPassword(hash, password); reused {
+			violations = append(violations, r.policy.Reused())
+			break
+		}
+	}
+	return violations, nil
+}
+
+// remember keeps the hash a user just replaced, for as long as the policy
+// looks back.
+func (r PasswordRules) remember(userId string, replaced *string) {
+	if replaced == nil || r.policy.HistoryDepth < 2 {
+		return
+	}
+	if err := r.history.AddPasswordHistory(userId, *replaced, r.policy.HistoryDepth-1); err != nil {
+		log.Printf("Error %s when recording the password history of user %s", err, userId)
+	}
+}
+
+// rejectPassword answers with every rule a new password breaks.
+func rejectPassword(c *gin.Context, violations []passwordpolicy.Violation) {
+	c.JSON(http.StatusBadRequest, gin.H{"error": "password does not meet the password policy", "violations": violations})
+}
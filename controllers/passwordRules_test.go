@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"testing"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/passwordpolicy"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func bcryptHash(t *testing.T, password string) string {
+	t.Helper()
+	hash, err := helpers.BcryptHasher{Cost: bcrypt.MinCost}.Hash(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
+
+// changePasswords takes the user through the passwords in order, the way
+// ChangePassword does, and returns the user as they end up.
+func changePasswords(t *testing.T, rules PasswordRules, repo repository.UserRepository, userId string, passwords ...string) models.User {
+	t.Helper()
+	for _, password := range passwords {
+		user, err := repo.GetUser(userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := repo.UpdatePassword(userId, bcryptHash(t, password)); err != nil {
+			t.Fatal(err)
+		}
+		rules.remember(userId, user.Password)
+	}
+	user, err := repo.GetUser(userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+func isReused(t *testing.T, rules PasswordRules, password string, user models.User) bool {
+	t.Helper()
+	violations, err := rules.check(password, user)
+	if err != nil {
+		t.Fatalf("check(%q): %v", password, err)
+	}
+	for _, v := range violations {
+		if v.Code == passwordpolicy.CodeReused {
+			return true
+		}
+	}
+	return false
+}
+
+func codes(violations []passwordpolicy.Violation) []string {
+	var codes []string
+	for _, v := range violations {
+		codes = append(codes, v.Code)
+	}
+	return codes
+}
+
+func TestPasswordRulesHistoryDepth(t *testing.T) {
+	for _, tt := range []struct {
+		depth  int
+		reused []string
+		fresh  []string
+	}{
+		// Passwords went p1, p2, p3, p4, p5 with p5 current
+		{0, nil, []string{"p5", "p4", "p1"}},
+		{1, []string{"p5"}, []string{"p4", "p1"}},
+		{3, []string{"p5", "p4", "p3"}, []string{"p2", "p1"}},
+		{5, []string{"p5", "p4", "p3", "p2", "p1"}, []string{"p6"}},
+	} {
+		repo := repository.NewMemoryRepository()
+		rules := NewPasswordRules(passwordpolicy.Policy{HistoryDepth: tt.depth}, repo)
+		userId := createTestUser(t, repo, "ann@example.com", bcryptHash(t, "p1"))
+		user := changePasswords(t, rules, repo, userId, "p2", "p3", "p4", "p5")
+
+		for _, password := range tt.reused {
+			if !isReused(t, rules, password, user) {
+				t.Errorf("depth %d: %s was allowed, want it refused as reused", tt.depth, password)
+			}
+		}
+		for _, password := range tt.fresh {
+			if isReused(t, rules, password, user) {
+				t.Errorf("depth %d: %s was refused as reused, want it allowed", tt.depth, password)
+			}
+		}
+	}
+}
+
+func TestPasswordRulesRememberKeepsDepth(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	rules := NewPasswordRules(passwordpolicy.Policy{HistoryDepth: 3}, repo)
+	userId := createTestUser(t, repo, "ann@example.com", bcryptHash(t, "p1"))
+	changePasswords(t, rules, repo, userId, "p2", "p3", "p4", "p5")
+
+	// The current hash is on the user, so only depth-1 earlier ones are kept
+	history, err := repo.GetPasswordHistory(userId, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("history has %d hashes, want 2", len(history))
+	}
+	for i, password := range []string{"p4", "p3"} {
+		if ok, _, _ := helpers.VerifyPassword(history[i], password); !ok {
+			t.Errorf("history[%d] isn't %s", i, password)
+		}
+	}
+}
+
+func TestPasswordRulesSignUp(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	rules := NewPasswordRules(passwordpolicy.Policy{MinLength: 8, RejectPersonalInfo: true, HistoryDepth: 5}, repo)
+
+	// Users signing up have no ID and no history, the other rules still apply
+	email, firstName := "ann@example.com", "Annabel"
+	violations, err := rules.check("annabel", models.User{Email: &email, FirstName: &firstName})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := codes(violations); len(got) != 2 || got[0] != passwordpolicy.CodeTooShort || got[1] != passwordpolicy.CodePersonalInfo {
+		t.Errorf("check at sign up = %v, want [%s %s]", got, passwordpolicy.CodeTooShort, passwordpolicy.CodePersonalInfo)
+	}
+}
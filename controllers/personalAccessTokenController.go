@@ -0,0 +1,247 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// lastUsedPrecision is how stale the recorded last use of a personal access
+// token may get, so busy tokens don't cost a write on every request.
+const lastUsedPrecision = time.Minute
+
+type PersonalAccessTokenController struct {
+	userRepo    repository.UserRepository
+	tokens      repository.PersonalAccessTokenStore
+	roles       repository.RoleStore
+	orgs        repository.OrganizationStore
+	revocations repository.RevocationStore
+}
+
+func NewPersonalAccessTokenController(repo repository.UserRepository, tokens repository.PersonalAccessTokenStore, roles repository.RoleStore, orgs repository.OrganizationStore, revocations repository.RevocationStore) PersonalAccessTokenController {
+	return PersonalAccessTokenController{userRepo: repo, tokens: tokens, roles: roles, orgs: orgs, revocations: revocations}
+}
+
+type createPersonalAccessTokenRequest struct {
+	Name      string     `json:"name" validate:"required,max=100"`
+	Scopes    []string   `json:"scopes" validate:"required,min=1,dive,required"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// createdPersonalAccessToken is the only response that ever holds the token
+// itself.
+type createdPersonalAccessToken struct {
+	models.PersonalAccessToken
+	Token string `json:"token"`
+}
+
+// listedPersonalAccessToken tells whether a token still works. A logout-all
+// or a change of the user's membership revokes the tokens created before it,
+// which are listed until the user deletes them.
+type listedPersonalAccessToken struct {
+	models.PersonalAccessToken
+	Revoked bool `json:"revoked"`
+}
+
+// CreateToken issues a personal access token acting in the caller's current
+// organization. Its scopes must be permissions the caller has.
+func (p PersonalAccessTokenController) CreateToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req createPersonalAccessTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		now := time.Now()
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(now) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "expires_at must be in the future"})
+			return
+		}
+
+		caller, _ := helpers.GetClaims(c)
+		scopes, err := grantableScopes(caller, req.Scopes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		token, tokenHash, err := helpers.NewPersonalAccessToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		pat := models.PersonalAccessToken{
+			UserId:    caller.Uid,
+			OrgId:     caller.OrgId,
+			Name:      req.Name,
+			Scopes:    scopes,
+			ExpiresAt: req.ExpiresAt,
+			CreatedAt: now.UTC().Truncate(time.Microsecond),
+		}
+		pat.TokenId, err = p.tokens.CreatePersonalAccessToken(pat, tokenHash)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"data": createdPersonalAccessToken{PersonalAccessToken: pat, Token: token}})
+	}
+}
+
+// grantableScopes returns requested without duplicates, as long as the
+// caller holds every one of those permissions and so can hand them on.
+func grantableScopes(caller *helpers.SignedDetails, requested []string) ([]string, error) {
+	scopes := []string{}
+	seen := map[string]bool{}
+	for _, scope := range requested {
+		if !caller.HasPermission(scope) {
+			return nil, fmt.Errorf("scope %s is not one of your permissions", scope)
+		}
+		if !seen[scope] {
+			seen[scope] = true
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes, nil
+}
+
+// GetTokens lists the caller's personal access tokens, without the tokens
+// themselves.
+func (p PersonalAccessTokenController) GetTokens() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		tokens, err := p.tokens.GetPersonalAccessTokens(caller.Uid)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		listed := make([]listedPersonalAccessToken, 0, len(tokens))
+		for _, pat := range tokens {
+			revoked, err := helpers.IsRevoked(p.revocations, personalTokenClaims(pat))
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			listed = append(listed, listedPersonalAccessToken{PersonalAccessToken: pat, Revoked: revoked})
+		}
+		c.JSON(http.StatusOK, gin.H{"data": listed})
+	}
+}
+
+// RevokeToken deletes one of the caller's personal access tokens. It stops
+// working right away.
+func (p PersonalAccessTokenController) RevokeToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		err := p.tokens.DeletePersonalAccessToken(caller.Uid, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "token not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": c.Param("id")})
+	}
+}
+
+// ResolvePersonalToken implements middleware.PersonalTokenResolver. The
+// permissions are worked out on every request, so a token never grants more
+// than its user currently has, and records when the token was used.
+func (p PersonalAccessTokenController) ResolvePersonalToken(token string) (*helpers.SignedDetails, string) {
+	pat, err := p.tokens.GetPersonalAccessToken(helpers.HashSecretToken(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "The token is invalid"
+	}
+	if err != nil {
+		return nil, "Unable to verify the token"
+	}
+
+	now := time.Now()
+	if pat.ExpiresAt != nil && !now.Before(*pat.ExpiresAt) {
+		return nil, "The token is expired"
+	}
+
+	user, err := p.userRepo.GetUser(pat.UserId)
+	if err != nil {
+		return nil, "Unable to verify the token"
+	}
+
+	var membership models.Membership
+	if pat.OrgId != "" {
+		membership, err = p.orgs.GetMembership(pat.OrgId, pat.UserId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "The token's user has left its organization"
+		}
+		if err != nil {
+			return nil, "Unable to verify the token"
+		}
+	}
+
+	permissions, err := userPermissions(p.roles, user, membership)
+	if err != nil {
+		log.Printf("Error %s when getting permissions of user %s", err, user.UserId)
+		return nil, "Unable to verify the token"
+	}
+	granted := []string{}
+	for _, scope := range pat.Scopes {
+		for _, permission := range permissions {
+			if scope == permission {
+				granted = append(granted, scope)
+				break
+			}
+		}
+	}
+
+	if pat.LastUsedAt == nil || now.Sub(*pat.LastUsedAt) >= lastUsedPrecision {
+		if err := p.tokens.TouchPersonalAccessToken(pat.TokenId, now); err != nil {
+			log.Printf("Error %s when recording use of personal access token %s", err, pat.TokenId)
+		}
+	}
+
+	claims := personalTokenClaims(pat)
+	claims.Email = *user.Email
+	claims.FirstName = *user.FirstName
+	claims.LastName = *user.LastName
+	claims.UserType = *user.UserType
+	claims.Permissions = granted
+	return claims, ""
+}
+
+// personalTokenClaims are the claims a personal access token is checked
+// against revocations with. The token ID stands in for the jti and the
+// creation time for iat, so revocations apply to personal access tokens like
+// to any other token. Tokens that never expire have no exp.
+func personalTokenClaims(pat models.PersonalAccessToken) *helpers.SignedDetails {
+	claims := &helpers.SignedDetails{
+		Uid:           pat.UserId,
+		TokenType:     helpers.PersonalTokenType,
+		OrgId:         pat.OrgId,
+		IssuedAtMicro: pat.CreatedAt.UnixMicro(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       pat.TokenId,
+			IssuedAt: jwt.NewNumericDate(pat.CreatedAt),
+		},
+	}
+	if pat.ExpiresAt != nil {
+		claims.ExpiresAt = jwt.NewNumericDate(*pat.ExpiresAt)
+	}
+	return claims
+}
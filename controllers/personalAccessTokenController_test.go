@@ -0,0 +1,117 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+)
+
+type patTest struct {
+	orgTest
+	tokens PersonalAccessTokenController
+	owner  *helpers.SignedDetails
+}
+
+func newPATTest(t *testing.T) patTest {
+	t.Helper()
+	o := newOrgTest(t)
+	tokens := NewPersonalAccessTokenController(o.stores.Users, o.stores.PersonalAccessTokens, o.stores.Roles, o.stores.Organizations, o.stores.Revocations)
+	owner := memberClaims(o.ownerId, o.orgId, repository.PermissionUsersRead, repository.PermissionUsersWrite)
+	return patTest{orgTest: o, tokens: tokens, owner: owner}
+}
+
+func (p patTest) create(t *testing.T, scopes ...string) string {
+	t.Helper()
+	w := serveAs(p.tokens.CreateToken(), p.owner, "POST", "/tokens", "/tokens", createPersonalAccessTokenRequest{Name: "ci", Scopes: scopes})
+	if w.Code != http.StatusCreated {
+		t.Fatalf("CreateToken returned %d: %s", w.Code, w.Body)
+	}
+	var res struct {
+		Data createdPersonalAccessToken `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	return res.Data.Token
+}
+
+// listed returns whether each of the owner's tokens is revoked, as listed.
+func (p patTest) listed(t *testing.T) []bool {
+	t.Helper()
+	w := serveAs(p.tokens.GetTokens(), p.owner, "GET", "/tokens", "/tokens", nil)
+	var res struct {
+		Data []listedPersonalAccessToken `json:"data"`
+	}
+	if err := json.Unmarshal(w.Body.Bytes(), &res); err != nil {
+		t.Fatal(err)
+	}
+	revoked := []bool{}
+	for _, token := range res.Data {
+		revoked = append(revoked, token.Revoked)
+	}
+	return revoked
+}
+
+func TestResolvePersonalToken(t *testing.T) {
+	p := newPATTest(t)
+	token := p.create(t, repository.PermissionUsersRead)
+
+	claims, msg := p.tokens.ResolvePersonalToken(token)
+	if msg != "" {
+		t.Fatalf("ResolvePersonalToken: %s", msg)
+	}
+	if claims.Uid != p.ownerId || claims.OrgId != p.orgId || claims.TokenType != helpers.PersonalTokenType || claims.ExpiresAt != nil {
+		t.Errorf("resolved claims %+v", claims)
+	}
+	if len(claims.Permissions) != 1 || claims.Permissions[0] != repository.PermissionUsersRead {
+		t.Errorf("resolved permissions %v, want only the token's scope", claims.Permissions)
+	}
+
+	// Scopes only grant what the user still has
+	if err := p.stores.Organizations.AddMember(p.orgId, p.ownerId, repository.RoleUser); err != nil {
+		t.Fatal(err)
+	}
+	if claims, _ := p.tokens.ResolvePersonalToken(token); len(claims.Permissions) != 0 {
+		t.Errorf("permissions after losing ORG_ADMIN %v, want none", claims.Permissions)
+	}
+
+	if _, msg := p.tokens.ResolvePersonalToken(token + "x"); msg != "The token is invalid" {
+		t.Errorf("ResolvePersonalToken of an unknown token = %q", msg)
+	}
+}
+
+func TestPersonalTokensAfterLogoutAll(t *testing.T) {
+	p := newPATTest(t)
+	token := p.create(t, repository.PermissionUsersRead)
+	claims, msg := p.tokens.ResolvePersonalToken(token)
+	if msg != "" {
+		t.Fatalf("ResolvePersonalToken: %s", msg)
+	}
+
+	// Logout is for sessions, a personal access token never expires
+	users := &UserController{revocations: p.stores.Revocations, families: p.stores.RefreshTokens}
+	if w := serveAs(users.Logout(), claims, "POST", "/logout", "/logout", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Logout with a personal access token returned %d, want %d", w.Code, http.StatusBadRequest)
+	}
+
+	if err := revokeAllTokens(p.stores.RefreshTokens, p.stores.Revocations, p.ownerId); err != nil {
+		t.Fatal(err)
+	}
+	if helpers.CheckRevoked(p.stores.Revocations, claims) == "" {
+		t.Errorf("a personal access token from before the logout-all is still accepted")
+	}
+
+	// Likely in the same second as the logout-all
+	newClaims, _ := p.tokens.ResolvePersonalToken(p.create(t, repository.PermissionUsersRead))
+	if msg := helpers.CheckRevoked(p.stores.Revocations, newClaims); msg != "" {
+		t.Errorf("a personal access token from after the logout-all was refused: %s", msg)
+	}
+
+	// Newest first
+	if revoked := p.listed(t); len(revoked) != 2 || revoked[0] || !revoked[1] {
+		t.Errorf("listed tokens revoked = %v, want [false true]", revoked)
+	}
+}
@@ -0,0 +1,195 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type RoleController struct {
+	roles repository.RoleStore
+}
+
+func NewRoleController(roles repository.RoleStore) RoleController {
+	return RoleController{roles: roles}
+}
+
+// roleError maps repository errors to a response. Unknown roles, permissions
+// or users show up either as sql.ErrNoRows or as a foreign key violation.
+func roleError(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		c.JSON(http.StatusNotFound, gin.H{"error": "not found"})
+	case errors.Is(err, repository.ErrBuiltInRole):
+		c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	}
+}
+
+func (r RoleController) GetRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roles, err := r.roles.GetRoles()
+		if err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": roles})
+	}
+}
+
+func (r RoleController) GetRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		role, err := r.roles.GetRole(c.Param("name"))
+		if err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": role})
+	}
+}
+
+func (r RoleController) CreateRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var role models.Role
+		if err := c.ShouldBindJSON(&role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(role); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := r.roles.CreateRole(role); err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"data": role})
+	}
+}
+
+func (r RoleController) DeleteRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := r.roles.DeleteRole(c.Param("name")); err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": c.Param("name")})
+	}
+}
+
+type rolePermissionsRequest struct {
+	Permissions []string `json:"permissions" validate:"required"`
+}
+
+// SetRolePermissions replaces the permissions a role grants.
+func (r RoleController) SetRolePermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req rolePermissionsRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := r.roles.SetRolePermissions(c.Param("name"), req.Permissions); err != nil {
+			roleError(c, err)
+			return
+		}
+
+		role, err := r.roles.GetRole(c.Param("name"))
+		if err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": role})
+	}
+}
+
+func (r RoleController) GetPermissions() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		permissions, err := r.roles.GetPermissions()
+		if err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": permissions})
+	}
+}
+
+func (r RoleController) CreatePermission() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var permission models.Permission
+		if err := c.ShouldBindJSON(&permission); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(permission); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := r.roles.CreatePermission(permission); err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, gin.H{"data": permission})
+	}
+}
+
+func (r RoleController) GetUserRoles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		roles, err := r.roles.GetUserRoles(c.Param("id"))
+		if err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": roles})
+	}
+}
+
+type assignRoleRequest struct {
+	Role string `json:"role" validate:"required"`
+}
+
+func (r RoleController) AssignRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req assignRoleRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := r.roles.AssignRole(c.Param("id"), req.Role); err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": req.Role})
+	}
+}
+
+func (r RoleController) UnassignRole() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := r.roles.UnassignRole(c.Param("id"), c.Param("role")); err != nil {
+			roleError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": c.Param("role")})
+	}
+}
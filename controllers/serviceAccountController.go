@@ -0,0 +1,128 @@
+package controllers
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+)
+
+type ServiceAccountController struct {
+	accounts    repository.ServiceAccountStore
+	revocations repository.RevocationStore
+}
+
+func NewServiceAccountController(accounts repository.ServiceAccountStore, revocations repository.RevocationStore) ServiceAccountController {
+	return ServiceAccountController{accounts: accounts, revocations: revocations}
+}
+
+// createdServiceAccount is the only response that ever holds the client
+// secret.
+type createdServiceAccount struct {
+	models.ServiceAccount
+	ClientSecret string `json:"client_secret"`
+}
+
+// CreateServiceAccount registers a service account in the caller's current
+// organization. Its scopes must be permissions the caller has. Accounts with
+// a certificate identity may also authenticate with client certificates
+// that have it, when the server asks for them. Whoever registers an identity
+// vouches for who holds its certificates, so that takes roles:write.
+func (s ServiceAccountController) CreateServiceAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var account models.ServiceAccount
+		if err := c.ShouldBindJSON(&account); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(account); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if account.CertificateIdentity != "" && !helpers.ValidCertificateIdentity(account.CertificateIdentity) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "certificate_identity must be a subject DN, or a SAN starting with dns:, uri:, email: or ip:"})
+			return
+		}
+
+		caller, _ := helpers.GetClaims(c)
+		if account.CertificateIdentity != "" && !caller.HasPermission(repository.PermissionRolesWrite) {
+			c.JSON(http.StatusForbidden, gin.H{"error": "registering a certificate identity takes " + repository.PermissionRolesWrite})
+			return
+		}
+		scopes, err := grantableScopes(caller, account.Scopes)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		secret, secretHash, err := helpers.NewSecretToken()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		account.SecretHash = secretHash
+		account.OrgId = caller.OrgId
+		account.Scopes = scopes
+		account.CreatedAt = time.Now().UTC().Truncate(time.Second)
+		account.ClientId, err = s.accounts.CreateServiceAccount(account)
+		if errors.Is(err, repository.ErrDuplicateCertificateIdentity) {
+			c.JSON(http.StatusConflict, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"data": createdServiceAccount{ServiceAccount: account, ClientSecret: secret}})
+	}
+}
+
+// GetServiceAccounts lists the service accounts of the caller's current
+// organization.
+func (s ServiceAccountController) GetServiceAccounts() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		accounts, err := s.accounts.GetServiceAccounts(caller.OrgId)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": accounts})
+	}
+}
+
+// DeleteServiceAccount removes a service account of the caller's current
+// organization, and revokes the tokens it still holds.
+func (s ServiceAccountController) DeleteServiceAccount() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		clientId := c.Param("id")
+		err := s.accounts.DeleteServiceAccount(caller.OrgId, clientId)
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "service account not found"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Client IDs take the place of user IDs in the revocation store,
+		// see SignedDetails.Principal
+		if err := s.revocations.RevokeUserTokens(clientId, time.Now()); err != nil {
+			log.Printf("Error %s when revoking the tokens of service account %s", err, clientId)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": clientId})
+	}
+}
@@ -0,0 +1,53 @@
+package controllers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+)
+
+func TestCreateServiceAccountCertificateIdentity(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	accounts := NewServiceAccountController(repo, repository.NewMemoryRevocationStore())
+	ownerId := createTestUser(t, repo, "owner@example.com", "x")
+	orgId, err := repo.CreateOrganization(models.Organization{Name: "Acme"}, ownerId, repository.RoleOrgAdmin)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	create := func(permissions []string, identity string) int {
+		caller := memberClaims(ownerId, orgId, permissions...)
+		account := models.ServiceAccount{Name: "billing", Scopes: []string{repository.PermissionUsersRead}, CertificateIdentity: identity}
+		return serveAs(accounts.CreateServiceAccount(), caller, "POST", "/service-accounts", "/service-accounts", account).Code
+	}
+
+	orgAdmin := []string{repository.PermissionUsersRead, repository.PermissionClientsWrite}
+	admin := append([]string{repository.PermissionRolesWrite}, orgAdmin...)
+	if code := create(orgAdmin, ""); code != http.StatusCreated {
+		t.Errorf("an account without a certificate identity = %d, want %d", code, http.StatusCreated)
+	}
+	if code := create(orgAdmin, "dns:billing.internal"); code != http.StatusForbidden {
+		t.Errorf("a certificate identity without roles:write = %d, want %d", code, http.StatusForbidden)
+	}
+	if code := create(admin, "dns:billing.internal"); code != http.StatusCreated {
+		t.Errorf("a certificate identity with roles:write = %d, want %d", code, http.StatusCreated)
+	}
+	if code := create(admin, "dns:billing.internal"); code != http.StatusConflict {
+		t.Errorf("a certificate identity taken in the organization = %d, want %d", code, http.StatusConflict)
+	}
+	if code := create(admin, "not an identity"); code != http.StatusBadRequest {
+		t.Errorf("a malformed certificate identity = %d, want %d", code, http.StatusBadRequest)
+	}
+
+	accountsOfOrg, err := repo.GetServiceAccounts(orgId)
+	if err != nil || len(accountsOfOrg) != 2 {
+		t.Fatalf("GetServiceAccounts = (%+v, %v), want two accounts", accountsOfOrg, err)
+	}
+	for _, account := range accountsOfOrg {
+		if account.OrgId != orgId || account.ClientId == "" {
+			t.Errorf("created account %+v", account)
+		}
+	}
+}
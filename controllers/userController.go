@@ -1,47 +1,65 @@
 package controllers
 
 import (
+	"database/sql"
+	"errors"
 	"log"
 	"net/http"
 	"strconv"
+	"sync"
+	"time"
 
 	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
 	"github.com/MoulieshN/Go-JWT-Project.git/models"
 	"github.com/MoulieshN/Go-JWT-Project.git/repository"
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
-	"golang.org/x/crypto/bcrypt"
+	"github.com/google/uuid"
 )
 
 var validate = validator.New()
 
-type UserController struct {
-	userRepo repository.UserRepository
-}
+var errNotMember = errors.New("user is not a member of the organization")
 
-func NewUserController(repo repository.UserRepository) UserController {
-	return UserController{userRepo: repo}
+// LoginSettings tune how Login treats users.
+type LoginSettings struct {
+	// UnverifiedLogin is one of the UnverifiedLogin constants
+	UnverifiedLogin string
+	// MFAPendingTTL is how long users with MFA have to enter their code
+	MFAPendingTTL time.Duration
 }
 
-func HashPassword(userPassword string) string {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(userPassword), 14)
-	if err != nil {
-		log.Panic(err)
-	}
-
-	return string(hashedPassword)
+type UserController struct {
+	userRepo          repository.UserRepository
+	roles             repository.RoleStore
+	orgs              repository.OrganizationStore
+	revocations       repository.RevocationStore
+	families          repository.RefreshTokenStore
+	dpop              helpers.DPoPVerifier
+	mfa               repository.MFAStore
+	emailVerification EmailVerificationController
+	guard             LoginGuard
+	passwords         PasswordRules
+	settings          LoginSettings
 }
 
-func VerfiyPassword(userPassword string, providedPassword string) (bool, string) {
-	err := bcrypt.CompareHashAndPassword([]byte(userPassword), []byte(providedPassword))
-	check := true
-	msg := ""
+func NewUserController(repo repository.UserRepository, roles repository.RoleStore, orgs repository.OrganizationStore, revocations repository.RevocationStore, families repository.RefreshTokenStore, dpop helpers.DPoPVerifier, mfa repository.MFAStore, emailVerification EmailVerificationController, guard LoginGuard, passwords PasswordRules, settings LoginSettings) UserController {
+	return UserController{userRepo: repo, roles: roles, orgs: orgs, revocations: revocations, families: families, dpop: dpop, mfa: mfa, emailVerification: emailVerification, guard: guard, passwords: passwords, settings: settings}
+}
 
+// dummyPasswordHash is checked against for emails nobody has.
+var dummyPasswordHash = sync.OnceValue(func() string {
+	hash, err := helpers.HashPassword("not the password of anyone")
 	if err != nil {
-		msg = "Email or password is incorrect"
-		check = false
+		log.Printf("Error %s when hashing the dummy password", err)
 	}
-	return check, msg
+	return hash
+})
+
+// withoutPassword keeps the password hash out of responses.
+func withoutPassword(user models.User) models.User {
+	user.Password = nil
+	return user
 }
 
 func (u *UserController) SignUp() gin.HandlerFunc {
@@ -52,13 +70,32 @@ func (u *UserController) SignUp() gin.HandlerFunc {
 			return
 		}
 
+		// Whatever user_type was sent, nobody signs themselves up as an
+		// admin. Admins are given the ADMIN role afterwards.
+		userType := repository.RoleUser
+		user.UserType = &userType
+
 		if err := validate.Struct(user); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		violations, err := u.passwords.check(*user.Password, user)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if len(violations) > 0 {
+			rejectPassword(c, violations)
+			return
+		}
+
 		// Converting password into hashed password for more security
-		hashedPassword := HashPassword(*user.Password)
+		hashedPassword, err := helpers.HashPassword(*user.Password)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
 		user.Password = &hashedPassword
 
 		// Save user in a db
@@ -68,40 +105,49 @@ func (u *UserController) SignUp() gin.HandlerFunc {
 			return
 		}
 
-		// Generate the token and refresh token
-		token, refreshToken, _ := helpers.GenerateAllTokens(*user.Email, *user.FirstName, *user.LastName, *user.UserType, userID)
-
-		// update the user with token and refresh token
-		err = u.userRepo.UpdateUserToken(token, refreshToken, userID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
+		// Tokens come from Login, which decides what an unverified user gets
+		user.UserId = userID
+		if err := u.emailVerification.sendVerification(user); err != nil {
+			log.Printf("Error %s when sending email verification to user %s", err, userID)
 		}
 
 		c.JSON(http.StatusOK, gin.H{"data": userID})
 	}
 }
 
+type loginRequest struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+	// OrgId picks the organization to act in, defaulting to the user's
+	// oldest membership
+	OrgId string `json:"org_id"`
+}
+
 func (u *UserController) Login() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var user models.User
+		var req loginRequest
 
-		if err := c.BindJSON(&user); err != nil {
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// Get the user by email
-		foundUser, err := u.userRepo.GetUserByEmail(*user.Email)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "email or password is incorrect"})
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		// check the login user's password and saved user password is save
-		isVerified, msg := VerfiyPassword(*user.Password, *foundUser.Password)
-		if !isVerified {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
+		foundUser, wait, err := u.checkPassword(req.Email, req.Password, c.ClientIP())
+		if wait > 0 {
+			tooManyAttempts(c, wait)
+			return
+		}
+		if errors.Is(err, errBadCredentials) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
 
@@ -110,26 +156,533 @@ func (u *UserController) Login() gin.HandlerFunc {
 			return
 		}
 
-		token, refreshToken, _ := helpers.GenerateAllTokens(*foundUser.Email, *foundUser.FirstName, *foundUser.LastName, *foundUser.UserType, foundUser.UserId)
+		if foundUser.EmailVerifiedAt == nil && u.settings.UnverifiedLogin == UnverifiedLoginDeny {
+			c.JSON(http.StatusForbidden, gin.H{"error": "email address has not been verified"})
+			return
+		}
+
+		// Users with MFA only get a pending token here, VerifyMFA exchanges
+		// it for real tokens
+		mfa, err := u.mfa.GetMFA(foundUser.UserId)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if err == nil && mfa.ConfirmedAt != nil {
+			mfaToken, err := helpers.GenerateMFAPendingToken(foundUser.UserId, req.OrgId, u.settings.MFAPendingTTL)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{"data": gin.H{"mfa_required": true, "mfa_token": mfaToken}})
+			return
+		}
+
+		u.guard.loggedIn(foundUser)
+		u.respondWithTokens(c, foundUser, req.OrgId)
+	}
+}
+
+type verifyMFARequest struct {
+	MFAToken string `json:"mfa_token" validate:"required"`
+	// Code is a TOTP code or one of the recovery codes
+	Code string `json:"code" validate:"required"`
+}
 
-		// update the user with token and refresh token
-		err = u.userRepo.UpdateUserToken(token, refreshToken, user.UserId)
+// VerifyMFA is the second step of logging in with MFA. It exchanges the
+// pending token from Login and a code for real tokens. A pending token is
+// good for one code only, after a wrong one the user logs in again.
+func (u *UserController) VerifyMFA() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req verifyMFARequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, msg := helpers.ValidateMFAPendingToken(req.MFAToken)
+		if msg == "" {
+			msg = helpers.CheckRevoked(u.revocations, claims)
+		}
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		foundUser, err := u.userRepo.GetUser(claims.Uid)
 		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		mfa, err := u.mfa.GetMFA(foundUser.UserId)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && mfa.ConfirmedAt == nil) {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "MFA is not enabled"})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Codes are guessed against the same limits as passwords
+		ok, wait, err := u.guard.checkMFACode(u.mfa, foundUser, mfa, req.Code, c.ClientIP())
+		if wait > 0 {
+			tooManyAttempts(c, wait)
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// The code was checked, so the pending token is spent whether it was
+		// right or not
+		if err := u.revocations.RevokeToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !ok {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid MFA code, log in again"})
+			return
+		}
+
+		u.guard.loggedIn(foundUser)
+		u.respondWithTokens(c, foundUser, claims.OrgId)
+	}
+}
+
+// rehashPassword replaces a hash made by an outdated hasher. This is the one
+// time the plain password is at hand, so failures are only logged.
+func (u *UserController) rehashPassword(userId string, password string) {
+	hash, err := helpers.HashPassword(password)
+	if err == nil {
+		err = u.userRepo.UpdatePassword(userId, hash)
+	}
+	if err != nil {
+		log.Printf("Error %s when rehashing the password of user %s", err, userId)
+	}
+}
+
+// errBadCredentials answers unknown emails and wrong passwords alike, so
+// the answer doesn't tell whether an email has an account.
+var errBadCredentials = errors.New("email or password is incorrect")
+
+// checkPassword is the password step of logging in. Attempts the guard holds
+// off get how long to wait, without checking the password at all. Unknown
+// emails and wrong passwords are counted as failures and get
+// errBadCredentials. A right password doesn't clear the failures yet, that
+// is up to the caller once the user is fully logged in.
+func (u *UserController) checkPassword(email string, password string, ip string) (models.User, time.Duration, error) {
+	wait, err := u.guard.retryAfter(email, ip)
+	if err != nil || wait > 0 {
+		return models.User{}, wait, err
+	}
+
+	// Get the user by email. Unknown emails still pay for a password
+	// check, so they take as long to reject as a wrong password.
+	foundUser, err := u.userRepo.GetUserByEmail(email)
+	if errors.Is(err, sql.ErrNoRows) {
+		helpers.VerifyPassword(dummyPasswordHash(), password)
+		return models.User{}, 0, u.loginFailed(email, ip)
+	}
+	if err != nil {
+		return models.User{}, 0, err
+	}
+
+	// check the login user's password and saved user password is save
+	isVerified, needsRehash, err := helpers.VerifyPassword(*foundUser.Password, password)
+	if err != nil {
+		log.Printf("Error %s when verifying the password of user %s", err, foundUser.UserId)
+	}
+	if !isVerified {
+		return models.User{}, 0, u.loginFailed(email, ip)
+	}
+	if needsRehash {
+		u.rehashPassword(foundUser.UserId, password)
+	}
+	return foundUser, 0, nil
+}
+
+// loginFailed counts a failed login and returns errBadCredentials.
+func (u *UserController) loginFailed(email string, ip string) error {
+	if err := u.guard.recordFailure(email, ip); err != nil {
+		log.Printf("Error %s when recording failed login", err)
+	}
+	return errBadCredentials
+}
+
+// confirmation returns what binds new tokens to the key of the request's
+// DPoP proof and to its client certificate, or nil when it has neither, or
+// why the proof is refused. A proof Authenticate already checked isn't
+// checked again.
+func (u *UserController) confirmation(c *gin.Context) (*helpers.Confirmation, string) {
+	jkt, ok := helpers.GetDPoPKey(c)
+	if !ok {
+		var msg string
+		if jkt, msg = u.dpop.Verify(c.Request, ""); msg != "" {
+			return nil, msg
+		}
+	}
+	var x5t string
+	if cert := helpers.ClientCertificate(c.Request); cert != nil {
+		x5t = helpers.CertificateThumbprint(cert)
+	}
+	if jkt == "" && x5t == "" {
+		return nil, ""
+	}
+	return &helpers.Confirmation{JKT: jkt, X5tS256: x5t}, ""
+}
+
+// respondWithTokens starts a session for the user acting in orgId, and
+// returns the user with its tokens. Requests with a DPoP proof or a client
+// certificate get tokens bound to them.
+func (u *UserController) respondWithTokens(c *gin.Context, user models.User, orgId string) {
+	cnf, msg := u.confirmation(c)
+	if msg != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": msg})
+		return
+	}
+
+	token, refreshToken, err := u.startSession(user, orgId, "", "", cnf)
+	if errors.Is(err, errNotMember) {
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	user.Token = &token
+	user.RefreshToken = &refreshToken
+
+	c.JSON(http.StatusOK, gin.H{"data": withoutPassword(user)})
+}
+
+type switchOrganizationRequest struct {
+	OrgId string `json:"org_id" validate:"required,uuid"`
+}
+
+// SwitchOrganization issues tokens for another organization the caller
+// belongs to, without asking for the password again.
+func (u *UserController) SwitchOrganization() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req switchOrganizationRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusOK, gin.H{"data": foundUser})
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		caller, _ := helpers.GetClaims(c)
+		foundUser, err := u.userRepo.GetUser(caller.Uid)
+		if err != nil {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "user not found"})
+			return
+		}
+
+		u.respondWithTokens(c, foundUser, req.OrgId)
 	}
 }
 
-func (u UserController) GetUsers() gin.HandlerFunc {
+// startSession issues the first token pair of a new refresh token family,
+// so each login can be refreshed and revoked apart from the user's others.
+func (u *UserController) startSession(user models.User, orgId string, clientId string, scope string, cnf *helpers.Confirmation) (string, string, error) {
+	familyId := uuid.NewString()
+	token, refreshToken, err := u.generateTokens(user, orgId, clientId, scope, familyId, cnf)
+	if err != nil {
+		return "", "", err
+	}
+
+	err = u.families.CreateRefreshTokenFamily(familyId, user.UserId, helpers.HashSecretToken(refreshToken), time.Now().Add(helpers.RefreshTokenTTL))
+	if err != nil {
+		return "", "", err
+	}
+	return token, refreshToken, nil
+}
+
+// generateTokens issues a token pair for the user acting in orgId, carrying
+// their current effective permissions there. An empty orgId picks the user's
+// oldest membership; users without any get a token with no tenant. Users who
+// still have to verify their email may get restricted tokens instead.
+// clientId is the OAuth client that asked for the tokens and scope what it
+// was granted, both empty when users log in themselves.
+// familyId is the refresh token family the pair belongs to. cnf binds the
+// tokens to the client's DPoP key or certificate, nil leaves them bearer
+// tokens.
+func (u *UserController) generateTokens(user models.User, orgId string, clientId string, scope string, familyId string, cnf *helpers.Confirmation) (string, string, error) {
+	var membership models.Membership
+	if orgId != "" {
+		var err error
+		membership, err = u.orgs.GetMembership(orgId, user.UserId)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", "", errNotMember
+		}
+		if err != nil {
+			return "", "", err
+		}
+	} else {
+		memberships, err := u.orgs.GetUserMemberships(user.UserId)
+		if err != nil {
+			return "", "", err
+		}
+		if len(memberships) > 0 {
+			membership = memberships[0]
+		}
+	}
+	subject := helpers.TokenSubject{
+		Email:        *user.Email,
+		FirstName:    *user.FirstName,
+		LastName:     *user.LastName,
+		UserType:     *user.UserType,
+		UserId:       user.UserId,
+		OrgId:        membership.OrgId,
+		ClientId:     clientId,
+		Scope:        scope,
+		Confirmation: cnf,
+		FamilyId:     familyId,
+	}
+	if user.EmailVerifiedAt == nil && u.settings.UnverifiedLogin == UnverifiedLoginRestrict {
+		subject.Restricted = true
+		return helpers.GenerateAllTokens(subject)
+	}
+
+	permissions, err := userPermissions(u.roles, user, membership)
+	if err != nil {
+		return "", "", err
+	}
+	roles, err := userRoles(u.roles, user, membership)
+	if err != nil {
+		return "", "", err
+	}
+	subject.Permissions, subject.Roles = permissions, roles
+	return helpers.GenerateAllTokens(subject)
+}
+
+// userPermissions returns the user's effective permissions: those of their
+// global roles, of the USER role everyone holds and of their role in the
+// organization of membership, if they act in one. user_type plays no part,
+// ADMIN has to be assigned.
+func userPermissions(roles repository.RoleStore, user models.User, membership models.Membership) ([]string, error) {
+	return roles.GetUserPermissions(user.UserId, implicitRoles(membership))
+}
+
+// userRoles returns the roles userPermissions goes by: the assigned ones and
+// the implicit ones.
+func userRoles(roles repository.RoleStore, user models.User, membership models.Membership) ([]string, error) {
+	assigned, err := roles.GetUserRoles(user.UserId)
+	if err != nil {
+		return nil, err
+	}
+	held := assigned
+	for _, role := range implicitRoles(membership) {
+		found := false
+		for _, assigned := range assigned {
+			found = found || assigned == role
+		}
+		if !found {
+			held = append(held, role)
+		}
+	}
+	return held, nil
+}
+
+// implicitRoles are the roles everyone holds without an assignment: USER,
+// and their role in the organization of membership if they act in one.
+func implicitRoles(membership models.Membership) []string {
+	roles := []string{repository.RoleUser}
+	if membership.OrgId != "" {
+		roles = append(roles, membership.Role)
+	}
+	return roles
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token" validate:"required"`
+}
+
+// RefreshToken exchanges a refresh token for a new access/refresh pair of the
+// same family. The presented token is rotated out; presenting it again later
+// is treated as theft and revokes every token of its family. The user's other
+// sessions keep working.
+func (u *UserController) RefreshToken() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req refreshTokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		if err := validate.Struct(req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		claims, msg := helpers.ValidateRefreshToken(req.RefreshToken)
+		if msg == "" {
+			msg = helpers.CheckRevoked(u.revocations, claims)
+		}
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		// Bound refresh tokens need a proof of their key or their client
+		// certificate, and the new tokens stay bound to them
+		cnf, msg := u.confirmation(c)
+		if msg == "" && claims.DPoPKey() != "" && (cnf == nil || cnf.JKT != claims.DPoPKey()) {
+			msg = "The refresh token is bound to a DPoP key and needs a DPoP proof signed with it"
+		}
+		if msg == "" && claims.BoundCertificate() != "" && (cnf == nil || cnf.X5tS256 != claims.BoundCertificate()) {
+			msg = "The refresh token is bound to another client certificate"
+		}
+		if msg != "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		// Refresh tokens from before families were kept have nothing to
+		// rotate against
+		foundUser, err := u.userRepo.GetUser(claims.Uid)
+		if err != nil || claims.FamilyId == "" {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid refresh token"})
+			return
+		}
+
+		// Stay in the same organization, as long as the user still belongs to
+		// it, and keep what the OAuth client was granted
+		token, refreshToken, err := u.generateTokens(foundUser, claims.OrgId, claims.AuthorizedParty, claims.Scope, claims.FamilyId, cnf)
+		if errors.Is(err, errNotMember) {
+			c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		// Only swap the tokens if the presented one is still the newest of
+		// its family and nobody else rotated it in the meantime
+		rotated, err := u.families.RotateRefreshToken(claims.FamilyId, helpers.HashSecretToken(req.RefreshToken), helpers.HashSecretToken(refreshToken), time.Now().Add(helpers.RefreshTokenTTL))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if !rotated {
+			u.revokeTokenFamily(c, foundUser.UserId, claims.FamilyId)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": gin.H{"token": token, "refresh_token": refreshToken}})
+	}
+}
+
+// revokeTokenFamily is called when a refresh token that was already rotated,
+// or whose family has ended, is presented again. Whoever holds it is not
+// necessarily the user, so every token of the family is dropped and that
+// session has to log in again.
+func (u *UserController) revokeTokenFamily(c *gin.Context, userId string, familyId string) {
+	log.Printf("Refresh token reuse detected for user %s, revoking token family %s", userId, familyId)
+	if err := endTokenFamily(u.families, u.revocations, familyId); err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusUnauthorized, gin.H{"error": "refresh token has already been used"})
+}
+
+// endTokenFamily rejects every token of the family, access tokens included,
+// and forgets its refresh token.
+func endTokenFamily(families repository.RefreshTokenStore, revocations repository.RevocationStore, familyId string) error {
+	// No token of the family outlives a refresh token issued now
+	if err := revocations.RevokeToken(familyId, time.Now().Add(helpers.RefreshTokenTTL)); err != nil {
+		return err
+	}
+	return families.DeleteRefreshTokenFamily(familyId)
+}
+
+// revokeAllTokens rejects every token issued to the user so far, access tokens
+// included, and ends all of their refresh token families.
+func revokeAllTokens(families repository.RefreshTokenStore, revocations repository.RevocationStore, userId string) error {
+	if err := revocations.RevokeUserTokens(userId, time.Now()); err != nil {
+		return err
+	}
+	return families.DeleteUserRefreshTokenFamilies(userId)
+}
+
+type logoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// Logout revokes the access token the request was made with and ends its
+// refresh token family, and revokes the refresh token too when the client
+// sends it along. The user's other sessions stay logged in.
+func (u *UserController) Logout() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if err := helpers.CheckUserType(c, "ADMIN"); err != nil {
-			c.JSON(http.StatusBadGateway, gin.H{"Error": err.Error()})
+		var req logoutRequest
+		if c.Request.ContentLength > 0 {
+			if err := c.ShouldBindJSON(&req); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		caller, _ := helpers.GetClaims(c)
+		// Personal access tokens aren't sessions, and may never expire
+		if caller.TokenType == helpers.PersonalTokenType {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "personal access tokens are revoked by deleting them"})
 			return
 		}
+		if err := u.revocations.RevokeToken(caller.ID, caller.ExpiresAt.Time); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		if caller.FamilyId != "" {
+			if err := endTokenFamily(u.families, u.revocations, caller.FamilyId); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
 
+		if req.RefreshToken != "" {
+			claims, msg := helpers.ValidateRefreshToken(req.RefreshToken)
+			if msg != "" || claims.Uid != caller.Uid {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "invalid refresh token"})
+				return
+			}
+			if err := u.revocations.RevokeToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": "logged out"})
+	}
+}
+
+// LogoutAll revokes every token issued to the user, on every device.
+func (u *UserController) LogoutAll() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		if err := revokeAllTokens(u.families, u.revocations, caller.Uid); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"data": "logged out of all sessions"})
+	}
+}
+
+func (u UserController) GetUsers() gin.HandlerFunc {
+	return func(c *gin.Context) {
 		itemsPerPage, err := strconv.Atoi(c.Query("itemsPerPage"))
 		if err != nil || itemsPerPage < 1 {
 			itemsPerPage = 10
@@ -142,12 +695,18 @@ func (u UserController) GetUsers() gin.HandlerFunc {
 
 		offset := (page - 1) * itemsPerPage
 
-		users, err := u.userRepo.GetUsers(page, offset)
+		// Only ever list the members of the caller's organization
+		caller, _ := helpers.GetClaims(c)
+		users, err := u.userRepo.InTenant(caller.OrgId).GetUsers(itemsPerPage, offset)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
 
+		for i := range users {
+			users[i] = withoutPassword(users[i])
+		}
+
 		data := make(map[string]interface{})
 		data["data"] = users
 
@@ -159,17 +718,47 @@ func (u UserController) GetUser() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		userId := c.Param("id")
 
-		if err := helpers.MatchUserTypeToUid(c, userId); err != nil {
+		// Users can always see themselves, anyone else has to share the
+		// caller's organization
+		var user models.User
+		var err error
+		caller, _ := helpers.GetClaims(c)
+		if userId == caller.Uid {
+			user, err = u.userRepo.GetUser(userId)
+		} else {
+			user, err = u.userRepo.InTenant(caller.OrgId).GetUser(userId)
+		}
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
+		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{"data": withoutPassword(user)})
+	}
+}
 
-		var user models.User
-		user, err := u.userRepo.GetUser(userId)
+// UnlockUser forgets the failed logins against a user, lifting a lockout
+// early.
+func (u UserController) UnlockUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		caller, _ := helpers.GetClaims(c)
+		user, err := u.userRepo.InTenant(caller.OrgId).GetUser(c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+			return
+		}
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 			return
 		}
-		c.JSON(http.StatusOK, gin.H{"data": user})
+
+		if err := u.guard.unlock(*user.Email); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": "user unlocked"})
 	}
 }
@@ -0,0 +1,71 @@
+package controllers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// createTestUser stores a user with the password hash and returns its ID.
+func createTestUser(t *testing.T, repo repository.UserRepository, email string, passwordHash string) string {
+	t.Helper()
+	firstName, lastName, phone := "Ann", "Lee", "1234567890"
+	userId, err := repo.CreateUser(models.User{FirstName: &firstName, LastName: &lastName, Email: &email, Phone: &phone, Password: &passwordHash})
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return userId
+}
+
+func storedHash(t *testing.T, repo repository.UserRepository, userId string) string {
+	t.Helper()
+	user, err := repo.GetUser(userId)
+	if err != nil {
+		t.Fatalf("GetUser: %v", err)
+	}
+	return *user.Password
+}
+
+func TestCheckPasswordRehashesOutdatedHashes(t *testing.T) {
+	repo := repository.NewMemoryRepository()
+	u := &UserController{userRepo: repo, guard: newTestGuard()}
+
+	bcryptHash, err := helpers.BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId := createTestUser(t, repo, "ann@example.com", bcryptHash)
+
+	// A wrong password leaves the old hash alone
+	if _, _, err := u.checkPassword("ann@example.com", "battery staple", "192.0.2.1"); !errors.Is(err, errBadCredentials) {
+		t.Fatalf("checkPassword with another password = %v, want errBadCredentials", err)
+	}
+	if storedHash(t, repo, userId) != bcryptHash {
+		t.Error("a wrong password replaced the hash")
+	}
+
+	// The right one upgrades it to the configured argon2id
+	if _, _, err := u.checkPassword("ann@example.com", "correct horse", "192.0.2.1"); err != nil {
+		t.Fatalf("checkPassword: %v", err)
+	}
+	rehashed := storedHash(t, repo, userId)
+	if !strings.HasPrefix(rehashed, "$argon2id$") {
+		t.Fatalf("hash after login = %s, want an argon2id hash", rehashed)
+	}
+	if ok, needsRehash, err := helpers.VerifyPassword(rehashed, "correct horse"); !ok || needsRehash || err != nil {
+		t.Errorf("VerifyPassword of the new hash = (%v, %v, %v), want (true, false, nil)", ok, needsRehash, err)
+	}
+
+	// Current hashes are kept as they are
+	if _, _, err := u.checkPassword("ann@example.com", "correct horse", "192.0.2.1"); err != nil {
+		t.Fatalf("checkPassword: %v", err)
+	}
+	if storedHash(t, repo, userId) != rehashed {
+		t.Error("a current hash was replaced")
+	}
+}
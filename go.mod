@@ -8,6 +8,8 @@ require (
 	github.com/go-sql-driver/mysql v1.8.1
 	github.com/golang-jwt/jwt/v4 v4.5.1
 	github.com/google/uuid v1.4.0
+	github.com/lib/pq v1.10.9
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/namsral/flag v1.7.4-pre
 	github.com/spf13/viper v1.19.0
 	golang.org/x/crypto v0.23.0
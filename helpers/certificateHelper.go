@@ -0,0 +1,73 @@
+package helpers
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// Prefixes of the SANs a service account's certificate identity may name,
+// anything else is a subject DN.
+var certificateSANPrefixes = []string{"dns:", "uri:", "email:", "ip:"}
+
+// ClientCertificate returns the client certificate the request was made
+// with, or nil. Only certificates that verified against the client CA count.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// CertificateThumbprint is the x5t#S256 of cert, what certificate-bound
+// tokens are bound to (RFC 8705).
+func CertificateThumbprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// CertificateIdentities lists the identities cert can stand for: its
+// subject DN, like "CN=billing,O=Example", and each of its SANs as
+// "dns:billing.internal", "uri:spiffe://example/billing",
+// "email:billing@example.com" or "ip:10.0.0.7".
+func CertificateIdentities(cert *x509.Certificate) []string {
+	var identities []string
+	if subject := cert.Subject.String(); subject != "" {
+		identities = append(identities, subject)
+	}
+	for _, name := range cert.DNSNames {
+		identities = append(identities, "dns:"+name)
+	}
+	for _, uri := range cert.URIs {
+		identities = append(identities, "uri:"+uri.String())
+	}
+	for _, email := range cert.EmailAddresses {
+		identities = append(identities, "email:"+email)
+	}
+	for _, ip := range cert.IPAddresses {
+		identities = append(identities, "ip:"+ip.String())
+	}
+	return identities
+}
+
+// ValidCertificateIdentity reports whether identity has one of the forms
+// CertificateIdentities gives.
+func ValidCertificateIdentity(identity string) bool {
+	for _, prefix := range certificateSANPrefixes {
+		if strings.HasPrefix(identity, prefix) {
+			return len(identity) > len(prefix)
+		}
+	}
+	return strings.Contains(identity, "=")
+}
+
+// BoundCertificate returns the x5t#S256 of the client certificate the token
+// is bound to, or an empty string for tokens bound to none.
+func (s *SignedDetails) BoundCertificate() string {
+	if s.Confirmation == nil {
+		return ""
+	}
+	return s.Confirmation.X5tS256
+}
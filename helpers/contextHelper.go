@@ -0,0 +1,42 @@
+package helpers
+
+import (
+	"github.com/gin-gonic/gin"
+)
+
+const claimsKey = "claims"
+
+// SetClaims stores the claims of the authenticated caller in the request context.
+func SetClaims(c *gin.Context, claims *SignedDetails) {
+	c.Set(claimsKey, claims)
+}
+
+// GetClaims returns the claims stored by SetClaims, if the request went
+// through middleware.Authenticate.
+func GetClaims(c *gin.Context) (*SignedDetails, bool) {
+	value, ok := c.Get(claimsKey)
+	if !ok {
+		return nil, false
+	}
+	claims, ok := value.(*SignedDetails)
+	return claims, ok && claims != nil
+}
+
+const dpopKeyKey = "dpop_jkt"
+
+// SetDPoPKey stores the thumbprint of the key the request's DPoP proof was
+// verified with, so the proof isn't checked, and used up, twice.
+func SetDPoPKey(c *gin.Context, jkt string) {
+	c.Set(dpopKeyKey, jkt)
+}
+
+// GetDPoPKey returns the thumbprint stored by SetDPoPKey, if the request's
+// proof went through middleware.Authenticate.
+func GetDPoPKey(c *gin.Context) (string, bool) {
+	jkt, ok := c.Get(dpopKeyKey)
+	if !ok {
+		return "", false
+	}
+	s, ok := jkt.(string)
+	return s, ok && s != ""
+}
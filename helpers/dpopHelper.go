@@ -0,0 +1,150 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/keyring"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// DPoPProofMaxAge is how long after its iat a DPoP proof is accepted. Proofs
+// are made for a single request, so it only has to cover the trip there.
+const DPoPProofMaxAge = 5 * time.Minute
+
+// dpopClockSkew is how far ahead of ours client clocks may run.
+const dpopClockSkew = 30 * time.Second
+
+// DPoPAlgorithms are the algorithms DPoP proofs may be signed with, the
+// asymmetric ones. A shared secret would prove nothing.
+var DPoPAlgorithms = []string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "EdDSA"}
+
+// DPoPProofClaims are the claims of DPoP proofs (RFC 9449).
+type DPoPProofClaims struct {
+	HTTPMethod string `json:"htm"`
+	HTTPURI    string `json:"htu"`
+	// AccessTokenHash is the hash of the access token the request presents
+	AccessTokenHash string `json:"ath,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// DPoPVerifier checks the DPoP proofs clients send to show that they hold
+// the key their tokens are bound to.
+type DPoPVerifier struct {
+	proofs repository.DPoPProofStore
+	// publicURL is where clients reach the server, the htu of proofs is
+	// checked against it
+	publicURL string
+}
+
+func NewDPoPVerifier(proofs repository.DPoPProofStore, publicURL string) DPoPVerifier {
+	return DPoPVerifier{proofs: proofs, publicURL: strings.TrimSuffix(publicURL, "/")}
+}
+
+// Verify checks the DPoP proof of the request and returns the thumbprint of
+// the key it is signed with, or why it is refused. accessToken is the token
+// the request presents, empty at the endpoints that issue tokens. Requests
+// without a proof give neither.
+func (v DPoPVerifier) Verify(r *http.Request, accessToken string) (jkt string, msg string) {
+	headers := r.Header.Values("DPoP")
+	if len(headers) == 0 {
+		return "", ""
+	}
+	if len(headers) > 1 {
+		return "", "The request has more than one DPoP proof"
+	}
+
+	claims := &DPoPProofClaims{}
+	parser := jwt.NewParser(jwt.WithValidMethods(DPoPAlgorithms), jwt.WithoutClaimsValidation())
+	_, err := parser.ParseWithClaims(headers[0], claims, func(t *jwt.Token) (interface{}, error) {
+		if t.Header["typ"] != "dpop+jwt" {
+			return nil, errors.New("the proof's typ is not dpop+jwt")
+		}
+		jwk, ok := t.Header["jwk"].(map[string]interface{})
+		if !ok {
+			return nil, errors.New("the proof has no jwk")
+		}
+		pub, err := keyring.ParsePublicJWK(jwk)
+		if err != nil {
+			return nil, err
+		}
+		if method, ok := t.Method.(*jwt.SigningMethodECDSA); ok {
+			if ec, ok := pub.(*ecdsa.PublicKey); ok && ec.Curve.Params().BitSize != method.CurveBits {
+				return nil, errors.New("the proof's jwk doesn't fit its alg")
+			}
+		}
+		if jkt, err = keyring.JWKThumbprint(jwk); err != nil {
+			return nil, err
+		}
+		return pub, nil
+	})
+	if err != nil {
+		return "", "The DPoP proof is invalid: " + err.Error()
+	}
+
+	if claims.HTTPMethod != r.Method {
+		return "", "The DPoP proof is for another HTTP method"
+	}
+	if !v.matchesURI(claims.HTTPURI, r) {
+		return "", "The DPoP proof is for another URL"
+	}
+
+	now := time.Now()
+	if claims.IssuedAt == nil || claims.IssuedAt.Time.After(now.Add(dpopClockSkew)) || now.Sub(claims.IssuedAt.Time) > DPoPProofMaxAge {
+		return "", "The DPoP proof is too old or from the future"
+	}
+
+	if accessToken != "" {
+		hash := sha256.Sum256([]byte(accessToken))
+		expected := base64.RawURLEncoding.EncodeToString(hash[:])
+		if subtle.ConstantTimeCompare([]byte(claims.AccessTokenHash), []byte(expected)) != 1 {
+			return "", "The DPoP proof is for another access token"
+		}
+	}
+
+	// Checked last, so a proof turned away above isn't used up
+	if claims.ID == "" || len(claims.ID) > 64 {
+		return "", "The DPoP proof needs a jti of at most 64 characters"
+	}
+	fresh, err := v.proofs.UseDPoPProof(claims.ID, claims.IssuedAt.Time.Add(DPoPProofMaxAge))
+	if err != nil {
+		log.Printf("Error %s when checking DPoP proof replay", err)
+		return "", "Unable to verify the DPoP proof"
+	}
+	if !fresh {
+		return "", "The DPoP proof has been used before"
+	}
+	return jkt, ""
+}
+
+// matchesURI reports whether htu is the URL the request was made to. Query
+// and fragment don't count, and scheme and host are case insensitive.
+func (v DPoPVerifier) matchesURI(htu string, r *http.Request) bool {
+	got, err := url.Parse(htu)
+	if err != nil {
+		return false
+	}
+	want, err := url.Parse(v.publicURL + r.URL.Path)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(got.Scheme, want.Scheme) && strings.EqualFold(got.Host, want.Host) && got.EscapedPath() == want.EscapedPath()
+}
+
+// DPoPKey returns the thumbprint of the DPoP key the token is bound to, or
+// an empty string for bearer tokens.
+func (s *SignedDetails) DPoPKey() string {
+	if s.Confirmation == nil {
+		return ""
+	}
+	return s.Confirmation.JKT
+}
@@ -0,0 +1,260 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/keyring"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+const dpopTestURL = "https://auth.example.com"
+
+func newDPoPKey(t *testing.T) *ecdsa.PrivateKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func dpopJWK(t *testing.T, key *ecdsa.PrivateKey) map[string]interface{} {
+	t.Helper()
+	jwk, err := keyring.PublicJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jwk
+}
+
+// signDPoPProof signs claims as an ES256 proof carrying the public key of
+// key, after edit had its way with the token.
+func signDPoPProof(t *testing.T, key *ecdsa.PrivateKey, claims *DPoPProofClaims, edit func(*jwt.Token)) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = dpopJWK(t, key)
+	if edit != nil {
+		edit(token)
+	}
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+func dpopClaims(method string, uri string) *DPoPProofClaims {
+	return &DPoPProofClaims{
+		HTTPMethod: method,
+		HTTPURI:    uri,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       uuid.NewString(),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	}
+}
+
+func dpopRequest(method string, path string, proofs ...string) *http.Request {
+	r := httptest.NewRequest(method, dpopTestURL+path, nil)
+	for _, proof := range proofs {
+		r.Header.Add("DPoP", proof)
+	}
+	return r
+}
+
+func newTestDPoPVerifier() DPoPVerifier {
+	return NewDPoPVerifier(repository.NewMemoryDPoPProofStore(), dpopTestURL+"/")
+}
+
+func TestDPoPVerify(t *testing.T) {
+	v := newTestDPoPVerifier()
+	key := newDPoPKey(t)
+	proof := signDPoPProof(t, key, dpopClaims("POST", dpopTestURL+"/api/v1/auth/token/refresh"), nil)
+
+	jkt, msg := v.Verify(dpopRequest("POST", "/api/v1/auth/token/refresh", proof), "")
+	want, _ := keyring.JWKThumbprint(dpopJWK(t, key))
+	if msg != "" || jkt != want {
+		t.Errorf("Verify = (%q, %q), want (%q, \"\")", jkt, msg, want)
+	}
+
+	// Without a proof there is nothing to check
+	if jkt, msg := v.Verify(dpopRequest("POST", "/api/v1/auth/token/refresh"), ""); jkt != "" || msg != "" {
+		t.Errorf("Verify without a proof = (%q, %q), want neither", jkt, msg)
+	}
+}
+
+func TestDPoPVerifyRefusesProofs(t *testing.T) {
+	key := newDPoPKey(t)
+	other := newDPoPKey(t)
+	const path = "/api/v1/users"
+	uri := dpopTestURL + path
+
+	for _, tt := range []struct {
+		name  string
+		proof func() string
+		msg   string
+	}{
+		{"signed by another key than its jwk", func() string {
+			return signDPoPProof(t, other, dpopClaims("GET", uri), func(token *jwt.Token) {
+				token.Header["jwk"] = dpopJWK(t, key)
+			})
+		}, "invalid"},
+		{"tampered with", func() string {
+			proof := signDPoPProof(t, key, dpopClaims("GET", uri), nil)
+			parts := strings.Split(proof, ".")
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			parts[1] = base64.RawURLEncoding.EncodeToString([]byte(strings.Replace(string(payload), `"GET"`, `"PUT"`, 1)))
+			return strings.Join(parts, ".")
+		}, "invalid"},
+		{"without typ", func() string {
+			return signDPoPProof(t, key, dpopClaims("GET", uri), func(token *jwt.Token) { token.Header["typ"] = "JWT" })
+		}, "typ"},
+		{"without jwk", func() string {
+			return signDPoPProof(t, key, dpopClaims("GET", uri), func(token *jwt.Token) { delete(token.Header, "jwk") })
+		}, "jwk"},
+		{"with a private jwk", func() string {
+			return signDPoPProof(t, key, dpopClaims("GET", uri), func(token *jwt.Token) {
+				jwk := dpopJWK(t, key)
+				jwk["d"] = base64.RawURLEncoding.EncodeToString(key.D.Bytes())
+				token.Header["jwk"] = jwk
+			})
+		}, "private key"},
+		{"signed with a shared secret", func() string {
+			token := jwt.NewWithClaims(jwt.SigningMethodHS256, dpopClaims("GET", uri))
+			token.Header["typ"] = "dpop+jwt"
+			token.Header["jwk"] = dpopJWK(t, key)
+			proof, _ := token.SignedString([]byte("secret"))
+			return proof
+		}, "invalid"},
+		{"for another method", func() string {
+			return signDPoPProof(t, key, dpopClaims("POST", uri), nil)
+		}, "another HTTP method"},
+		{"for another path", func() string {
+			return signDPoPProof(t, key, dpopClaims("GET", dpopTestURL+"/api/v1/orgs"), nil)
+		}, "another URL"},
+		{"for another host", func() string {
+			return signDPoPProof(t, key, dpopClaims("GET", "https://evil.example.com"+path), nil)
+		}, "another URL"},
+		{"for another scheme", func() string {
+			return signDPoPProof(t, key, dpopClaims("GET", "http://auth.example.com"+path), nil)
+		}, "another URL"},
+		{"issued too long ago", func() string {
+			claims := dpopClaims("GET", uri)
+			claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-DPoPProofMaxAge - time.Minute))
+			return signDPoPProof(t, key, claims, nil)
+		}, "too old"},
+		{"issued in the future", func() string {
+			claims := dpopClaims("GET", uri)
+			claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(2 * time.Minute))
+			return signDPoPProof(t, key, claims, nil)
+		}, "too old"},
+		{"without iat", func() string {
+			claims := dpopClaims("GET", uri)
+			claims.IssuedAt = nil
+			return signDPoPProof(t, key, claims, nil)
+		}, "too old"},
+		{"without jti", func() string {
+			claims := dpopClaims("GET", uri)
+			claims.ID = ""
+			return signDPoPProof(t, key, claims, nil)
+		}, "jti"},
+	} {
+		jkt, msg := newTestDPoPVerifier().Verify(dpopRequest("GET", path, tt.proof()), "")
+		if jkt != "" || !strings.Contains(msg, tt.msg) {
+			t.Errorf("proof %s: Verify = (%q, %q), want a message about %q", tt.name, jkt, msg, tt.msg)
+		}
+	}
+
+	proof := signDPoPProof(t, key, dpopClaims("GET", uri), nil)
+	if _, msg := newTestDPoPVerifier().Verify(dpopRequest("GET", path, proof, proof), ""); !strings.Contains(msg, "more than one") {
+		t.Errorf("Verify with two proofs = %q, want it refused", msg)
+	}
+}
+
+func TestDPoPVerifyURIMatching(t *testing.T) {
+	key := newDPoPKey(t)
+
+	// Query, fragment and the case of scheme and host don't matter
+	proof := signDPoPProof(t, key, dpopClaims("GET", "HTTPS://Auth.Example.com/api/v1/users?page=2#top"), nil)
+	if _, msg := newTestDPoPVerifier().Verify(dpopRequest("GET", "/api/v1/users?page=3", proof), ""); msg != "" {
+		t.Errorf("Verify = %q, want the proof accepted", msg)
+	}
+}
+
+func TestDPoPVerifyAccessTokenHash(t *testing.T) {
+	key := newDPoPKey(t)
+	const path = "/api/v1/users"
+	hash := sha256.Sum256([]byte("access-token"))
+
+	claims := dpopClaims("GET", dpopTestURL+path)
+	claims.AccessTokenHash = base64.RawURLEncoding.EncodeToString(hash[:])
+	proof := signDPoPProof(t, key, claims, nil)
+	if _, msg := newTestDPoPVerifier().Verify(dpopRequest("GET", path, proof), "access-token"); msg != "" {
+		t.Errorf("Verify with the right ath = %q, want it accepted", msg)
+	}
+	if _, msg := newTestDPoPVerifier().Verify(dpopRequest("GET", path, proof), "other-token"); !strings.Contains(msg, "another access token") {
+		t.Errorf("Verify with another token = %q, want it refused", msg)
+	}
+
+	// Proofs for requests presenting a token must carry its hash
+	proof = signDPoPProof(t, key, dpopClaims("GET", dpopTestURL+path), nil)
+	if _, msg := newTestDPoPVerifier().Verify(dpopRequest("GET", path, proof), "access-token"); !strings.Contains(msg, "another access token") {
+		t.Errorf("Verify without ath = %q, want it refused", msg)
+	}
+}
+
+func TestDPoPVerifyReplay(t *testing.T) {
+	v := newTestDPoPVerifier()
+	key := newDPoPKey(t)
+	const path = "/api/v1/auth/token/refresh"
+
+	claims := dpopClaims("POST", dpopTestURL+path)
+	proof := signDPoPProof(t, key, claims, nil)
+	if _, msg := v.Verify(dpopRequest("POST", path, proof), ""); msg != "" {
+		t.Fatalf("first Verify = %q, want it accepted", msg)
+	}
+	if _, msg := v.Verify(dpopRequest("POST", path, proof), ""); !strings.Contains(msg, "used before") {
+		t.Errorf("replayed Verify = %q, want it refused", msg)
+	}
+
+	// Proofs turned away for another reason don't use their jti up
+	wrong := dpopClaims("GET", dpopTestURL+path)
+	if _, msg := v.Verify(dpopRequest("POST", path, signDPoPProof(t, key, wrong, nil)), ""); msg == "" {
+		t.Fatal("Verify of a proof for another method accepted it")
+	}
+	right := dpopClaims("POST", dpopTestURL+path)
+	right.ID = wrong.ID
+	if _, msg := v.Verify(dpopRequest("POST", path, signDPoPProof(t, key, right, nil)), ""); msg != "" {
+		t.Errorf("Verify after a refused proof with the same jti = %q, want it accepted", msg)
+	}
+}
+
+// failingProofStore can't be reached.
+type failingProofStore struct{}
+
+func (failingProofStore) UseDPoPProof(jti string, expiresAt time.Time) (bool, error) {
+	return false, errors.New("store unavailable")
+}
+
+func TestDPoPVerifyStoreError(t *testing.T) {
+	v := NewDPoPVerifier(failingProofStore{}, dpopTestURL)
+	key := newDPoPKey(t)
+	proof := signDPoPProof(t, key, dpopClaims("GET", dpopTestURL+"/api/v1/users"), nil)
+
+	if jkt, msg := v.Verify(dpopRequest("GET", "/api/v1/users", proof), ""); jkt != "" || msg == "" {
+		t.Errorf("Verify with a failing store = (%q, %q), want it refused", jkt, msg)
+	}
+}
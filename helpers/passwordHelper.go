@@ -0,0 +1,194 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	PasswordHasherArgon2id = "argon2id"
+	PasswordHasherBcrypt   = "bcrypt"
+
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+var errUnknownPasswordHash = errors.New("unknown password hash format")
+
+// PasswordHasher hashes passwords into PHC strings, "$<algorithm>$...", so
+// every stored hash tells which algorithm and parameters made it.
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	// Verify reports whether password matches a hash made with the
+	// hasher's algorithm. The parameters are read from the hash.
+	Verify(hash string, password string) (bool, error)
+	// NeedsRehash reports whether hash was made by another algorithm or
+	// with other parameters than the hasher uses.
+	NeedsRehash(hash string) bool
+}
+
+// BcryptHasher makes "$2a$<cost>$..." hashes, the format every hash had
+// before argon2id.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), h.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func (h BcryptHasher) Verify(hash string, password string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (h BcryptHasher) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher makes "$argon2id$v=19$m=<KiB>,t=<passes>,p=<lanes>$<salt>$<key>"
+// hashes.
+type Argon2idHasher struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+type argon2idHash struct {
+	params Argon2idHasher
+	salt   []byte
+	key    []byte
+}
+
+func (h Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, argon2SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (h Argon2idHasher) Verify(hash string, password string) (bool, error) {
+	parsed, err := parseArgon2id(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(password), parsed.salt, parsed.params.Iterations, parsed.params.Memory, parsed.params.Parallelism, uint32(len(parsed.key)))
+	return subtle.ConstantTimeCompare(key, parsed.key) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash string) bool {
+	parsed, err := parseArgon2id(hash)
+	return err != nil || parsed.params != h || len(parsed.salt) != argon2SaltLength || len(parsed.key) != argon2KeyLength
+}
+
+func parseArgon2id(hash string) (argon2idHash, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[1] != PasswordHasherArgon2id {
+		return argon2idHash{}, errUnknownPasswordHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return argon2idHash{}, fmt.Errorf("unsupported argon2id version %q", parts[2])
+	}
+
+	var parsed argon2idHash
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &parsed.params.Memory, &parsed.params.Iterations, &parsed.params.Parallelism); err != nil {
+		return argon2idHash{}, fmt.Errorf("invalid argon2id parameters %q", parts[3])
+	}
+
+	var err error
+	if parsed.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return argon2idHash{}, fmt.Errorf("invalid argon2id salt: %w", err)
+	}
+	if parsed.key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return argon2idHash{}, fmt.Errorf("invalid argon2id key: %w", err)
+	}
+	return parsed, nil
+}
+
+// hasherFor returns a hasher that can verify hash, going by its prefix.
+func hasherFor(hash string) (PasswordHasher, error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return Argon2idHasher{}, nil
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return BcryptHasher{}, nil
+	}
+	return nil, errUnknownPasswordHash
+}
+
+// NewPasswordHasher returns the hasher cfg asks for.
+func NewPasswordHasher(cfg *config.PasswordConfig) (PasswordHasher, error) {
+	switch cfg.Hasher {
+	case PasswordHasherArgon2id:
+		if cfg.Argon2Memory == 0 || cfg.Argon2Iterations == 0 || cfg.Argon2Parallelism == 0 {
+			return nil, errors.New("argon2id memory, iterations and parallelism must not be zero")
+		}
+		return Argon2idHasher{Memory: cfg.Argon2Memory, Iterations: cfg.Argon2Iterations, Parallelism: cfg.Argon2Parallelism}, nil
+	case PasswordHasherBcrypt:
+		if cfg.BcryptCost < bcrypt.MinCost || cfg.BcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("bcrypt cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return BcryptHasher{Cost: cfg.BcryptCost}, nil
+	}
+	return nil, fmt.Errorf("unknown password hasher %q", cfg.Hasher)
+}
+
+// passwordHasher makes new hashes. Until InitPasswordHashing runs it uses
+// the OWASP recommended argon2id parameters.
+var passwordHasher PasswordHasher = Argon2idHasher{Memory: 19 * 1024, Iterations: 2, Parallelism: 1}
+
+// InitPasswordHashing sets the hasher new password hashes are made with.
+// Hashes made by any other supported hasher still verify.
+func InitPasswordHashing(cfg *config.PasswordConfig) error {
+	hasher, err := NewPasswordHasher(cfg)
+	if err != nil {
+		return err
+	}
+	passwordHasher = hasher
+	return nil
+}
+
+// HashPassword hashes password with the configured hasher.
+func HashPassword(password string) (string, error) {
+	return passwordHasher.Hash(password)
+}
+
+// VerifyPassword checks password against hash, whichever supported hasher
+// made it. When the password matches, needsRehash tells whether the hash
+// should be replaced by one from the configured hasher.
+func VerifyPassword(hash string, password string) (ok bool, needsRehash bool, err error) {
+	hasher, err := hasherFor(hash)
+	if err != nil {
+		return false, false, err
+	}
+	ok, err = hasher.Verify(hash, password)
+	if err != nil || !ok {
+		return false, false, err
+	}
+	return true, passwordHasher.NeedsRehash(hash), nil
+}
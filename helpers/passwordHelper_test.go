@@ -0,0 +1,179 @@
+package helpers
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// testArgon2id is cheap enough to hash with in tests.
+var testArgon2id = Argon2idHasher{Memory: 64, Iterations: 1, Parallelism: 1}
+
+func TestArgon2idHashFormat(t *testing.T) {
+	hash, err := testArgon2id.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=64,t=1,p=1$") {
+		t.Errorf("Hash = %s, want the $argon2id$v=19$m=64,t=1,p=1$ prefix", hash)
+	}
+
+	parsed, err := parseArgon2id(hash)
+	if err != nil {
+		t.Fatalf("parseArgon2id: %v", err)
+	}
+	if parsed.params != testArgon2id || len(parsed.salt) != argon2SaltLength || len(parsed.key) != argon2KeyLength {
+		t.Errorf("parseArgon2id = %+v, want the hasher's parameters with full length salt and key", parsed)
+	}
+
+	other, _ := testArgon2id.Hash("correct horse")
+	if other == hash {
+		t.Error("two hashes of the same password are equal, want a fresh salt each time")
+	}
+}
+
+func TestArgon2idVerify(t *testing.T) {
+	hash, _ := testArgon2id.Hash("correct horse")
+
+	if ok, err := testArgon2id.Verify(hash, "correct horse"); !ok || err != nil {
+		t.Errorf("Verify with the password = (%v, %v), want true", ok, err)
+	}
+	if ok, err := testArgon2id.Verify(hash, "battery staple"); ok || err != nil {
+		t.Errorf("Verify with another password = (%v, %v), want false", ok, err)
+	}
+
+	// The parameters come from the hash, not the hasher
+	if ok, err := (Argon2idHasher{}).Verify(hash, "correct horse"); !ok || err != nil {
+		t.Errorf("Verify by a hasher with other parameters = (%v, %v), want true", ok, err)
+	}
+}
+
+func TestParseArgon2idInvalid(t *testing.T) {
+	const salt, key = "c2FsdHNhbHRzYWx0c2FsdA", "a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2V5a2U"
+	for _, hash := range []string{
+		"",
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt,
+		"$argon2i$v=19$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=16$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$19$m=64,t=1,p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64;t=1;p=1$" + salt + "$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "==$" + key,
+		"$argon2id$v=19$m=64,t=1,p=1$" + salt + "$not base64!",
+	} {
+		if _, err := parseArgon2id(hash); err == nil {
+			t.Errorf("parseArgon2id(%q) succeeded, want an error", hash)
+		}
+	}
+}
+
+func TestArgon2idNeedsRehash(t *testing.T) {
+	hash, _ := testArgon2id.Hash("correct horse")
+	if testArgon2id.NeedsRehash(hash) {
+		t.Error("NeedsRehash of the hasher's own hash = true")
+	}
+
+	for _, other := range []Argon2idHasher{
+		{Memory: 128, Iterations: 1, Parallelism: 1},
+		{Memory: 64, Iterations: 2, Parallelism: 1},
+		{Memory: 64, Iterations: 1, Parallelism: 2},
+	} {
+		if !other.NeedsRehash(hash) {
+			t.Errorf("NeedsRehash by %+v of a hash with %+v = false", other, testArgon2id)
+		}
+	}
+
+	bcryptHash, _ := BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct horse")
+	if !testArgon2id.NeedsRehash(bcryptHash) {
+		t.Error("NeedsRehash of a bcrypt hash = false")
+	}
+}
+
+func TestBcryptHasher(t *testing.T) {
+	h := BcryptHasher{Cost: bcrypt.MinCost}
+	hash, err := h.Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := h.Verify(hash, "correct horse"); !ok || err != nil {
+		t.Errorf("Verify with the password = (%v, %v), want true", ok, err)
+	}
+	if ok, err := h.Verify(hash, "battery staple"); ok || err != nil {
+		t.Errorf("Verify with another password = (%v, %v), want false", ok, err)
+	}
+
+	if h.NeedsRehash(hash) {
+		t.Error("NeedsRehash at the same cost = true")
+	}
+	if !(BcryptHasher{Cost: bcrypt.MinCost + 1}).NeedsRehash(hash) {
+		t.Error("NeedsRehash at another cost = false")
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	defer func(h PasswordHasher) { passwordHasher = h }(passwordHasher)
+	passwordHasher = testArgon2id
+
+	current, _ := testArgon2id.Hash("correct horse")
+	weaker, _ := Argon2idHasher{Memory: 32, Iterations: 1, Parallelism: 1}.Hash("correct horse")
+	bcryptHash, _ := BcryptHasher{Cost: bcrypt.MinCost}.Hash("correct horse")
+
+	for _, tt := range []struct {
+		name        string
+		hash        string
+		needsRehash bool
+	}{
+		{"current argon2id", current, false},
+		{"argon2id with other parameters", weaker, true},
+		{"bcrypt $2a$", bcryptHash, true},
+		{"bcrypt $2b$", "$2b$" + strings.TrimPrefix(bcryptHash, "$2a$"), true},
+		{"bcrypt $2y$", "$2y$" + strings.TrimPrefix(bcryptHash, "$2a$"), true},
+	} {
+		ok, needsRehash, err := VerifyPassword(tt.hash, "correct horse")
+		if !ok || needsRehash != tt.needsRehash || err != nil {
+			t.Errorf("%s: VerifyPassword = (%v, %v, %v), want (true, %v, nil)", tt.name, ok, needsRehash, err, tt.needsRehash)
+		}
+
+		// Wrong passwords never ask for a rehash
+		ok, needsRehash, err = VerifyPassword(tt.hash, "battery staple")
+		if ok || needsRehash || err != nil {
+			t.Errorf("%s: VerifyPassword with another password = (%v, %v, %v), want false", tt.name, ok, needsRehash, err)
+		}
+	}
+}
+
+func TestVerifyPasswordUnknownHash(t *testing.T) {
+	for _, hash := range []string{"", "correct horse", "$scrypt$ln=15,r=8,p=1$c2FsdA$a2V5", "$1$salt$hash"} {
+		ok, _, err := VerifyPassword(hash, "correct horse")
+		if ok || !errors.Is(err, errUnknownPasswordHash) {
+			t.Errorf("VerifyPassword(%q) = (%v, %v), want errUnknownPasswordHash", hash, ok, err)
+		}
+	}
+}
+
+func TestNewPasswordHasher(t *testing.T) {
+	h, err := NewPasswordHasher(&config.PasswordConfig{Hasher: "argon2id", Argon2Memory: 64, Argon2Iterations: 1, Argon2Parallelism: 1})
+	if err != nil || h != PasswordHasher(testArgon2id) {
+		t.Errorf("NewPasswordHasher(argon2id) = (%v, %v), want %v", h, err, testArgon2id)
+	}
+	h, err = NewPasswordHasher(&config.PasswordConfig{Hasher: "bcrypt", BcryptCost: 12})
+	if err != nil || h != PasswordHasher(BcryptHasher{Cost: 12}) {
+		t.Errorf("NewPasswordHasher(bcrypt) = (%v, %v), want cost 12", h, err)
+	}
+
+	for _, cfg := range []config.PasswordConfig{
+		{Hasher: "argon2id", Argon2Iterations: 1, Argon2Parallelism: 1},
+		{Hasher: "argon2id", Argon2Memory: 64, Argon2Parallelism: 1},
+		{Hasher: "argon2id", Argon2Memory: 64, Argon2Iterations: 1},
+		{Hasher: "bcrypt", BcryptCost: bcrypt.MinCost - 1},
+		{Hasher: "bcrypt", BcryptCost: bcrypt.MaxCost + 1},
+		{Hasher: "scrypt"},
+	} {
+		if _, err := NewPasswordHasher(&cfg); err == nil {
+			t.Errorf("NewPasswordHasher(%+v) succeeded, want an error", cfg)
+		}
+	}
+}
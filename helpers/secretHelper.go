@@ -0,0 +1,98 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"unicode"
+)
+
+// PersonalTokenPrefix starts every personal access token, which tells them
+// apart from JWTs and lets secret scanners spot leaked ones.
+const PersonalTokenPrefix = "pat_"
+
+// NewSecretToken returns a random, URL safe token for links sent to users,
+// together with the hash to store in place of the token.
+func NewSecretToken() (string, []byte, error) {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", nil, err
+	}
+
+	token := base64.RawURLEncoding.EncodeToString(raw)
+	return token, HashSecretToken(token), nil
+}
+
+// HashSecretToken returns the hash stored for a token from NewSecretToken. The
+// tokens are long and random, so a plain SHA-256 is enough.
+func HashSecretToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
+}
+
+// userCodeAlphabet has no vowels, so user codes don't spell words, and no
+// letters easily mistaken for digits (RFC 8628).
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// NewUserCode returns a random user code for the device authorization grant,
+// eight letters users type in on another device.
+func NewUserCode() (string, error) {
+	code := make([]byte, 8)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(userCodeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		code[i] = userCodeAlphabet[n.Int64()]
+	}
+	return string(code), nil
+}
+
+// FormatUserCode shows a user code as two halves, like BCDF-GHJK.
+func FormatUserCode(code string) string {
+	if len(code) != 8 {
+		return code
+	}
+	return code[:4] + "-" + code[4:]
+}
+
+// NormalizeUserCode turns what a user typed in back into a user code,
+// ignoring case, dashes and spaces.
+func NormalizeUserCode(input string) string {
+	var b strings.Builder
+	for _, r := range input {
+		if unicode.IsLetter(r) {
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// NewPersonalAccessToken returns a new personal access token together with
+// the hash to store in its place.
+func NewPersonalAccessToken() (string, []byte, error) {
+	secret, _, err := NewSecretToken()
+	if err != nil {
+		return "", nil, err
+	}
+
+	token := PersonalTokenPrefix + secret
+	return token, HashSecretToken(token), nil
+}
+
+// IsPersonalAccessToken reports whether token looks like a personal access
+// token rather than a JWT.
+func IsPersonalAccessToken(token string) bool {
+	return strings.HasPrefix(token, PersonalTokenPrefix)
+}
+
+// VerifyCodeChallenge reports whether a PKCE code verifier matches the S256
+// code challenge a client sent before, the one method we accept (RFC 7636).
+func VerifyCodeChallenge(verifier string, challenge string) bool {
+	hash := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(hash[:])
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
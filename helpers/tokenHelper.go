@@ -1,63 +1,448 @@
 package helpers
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
-	"os"
+	"strings"
 	"time"
 
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"github.com/MoulieshN/Go-JWT-Project.git/keyring"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
 	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
 )
 
-type signedDetails struct {
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+	// MFAPendingTokenType is what Login hands out to users with MFA enabled.
+	// It is only good for exchanging, together with a code, for real tokens.
+	MFAPendingTokenType = "mfa_pending"
+	// PersonalTokenType marks the claims Authenticate builds for personal
+	// access tokens, which are not JWTs.
+	PersonalTokenType = "personal"
+)
+
+// AccessTokenTTL is how long access tokens from GenerateAllTokens last.
+const AccessTokenTTL = 24 * time.Hour
+
+// RefreshTokenTTL is how long refresh tokens from GenerateAllTokens last.
+const RefreshTokenTTL = 168 * time.Hour
+
+// IDTokenTTL is how long ID tokens last. Clients only read them right after
+// signing in.
+const IDTokenTTL = time.Hour
+
+type SignedDetails struct {
 	Email     string
 	FirstName string
 	LastName  string
 	UserType  string
 	Uid       string
+	TokenType string
+	// Permissions are the user's effective permissions when the token was
+	// issued. Role changes take effect once the token is refreshed.
+	Permissions []string `json:",omitempty"`
+	// Roles are the user's roles when the token was issued, the assigned
+	// ones and those held implicitly, for the role guards
+	Roles []string `json:"roles,omitempty"`
+	// OrgId is the tenant the token acts in. Tokens without one can't see
+	// any tenant's data.
+	OrgId string `json:"org_id,omitempty"`
+	// Restricted tokens are issued to users who haven't verified their email
+	// address yet. They carry no permissions and only work on the auth routes.
+	Restricted bool `json:"restricted,omitempty"`
+	// ClientId is the service account of client credentials tokens, which
+	// have no Uid
+	ClientId string `json:"client_id,omitempty"`
+	// AuthorizedParty is the OAuth client user tokens were issued to, empty
+	// for tokens from Login
+	AuthorizedParty string `json:"azp,omitempty"`
+	// Scope holds the OAuth scopes granted: the Permissions of client
+	// credentials tokens, space separated the way OAuth resource servers
+	// expect them, or the OpenID Connect scopes of tokens users got through
+	// the authorization endpoint
+	Scope string `json:"scope,omitempty"`
+	// Confirmation binds the token to a key of the client's (RFC 7800), so
+	// it is only good together with proof of holding that key
+	Confirmation *Confirmation `json:"cnf,omitempty"`
+	// FamilyId is the refresh token family both tokens of a pair belong to.
+	// A login starts a family and refreshing stays in it, so a stolen
+	// refresh token only takes down the session it was stolen from.
+	FamilyId string `json:"fid,omitempty"`
+	// IssuedAtMicro is iat to the microsecond. iat only counts whole
+	// seconds, which can't tell the tokens a logout-all revokes from those
+	// of the login right after it.
+	IssuedAtMicro int64 `json:"iat_us,omitempty"`
 	jwt.RegisteredClaims
 }
 
-var SECRET_KEY = os.Getenv("SECRET_KEY")
+// Confirmation is the cnf claim of bound tokens.
+type Confirmation struct {
+	// JKT is the RFC 7638 thumbprint of the client's DPoP key (RFC 9449)
+	JKT string `json:"jkt,omitempty"`
+	// X5tS256 is the SHA-256 thumbprint of the client's TLS certificate
+	// (RFC 8705)
+	X5tS256 string `json:"x5t#S256,omitempty"`
+}
+
+// TokenSubject is the user a token pair is issued to.
+type TokenSubject struct {
+	Email       string
+	FirstName   string
+	LastName    string
+	UserType    string
+	UserId      string
+	OrgId       string
+	Permissions []string
+	Roles       []string
+	Restricted  bool
+	// ClientId is the OAuth client that asked for the tokens and Scope what
+	// it was granted, both empty for tokens from Login. Refreshed tokens
+	// keep them.
+	ClientId string
+	Scope    string
+	// Confirmation binds both tokens to the client's key, nil for bearer
+	// tokens
+	Confirmation *Confirmation
+	// FamilyId is the refresh token family of the session
+	FamilyId string
+}
+
+// UserClaims are the OpenID Connect standard claims about a user, as far as
+// the granted scopes reveal them.
+type UserClaims struct {
+	Email         string `json:"email,omitempty"`
+	EmailVerified *bool  `json:"email_verified,omitempty"`
+	Name          string `json:"name,omitempty"`
+	GivenName     string `json:"given_name,omitempty"`
+	FamilyName    string `json:"family_name,omitempty"`
+}
+
+// IDTokenSubject is the user an ID token tells a client about.
+type IDTokenSubject struct {
+	UserId   string
+	ClientId string
+	// Nonce is echoed from the authorization request, it ties the token to
+	// the client's session
+	Nonce string
+	// AuthTime is when the user entered their password
+	AuthTime time.Time
+	UserClaims
+}
+
+// IDTokenClaims are the claims of ID tokens.
+type IDTokenClaims struct {
+	Nonce    string           `json:"nonce,omitempty"`
+	AuthTime *jwt.NumericDate `json:"auth_time,omitempty"`
+	UserClaims
+	jwt.RegisteredClaims
+}
 
-func GenerateAllTokens(email string, firstname string, lastname string, userType string, userId string) (string, string, error) {
-	claims := &signedDetails{
-		Email:     email,
-		FirstName: firstname,
-		LastName:  lastname,
-		Uid:       userId,
-		UserType:  userType,
+// ClientSubject is the service account a client credentials token is issued
+// to.
+type ClientSubject struct {
+	ClientId string
+	OrgId    string
+	Scopes   []string
+	// Audience defaults to this service
+	Audience     string
+	Confirmation *Confirmation
+}
+
+var keys *keyring.Keyring
+
+// audience is this service's name in the aud claim.
+var audience string
+
+// issuer is the iss claim of ID tokens.
+var issuer string
+
+// InitSigning loads the keys used to sign and verify tokens. With a keyring
+// manifest configured the keys are reloaded from it periodically, otherwise
+// a single key is built from the JWT settings: HMAC algorithms use the shared
+// SECRET_KEY, everything else reads a PEM private key file.
+func InitSigning(cfg *config.JWTConfig) error {
+	audience, issuer = cfg.Audience, cfg.Issuer
+	if cfg.KeyringFile != "" {
+		ring, err := keyring.Load(cfg.KeyringFile)
+		if err != nil {
+			return err
+		}
+		if _, err := ring.SigningKey(time.Now()); err != nil {
+			return fmt.Errorf("keyring %s: %w", cfg.KeyringFile, err)
+		}
+		go ring.Watch(context.Background(), cfg.KeyringReloadInterval)
+		keys = ring
+		return nil
+	}
+
+	var key *keyring.Key
+	var err error
+	if strings.HasPrefix(cfg.Algorithm, "HS") {
+		key, err = keyring.NewHMACKey(cfg.Algorithm, cfg.KeyID, []byte(cfg.Secret))
+	} else {
+		key, err = keyring.LoadPrivateKey(cfg.Algorithm, cfg.KeyID, cfg.PrivateKeyFile)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to load %s signing key: %w", cfg.Algorithm, err)
+	}
+
+	keys = keyring.New(keyring.Entry{Key: key})
+	return nil
+}
+
+// JWKS returns the JSON Web Key Set holding every key other services need to
+// verify our tokens, including keys that are about to be promoted. Shared
+// secrets are never included.
+func JWKS() map[string]interface{} {
+	jwks := []interface{}{}
+	if keys == nil {
+		return map[string]interface{}{"keys": jwks}
+	}
+
+	for _, key := range keys.VerificationKeys(time.Now()) {
+		if key.IsSymmetric() {
+			continue
+		}
+		jwk, err := key.JWK()
+		if err != nil {
+			log.Printf("Error %s when encoding signing key %s", err, key.ID)
+			continue
+		}
+		jwks = append(jwks, jwk)
+	}
+	return map[string]interface{}{"keys": jwks}
+}
+
+func signToken(claims jwt.Claims) (string, error) {
+	if keys == nil {
+		return "", errors.New("no signing key has been configured")
+	}
+	key, err := keys.SigningKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	return token.SignedString(key.PrivateKey)
+}
+
+// verificationKey picks the key a token was signed with by its kid. Tokens
+// without a kid predate key rotation and are checked against the current
+// signing key.
+func verificationKey(t *jwt.Token) (interface{}, error) {
+	if keys == nil {
+		return nil, errors.New("no signing key has been configured")
+	}
+
+	var key *keyring.Key
+	var err error
+	if kid, ok := t.Header["kid"].(string); ok {
+		key, err = keys.VerificationKey(kid, time.Now())
+	} else {
+		key, err = keys.SigningKey(time.Now())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Never let the token choose how it is verified
+	if t.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("unexpected signing method %s", t.Method.Alg())
+	}
+	return key.PublicKey, nil
+}
+
+func GenerateAllTokens(subject TokenSubject) (string, string, error) {
+	now := time.Now()
+
+	// Every token carries a unique ID so it can be revoked on its own
+	claims := &SignedDetails{
+		Email:           subject.Email,
+		FirstName:       subject.FirstName,
+		LastName:        subject.LastName,
+		Uid:             subject.UserId,
+		UserType:        subject.UserType,
+		TokenType:       AccessTokenType,
+		Permissions:     subject.Permissions,
+		Roles:           subject.Roles,
+		OrgId:           subject.OrgId,
+		Restricted:      subject.Restricted,
+		AuthorizedParty: subject.ClientId,
+		Scope:           subject.Scope,
+		Confirmation:    subject.Confirmation,
+		FamilyId:        subject.FamilyId,
+		IssuedAtMicro:   now.UnixMicro(),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Hour * time.Duration(24))),
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Local().Add(AccessTokenTTL)),
 		},
 	}
 
-	refreshClaims := &signedDetails{
+	// The unique ID also makes every rotation produce a distinct refresh
+	// token, which is what makes reuse of an old one detectable. Bound
+	// refresh tokens can only be used by the client holding the key.
+	refreshClaims := &SignedDetails{
+		Uid:             subject.UserId,
+		TokenType:       RefreshTokenType,
+		OrgId:           subject.OrgId,
+		AuthorizedParty: subject.ClientId,
+		Scope:           subject.Scope,
+		Confirmation:    subject.Confirmation,
+		FamilyId:        subject.FamilyId,
+		IssuedAtMicro:   now.UnixMicro(),
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Local().Add(time.Hour * time.Duration(168))),
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Local().Add(RefreshTokenTTL)),
 		},
 	}
 
-	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString([]byte(SECRET_KEY))
+	token, err := signToken(claims)
 	if err != nil {
-		log.Panic(err)
 		return "", "", err
 	}
 
-	refresh_token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, refreshClaims).SignedString([]byte(SECRET_KEY))
+	refresh_token, err := signToken(refreshClaims)
 	if err != nil {
-		log.Panic(err)
 		return "", "", err
 	}
 	return token, refresh_token, nil
 }
 
-func ValidateToken(signedToken string) (claims *signedDetails, msg string) {
+// GenerateClientToken issues an access token to a service account. It is
+// not a user's token, so sub is the client and there is no refresh token.
+func GenerateClientToken(subject ClientSubject, ttl time.Duration) (string, error) {
+	aud := subject.Audience
+	if aud == "" {
+		aud = audience
+	}
+
+	now := time.Now()
+	claims := &SignedDetails{
+		ClientId:      subject.ClientId,
+		TokenType:     AccessTokenType,
+		Permissions:   subject.Scopes,
+		Scope:         strings.Join(subject.Scopes, " "),
+		OrgId:         subject.OrgId,
+		Confirmation:  subject.Confirmation,
+		IssuedAtMicro: now.UnixMicro(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Subject:   subject.ClientId,
+			Audience:  jwt.ClaimStrings{aud},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return signToken(claims)
+}
+
+// GenerateIDToken issues an OpenID Connect ID token, meant for the client
+// rather than for calling the API.
+func GenerateIDToken(subject IDTokenSubject) (string, error) {
+	now := time.Now()
+	claims := &IDTokenClaims{
+		Nonce:      subject.Nonce,
+		AuthTime:   jwt.NewNumericDate(subject.AuthTime),
+		UserClaims: subject.UserClaims,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			Subject:   subject.UserId,
+			Audience:  jwt.ClaimStrings{subject.ClientId},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(IDTokenTTL)),
+		},
+	}
+	return signToken(claims)
+}
+
+// SigningAlgorithm returns the algorithm new tokens are signed with.
+func SigningAlgorithm() (string, error) {
+	if keys == nil {
+		return "", errors.New("no signing key has been configured")
+	}
+	key, err := keys.SigningKey(time.Now())
+	if err != nil {
+		return "", err
+	}
+	return key.Method.Alg(), nil
+}
+
+func ValidateToken(signedToken string) (claims *SignedDetails, msg string) {
+	claims, msg = parseToken(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+
+	// Tokens from before token types were introduced have none
+	if claims.TokenType != AccessTokenType && claims.TokenType != "" {
+		return nil, "The token is not an access token"
+	}
+
+	// Tokens without an audience are our own users' tokens
+	if len(claims.Audience) > 0 && !claims.VerifyAudience(audience, true) {
+		return nil, "The token is meant for another audience"
+	}
+
+	return claims, ""
+}
+
+func ValidateRefreshToken(signedToken string) (claims *SignedDetails, msg string) {
+	claims, msg = parseToken(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+
+	if claims.TokenType != RefreshTokenType || claims.Uid == "" {
+		return nil, "The token is not a refresh token"
+	}
+
+	return claims, ""
+}
+
+// GenerateMFAPendingToken issues the short-lived token that proves the user
+// got the password right. orgId is the organization they asked to log in to.
+func GenerateMFAPendingToken(userId string, orgId string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims := &SignedDetails{
+		Uid:           userId,
+		TokenType:     MFAPendingTokenType,
+		OrgId:         orgId,
+		IssuedAtMicro: now.UnixMicro(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+	}
+	return signToken(claims)
+}
+
+func ValidateMFAPendingToken(signedToken string) (claims *SignedDetails, msg string) {
+	claims, msg = parseToken(signedToken)
+	if msg != "" {
+		return nil, msg
+	}
+
+	if claims.TokenType != MFAPendingTokenType || claims.Uid == "" {
+		return nil, "The token is not an MFA token"
+	}
+
+	return claims, ""
+}
+
+func parseToken(signedToken string) (claims *SignedDetails, msg string) {
 	token, err := jwt.ParseWithClaims(
 		signedToken,
-		&signedDetails{},
-		func(t *jwt.Token) (interface{}, error) {
-			return []byte(SECRET_KEY), nil
-		},
+		&SignedDetails{},
+		verificationKey,
 	)
 
 	if err != nil {
@@ -65,17 +450,104 @@ func ValidateToken(signedToken string) (claims *signedDetails, msg string) {
 		return
 	}
 
-	claims, ok := token.Claims.(*signedDetails)
-	if !ok {
-		msg = "The token is invalid: " + err.Error()
-		return
+	claims, ok := token.Claims.(*SignedDetails)
+	if !ok || !token.Valid {
+		msg = "The token is invalid"
+		return nil, msg
+	}
+
+	if claims.ExpiresAt == nil {
+		msg = "The token has no expiry"
+		return nil, msg
 	}
 
-	if claims.ExpiresAt != nil && claims.ExpiresAt.Time.Before(time.Now()) {
+	if claims.ExpiresAt.Time.Before(time.Now()) {
 		// Token is expired
-		msg = "The token is expired: " + err.Error()
-		return
+		msg = "The token is expired"
+		return nil, msg
 	}
 
 	return claims, msg
 }
+
+// IssuedAtTime is when the token was issued, as precisely as its claims
+// tell. Tokens minted before iat was added count as issued at the zero time.
+func (s *SignedDetails) IssuedAtTime() time.Time {
+	if s.IssuedAtMicro != 0 {
+		return time.UnixMicro(s.IssuedAtMicro)
+	}
+	if s.IssuedAt != nil {
+		return s.IssuedAt.Time
+	}
+	return time.Time{}
+}
+
+// CheckRevoked looks the token up in the revocation store. Tokens without
+// iat_us only say the second they were issued in, so a logout-all in that
+// second revokes them too. Revoking a refresh token family revokes its
+// family ID like a jti, which takes every token of the family with it.
+// Tokens acting in an organization are also revoked when the user's
+// membership changed after they were issued.
+func CheckRevoked(store repository.RevocationStore, claims *SignedDetails) (msg string) {
+	revoked, err := IsRevoked(store, claims)
+	if err != nil {
+		log.Printf("Error %s when checking token revocation", err)
+		return "Unable to verify the token"
+	}
+	if revoked {
+		return "The token has been revoked"
+	}
+	return ""
+}
+
+// IsRevoked is CheckRevoked for callers that need the store's error rather
+// than a message.
+func IsRevoked(store repository.RevocationStore, claims *SignedDetails) (bool, error) {
+	revoked, err := store.IsRevoked(claims.ID, claims.Principal(), claims.IssuedAtTime())
+	if err == nil && !revoked && claims.FamilyId != "" {
+		revoked, err = store.IsRevoked(claims.FamilyId, "", time.Time{})
+	}
+	if err == nil && !revoked && claims.OrgId != "" && claims.Uid != "" {
+		revoked, err = store.IsMemberRevoked(claims.OrgId, claims.Uid, claims.IssuedAtTime())
+	}
+	return revoked, err
+}
+
+// Principal is who the token acts for: the user, or the service account of
+// client credentials tokens. Revoking all tokens of either goes by it.
+func (s *SignedDetails) Principal() string {
+	if s.Uid != "" {
+		return s.Uid
+	}
+	return s.ClientId
+}
+
+// IssuedTo is the client the token was issued to: the OAuth client of user
+// tokens it asked for, or the service account of client credentials tokens.
+// It is empty for tokens users got from Login themselves.
+func (s *SignedDetails) IssuedTo() string {
+	if s.Uid != "" {
+		return s.AuthorizedParty
+	}
+	return s.ClientId
+}
+
+// HasRole reports whether the token's user held the role.
+func (s *SignedDetails) HasRole(role string) bool {
+	for _, held := range s.Roles {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasPermission reports whether the token grants the permission.
+func (s *SignedDetails) HasPermission(permission string) bool {
+	for _, granted := range s.Permissions {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+package helpers
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"github.com/MoulieshN/Go-JWT-Project.git/keyring"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+func initTestSigning(t *testing.T) {
+	t.Helper()
+	if err := InitSigning(&config.JWTConfig{Algorithm: "HS256", Secret: "test-secret", Audience: dpopTestURL}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func issueTestTokens(t *testing.T, userId string) (access *SignedDetails, refresh *SignedDetails) {
+	t.Helper()
+	token, refreshToken, err := GenerateAllTokens(TokenSubject{UserId: userId, Email: "user@example.com", FamilyId: uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	access, msg := ValidateToken(token)
+	if msg != "" {
+		t.Fatalf("ValidateToken: %s", msg)
+	}
+	refresh, msg = ValidateRefreshToken(refreshToken)
+	if msg != "" {
+		t.Fatalf("ValidateRefreshToken: %s", msg)
+	}
+	return access, refresh
+}
+
+// writeTestKey saves private as a PEM file for JWT_PRIVATE_KEY_FILE.
+func writeTestKey(t *testing.T, private interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "signing.pem")
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestTokenTypes(t *testing.T) {
+	initTestSigning(t)
+	access, refresh := issueTestTokens(t, uuid.NewString())
+
+	if _, msg := ValidateToken(mustSign(t, refresh)); msg == "" {
+		t.Error("a refresh token was accepted as an access token")
+	}
+	if _, msg := ValidateRefreshToken(mustSign(t, access)); msg == "" {
+		t.Error("an access token was accepted as a refresh token")
+	}
+	if access.FamilyId == "" || refresh.FamilyId != access.FamilyId {
+		t.Errorf("token families = %q and %q, want the same", access.FamilyId, refresh.FamilyId)
+	}
+
+	// Rotating must still give a refresh token of its own
+	if _, again := issueTestTokens(t, refresh.Uid); again.ID == refresh.ID {
+		t.Error("a new refresh token reused the ID of the last one")
+	}
+}
+
+func TestAsymmetricSigning(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for alg, private := range map[string]interface{}{"RS256": rsaKey, "ES256": ecKey, "EdDSA": edKey} {
+		err := InitSigning(&config.JWTConfig{Algorithm: alg, KeyID: "key-1", PrivateKeyFile: writeTestKey(t, private), Audience: dpopTestURL})
+		if err != nil {
+			t.Fatalf("InitSigning(%s): %v", alg, err)
+		}
+
+		access, _ := issueTestTokens(t, uuid.NewString())
+		token, err := jwt.ParseWithClaims(mustSign(t, access), &SignedDetails{}, verificationKey)
+		if err != nil || token.Method.Alg() != alg || token.Header["kid"] != "key-1" {
+			t.Errorf("%s token: alg %v, kid %v, err %v", alg, token.Header["alg"], token.Header["kid"], err)
+		}
+
+		// Verifiers find the public key, and only that, by kid
+		jwks := JWKS()["keys"].([]interface{})
+		if len(jwks) != 1 {
+			t.Fatalf("%s JWKS has %d keys, want 1", alg, len(jwks))
+		}
+		jwk := jwks[0].(map[string]interface{})
+		if jwk["kid"] != "key-1" || jwk["alg"] != alg {
+			t.Errorf("%s JWKS key = %v", alg, jwk)
+		}
+		if _, ok := jwk["d"]; ok {
+			t.Errorf("%s JWKS publishes the private key", alg)
+		}
+	}
+}
+
+// mustSign signs claims again with the current signing key.
+func mustSign(t *testing.T, claims *SignedDetails) string {
+	t.Helper()
+	token, err := signToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestJWKSLeavesOutSharedSecrets(t *testing.T) {
+	initTestSigning(t)
+	if keys := JWKS()["keys"].([]interface{}); len(keys) != 0 {
+		t.Errorf("JWKS with an HMAC key = %v, want no keys", keys)
+	}
+}
+
+func TestTokenCantPickItsAlgorithm(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := InitSigning(&config.JWTConfig{Algorithm: "RS256", KeyID: "key-1", PrivateKeyFile: writeTestKey(t, rsaKey), Audience: dpopTestURL}); err != nil {
+		t.Fatal(err)
+	}
+	access, _ := issueTestTokens(t, uuid.NewString())
+
+	// HS256 keyed with the public key, which anyone can fetch from the JWKS
+	publicKey, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := jwt.NewWithClaims(jwt.SigningMethodHS256, access)
+	forged.Header["kid"] = "key-1"
+	hmacToken, err := forged.SignedString(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicKey}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, access)
+	noneToken, err := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, token := range map[string]string{"HS256": hmacToken, "none": noneToken} {
+		if _, msg := ValidateToken(token); msg == "" {
+			t.Errorf("a token signed with %s was accepted", name)
+		}
+	}
+}
+
+func TestRotatedKeysKeepVerifying(t *testing.T) {
+	initTestSigning(t)
+	now := time.Now()
+	oldKey, _ := keyring.NewHMACKey("HS256", "old", []byte("old secret"))
+	newKey, _ := keyring.NewHMACKey("HS256", "new", []byte("new secret"))
+
+	keys = keyring.New(keyring.Entry{Key: oldKey, ActivatesAt: now.Add(-time.Hour)})
+	oldToken, _, err := GenerateAllTokens(TokenSubject{UserId: uuid.NewString(), FamilyId: uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The new key signs, the old one verifies what it signed until it retires
+	keys = keyring.New(
+		keyring.Entry{Key: oldKey, ActivatesAt: now.Add(-time.Hour), RetiresAt: now.Add(time.Hour)},
+		keyring.Entry{Key: newKey, ActivatesAt: now.Add(-time.Minute)},
+	)
+	newToken, _, err := GenerateAllTokens(TokenSubject{UserId: uuid.NewString(), FamilyId: uuid.NewString()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed, _ := jwt.Parse(newToken, nil); parsed == nil || parsed.Header["kid"] != "new" {
+		t.Error("a token signed after the rotation isn't signed with the new key")
+	}
+	for name, token := range map[string]string{"old": oldToken, "new": newToken} {
+		if _, msg := ValidateToken(token); msg != "" {
+			t.Errorf("the %s token was refused: %s", name, msg)
+		}
+	}
+
+	keys = keyring.New(
+		keyring.Entry{Key: oldKey, ActivatesAt: now.Add(-time.Hour), RetiresAt: now.Add(-time.Second)},
+		keyring.Entry{Key: newKey, ActivatesAt: now.Add(-time.Minute)},
+	)
+	if _, msg := ValidateToken(oldToken); msg == "" {
+		t.Error("a token of a retired key was accepted")
+	}
+}
+
+func TestCheckRevokedLogoutAll(t *testing.T) {
+	initTestSigning(t)
+	store := repository.NewMemoryRevocationStore()
+	userId := uuid.NewString()
+
+	oldAccess, oldRefresh := issueTestTokens(t, userId)
+	if err := store.RevokeUserTokens(userId, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	newAccess, newRefresh := issueTestTokens(t, userId)
+
+	for _, claims := range []*SignedDetails{oldAccess, oldRefresh} {
+		if msg := CheckRevoked(store, claims); msg == "" {
+			t.Errorf("a %s token from before the logout-all was accepted", claims.TokenType)
+		}
+	}
+	// Likely from the same second, which iat can't tell apart
+	for _, claims := range []*SignedDetails{newAccess, newRefresh} {
+		if msg := CheckRevoked(store, claims); msg != "" {
+			t.Errorf("a %s token from after the logout-all was refused: %s", claims.TokenType, msg)
+		}
+	}
+}
+
+func TestCheckRevokedSameSecond(t *testing.T) {
+	store := repository.NewMemoryRevocationStore()
+	userId := uuid.NewString()
+	cutoff := time.Now().Truncate(time.Second).Add(500 * time.Millisecond)
+	if err := store.RevokeUserTokens(userId, cutoff); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		issuedAt time.Time
+		micro    int64
+		want     bool
+	}{
+		{"earlier in the second", cutoff, cutoff.Add(-time.Millisecond).UnixMicro(), true},
+		{"later in the second", cutoff, cutoff.Add(time.Millisecond).UnixMicro(), false},
+		{"in the second, without iat_us", cutoff, 0, true},
+		{"the next second, without iat_us", cutoff.Add(time.Second), 0, false},
+	} {
+		claims := &SignedDetails{
+			Uid:           userId,
+			IssuedAtMicro: tt.micro,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:       uuid.NewString(),
+				IssuedAt: jwt.NewNumericDate(tt.issuedAt),
+			},
+		}
+		if revoked := CheckRevoked(store, claims) != ""; revoked != tt.want {
+			t.Errorf("a token issued %s: revoked = %v, want %v", tt.name, revoked, tt.want)
+		}
+	}
+}
+
+func TestCheckRevokedMemberTokens(t *testing.T) {
+	store := repository.NewMemoryRevocationStore()
+	userId, orgId := uuid.NewString(), uuid.NewString()
+	issuedAt := time.Now().Add(-time.Minute)
+	if err := store.RevokeMemberTokens(orgId, userId, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name  string
+		orgId string
+		want  bool
+	}{
+		{"in the organization", orgId, true},
+		{"in another organization", uuid.NewString(), false},
+		{"in no organization", "", false},
+	} {
+		claims := &SignedDetails{
+			Uid:           userId,
+			OrgId:         tt.orgId,
+			IssuedAtMicro: issuedAt.UnixMicro(),
+			RegisteredClaims: jwt.RegisteredClaims{
+				ID:       uuid.NewString(),
+				IssuedAt: jwt.NewNumericDate(issuedAt),
+			},
+		}
+		if revoked := CheckRevoked(store, claims) != ""; revoked != tt.want {
+			t.Errorf("a token %s: revoked = %v, want %v", tt.name, revoked, tt.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package keyring
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// Key is a key usable for signing and verifying tokens. Verification
+// only keys have a nil PrivateKey. For HMAC algorithms both PrivateKey and
+// PublicKey hold the shared secret as a []byte.
+type Key struct {
+	ID         string
+	Method     jwt.SigningMethod
+	PrivateKey crypto.PrivateKey
+	PublicKey  crypto.PublicKey
+}
+
+// NewHMACKey builds a symmetric key for one of the HS256/HS384/HS512 algorithms.
+func NewHMACKey(alg string, kid string, secret []byte) (*Key, error) {
+	method, ok := jwt.GetSigningMethod(alg).(*jwt.SigningMethodHMAC)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an HMAC algorithm", alg)
+	}
+	if len(secret) == 0 {
+		return nil, errors.New("the HMAC secret must not be empty")
+	}
+	if kid == "" {
+		kid = "default"
+	}
+
+	return &Key{ID: kid, Method: method, PrivateKey: secret, PublicKey: secret}, nil
+}
+
+// LoadPrivateKey reads a PEM encoded private key for an asymmetric algorithm
+// (RS*, PS*, ES* or EdDSA). When kid is empty the RFC 7638 thumbprint of the
+// public key is used, so every replica loading the same file agrees on it.
+func LoadPrivateKey(alg string, kid string, privateKeyFile string) (*Key, error) {
+	pemBytes, err := os.ReadFile(privateKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	key := &Key{ID: kid, Method: jwt.GetSigningMethod(alg)}
+	switch method := key.Method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		key.PrivateKey, key.PublicKey = private, &private.PublicKey
+	case *jwt.SigningMethodECDSA:
+		private, err := jwt.ParseECPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		if private.Curve.Params().BitSize != method.CurveBits {
+			return nil, fmt.Errorf("%s needs a %d bit curve, got %s", alg, method.CurveBits, private.Curve.Params().Name)
+		}
+		key.PrivateKey, key.PublicKey = private, &private.PublicKey
+	case *jwt.SigningMethodEd25519:
+		private, err := jwt.ParseEdPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, err
+		}
+		edKey, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, errors.New("the private key is not an Ed25519 key")
+		}
+		key.PrivateKey, key.PublicKey = edKey, edKey.Public()
+	default:
+		return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+
+	if key.ID == "" {
+		jwk, err := PublicJWK(key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		if key.ID, err = JWKThumbprint(jwk); err != nil {
+			return nil, err
+		}
+	}
+
+	return key, nil
+}
+
+// IsSymmetric reports whether the key is a shared secret, which must never be
+// published.
+func (k *Key) IsSymmetric() bool {
+	_, ok := k.Method.(*jwt.SigningMethodHMAC)
+	return ok
+}
+
+// JWK returns the public half of the key in JWK form, tagged with its kid and
+// algorithm. It fails for symmetric keys.
+func (k *Key) JWK() (map[string]interface{}, error) {
+	if k.IsSymmetric() {
+		return nil, errors.New("symmetric keys can't be published")
+	}
+
+	jwk, err := PublicJWK(k.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	jwk["kid"] = k.ID
+	jwk["alg"] = k.Method.Alg()
+	jwk["use"] = "sig"
+	return jwk, nil
+}
+
+// PublicJWK encodes an RSA, ECDSA or Ed25519 public key as a JWK holding only
+// the required members.
+func PublicJWK(pub crypto.PublicKey) (map[string]interface{}, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return map[string]interface{}{
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return map[string]interface{}{
+			"kty": "EC",
+			"crv": pub.Curve.Params().Name,
+			"x":   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+			"y":   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return map[string]interface{}{
+			"kty": "OKP",
+			"crv": "Ed25519",
+			"x":   base64.RawURLEncoding.EncodeToString(pub),
+		}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+// ParsePublicJWK decodes a public RSA, ECDSA or Ed25519 key from a JWK, the
+// inverse of PublicJWK. JWKs holding a private key are refused, whoever sent
+// one has leaked it.
+func ParsePublicJWK(jwk map[string]interface{}) (crypto.PublicKey, error) {
+	if _, ok := jwk["d"]; ok {
+		return nil, errors.New("the JWK holds a private key")
+	}
+	member := func(name string) ([]byte, error) {
+		value, ok := jwk[name].(string)
+		if !ok || value == "" {
+			return nil, fmt.Errorf("the JWK has no %s", name)
+		}
+		decoded, err := base64.RawURLEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("the JWK's %s is not base64url encoded: %w", name, err)
+		}
+		return decoded, nil
+	}
+
+	switch jwk["kty"] {
+	case "RSA":
+		n, err := member("n")
+		if err != nil {
+			return nil, err
+		}
+		e, err := member("e")
+		if err != nil {
+			return nil, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+			return nil, errors.New("the JWK's RSA exponent is out of range")
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}
+		if pub.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("the JWK's RSA key has %d bits, at least 2048 are needed", pub.N.BitLen())
+		}
+		return pub, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch jwk["crv"] {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %v", jwk["crv"])
+		}
+		x, err := member("x")
+		if err != nil {
+			return nil, err
+		}
+		y, err := member("y")
+		if err != nil {
+			return nil, err
+		}
+		size := (curve.Params().BitSize + 7) / 8
+		if len(x) != size || len(y) != size {
+			return nil, errors.New("the JWK's EC coordinates have the wrong length")
+		}
+		pub := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("the JWK's EC point is not on the curve")
+		}
+		return pub, nil
+	case "OKP":
+		if jwk["crv"] != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %v", jwk["crv"])
+		}
+		x, err := member("x")
+		if err != nil {
+			return nil, err
+		}
+		if len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("the JWK's Ed25519 key has the wrong length")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %v", jwk["kty"])
+	}
+}
+
+// JWKThumbprint computes the RFC 7638 SHA-256 thumbprint of a public JWK.
+func JWKThumbprint(jwk map[string]interface{}) (string, error) {
+	var required []string
+	switch jwk["kty"] {
+	case "RSA":
+		required = []string{"e", "kty", "n"}
+	case "EC":
+		required = []string{"crv", "kty", "x", "y"}
+	case "OKP":
+		required = []string{"crv", "kty", "x"}
+	default:
+		return "", fmt.Errorf("unsupported key type %v", jwk["kty"])
+	}
+
+	// The members have to be in lexicographic order with no whitespace
+	members := make([]string, 0, len(required))
+	for _, name := range required {
+		value, err := json.Marshal(jwk[name])
+		if err != nil {
+			return "", err
+		}
+		members = append(members, fmt.Sprintf("%q:%s", name, value))
+	}
+
+	sum := sha256.Sum256([]byte("{" + strings.Join(members, ",") + "}"))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,139 @@
+package keyring
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writePrivateKey saves private as a PKCS #8 PEM file in dir.
+func writePrivateKey(t *testing.T, dir string, name string, private interface{}) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrivateKey(t *testing.T) {
+	dir := t.TempDir()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		alg     string
+		private interface{}
+		kty     string
+	}{
+		{"RS256", rsaKey, "RSA"},
+		{"PS256", rsaKey, "RSA"},
+		{"ES256", ecKey, "EC"},
+		{"EdDSA", edKey, "OKP"},
+	} {
+		path := writePrivateKey(t, dir, tt.alg+".pem", tt.private)
+		key, err := LoadPrivateKey(tt.alg, "", path)
+		if err != nil {
+			t.Errorf("LoadPrivateKey(%s): %v", tt.alg, err)
+			continue
+		}
+		if key.IsSymmetric() || key.Method.Alg() != tt.alg {
+			t.Errorf("%s key: symmetric %v, alg %s", tt.alg, key.IsSymmetric(), key.Method.Alg())
+		}
+
+		jwk, err := key.JWK()
+		if err != nil {
+			t.Errorf("JWK of the %s key: %v", tt.alg, err)
+			continue
+		}
+		if jwk["kty"] != tt.kty || jwk["alg"] != tt.alg || jwk["use"] != "sig" || jwk["kid"] != key.ID {
+			t.Errorf("JWK of the %s key = %v", tt.alg, jwk)
+		}
+		if _, ok := jwk["d"]; ok {
+			t.Errorf("JWK of the %s key holds the private key", tt.alg)
+		}
+
+		// Without a kid, replicas agree on the thumbprint
+		if thumbprint, _ := JWKThumbprint(jwk); key.ID != thumbprint {
+			t.Errorf("%s key id = %s, want its thumbprint %s", tt.alg, key.ID, thumbprint)
+		}
+		if named, _ := LoadPrivateKey(tt.alg, "2024-01", path); named == nil || named.ID != "2024-01" {
+			t.Errorf("LoadPrivateKey(%s) with a kid = %+v", tt.alg, named)
+		}
+	}
+
+	// The curve has to match the algorithm
+	if _, err := LoadPrivateKey("ES384", "", writePrivateKey(t, dir, "p256.pem", ecKey)); err == nil {
+		t.Error("LoadPrivateKey(ES384) of a P-256 key succeeded")
+	}
+	if _, err := LoadPrivateKey("HS256", "", filepath.Join(dir, "RS256.pem")); err == nil {
+		t.Error("LoadPrivateKey(HS256) succeeded")
+	}
+	if _, err := LoadPrivateKey("RS256", "", filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("LoadPrivateKey of a missing file succeeded")
+	}
+}
+
+func TestNewHMACKey(t *testing.T) {
+	key, err := NewHMACKey("HS256", "", []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.IsSymmetric() || key.ID != "default" {
+		t.Errorf("HMAC key: symmetric %v, id %s", key.IsSymmetric(), key.ID)
+	}
+	if _, err := key.JWK(); err == nil {
+		t.Error("JWK of a shared secret succeeded")
+	}
+
+	if _, err := NewHMACKey("HS256", "", nil); err == nil {
+		t.Error("NewHMACKey without a secret succeeded")
+	}
+	if _, err := NewHMACKey("RS256", "", []byte("secret")); err == nil {
+		t.Error("NewHMACKey(RS256) succeeded")
+	}
+}
+
+// TestJWKThumbprint checks the example of RFC 7638 section 3.1.
+func TestJWKThumbprint(t *testing.T) {
+	jwk := map[string]interface{}{
+		"kty": "RSA",
+		"n": strings.Join([]string{
+			"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn6",
+			"4tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91",
+			"CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw",
+		}, ""),
+		"e":   "AQAB",
+		"alg": "RS256",
+		"kid": "2011-04-29",
+	}
+	thumbprint, err := JWKThumbprint(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint != "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs" {
+		t.Errorf("JWKThumbprint = %s", thumbprint)
+	}
+}
@@ -0,0 +1,146 @@
+package keyring
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Entry is a key together with the window in which it is used. A key signs
+// new tokens from ActivatesAt until a newer key activates, and verifies tokens
+// until RetiresAt. A zero RetiresAt means the key never retires.
+type Entry struct {
+	Key         *Key
+	ActivatesAt time.Time
+	RetiresAt   time.Time
+}
+
+func (e Entry) canVerify(now time.Time) bool {
+	return e.RetiresAt.IsZero() || now.Before(e.RetiresAt)
+}
+
+func (e Entry) canSign(now time.Time) bool {
+	return e.Key.PrivateKey != nil && !now.Before(e.ActivatesAt) && e.canVerify(now)
+}
+
+// Keyring holds every key that is currently trusted. It is safe for
+// concurrent use, and when it was loaded from a manifest it can be reloaded in
+// place so keys are rotated without restarting the server.
+type Keyring struct {
+	mu      sync.RWMutex
+	entries []Entry
+	path    string
+}
+
+// New builds a keyring from fixed entries.
+func New(entries ...Entry) *Keyring {
+	k := &Keyring{}
+	k.set(entries)
+	return k
+}
+
+// Load builds a keyring from the manifest at path.
+func Load(path string) (*Keyring, error) {
+	k := &Keyring{path: path}
+	if err := k.Reload(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Reload re-reads the manifest the keyring was loaded from. On error the
+// current keys stay in place.
+func (k *Keyring) Reload() error {
+	if k.path == "" {
+		return errors.New("the keyring was not loaded from a manifest")
+	}
+
+	manifest, err := ReadManifest(k.path)
+	if err != nil {
+		return err
+	}
+	entries, err := manifest.Entries(k.path)
+	if err != nil {
+		return err
+	}
+
+	k.set(entries)
+	return nil
+}
+
+// Watch reloads the manifest every interval until ctx is done.
+func (k *Keyring) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := k.Reload(); err != nil {
+				log.Printf("Error %s when reloading keyring %s", err, k.path)
+			}
+		}
+	}
+}
+
+func (k *Keyring) set(entries []Entry) {
+	sorted := append([]Entry(nil), entries...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].ActivatesAt.After(sorted[j].ActivatesAt)
+	})
+
+	k.mu.Lock()
+	k.entries = sorted
+	k.mu.Unlock()
+}
+
+// SigningKey returns the most recently activated key that can sign at now.
+func (k *Keyring) SigningKey(now time.Time) (*Key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, entry := range k.entries {
+		if entry.canSign(now) {
+			return entry.Key, nil
+		}
+	}
+	return nil, errors.New("no signing key is active")
+}
+
+// VerificationKey returns the key with the given kid if it hasn't retired.
+// Keys that are not active yet are accepted too, so replicas whose manifest
+// is a few seconds ahead don't cause spurious failures.
+func (k *Keyring) VerificationKey(kid string, now time.Time) (*Key, error) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	for _, entry := range k.entries {
+		if entry.Key.ID == kid {
+			if !entry.canVerify(now) {
+				return nil, fmt.Errorf("key %s has been retired", kid)
+			}
+			return entry.Key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %s", kid)
+}
+
+// VerificationKeys returns every key that hasn't retired at now, newest first.
+func (k *Keyring) VerificationKeys(now time.Time) []*Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+
+	keys := make([]*Key, 0, len(k.entries))
+	for _, entry := range k.entries {
+		if entry.canVerify(now) {
+			keys = append(keys, entry.Key)
+		}
+	}
+	return keys
+}
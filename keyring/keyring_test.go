@@ -0,0 +1,150 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func hmacEntry(t *testing.T, kid string, activatesAt time.Time, retiresAt time.Time) Entry {
+	t.Helper()
+	key, err := NewHMACKey("HS256", kid, []byte("secret of "+kid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Entry{Key: key, ActivatesAt: activatesAt, RetiresAt: retiresAt}
+}
+
+func TestKeyringWindows(t *testing.T) {
+	now := time.Now()
+	ring := New(
+		hmacEntry(t, "old", now.Add(-48*time.Hour), now.Add(time.Hour)),
+		hmacEntry(t, "current", now.Add(-time.Hour), time.Time{}),
+		hmacEntry(t, "next", now.Add(time.Hour), time.Time{}),
+		hmacEntry(t, "retired", now.Add(-72*time.Hour), now.Add(-time.Minute)),
+	)
+
+	// The newest active key signs, the next one only once it activates
+	for _, tt := range []struct {
+		at  time.Time
+		kid string
+	}{
+		{now, "current"},
+		{now.Add(2 * time.Hour), "next"},
+		{now.Add(-24 * time.Hour), "old"},
+	} {
+		key, err := ring.SigningKey(tt.at)
+		if err != nil || key.ID != tt.kid {
+			t.Errorf("SigningKey(%s) = (%v, %v), want %s", tt.at, key, err, tt.kid)
+		}
+	}
+	if _, err := ring.SigningKey(now.Add(-96 * time.Hour)); err == nil {
+		t.Error("SigningKey before any key activated succeeded")
+	}
+
+	// Tokens of the old key verify until it retires, the next key's early
+	for _, kid := range []string{"old", "current", "next"} {
+		if _, err := ring.VerificationKey(kid, now); err != nil {
+			t.Errorf("VerificationKey(%s): %v", kid, err)
+		}
+	}
+	if _, err := ring.VerificationKey("old", now.Add(2*time.Hour)); err == nil {
+		t.Error("VerificationKey of a retired key succeeded")
+	}
+	if _, err := ring.VerificationKey("unknown", now); err == nil {
+		t.Error("VerificationKey of an unknown key succeeded")
+	}
+
+	var kids []string
+	for _, key := range ring.VerificationKeys(now) {
+		kids = append(kids, key.ID)
+	}
+	if len(kids) != 3 || kids[0] != "next" || kids[1] != "current" || kids[2] != "old" {
+		t.Errorf("VerificationKeys = %v, want [next current old]", kids)
+	}
+}
+
+func TestManifestRotation(t *testing.T) {
+	dir := t.TempDir()
+	for _, kid := range []string{"first", "second"} {
+		if err := os.WriteFile(filepath.Join(dir, kid+".key"), []byte("secret of "+kid+"\n"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "keyring.json")
+	now := time.Now().UTC().Truncate(time.Second)
+
+	manifest := &Manifest{}
+	if err := manifest.Add(ManifestKey{KeyID: "first", Algorithm: "HS256", SecretFile: "first.key"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Promote("first", now.Add(-time.Hour), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	ring, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	// An added key is published for verification but doesn't sign
+	if err := manifest.Add(ManifestKey{KeyID: "second", Algorithm: "HS256", SecretFile: "second.key"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Add(ManifestKey{KeyID: "second", Algorithm: "HS256", SecretFile: "second.key"}); err == nil {
+		t.Error("adding a key id twice succeeded")
+	}
+	if err := manifest.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if key, _ := ring.SigningKey(now); key == nil || key.ID != "first" {
+		t.Errorf("signing key after adding a key = %v, want first", key)
+	}
+	if _, err := ring.VerificationKey("second", now); err != nil {
+		t.Errorf("the added key doesn't verify: %v", err)
+	}
+
+	// Promoting it keeps the first key verifying for the overlap
+	if err := manifest.Promote("second", now, 24*time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Write(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Reload(); err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if key, _ := ring.SigningKey(now); key == nil || key.ID != "second" {
+		t.Errorf("signing key after the promotion = %v, want second", key)
+	}
+	if _, err := ring.VerificationKey("first", now.Add(23*time.Hour)); err != nil {
+		t.Errorf("the first key stopped verifying within the overlap: %v", err)
+	}
+	if _, err := ring.VerificationKey("first", now.Add(24*time.Hour)); err == nil {
+		t.Error("the first key still verifies after the overlap")
+	}
+
+	if err := manifest.Retire("second", now); err != nil {
+		t.Fatal(err)
+	}
+	if err := manifest.Retire("unknown", now); err == nil {
+		t.Error("retiring an unknown key succeeded")
+	}
+
+	// A broken manifest leaves the loaded keys in place
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ring.Reload(); err == nil {
+		t.Error("Reload of a broken manifest succeeded")
+	}
+	if key, _ := ring.SigningKey(now); key == nil || key.ID != "second" {
+		t.Errorf("signing key after a failed reload = %v, want second", key)
+	}
+}
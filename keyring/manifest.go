@@ -0,0 +1,161 @@
+package keyring
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Manifest is the on-disk description of a keyring. Key material lives in
+// separate files referenced relative to the manifest, so the manifest itself
+// holds no secrets.
+type Manifest struct {
+	Keys []ManifestKey `json:"keys"`
+}
+
+type ManifestKey struct {
+	KeyID          string     `json:"kid"`
+	Algorithm      string     `json:"algorithm"`
+	PrivateKeyFile string     `json:"private_key_file,omitempty"`
+	SecretFile     string     `json:"secret_file,omitempty"`
+	ActivatesAt    time.Time  `json:"activates_at"`
+	RetiresAt      *time.Time `json:"retires_at,omitempty"`
+}
+
+func ReadManifest(path string) (*Manifest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring manifest: %w", err)
+	}
+	return &manifest, nil
+}
+
+// Write saves the manifest atomically, so a server reloading it concurrently
+// sees either the old or the new version.
+func (m *Manifest) Write(path string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".keyring-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(append(data, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (m *Manifest) find(kid string) (*ManifestKey, error) {
+	for i := range m.Keys {
+		if m.Keys[i].KeyID == kid {
+			return &m.Keys[i], nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %s", kid)
+}
+
+// Add registers a new key. It is published for verification straight away but
+// only signs once it is promoted.
+func (m *Manifest) Add(key ManifestKey) error {
+	if key.KeyID == "" {
+		return fmt.Errorf("the key id must not be empty")
+	}
+	if _, err := m.find(key.KeyID); err == nil {
+		return fmt.Errorf("key id %s already exists", key.KeyID)
+	}
+
+	// Far in the future until promoted
+	key.ActivatesAt = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.Keys = append(m.Keys, key)
+	return nil
+}
+
+// Promote makes kid the signing key from at onwards. Every key that was
+// signing before then keeps verifying for retireAfter, which should be at
+// least the lifetime of the longest-lived token.
+func (m *Manifest) Promote(kid string, at time.Time, retireAfter time.Duration) error {
+	promoted, err := m.find(kid)
+	if err != nil {
+		return err
+	}
+
+	retiresAt := at.Add(retireAfter)
+	for i := range m.Keys {
+		key := &m.Keys[i]
+		if key.KeyID == kid || key.ActivatesAt.After(at) {
+			continue
+		}
+		if key.RetiresAt == nil || key.RetiresAt.After(retiresAt) {
+			key.RetiresAt = &retiresAt
+		}
+	}
+
+	promoted.ActivatesAt = at
+	promoted.RetiresAt = nil
+	return nil
+}
+
+// Retire stops kid from signing or verifying anything from at onwards.
+func (m *Manifest) Retire(kid string, at time.Time) error {
+	key, err := m.find(kid)
+	if err != nil {
+		return err
+	}
+	key.RetiresAt = &at
+	return nil
+}
+
+// Entries loads the key material for every key in the manifest. Relative
+// paths are resolved against the directory of manifestPath.
+func (m *Manifest) Entries(manifestPath string) ([]Entry, error) {
+	dir := filepath.Dir(manifestPath)
+	resolve := func(path string) string {
+		if filepath.IsAbs(path) {
+			return path
+		}
+		return filepath.Join(dir, path)
+	}
+
+	entries := make([]Entry, 0, len(m.Keys))
+	for _, mk := range m.Keys {
+		var key *Key
+		var err error
+		if strings.HasPrefix(mk.Algorithm, "HS") {
+			var secret []byte
+			secret, err = os.ReadFile(resolve(mk.SecretFile))
+			if err == nil {
+				key, err = NewHMACKey(mk.Algorithm, mk.KeyID, bytes.TrimSpace(secret))
+			}
+		} else {
+			key, err = LoadPrivateKey(mk.Algorithm, mk.KeyID, resolve(mk.PrivateKeyFile))
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to load key %s: %w", mk.KeyID, err)
+		}
+
+		entry := Entry{Key: key, ActivatesAt: mk.ActivatesAt}
+		if mk.RetiresAt != nil {
+			entry.RetiresAt = *mk.RetiresAt
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
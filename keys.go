@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"github.com/MoulieshN/Go-JWT-Project.git/keyring"
+	"github.com/namsral/flag"
+)
+
+const keysUsage = `usage: keys <command> [flags]
+
+commands:
+  list                                          show every key and its state
+  add -kid ID -alg ALG (-private-key-file F | -secret-file F)
+                                                publish a new key without signing with it
+  promote -kid ID [-at RFC3339]                 sign with the key from -at onwards
+  retire -kid ID [-at RFC3339]                  stop trusting the key from -at onwards`
+
+// runKeysCommand edits the keyring manifest. Running servers pick the change
+// up on their next reload, so keys are rotated without a restart.
+func runKeysCommand(args []string) error {
+	cfg := config.GetConfig().JWT
+	if cfg.KeyringFile == "" {
+		return errors.New("JWT_KEYRING_FILE is not set")
+	}
+	if len(args) == 0 {
+		return errors.New(keysUsage)
+	}
+
+	if args[0] == "list" {
+		manifest, err := readOrCreateManifest(cfg.KeyringFile)
+		if err != nil {
+			return err
+		}
+		printKeys(manifest, time.Now())
+		return nil
+	}
+
+	fs := flag.NewFlagSet("keys "+args[0], flag.ContinueOnError)
+	kid := fs.String("kid", "", "Key ID")
+	at := fs.String("at", "", "When the change takes effect (RFC3339)")
+	alg := fs.String("alg", "", "Signing algorithm")
+	privateKeyFile := fs.String("private-key-file", "", "PEM private key, relative to the manifest")
+	secretFile := fs.String("secret-file", "", "HMAC secret, relative to the manifest")
+	if err := fs.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	manifest, err := readOrCreateManifest(cfg.KeyringFile)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		err = manifest.Add(keyring.ManifestKey{
+			KeyID:          *kid,
+			Algorithm:      *alg,
+			PrivateKeyFile: *privateKeyFile,
+			SecretFile:     *secretFile,
+		})
+	case "promote":
+		// By default give every replica two reloads to learn the key before
+		// anything is signed with it
+		when := time.Now().Add(2 * cfg.KeyringReloadInterval)
+		if when, err = parseWhen(*at, when); err == nil {
+			err = manifest.Promote(*kid, when, cfg.KeyRetirementDelay)
+		}
+	case "retire":
+		var when time.Time
+		if when, err = parseWhen(*at, time.Now()); err == nil {
+			err = manifest.Retire(*kid, when)
+		}
+	default:
+		return errors.New(keysUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Refuse to write a manifest the servers would fail to load
+	if _, err := manifest.Entries(cfg.KeyringFile); err != nil {
+		return err
+	}
+	if err := manifest.Write(cfg.KeyringFile); err != nil {
+		return err
+	}
+
+	printKeys(manifest, time.Now())
+	return nil
+}
+
+func readOrCreateManifest(path string) (*keyring.Manifest, error) {
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return &keyring.Manifest{}, nil
+	}
+	return keyring.ReadManifest(path)
+}
+
+func parseWhen(value string, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback.UTC().Truncate(time.Second), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func printKeys(manifest *keyring.Manifest, now time.Time) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "KID\tALG\tACTIVATES\tRETIRES\tSTATE")
+
+	signing := ""
+	if entries, err := manifest.Entries(config.GetConfig().JWT.KeyringFile); err == nil {
+		if key, err := keyring.New(entries...).SigningKey(now); err == nil {
+			signing = key.ID
+		}
+	}
+
+	for _, key := range manifest.Keys {
+		retires, state := "never", "verifying"
+		if key.RetiresAt != nil {
+			retires = key.RetiresAt.Format(time.RFC3339)
+		}
+		switch {
+		case key.RetiresAt != nil && !now.Before(*key.RetiresAt):
+			state = "retired"
+		case key.KeyID == signing:
+			state = "signing"
+		case key.ActivatesAt.After(now):
+			state = "pending"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", key.KeyID, key.Algorithm, key.ActivatesAt.Format(time.RFC3339), retires, state)
+	}
+	w.Flush()
+}
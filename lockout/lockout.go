@@ -0,0 +1,47 @@
+// Package lockout decides how long logins are held off after failed
+// attempts. The first few failures are free, after that every failure doubles
+// the wait before the next attempt, and too many failures lock out for a
+// while.
+package lockout
+
+import "time"
+
+// maxShift keeps the doubling from overflowing, the delay is capped long
+// before that anyway.
+const maxShift = 30
+
+type Policy struct {
+	// BackoffAfter is how many failures are allowed before delays start.
+	// Zero turns the delays off.
+	BackoffAfter int
+	// BaseDelay is the wait after failure number BackoffAfter, it doubles
+	// with every further failure.
+	BaseDelay time.Duration
+	// LockoutAfter is how many failures lock out. Zero turns lockouts off.
+	LockoutAfter int
+	// LockoutDuration is how long a lockout lasts. It also caps the delays,
+	// and failures older than this are forgotten.
+	LockoutDuration time.Duration
+}
+
+// BlockedUntil returns when the next attempt is allowed, after count failures
+// with the last one at lastFailure. Attempts are allowed right away when it
+// returns a time in the past.
+func (p Policy) BlockedUntil(count int, lastFailure time.Time) time.Time {
+	if p.LockoutAfter > 0 && count >= p.LockoutAfter {
+		return lastFailure.Add(p.LockoutDuration)
+	}
+	if p.BackoffAfter <= 0 || count < p.BackoffAfter {
+		return time.Time{}
+	}
+
+	shift := count - p.BackoffAfter
+	if shift > maxShift {
+		shift = maxShift
+	}
+	delay := p.BaseDelay << shift
+	if delay > p.LockoutDuration {
+		delay = p.LockoutDuration
+	}
+	return lastFailure.Add(delay)
+}
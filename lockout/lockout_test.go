@@ -0,0 +1,64 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlockedUntil(t *testing.T) {
+	p := Policy{BackoffAfter: 3, BaseDelay: time.Second, LockoutAfter: 10, LockoutDuration: 15 * time.Minute}
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tt := range []struct {
+		count int
+		wait  time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{9, 64 * time.Second},
+		{10, 15 * time.Minute},
+		{25, 15 * time.Minute},
+	} {
+		got := p.BlockedUntil(tt.count, last)
+		if tt.wait == 0 {
+			if got.After(last) {
+				t.Errorf("BlockedUntil after %d failures = %v, want no wait", tt.count, got)
+			}
+			continue
+		}
+		if want := last.Add(tt.wait); !got.Equal(want) {
+			t.Errorf("BlockedUntil after %d failures = %v, want %v", tt.count, got.Sub(last), tt.wait)
+		}
+	}
+}
+
+func TestBlockedUntilCapsDelays(t *testing.T) {
+	// Without a lockout the doubling runs into LockoutDuration and stays there
+	p := Policy{BackoffAfter: 1, BaseDelay: time.Second, LockoutDuration: time.Minute}
+	last := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, count := range []int{7, 8, 40, 1000} {
+		if got := p.BlockedUntil(count, last).Sub(last); got != time.Minute {
+			t.Errorf("BlockedUntil after %d failures waits %v, want 1m", count, got)
+		}
+	}
+}
+
+func TestBlockedUntilDisabled(t *testing.T) {
+	last := time.Now()
+	if got := (Policy{}).BlockedUntil(1000, last); got.After(last) {
+		t.Errorf("zero Policy blocks until %v, want no wait", got)
+	}
+
+	// Lockouts without delays only start at LockoutAfter
+	p := Policy{LockoutAfter: 5, LockoutDuration: time.Hour}
+	if got := p.BlockedUntil(4, last); got.After(last) {
+		t.Errorf("BlockedUntil before the lockout = %v, want no wait", got)
+	}
+	if got := p.BlockedUntil(5, last); !got.Equal(last.Add(time.Hour)) {
+		t.Errorf("BlockedUntil at the lockout = %v, want %v", got, last.Add(time.Hour))
+	}
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"fmt"
+	"log"
 	"os"
 
 	config "github.com/MoulieshN/Go-JWT-Project.git/config"
@@ -14,13 +16,35 @@ var (
 )
 
 func main() {
+	flag.Parse()
+
+	config.Init(*env)
+
+	if args := flag.Args(); len(args) > 0 {
+		if err := runCommand(args); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	port := os.Getenv("PORT")
 
 	if port == "" {
 		port = "8123"
 	}
 
-	config.Init(*env)
-
 	server.Init(context.Background(), port)
 }
+
+func runCommand(args []string) error {
+	switch args[0] {
+	case "admins":
+		return runAdminsCommand(args[1:])
+	case "keys":
+		return runKeysCommand(args[1:])
+	case "migrate":
+		return runMigrateCommand(args[1:])
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
@@ -3,31 +3,150 @@ package middleware
 import (
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
 	"github.com/gin-gonic/gin"
 )
 
-func Authenticate() gin.HandlerFunc {
+// PersonalTokenResolver looks up personal access tokens and returns the
+// claims requests made with them run with, or why the token is refused.
+type PersonalTokenResolver interface {
+	ResolvePersonalToken(token string) (claims *helpers.SignedDetails, msg string)
+}
+
+// Authenticate accepts an access token from the standard
+// "Authorization: Bearer" header, or from the older "token" header, and
+// stores its claims in the context for the handlers and guards that follow.
+// Tokens bound to a DPoP key come in an "Authorization: DPoP" header instead,
+// together with a proof signed by that key. Tokens bound to a client
+// certificate only count over a connection made with it.
+func Authenticate(revocations repository.RevocationStore, dpop helpers.DPoPVerifier) gin.HandlerFunc {
+	return authenticate(revocations, dpop, nil)
+}
+
+// AuthenticateWithPersonalTokens is Authenticate that also accepts personal
+// access tokens. Their scopes only show in the permissions, so it is meant
+// for routes guarded by permissions, not for the auth routes where a token
+// could be exchanged for a session.
+func AuthenticateWithPersonalTokens(revocations repository.RevocationStore, dpop helpers.DPoPVerifier, personalTokens PersonalTokenResolver) gin.HandlerFunc {
+	return authenticate(revocations, dpop, personalTokens)
+}
+
+func authenticate(revocations repository.RevocationStore, dpop helpers.DPoPVerifier, personalTokens PersonalTokenResolver) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		clientToken := c.Request.Header.Get("token")
+		clientToken, scheme := requestToken(c.Request)
 		if clientToken == "" {
 			log.Print("Not authorized to acces the resources")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Unauthorized"})
-			c.Abort()
+			c.Header("WWW-Authenticate", `Bearer, DPoP algs="`+strings.Join(helpers.DPoPAlgorithms, " ")+`"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
 			return
 		}
-		claims, msg := helpers.ValidateToken(clientToken)
+
+		var claims *helpers.SignedDetails
+		var msg string
+		switch {
+		case !helpers.IsPersonalAccessToken(clientToken):
+			claims, msg = helpers.ValidateToken(clientToken)
+		case personalTokens != nil:
+			claims, msg = personalTokens.ResolvePersonalToken(clientToken)
+		default:
+			msg = "Personal access tokens can't be used here"
+		}
+		// Logging out everywhere and password resets revoke personal access
+		// tokens too
+		if msg == "" {
+			msg = helpers.CheckRevoked(revocations, claims)
+		}
 		if msg != "" {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": msg})
-			c.Abort()
+			c.Header("WWW-Authenticate", scheme+` error="invalid_token"`)
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+			return
+		}
+
+		// A bound token is no good without a proof of its key, and the DPoP
+		// scheme is only for bound tokens
+		jkt := claims.DPoPKey()
+		if jkt != "" || scheme == dpopScheme {
+			switch {
+			case scheme != dpopScheme:
+				msg = "The token is bound to a DPoP key and must be sent with the DPoP scheme"
+			case jkt == "":
+				msg = "The token is not bound to a DPoP key"
+			}
+			if msg != "" {
+				c.Header("WWW-Authenticate", scheme+` error="invalid_token"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+				return
+			}
+
+			proofKey, msg := dpop.Verify(c.Request, clientToken)
+			if msg == "" && proofKey == "" {
+				msg = "The request has no DPoP proof"
+			}
+			if msg == "" && proofKey != jkt {
+				msg = "The DPoP proof is signed with another key than the token is bound to"
+			}
+			if msg != "" {
+				c.Header("WWW-Authenticate", dpopScheme+` error="invalid_dpop_proof"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": msg})
+				return
+			}
+			helpers.SetDPoPKey(c, proofKey)
+		}
+
+		if x5t := claims.BoundCertificate(); x5t != "" {
+			cert := helpers.ClientCertificate(c.Request)
+			if cert == nil || helpers.CertificateThumbprint(cert) != x5t {
+				c.Header("WWW-Authenticate", scheme+` error="invalid_token"`)
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "The token is bound to another client certificate"})
+				return
+			}
+		}
+
+		helpers.SetClaims(c, claims)
+		c.Next()
+	}
+}
+
+const (
+	bearerScheme = "Bearer"
+	dpopScheme   = "DPoP"
+)
+
+// requestToken returns the token the request presents, and the scheme of
+// its Authorization header. The older "token" header counts as Bearer.
+func requestToken(r *http.Request) (token string, scheme string) {
+	if header := r.Header.Get("Authorization"); header != "" {
+		scheme, token, ok := strings.Cut(header, " ")
+		if !ok {
+			return "", bearerScheme
+		}
+		switch {
+		case strings.EqualFold(scheme, bearerScheme):
+			return strings.TrimSpace(token), bearerScheme
+		case strings.EqualFold(scheme, dpopScheme):
+			return strings.TrimSpace(token), dpopScheme
+		}
+		return "", bearerScheme
+	}
+	return r.Header.Get("token"), bearerScheme
+}
+
+// RejectRestricted turns away restricted tokens, which users get before they
+// verify their email address. It must run after Authenticate.
+func RejectRestricted() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := helpers.GetClaims(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if claims.Restricted {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "email address has not been verified"})
 			return
 		}
-		c.Set("Email", claims.Email)
-		c.Set("FirstName", claims.FirstName)
-		c.Set("LastName", claims.LastName)
-		c.Set("Uid", claims.Uid)
-		c.Set("UserType", claims.UserType)
 		c.Next()
 	}
 }
@@ -0,0 +1,196 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/keyring"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+const testURL = "https://auth.example.com"
+
+func TestMain(m *testing.M) {
+	gin.SetMode(gin.TestMode)
+	if err := helpers.InitSigning(&config.JWTConfig{Algorithm: "HS256", Secret: "test-secret", Audience: testURL}); err != nil {
+		panic(err)
+	}
+	m.Run()
+}
+
+// newTestRouter serves /api/v1/users behind Authenticate, answering with
+// the user the token is for.
+func newTestRouter() *gin.Engine {
+	dpop := helpers.NewDPoPVerifier(repository.NewMemoryDPoPProofStore(), testURL)
+	router := gin.New()
+	router.GET("/api/v1/users", Authenticate(repository.NewMemoryRevocationStore(), dpop), func(c *gin.Context) {
+		claims, _ := helpers.GetClaims(c)
+		c.JSON(http.StatusOK, gin.H{"data": claims.Uid})
+	})
+	return router
+}
+
+type dpopKey struct {
+	key *ecdsa.PrivateKey
+	jwk map[string]interface{}
+	jkt string
+}
+
+func newDPoPKey(t *testing.T) dpopKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := keyring.PublicJWK(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jkt, err := keyring.JWKThumbprint(jwk)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dpopKey{key: key, jwk: jwk, jkt: jkt}
+}
+
+// proof signs a proof for GET /api/v1/users presenting accessToken.
+func (k dpopKey) proof(t *testing.T, accessToken string) string {
+	t.Helper()
+	hash := sha256.Sum256([]byte(accessToken))
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, &helpers.DPoPProofClaims{
+		HTTPMethod:      "GET",
+		HTTPURI:         testURL + "/api/v1/users",
+		AccessTokenHash: base64.RawURLEncoding.EncodeToString(hash[:]),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:       uuid.NewString(),
+			IssuedAt: jwt.NewNumericDate(time.Now()),
+		},
+	})
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = k.jwk
+	proof, err := token.SignedString(k.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+// accessToken issues an access token, bound to the DPoP key with the
+// thumbprint jkt unless it is empty.
+func accessToken(t *testing.T, jkt string) string {
+	t.Helper()
+	subject := helpers.TokenSubject{Email: "ann@example.com", UserType: "USER", UserId: uuid.NewString(), FamilyId: uuid.NewString()}
+	if jkt != "" {
+		subject.Confirmation = &helpers.Confirmation{JKT: jkt}
+	}
+	token, _, err := helpers.GenerateAllTokens(subject)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func get(router *gin.Engine, authorization string, proofs ...string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", testURL+"/api/v1/users", nil)
+	r.Header.Set("Authorization", authorization)
+	for _, proof := range proofs {
+		r.Header.Add("DPoP", proof)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w
+}
+
+func assertRefused(t *testing.T, w *httptest.ResponseRecorder, challenge string, msg string) {
+	t.Helper()
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("status = %d, want 401", w.Code)
+	}
+	if got := w.Header().Get("WWW-Authenticate"); got != challenge {
+		t.Errorf("WWW-Authenticate = %q, want %q", got, challenge)
+	}
+	if !strings.Contains(w.Body.String(), msg) {
+		t.Errorf("body = %s, want it to mention %q", w.Body.String(), msg)
+	}
+}
+
+func TestAuthenticateDPoP(t *testing.T) {
+	router := newTestRouter()
+	key := newDPoPKey(t)
+	token := accessToken(t, key.jkt)
+
+	w := get(router, "DPoP "+token, key.proof(t, token))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d %s, want 200", w.Code, w.Body.String())
+	}
+
+	// Every request takes a fresh proof
+	proof := key.proof(t, token)
+	get(router, "DPoP "+token, proof)
+	assertRefused(t, get(router, "DPoP "+token, proof), `DPoP error="invalid_dpop_proof"`, "used before")
+}
+
+func TestAuthenticateDPoPRefusals(t *testing.T) {
+	key := newDPoPKey(t)
+	other := newDPoPKey(t)
+	bound := accessToken(t, key.jkt)
+	bearer := accessToken(t, "")
+
+	for _, tt := range []struct {
+		name          string
+		authorization string
+		proofs        []string
+		challenge     string
+		msg           string
+	}{
+		{"bound token as Bearer", "Bearer " + bound, []string{key.proof(t, bound)}, `Bearer error="invalid_token"`, "must be sent with the DPoP scheme"},
+		{"bound token in the token header", "", nil, `Bearer error="invalid_token"`, "must be sent with the DPoP scheme"},
+		{"bearer token with DPoP", "DPoP " + bearer, []string{key.proof(t, bearer)}, `DPoP error="invalid_token"`, "not bound to a DPoP key"},
+		{"no proof", "DPoP " + bound, nil, `DPoP error="invalid_dpop_proof"`, "no DPoP proof"},
+		{"proof by another key", "DPoP " + bound, []string{other.proof(t, bound)}, `DPoP error="invalid_dpop_proof"`, "another key"},
+		{"proof for another token", "DPoP " + bound, []string{key.proof(t, bearer)}, `DPoP error="invalid_dpop_proof"`, "another access token"},
+		{"two proofs", "DPoP " + bound, []string{key.proof(t, bound), key.proof(t, bound)}, `DPoP error="invalid_dpop_proof"`, "more than one"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", testURL+"/api/v1/users", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			} else {
+				r.Header.Set("token", bound)
+			}
+			for _, proof := range tt.proofs {
+				r.Header.Add("DPoP", proof)
+			}
+			w := httptest.NewRecorder()
+			newTestRouter().ServeHTTP(w, r)
+			assertRefused(t, w, tt.challenge, tt.msg)
+		})
+	}
+}
+
+func TestAuthenticateBearer(t *testing.T) {
+	router := newTestRouter()
+	token := accessToken(t, "")
+
+	if w := get(router, "Bearer "+token); w.Code != http.StatusOK {
+		t.Errorf("status = %d %s, want 200", w.Code, w.Body.String())
+	}
+
+	w := get(router, "")
+	if w.Code != http.StatusUnauthorized || !strings.Contains(w.Header().Get("WWW-Authenticate"), "DPoP algs=") {
+		t.Errorf("without a token = %d %q, want 401 offering Bearer and DPoP", w.Code, w.Header().Get("WWW-Authenticate"))
+	}
+}
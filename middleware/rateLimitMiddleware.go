@@ -0,0 +1,136 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+// maxKeyBody caps how much of a request body KeyByEmail reads to find the
+// email, the rest is left unread for the handler.
+const maxKeyBody = 1 << 20
+
+// RateLimitKey picks the bucket a request counts against. An empty key
+// leaves the request alone.
+type RateLimitKey func(c *gin.Context) string
+
+// KeyByIP counts requests per client IP.
+func KeyByIP(c *gin.Context) string {
+	return "ip:" + c.ClientIP()
+}
+
+// KeyByEmail counts requests per "email" in the JSON body, so guesses at one
+// account are limited however many IPs they come from. The body is left in
+// place for the handler.
+func KeyByEmail(c *gin.Context) string {
+	if c.Request.Body == nil {
+		return ""
+	}
+	original := c.Request.Body
+	body, err := io.ReadAll(io.LimitReader(original, maxKeyBody))
+	c.Request.Body = restoredBody{Reader: io.MultiReader(bytes.NewReader(body), original), Closer: original}
+	if err != nil {
+		return ""
+	}
+
+	var req struct {
+		Email string `json:"email"`
+	}
+	if json.Unmarshal(body, &req) != nil || req.Email == "" {
+		return ""
+	}
+	return "email:" + strings.ToLower(strings.TrimSpace(req.Email))
+}
+
+// KeyByFormEmail counts requests per "email" field of a form post, like
+// KeyByEmail does for the JSON API. The parsed form stays on the request for
+// the handler.
+func KeyByFormEmail(c *gin.Context) string {
+	email := strings.ToLower(strings.TrimSpace(c.PostForm("email")))
+	if email == "" {
+		return ""
+	}
+	return "email:" + email
+}
+
+// restoredBody is a request body put back together after part of it was
+// read, still closing the original.
+type restoredBody struct {
+	io.Reader
+	io.Closer
+}
+
+// KeyByUser counts requests per authenticated user or service account, and
+// per client IP before Authenticate ran.
+func KeyByUser(c *gin.Context) string {
+	if claims, ok := helpers.GetClaims(c); ok {
+		return "user:" + claims.Principal()
+	}
+	return KeyByIP(c)
+}
+
+// RateLimit allows every key limit.Burst requests in a row, earned back over
+// limit.Per, and answers the rest with 429. name keeps the buckets of
+// different policies in one store apart. A disabled limit lets everything
+// through.
+func RateLimit(store ratelimit.Store, name string, limit ratelimit.Limit, key RateLimitKey) gin.HandlerFunc {
+	if !limit.Enabled() {
+		return func(c *gin.Context) {
+			c.Next()
+		}
+	}
+	policy := strconv.Itoa(limit.Burst) + ";w=" + strconv.Itoa(int(limit.Per.Seconds()))
+
+	return func(c *gin.Context) {
+		k := key(c)
+		if k == "" {
+			c.Next()
+			return
+		}
+
+		// A broken store shouldn't take the routes down with it
+		result, err := store.Take(name+":"+k, limit, time.Now())
+		if err != nil {
+			log.Printf("Error %s when checking rate limit %s", err, name)
+			c.Next()
+			return
+		}
+
+		setRateLimitHeaders(c, policy, limit, result)
+		if !result.Allowed {
+			c.Header("Retry-After", seconds(result.RetryAfter))
+			c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests, try again later"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// setRateLimitHeaders describes the limit closest to running out, when a
+// route has several.
+func setRateLimitHeaders(c *gin.Context, policy string, limit ratelimit.Limit, result ratelimit.Result) {
+	if current := c.Writer.Header().Get("RateLimit-Remaining"); current != "" {
+		if remaining, err := strconv.Atoi(current); err == nil && remaining <= result.Remaining {
+			return
+		}
+	}
+	c.Header("RateLimit-Policy", policy)
+	c.Header("RateLimit-Limit", strconv.Itoa(limit.Burst))
+	c.Header("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	c.Header("RateLimit-Reset", seconds(result.Reset))
+}
+
+// seconds rounds up, so clients never retry too early.
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/ratelimit"
+	"github.com/gin-gonic/gin"
+)
+
+func TestKeyByEmailKeepsWholeBody(t *testing.T) {
+	body := `{"email":" User@Example.com ","padding":"` + strings.Repeat("x", maxKeyBody) + `"}`
+
+	var key, seen string
+	router := gin.New()
+	router.POST("/login", func(c *gin.Context) {
+		key = KeyByEmail(c)
+		read, _ := io.ReadAll(c.Request.Body)
+		seen = string(read)
+	})
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", strings.NewReader(body)))
+
+	// The email is read from the first megabyte, the handler gets it all
+	if key != "" {
+		t.Errorf("KeyByEmail of a body over the limit = %q, want none", key)
+	}
+	if seen != body {
+		t.Errorf("the handler read %d bytes of a %d byte body", len(seen), len(body))
+	}
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/login", strings.NewReader(`{"email":" User@Example.com "}`)))
+	if key != "email:user@example.com" {
+		t.Errorf("KeyByEmail = %q, want email:user@example.com", key)
+	}
+}
+
+func TestKeyByFormEmail(t *testing.T) {
+	router := gin.New()
+	limit := RateLimit(ratelimit.NewMemoryStore(), "login-email", ratelimit.Limit{Burst: 2, Per: time.Hour}, KeyByFormEmail)
+	router.POST("/oauth/authorize", limit, func(c *gin.Context) {
+		c.String(http.StatusOK, c.PostForm("password"))
+	})
+
+	post := func(email string) *httptest.ResponseRecorder {
+		form := url.Values{"email": {email}, "password": {"guess"}}
+		req := httptest.NewRequest("POST", "/oauth/authorize", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := post("user@example.com"); w.Code != http.StatusOK || w.Body.String() != "guess" {
+			t.Fatalf("sign-in %d = %d %q, want the form passed on", i+1, w.Code, w.Body.String())
+		}
+	}
+	if w := post(" USER@example.com"); w.Code != http.StatusTooManyRequests {
+		t.Errorf("a third sign-in for the email = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if w := post("other@example.com"); w.Code != http.StatusOK {
+		t.Errorf("a sign-in for another email = %d, want %d", w.Code, http.StatusOK)
+	}
+}
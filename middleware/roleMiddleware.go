@@ -0,0 +1,98 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// RequireRole only lets the request through when the caller held one of the
+// given roles when their token was issued. Prefer RequirePermission, roles
+// are for the few routes that are about a role rather than what it allows.
+// It must run after Authenticate.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := helpers.GetClaims(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if !hasRole(claims, roles) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrRole lets the request through when the user ID in the path
+// parameter param is the caller's own, or when the caller held one of roles.
+// It must run after Authenticate.
+func RequireSelfOrRole(param string, roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := helpers.GetClaims(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if claims.Uid != c.Param(param) && !hasRole(claims, roles) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequirePermission only lets the request through when the caller's token
+// grants every one of the given permissions. It must run after Authenticate.
+func RequirePermission(permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := helpers.GetClaims(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if !hasPermissions(claims, permissions) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireSelfOrPermission lets the request through when the user ID in the
+// path parameter param is the caller's own, or when the caller's token grants
+// every one of permissions. It must run after Authenticate.
+func RequireSelfOrPermission(param string, permissions ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		claims, ok := helpers.GetClaims(c)
+		if !ok {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Unauthorized"})
+			return
+		}
+		if claims.Uid != c.Param(param) && !hasPermissions(claims, permissions) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "unauthorized to access this resource"})
+			return
+		}
+		c.Next()
+	}
+}
+
+func hasRole(claims *helpers.SignedDetails, roles []string) bool {
+	for _, role := range roles {
+		if claims.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPermissions(claims *helpers.SignedDetails, permissions []string) bool {
+	for _, permission := range permissions {
+		if !claims.HasPermission(permission) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/gin-gonic/gin"
+)
+
+// guarded serves /users/:user_id behind guard, as caller. A nil caller
+// skips Authenticate.
+func guarded(guard gin.HandlerFunc, caller *helpers.SignedDetails, userId string) int {
+	router := gin.New()
+	router.GET("/users/:user_id", func(c *gin.Context) {
+		if caller != nil {
+			helpers.SetClaims(c, caller)
+		}
+	}, guard, func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	})
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/users/"+userId, nil))
+	return w.Code
+}
+
+func TestRoleGuards(t *testing.T) {
+	admin := &helpers.SignedDetails{Uid: "admin-id", Roles: []string{"USER", "ADMIN"}}
+	user := &helpers.SignedDetails{Uid: "user-id", Roles: []string{"USER"}}
+
+	for _, tt := range []struct {
+		name   string
+		guard  gin.HandlerFunc
+		caller *helpers.SignedDetails
+		userId string
+		want   int
+	}{
+		{"an admin", RequireRole("ADMIN"), admin, "user-id", http.StatusOK},
+		{"a user", RequireRole("ADMIN"), user, "user-id", http.StatusForbidden},
+		{"one of the roles", RequireRole("ORG_ADMIN", "USER"), user, "user-id", http.StatusOK},
+		{"without Authenticate", RequireRole("ADMIN"), nil, "user-id", http.StatusUnauthorized},
+		{"a user for themselves", RequireSelfOrRole("user_id", "ADMIN"), user, "user-id", http.StatusOK},
+		{"a user for another", RequireSelfOrRole("user_id", "ADMIN"), user, "admin-id", http.StatusForbidden},
+		{"an admin for another", RequireSelfOrRole("user_id", "ADMIN"), admin, "user-id", http.StatusOK},
+		{"self without Authenticate", RequireSelfOrRole("user_id", "ADMIN"), nil, "user-id", http.StatusUnauthorized},
+	} {
+		if code := guarded(tt.guard, tt.caller, tt.userId); code != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+}
+
+func TestPermissionGuards(t *testing.T) {
+	reader := &helpers.SignedDetails{Uid: "reader-id", Permissions: []string{"users:read"}}
+	writer := &helpers.SignedDetails{Uid: "writer-id", Permissions: []string{"users:read", "users:write"}}
+
+	for _, tt := range []struct {
+		name   string
+		guard  gin.HandlerFunc
+		caller *helpers.SignedDetails
+		userId string
+		want   int
+	}{
+		{"the permission", RequirePermission("users:read"), reader, "reader-id", http.StatusOK},
+		{"all of the permissions", RequirePermission("users:read", "users:write"), writer, "writer-id", http.StatusOK},
+		{"some of the permissions", RequirePermission("users:read", "users:write"), reader, "reader-id", http.StatusForbidden},
+		{"without Authenticate", RequirePermission("users:read"), nil, "reader-id", http.StatusUnauthorized},
+		{"self", RequireSelfOrPermission("user_id", "users:write"), reader, "reader-id", http.StatusOK},
+		{"another without the permission", RequireSelfOrPermission("user_id", "users:write"), reader, "writer-id", http.StatusForbidden},
+		{"another with the permission", RequireSelfOrPermission("user_id", "users:write"), writer, "reader-id", http.StatusOK},
+	} {
+		if code := guarded(tt.guard, tt.caller, tt.userId); code != tt.want {
+			t.Errorf("%s = %d, want %d", tt.name, code, tt.want)
+		}
+	}
+}
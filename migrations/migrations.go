@@ -0,0 +1,300 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+)
+
+// Every dialect has its own copy of the migrations, in a directory named after
+// it. They are kept in step, version for version.
+//
+//go:embed mysql/*.sql postgres/*.sql sqlite/*.sql
+var files embed.FS
+
+// lockName is the advisory lock held while migrating, so replicas starting
+// at the same time apply every migration exactly once. Postgres advisory
+// locks take a number instead, lockKey.
+const (
+	lockName = "schema_migrations"
+	lockKey  = 7365686
+)
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change. Files are named
+// <version>_<name>.up.sql and <version>_<name>.down.sql.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	DB         *repository.DB
+	Migrations []Migration
+}
+
+// New returns a migrator for the migrations embedded in the binary for the
+// dialect of db.
+func New(db *repository.DB) (*Migrator, error) {
+	migrations, err := Load(files, string(db.Dialect))
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Load reads the migrations in dir, ordered by version. Every version needs
+// both an up and a down file.
+func Load(fsys fs.FS, dir string) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		contents, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has two names, %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(contents)
+		} else {
+			migration.Down = string(contents)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies every pending migration in order.
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.Migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			log.Printf("Applying migration %d_%s", migration.Version, migration.Name)
+			if err := execScript(ctx, conn, migration.Up); err != nil {
+				return fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+			_, err := conn.ExecContext(ctx, m.DB.Dialect.Rebind(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`), migration.Version, migration.Name)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Down rolls back the most recently applied steps migrations.
+func (m *Migrator) Down(ctx context.Context, steps int) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0 && steps > 0; i-- {
+			migration := m.Migrations[i]
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+
+			log.Printf("Rolling back migration %d_%s", migration.Version, migration.Name)
+			if err := execScript(ctx, conn, migration.Down); err != nil {
+				return fmt.Errorf("rolling back %d_%s failed: %w", migration.Version, migration.Name, err)
+			}
+			_, err := conn.ExecContext(ctx, m.DB.Dialect.Rebind(`DELETE FROM schema_migrations WHERE version = ?`), migration.Version)
+			if err != nil {
+				return err
+			}
+			steps--
+		}
+		return nil
+	})
+}
+
+// Status lists every known migration and when it was applied.
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.Migrations {
+			status := Status{Migration: migration}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// withLock runs fn on a single connection holding the advisory lock. MySQL
+// and Postgres locks belong to the session, which is why everything has to go
+// through the same connection. SQLite has no advisory locks, but only ever
+// has one connection open.
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	switch m.DB.Dialect {
+	case repository.MySQL:
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 60)`, lockName).Scan(&acquired); err != nil {
+			return err
+		}
+		if acquired.Int64 != 1 {
+			return errors.New("timed out waiting for the migration lock")
+		}
+		defer releaseLock(conn, `SELECT RELEASE_LOCK(?)`, lockName)
+	case repository.Postgres:
+		lockCtx, cancel := context.WithTimeout(ctx, 60*time.Second)
+		defer cancel()
+		if _, err := conn.ExecContext(lockCtx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+			return fmt.Errorf("failed to take the migration lock: %w", err)
+		}
+		defer releaseLock(conn, `SELECT pg_advisory_unlock($1)`, lockKey)
+	}
+
+	// datetime in MySQL, where a timestamp would be converted to the session
+	// time zone, timestamp elsewhere
+	appliedAtType := "timestamp"
+	if m.DB.Dialect == repository.MySQL {
+		appliedAtType = "datetime"
+	}
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version bigint NOT NULL,
+			name varchar(255) NOT NULL,
+			applied_at `+appliedAtType+` NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (version)
+		)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(conn)
+}
+
+func releaseLock(conn *sql.Conn, query string, lock any) {
+	// Use a fresh context, the lock must be released even if ctx is done
+	if _, err := conn.ExecContext(context.Background(), query, lock); err != nil {
+		log.Printf("Error %s when releasing the migration lock", err)
+	}
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// execScript runs the statements of a migration one by one, since the driver
+// doesn't accept several statements in one call. Statements end with a
+// semicolon at the end of a line.
+func execScript(ctx context.Context, conn *sql.Conn, script string) error {
+	for _, statement := range splitStatements(script) {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func splitStatements(script string) []string {
+	var statements []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(trimmed, ";") {
+			statements = append(statements, strings.TrimSpace(current.String()))
+			current.Reset()
+		}
+	}
+	if rest := strings.TrimSpace(current.String()); rest != "" {
+		statements = append(statements, rest)
+	}
+	return statements
+}
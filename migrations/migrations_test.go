@@ -0,0 +1,206 @@
+package migrations
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/google/uuid"
+)
+
+func TestLoad(t *testing.T) {
+	migrations, err := Load(fstest.MapFS{
+		"db/0002_add_b.up.sql":      {Data: []byte("B")},
+		"db/0002_add_b.down.sql":    {Data: []byte("-B")},
+		"db/0001_create_a.up.sql":   {Data: []byte("A")},
+		"db/0001_create_a.down.sql": {Data: []byte("-A")},
+	}, "db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[0].Name != "create_a" || migrations[0].Up != "A" || migrations[1].Down != "-B" {
+		t.Errorf("Load = %+v", migrations)
+	}
+
+	for name, fsys := range map[string]fstest.MapFS{
+		"a stray file": {
+			"db/0001_create_a.up.sql":   {Data: []byte("A")},
+			"db/0001_create_a.down.sql": {Data: []byte("-A")},
+			"db/README.md":              {Data: []byte("")},
+		},
+		"no down file": {
+			"db/0001_create_a.up.sql": {Data: []byte("A")},
+		},
+		"two names for a version": {
+			"db/0001_create_a.up.sql":   {Data: []byte("A")},
+			"db/0001_create_b.down.sql": {Data: []byte("-B")},
+		},
+	} {
+		if _, err := Load(fsys, "db"); err == nil {
+			t.Errorf("Load with %s succeeded", name)
+		}
+	}
+}
+
+// TestDialectsInStep checks every dialect has the same migrations.
+func TestDialectsInStep(t *testing.T) {
+	var want []Migration
+	for _, dialect := range []repository.Dialect{repository.MySQL, repository.Postgres, repository.SQLite} {
+		migrations, err := Load(files, string(dialect))
+		if err != nil {
+			t.Fatalf("Load(%s): %v", dialect, err)
+		}
+		if want == nil {
+			want = migrations
+			continue
+		}
+		if len(migrations) != len(want) {
+			t.Fatalf("%s has %d migrations, %s has %d", dialect, len(migrations), repository.MySQL, len(want))
+		}
+		for i := range migrations {
+			if migrations[i].Version != want[i].Version || migrations[i].Name != want[i].Name {
+				t.Errorf("%s migration %d_%s, %s has %d_%s", dialect, migrations[i].Version, migrations[i].Name, repository.MySQL, want[i].Version, want[i].Name)
+			}
+		}
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	statements := splitStatements(`-- A comment
+CREATE TABLE a (
+	id int
+);
+
+INSERT INTO a VALUES (1);
+SELECT 1`)
+	if len(statements) != 3 || !strings.HasPrefix(statements[0], "CREATE TABLE a (") || statements[1] != "INSERT INTO a VALUES (1);" || statements[2] != "SELECT 1" {
+		t.Errorf("splitStatements = %q", statements)
+	}
+}
+
+// testDatabase opens a scratch database for dialect with no tables in it,
+// skipping MySQL and Postgres unless TEST_MYSQL_DSN or TEST_POSTGRES_DSN
+// names one.
+func testDatabase(t *testing.T, dialect repository.Dialect) *Migrator {
+	t.Helper()
+	var dsn string
+	switch dialect {
+	case repository.MySQL:
+		dsn = os.Getenv("TEST_MYSQL_DSN")
+	case repository.Postgres:
+		dsn = os.Getenv("TEST_POSTGRES_DSN")
+	case repository.SQLite:
+		dsn = "file:" + filepath.Join(t.TempDir(), "account.db") + "?_foreign_keys=on"
+	}
+	if dsn == "" {
+		t.Skipf("no %s database to test with", dialect)
+	}
+
+	db, err := repository.Open(dialect, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := New(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrator.Down(context.Background(), len(migrator.Migrations)); err != nil {
+		t.Fatal(err)
+	}
+	return migrator
+}
+
+func TestUpAndDown(t *testing.T) {
+	for _, dialect := range []repository.Dialect{repository.MySQL, repository.Postgres, repository.SQLite} {
+		t.Run(string(dialect), func(t *testing.T) {
+			migrator := testDatabase(t, dialect)
+			ctx := context.Background()
+
+			// Applying twice is a no-op the second time
+			for i := 0; i < 2; i++ {
+				if err := migrator.Up(ctx); err != nil {
+					t.Fatalf("Up: %v", err)
+				}
+			}
+			assertApplied(t, migrator, len(migrator.Migrations))
+
+			if err := migrator.Down(ctx, 2); err != nil {
+				t.Fatalf("Down: %v", err)
+			}
+			assertApplied(t, migrator, len(migrator.Migrations)-2)
+
+			// Every down migration undoes its up migration
+			if err := migrator.Down(ctx, len(migrator.Migrations)); err != nil {
+				t.Fatalf("Down of everything: %v", err)
+			}
+			assertApplied(t, migrator, 0)
+			if err := migrator.Up(ctx); err != nil {
+				t.Fatalf("Up after Down: %v", err)
+			}
+		})
+	}
+}
+
+// assertApplied checks the first n migrations are applied, and no others.
+func assertApplied(t *testing.T, migrator *Migrator, n int) {
+	t.Helper()
+	statuses, err := migrator.Status(context.Background())
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for i, status := range statuses {
+		if applied := status.AppliedAt != nil; applied != (i < n) {
+			t.Errorf("migration %d_%s applied = %v, want %v", status.Version, status.Name, applied, i < n)
+		}
+	}
+}
+
+// TestRolesKeepUserTypes checks users from before roles existed get the role
+// of their user_type.
+func TestRolesKeepUserTypes(t *testing.T) {
+	for _, dialect := range []repository.Dialect{repository.MySQL, repository.Postgres, repository.SQLite} {
+		t.Run(string(dialect), func(t *testing.T) {
+			migrator := testDatabase(t, dialect)
+			ctx := context.Background()
+
+			all := migrator.Migrations
+			for i, migration := range all {
+				if migration.Name == "create_roles" {
+					migrator.Migrations = all[:i]
+				}
+			}
+			if err := migrator.Up(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			// Inserted by hand, the repository already expects later columns
+			userIds := map[string]uuid.UUID{}
+			for _, userType := range []string{"ADMIN", "USER"} {
+				userId := uuid.New()
+				_, err := migrator.DB.ExecContext(ctx, `INSERT INTO users (user_id, first_name, user_type, email, phone) VALUES (?, ?, ?, ?, ?)`,
+					userId[:], "Ann", userType, strings.ToLower(userType)+"@example.com", "1234567890")
+				if err != nil {
+					t.Fatal(err)
+				}
+				userIds[userType] = userId
+			}
+
+			migrator.Migrations = all
+			if err := migrator.Up(ctx); err != nil {
+				t.Fatal(err)
+			}
+			roles := repository.NewRoleRepository(migrator.DB)
+			for userType, userId := range userIds {
+				held, err := roles.GetUserRoles(userId.String())
+				if err != nil || len(held) != 1 || held[0] != userType {
+					t.Errorf("roles of a former %s = (%v, %v), want [%s]", userType, held, err, userType)
+				}
+			}
+		})
+	}
+}
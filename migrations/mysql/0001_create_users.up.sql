@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS users (
+	user_id binary(16) NOT NULL DEFAULT (UUID_TO_BIN(UUID())),
+	first_name varchar(32) NOT NULL,
+	last_name varchar(32) DEFAULT NULL,
+	user_type enum('ADMIN','USER') NOT NULL DEFAULT 'USER',
+	email varchar(64) NOT NULL,
+	phone varchar(10) NOT NULL,
+	password VARCHAR(100) DEFAULT NULL,
+	token VARCHAR(500) DEFAULT NULL,
+	refresh_token VARCHAR(500) DEFAULT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id),
+	UNIQUE KEY username (email)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_token_families (
+	family_id binary(16) NOT NULL,
+	user_id binary(16) NOT NULL,
+	token_hash binary(32) NOT NULL,
+	expires_at datetime NOT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (family_id),
+	KEY refresh_token_families_user_id (user_id),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
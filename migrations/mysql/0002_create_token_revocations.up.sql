@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti varchar(64) NOT NULL,
+	expires_at datetime NOT NULL,
+	PRIMARY KEY (jti),
+	KEY expires_at (expires_at)
+);
+
+CREATE TABLE IF NOT EXISTS user_token_revocations (
+	user_id binary(16) NOT NULL,
+	revoked_before datetime(6) NOT NULL,
+	PRIMARY KEY (user_id)
+);
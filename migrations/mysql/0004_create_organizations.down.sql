@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS member_token_revocations;
+DROP TABLE IF EXISTS organization_invitations;
+DROP TABLE IF EXISTS organization_members;
+DROP TABLE IF EXISTS organizations;
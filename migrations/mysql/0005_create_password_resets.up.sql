@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash binary(32) NOT NULL,
+	user_id binary(16) NOT NULL,
+	expires_at datetime NOT NULL,
+	used_at datetime DEFAULT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (token_hash),
+	KEY user_id (user_id),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
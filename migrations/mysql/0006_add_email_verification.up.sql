@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN email_verified_at datetime DEFAULT NULL;
+
+-- Accounts that existed before verification was introduced are trusted
+UPDATE users SET email_verified_at = created_on;
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+	token_hash binary(32) NOT NULL,
+	user_id binary(16) NOT NULL,
+	expires_at datetime NOT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (token_hash),
+	KEY user_id (user_id),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+	user_id binary(16) NOT NULL,
+	secret varchar(64) NOT NULL,
+	confirmed_at datetime DEFAULT NULL,
+	last_used_step bigint NOT NULL DEFAULT 0,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+	user_id binary(16) NOT NULL,
+	code_hash binary(32) NOT NULL,
+	PRIMARY KEY (user_id, code_hash),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
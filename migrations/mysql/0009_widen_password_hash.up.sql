@@ -0,0 +1,2 @@
+-- argon2id hashes with large parameters don't fit 100 characters
+ALTER TABLE users MODIFY password varchar(255) DEFAULT NULL;
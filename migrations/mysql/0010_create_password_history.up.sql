@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS password_history (
+	id bigint NOT NULL AUTO_INCREMENT,
+	user_id binary(16) NOT NULL,
+	password_hash varchar(255) NOT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	KEY password_history_user_id (user_id),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
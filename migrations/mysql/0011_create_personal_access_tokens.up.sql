@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+	token_id binary(16) NOT NULL,
+	token_hash binary(32) NOT NULL,
+	user_id binary(16) NOT NULL,
+	org_id binary(16) DEFAULT NULL,
+	name varchar(100) NOT NULL,
+	scopes varchar(1024) NOT NULL,
+	expires_at datetime DEFAULT NULL,
+	last_used_at datetime DEFAULT NULL,
+	created_on datetime(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+	PRIMARY KEY (token_id),
+	UNIQUE KEY token_hash (token_hash),
+	KEY personal_access_tokens_user_id (user_id),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS service_accounts;
+
+DELETE FROM permissions WHERE name IN ('clients:read', 'clients:write');
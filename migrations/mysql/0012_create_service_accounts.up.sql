@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS service_accounts (
+	client_id binary(16) NOT NULL,
+	secret_hash binary(32) NOT NULL,
+	org_id binary(16) DEFAULT NULL,
+	name varchar(100) NOT NULL,
+	scopes varchar(1024) NOT NULL,
+	audience varchar(255) NOT NULL DEFAULT '',
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (client_id),
+	KEY service_accounts_org_id (org_id),
+	FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE
+);
+
+INSERT IGNORE INTO permissions (name, description) VALUES
+	('clients:read', 'List service accounts'),
+	('clients:write', 'Manage service accounts');
+
+INSERT IGNORE INTO role_permissions (role, permission) VALUES
+	('ADMIN', 'clients:read'),
+	('ADMIN', 'clients:write'),
+	('ORG_ADMIN', 'clients:read'),
+	('ORG_ADMIN', 'clients:write');
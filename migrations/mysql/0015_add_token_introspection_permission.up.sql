@@ -0,0 +1,5 @@
+INSERT IGNORE INTO permissions (name, description) VALUES
+	('tokens:introspect', 'Look up tokens at the introspection endpoint');
+
+INSERT IGNORE INTO role_permissions (role, permission) VALUES
+	('ADMIN', 'tokens:introspect');
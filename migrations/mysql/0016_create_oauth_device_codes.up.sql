@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS oauth_device_codes (
+	device_code_hash binary(32) NOT NULL,
+	user_code varchar(8) NOT NULL,
+	client_id binary(16) NOT NULL,
+	scope varchar(255) NOT NULL DEFAULT '',
+	status varchar(16) NOT NULL DEFAULT 'pending',
+	user_id binary(16) DEFAULT NULL,
+	auth_time datetime DEFAULT NULL,
+	last_polled_at datetime DEFAULT NULL,
+	expires_at datetime NOT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (device_code_hash),
+	UNIQUE KEY user_code (user_code),
+	KEY oauth_device_codes_client_id (client_id),
+	KEY oauth_device_codes_user_id (user_id),
+	FOREIGN KEY (client_id) REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
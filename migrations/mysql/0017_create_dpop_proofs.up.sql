@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS dpop_proofs (
+	jti varchar(64) NOT NULL,
+	expires_at datetime NOT NULL,
+	PRIMARY KEY (jti),
+	KEY expires_at (expires_at)
+);
@@ -0,0 +1,2 @@
+DROP INDEX service_accounts_certificate_identity ON service_accounts;
+ALTER TABLE service_accounts DROP COLUMN certificate_identity;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS refresh_token_families;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+	jti varchar(64) NOT NULL,
+	expires_at timestamp NOT NULL,
+	PRIMARY KEY (jti)
+);
+
+CREATE INDEX IF NOT EXISTS revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS user_token_revocations (
+	user_id bytea NOT NULL,
+	revoked_before timestamp NOT NULL,
+	PRIMARY KEY (user_id)
+);
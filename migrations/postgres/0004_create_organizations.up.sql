@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS organizations (
+	org_id bytea NOT NULL,
+	name varchar(100) NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (org_id)
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+	org_id bytea NOT NULL,
+	user_id bytea NOT NULL,
+	role varchar(64) NOT NULL DEFAULT 'USER',
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (org_id, user_id),
+	FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	FOREIGN KEY (role) REFERENCES roles (name)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id ON organization_members (user_id);
+
+CREATE TABLE IF NOT EXISTS organization_invitations (
+	org_id bytea NOT NULL,
+	user_id bytea NOT NULL,
+	role varchar(64) NOT NULL,
+	expires_at timestamp NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (org_id, user_id),
+	FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS organization_invitations_user_id ON organization_invitations (user_id);
+
+CREATE TABLE IF NOT EXISTS member_token_revocations (
+	org_id bytea NOT NULL,
+	user_id bytea NOT NULL,
+	revoked_before timestamp NOT NULL,
+	PRIMARY KEY (org_id, user_id)
+);
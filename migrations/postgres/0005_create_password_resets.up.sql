@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash bytea NOT NULL,
+	user_id bytea NOT NULL,
+	expires_at timestamp NOT NULL,
+	used_at timestamp DEFAULT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_id ON password_resets (user_id);
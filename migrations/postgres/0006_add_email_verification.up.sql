@@ -0,0 +1,15 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified_at timestamp DEFAULT NULL;
+
+-- Accounts that existed before verification was introduced are trusted
+UPDATE users SET email_verified_at = created_on;
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+	token_hash bytea NOT NULL,
+	user_id bytea NOT NULL,
+	expires_at timestamp NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS email_verifications_user_id ON email_verifications (user_id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS user_mfa (
+	user_id bytea NOT NULL,
+	secret varchar(64) NOT NULL,
+	confirmed_at timestamp DEFAULT NULL,
+	last_used_step bigint NOT NULL DEFAULT 0,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS mfa_recovery_codes (
+	user_id bytea NOT NULL,
+	code_hash bytea NOT NULL,
+	PRIMARY KEY (user_id, code_hash),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
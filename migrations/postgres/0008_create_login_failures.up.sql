@@ -0,0 +1,6 @@
+CREATE TABLE IF NOT EXISTS login_failures (
+	attempt_key varchar(320) NOT NULL,
+	failures int NOT NULL,
+	last_failure_at timestamp NOT NULL,
+	PRIMARY KEY (attempt_key)
+);
@@ -0,0 +1,2 @@
+-- argon2id hashes with large parameters don't fit 100 characters
+ALTER TABLE users ALTER COLUMN password TYPE varchar(255);
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS password_history (
+	id bigserial NOT NULL,
+	user_id bytea NOT NULL,
+	password_hash varchar(255) NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (id),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS password_history_user_id ON password_history (user_id);
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS personal_access_tokens (
+	token_id bytea NOT NULL,
+	token_hash bytea NOT NULL,
+	user_id bytea NOT NULL,
+	org_id bytea DEFAULT NULL,
+	name varchar(100) NOT NULL,
+	scopes varchar(1024) NOT NULL,
+	expires_at timestamp DEFAULT NULL,
+	last_used_at timestamp DEFAULT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (token_id),
+	UNIQUE (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS personal_access_tokens_user_id ON personal_access_tokens (user_id);
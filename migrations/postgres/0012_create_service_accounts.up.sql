@@ -0,0 +1,25 @@
+CREATE TABLE IF NOT EXISTS service_accounts (
+	client_id bytea NOT NULL,
+	secret_hash bytea NOT NULL,
+	org_id bytea DEFAULT NULL,
+	name varchar(100) NOT NULL,
+	scopes varchar(1024) NOT NULL,
+	audience varchar(255) NOT NULL DEFAULT '',
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (client_id),
+	FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS service_accounts_org_id ON service_accounts (org_id);
+
+INSERT INTO permissions (name, description) VALUES
+	('clients:read', 'List service accounts'),
+	('clients:write', 'Manage service accounts')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+	('ADMIN', 'clients:read'),
+	('ADMIN', 'clients:write'),
+	('ORG_ADMIN', 'clients:read'),
+	('ORG_ADMIN', 'clients:write')
+ON CONFLICT DO NOTHING;
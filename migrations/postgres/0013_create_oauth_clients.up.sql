@@ -0,0 +1,35 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	client_id bytea NOT NULL,
+	secret_hash bytea DEFAULT NULL,
+	name varchar(100) NOT NULL,
+	redirect_uris varchar(2048) NOT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (client_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+	code_hash bytea NOT NULL,
+	client_id bytea NOT NULL,
+	user_id bytea NOT NULL,
+	redirect_uri varchar(255) NOT NULL,
+	code_challenge varchar(128) NOT NULL,
+	expires_at timestamp NOT NULL,
+	used_at timestamp DEFAULT NULL,
+	created_on timestamp NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (code_hash),
+	FOREIGN KEY (client_id) REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS oauth_authorization_codes_client_id ON oauth_authorization_codes (client_id);
+CREATE INDEX IF NOT EXISTS oauth_authorization_codes_user_id ON oauth_authorization_codes (user_id);
+
+INSERT INTO permissions (name, description) VALUES
+	('oauth_clients:read', 'List OAuth clients'),
+	('oauth_clients:write', 'Manage OAuth clients')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+	('ADMIN', 'oauth_clients:read'),
+	('ADMIN', 'oauth_clients:write')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS scope varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS nonce varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE oauth_authorization_codes ADD COLUMN IF NOT EXISTS auth_time timestamp DEFAULT NULL;
@@ -0,0 +1,7 @@
+INSERT INTO permissions (name, description) VALUES
+	('tokens:introspect', 'Look up tokens at the introspection endpoint')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role, permission) VALUES
+	('ADMIN', 'tokens:introspect')
+ON CONFLICT DO NOTHING;
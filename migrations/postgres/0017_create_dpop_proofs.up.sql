@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS dpop_proofs (
+	jti varchar(64) NOT NULL,
+	expires_at timestamp NOT NULL,
+	PRIMARY KEY (jti)
+);
+
+CREATE INDEX IF NOT EXISTS dpop_proofs_expires_at ON dpop_proofs (expires_at);
@@ -0,0 +1,27 @@
+CREATE TABLE IF NOT EXISTS users (
+	user_id blob NOT NULL,
+	first_name varchar(32) NOT NULL,
+	last_name varchar(32) DEFAULT NULL,
+	user_type varchar(16) NOT NULL DEFAULT 'USER' CHECK (user_type IN ('ADMIN', 'USER')),
+	email varchar(64) NOT NULL COLLATE NOCASE,
+	phone varchar(10) NOT NULL,
+	password varchar(100) DEFAULT NULL,
+	token varchar(500) DEFAULT NULL,
+	refresh_token varchar(500) DEFAULT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (user_id),
+	UNIQUE (email)
+);
+
+CREATE TABLE IF NOT EXISTS refresh_token_families (
+	family_id blob NOT NULL,
+	user_id blob NOT NULL,
+	token_hash blob NOT NULL,
+	expires_at datetime NOT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (family_id),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS refresh_token_families_user_id ON refresh_token_families (user_id);
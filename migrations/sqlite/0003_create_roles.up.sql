@@ -0,0 +1,54 @@
+CREATE TABLE IF NOT EXISTS roles (
+	name varchar(64) NOT NULL,
+	description varchar(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (name)
+);
+
+CREATE TABLE IF NOT EXISTS permissions (
+	name varchar(64) NOT NULL,
+	description varchar(255) NOT NULL DEFAULT '',
+	PRIMARY KEY (name)
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+	role varchar(64) NOT NULL,
+	permission varchar(64) NOT NULL,
+	PRIMARY KEY (role, permission),
+	FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE,
+	FOREIGN KEY (permission) REFERENCES permissions (name) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id blob NOT NULL,
+	role varchar(64) NOT NULL,
+	PRIMARY KEY (user_id, role),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE
+);
+
+INSERT OR IGNORE INTO permissions (name, description) VALUES
+	('users:read', 'List and view users'),
+	('users:write', 'Modify users'),
+	('roles:read', 'List roles and permissions'),
+	('roles:write', 'Manage roles, permissions and role assignments');
+
+INSERT OR IGNORE INTO roles (name, description) VALUES
+	('ADMIN', 'Administrators'),
+	('USER', 'Regular users'),
+	('ORG_ADMIN', 'Organization administrators');
+
+INSERT OR IGNORE INTO role_permissions (role, permission) VALUES
+	('ADMIN', 'users:read'),
+	('ADMIN', 'users:write'),
+	('ADMIN', 'roles:read'),
+	('ADMIN', 'roles:write'),
+	('ORG_ADMIN', 'users:read'),
+	('ORG_ADMIN', 'users:write');
+
+-- Existing users keep what their user_type gave them, permissions only come
+-- from assigned roles from now on
+INSERT OR IGNORE INTO user_roles (user_id, role)
+SELECT user_id, 'ADMIN' FROM users WHERE user_type = 'ADMIN';
+
+INSERT OR IGNORE INTO user_roles (user_id, role)
+SELECT user_id, 'USER' FROM users WHERE user_type = 'USER';
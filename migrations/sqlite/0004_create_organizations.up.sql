@@ -0,0 +1,40 @@
+CREATE TABLE IF NOT EXISTS organizations (
+	org_id blob NOT NULL,
+	name varchar(100) NOT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (org_id)
+);
+
+CREATE TABLE IF NOT EXISTS organization_members (
+	org_id blob NOT NULL,
+	user_id blob NOT NULL,
+	role varchar(64) NOT NULL DEFAULT 'USER',
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (org_id, user_id),
+	FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	FOREIGN KEY (role) REFERENCES roles (name)
+);
+
+CREATE INDEX IF NOT EXISTS organization_members_user_id ON organization_members (user_id);
+
+CREATE TABLE IF NOT EXISTS organization_invitations (
+	org_id blob NOT NULL,
+	user_id blob NOT NULL,
+	role varchar(64) NOT NULL,
+	expires_at datetime NOT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (org_id, user_id),
+	FOREIGN KEY (org_id) REFERENCES organizations (org_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE,
+	FOREIGN KEY (role) REFERENCES roles (name) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS organization_invitations_user_id ON organization_invitations (user_id);
+
+CREATE TABLE IF NOT EXISTS member_token_revocations (
+	org_id blob NOT NULL,
+	user_id blob NOT NULL,
+	revoked_before datetime NOT NULL,
+	PRIMARY KEY (org_id, user_id)
+);
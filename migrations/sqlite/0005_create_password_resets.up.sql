@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS password_resets (
+	token_hash blob NOT NULL,
+	user_id blob NOT NULL,
+	expires_at datetime NOT NULL,
+	used_at datetime DEFAULT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (token_hash),
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS password_resets_user_id ON password_resets (user_id);
@@ -0,0 +1,3 @@
+-- SQLite doesn't enforce varchar lengths, this keeps the versions in step
+-- with the other databases
+SELECT 1;
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS password_history (
+	id integer PRIMARY KEY AUTOINCREMENT,
+	user_id blob NOT NULL,
+	password_hash varchar(255) NOT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS password_history_user_id ON password_history (user_id);
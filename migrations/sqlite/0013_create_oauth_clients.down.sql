@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS oauth_authorization_codes;
+DROP TABLE IF EXISTS oauth_clients;
+
+DELETE FROM permissions WHERE name IN ('oauth_clients:read', 'oauth_clients:write');
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS oauth_clients (
+	client_id blob NOT NULL,
+	secret_hash blob DEFAULT NULL,
+	name varchar(100) NOT NULL,
+	redirect_uris varchar(2048) NOT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (client_id)
+);
+
+CREATE TABLE IF NOT EXISTS oauth_authorization_codes (
+	code_hash blob NOT NULL,
+	client_id blob NOT NULL,
+	user_id blob NOT NULL,
+	redirect_uri varchar(255) NOT NULL,
+	code_challenge varchar(128) NOT NULL,
+	expires_at datetime NOT NULL,
+	used_at datetime DEFAULT NULL,
+	created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (code_hash),
+	FOREIGN KEY (client_id) REFERENCES oauth_clients (client_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users (user_id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS oauth_authorization_codes_client_id ON oauth_authorization_codes (client_id);
+CREATE INDEX IF NOT EXISTS oauth_authorization_codes_user_id ON oauth_authorization_codes (user_id);
+
+INSERT OR IGNORE INTO permissions (name, description) VALUES
+	('oauth_clients:read', 'List OAuth clients'),
+	('oauth_clients:write', 'Manage OAuth clients');
+
+INSERT OR IGNORE INTO role_permissions (role, permission) VALUES
+	('ADMIN', 'oauth_clients:read'),
+	('ADMIN', 'oauth_clients:write');
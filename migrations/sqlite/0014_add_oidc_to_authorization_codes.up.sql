@@ -0,0 +1,3 @@
+ALTER TABLE oauth_authorization_codes ADD COLUMN scope varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE oauth_authorization_codes ADD COLUMN nonce varchar(255) NOT NULL DEFAULT '';
+ALTER TABLE oauth_authorization_codes ADD COLUMN auth_time datetime DEFAULT NULL;
@@ -0,0 +1,5 @@
+INSERT OR IGNORE INTO permissions (name, description) VALUES
+	('tokens:introspect', 'Look up tokens at the introspection endpoint');
+
+INSERT OR IGNORE INTO role_permissions (role, permission) VALUES
+	('ADMIN', 'tokens:introspect');
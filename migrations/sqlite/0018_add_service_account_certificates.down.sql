@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS service_accounts_certificate_identity;
+ALTER TABLE service_accounts DROP COLUMN certificate_identity;
@@ -0,0 +1,3 @@
+ALTER TABLE service_accounts ADD COLUMN certificate_identity varchar(255) DEFAULT NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS service_accounts_certificate_identity ON service_accounts (certificate_identity, org_id);
@@ -0,0 +1,9 @@
+package models
+
+import "time"
+
+// LoginFailures counts the failed logins for an account or a client IP.
+type LoginFailures struct {
+	Count         int
+	LastFailureAt time.Time
+}
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// MFA is a user's TOTP enrollment. It only protects logins once confirmed.
+type MFA struct {
+	Secret      string
+	ConfirmedAt *time.Time
+	// LastUsedStep is the TOTP time step of the last accepted code. Codes
+	// for it or any earlier step are refused, so none can be replayed.
+	LastUsedStep int64
+}
@@ -0,0 +1,63 @@
+package models
+
+import "time"
+
+// OAuthClient is an app that signs users in through the authorization
+// endpoint, like our web and mobile apps, or with the device authorization
+// grant, like our CLI. Confidential clients authenticate with a secret, of
+// which only a hash is stored. Public clients can't keep one, PKCE is all
+// that protects their codes.
+type OAuthClient struct {
+	ClientId   string `json:"client_id"`
+	SecretHash []byte `json:"-"`
+	Name       string `json:"name" validate:"required,max=100"`
+	// RedirectURIs are where codes may be sent, compared exactly. Clients
+	// without any only use the device authorization grant.
+	RedirectURIs []string  `json:"redirect_uris" validate:"max=8,dive,required,max=255"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AuthorizationCode is a code the authorization endpoint handed to a client,
+// waiting to be exchanged for tokens. Only a hash of the code is stored.
+type AuthorizationCode struct {
+	ClientId    string
+	UserId      string
+	RedirectURI string
+	// CodeChallenge is the PKCE S256 challenge the code verifier must match
+	CodeChallenge string
+	// Scopes are the OpenID Connect scopes the user granted
+	Scopes []string
+	// Nonce goes into the ID token
+	Nonce string
+	// AuthTime is when the user entered their password
+	AuthTime  time.Time
+	ExpiresAt time.Time
+}
+
+// The states a DeviceCode goes through. It stays pending until the user
+// approves or denies it.
+const (
+	DeviceCodePending  = "pending"
+	DeviceCodeApproved = "approved"
+	DeviceCodeDenied   = "denied"
+)
+
+// DeviceCode is a request of the device authorization grant, made by a
+// device that can't show the authorization endpoint's page. Users approve it
+// elsewhere by entering its user code. Only a hash of the device code, which
+// the device redeems, is stored.
+type DeviceCode struct {
+	ClientId string
+	// UserCode is what users type in, eight letters without the dash
+	UserCode string
+	// Scopes are the OpenID Connect scopes the device asked for
+	Scopes []string
+	Status string
+	// UserId and AuthTime are set once a user approves
+	UserId   string
+	AuthTime time.Time
+	// LastPolledAt is when the device last asked for its tokens
+	LastPolledAt *time.Time
+	ExpiresAt    time.Time
+}
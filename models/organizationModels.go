@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type Organization struct {
+	OrgId string `json:"org_id"`
+	Name  string `json:"name" validate:"required,min=2,max=100"`
+}
+
+// Membership is a user's place in an organization. Role names one of the
+// roles in the roles table and applies only inside that organization.
+type Membership struct {
+	OrgId  string `json:"org_id"`
+	Name   string `json:"name"`
+	UserId string `json:"user_id"`
+	Role   string `json:"role"`
+}
+
+// Invitation offers a user a role in an organization. Users only become
+// members once they accept it.
+type Invitation struct {
+	OrgId     string    `json:"org_id"`
+	Name      string    `json:"name"`
+	UserId    string    `json:"user_id"`
+	Role      string    `json:"role"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
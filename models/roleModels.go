@@ -0,0 +1,12 @@
+package models
+
+type Permission struct {
+	Name        string `json:"name" validate:"required,max=64,contains=:"`
+	Description string `json:"description" validate:"max=255"`
+}
+
+type Role struct {
+	Name        string   `json:"name" validate:"required,min=2,max=64"`
+	Description string   `json:"description" validate:"max=255"`
+	Permissions []string `json:"permissions"`
+}
@@ -0,0 +1,43 @@
+package models
+
+import "time"
+
+// PersonalAccessToken is a long lived token users create for scripts and CI.
+// Only a hash of the token itself is stored, so it is shown once, when it is
+// created.
+type PersonalAccessToken struct {
+	TokenId string `json:"token_id"`
+	UserId  string `json:"user_id"`
+	// OrgId is the organization the token acts in, the one the user was in
+	// when they created it
+	OrgId string `json:"org_id,omitempty"`
+	Name  string `json:"name"`
+	// Scopes are the permissions the token may use, as far as the user still
+	// has them
+	Scopes     []string   `json:"scopes"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// ServiceAccount is a backend service calling the API on its own behalf, with
+// tokens from the client credentials grant. It authenticates with its client
+// ID and a secret, of which only a hash is stored, or with a TLS client
+// certificate when it has a CertificateIdentity.
+type ServiceAccount struct {
+	ClientId   string `json:"client_id"`
+	SecretHash []byte `json:"-"`
+	// OrgId is the organization the account's tokens act in
+	OrgId string `json:"org_id,omitempty"`
+	Name  string `json:"name" validate:"required,max=100"`
+	// Scopes are the permissions the account's tokens may carry
+	Scopes []string `json:"scopes" validate:"required,min=1,dive,required,max=64"`
+	// Audience is who the account's tokens are meant for, empty for this
+	// service
+	Audience string `json:"audience" validate:"max=255"`
+	// CertificateIdentity is the subject DN or SAN of the client
+	// certificates the account authenticates with, see
+	// helpers.CertificateIdentities
+	CertificateIdentity string    `json:"certificate_identity,omitempty" validate:"max=255"`
+	CreatedAt           time.Time `json:"created_at"`
+}
@@ -1,14 +1,20 @@
 package models
 
+import "time"
+
 type User struct {
-	ID           int     `json:"id"`
-	FirstName    *string `json:"first_name" validate:"required,min=2,max=100"`
-	LastName     *string `json:"last_name" validate:"required,min=2,max=100"`
-	Password     *string `json:"password" validate:"required,min=6"`
+	ID        int     `json:"id"`
+	FirstName *string `json:"first_name" validate:"required,min=2,max=100"`
+	LastName  *string `json:"last_name" validate:"required,min=2,max=100"`
+	// Password is the hash once stored, responses leave it out. The
+	// password policy decides what passwords are acceptable.
+	Password     *string `json:"password,omitempty" validate:"required"`
 	Email        *string `json:"email" validate:"required,email"`
 	Phone        *string `json:"phone" validate:"required,min=10,max=10"`
-	Token        *string `json:"token"`
+	Token        *string `json:"token,omitempty"`
 	UserType     *string `json:"user_type" validate:"required,eq=ADMIN|eq=USER"`
-	RefreshToken *string `json:"refresh_token"`
+	RefreshToken *string `json:"refresh_token,omitempty"`
 	UserId       string  `json:"user_id"`
+	// EmailVerifiedAt is nil until the user confirms their email address
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
 }
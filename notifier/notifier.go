@@ -0,0 +1,92 @@
+// Package notifier delivers messages such as password reset tokens to users.
+// Real deployments plug in their own delivery, the log and file notifiers here
+// are for development and tests.
+package notifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Message is one notification. Kind says what it is about, such as
+// "password_reset", so tests and templates can tell messages apart.
+type Message struct {
+	Kind    string `json:"kind"`
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// Token is the secret the message carries, if any, kept apart from the
+	// body so it doesn't have to be parsed out of it
+	Token string `json:"token,omitempty"`
+}
+
+type Notifier interface {
+	Notify(message Message) error
+}
+
+// New returns the notifier of the given kind, "log" or "file". The file
+// notifier appends to path.
+func New(kind string, path string) (Notifier, error) {
+	switch kind {
+	case "log":
+		return NewLogNotifier(), nil
+	case "file":
+		if path == "" {
+			return nil, fmt.Errorf("the file notifier needs a file")
+		}
+		return NewFileNotifier(path), nil
+	}
+	return nil, fmt.Errorf("unknown notifier %q", kind)
+}
+
+// LogNotifier writes messages to the standard logger. Tokens end up in the
+// log, so it must not be used in production.
+type LogNotifier struct{}
+
+func NewLogNotifier() Notifier {
+	return LogNotifier{}
+}
+
+func (LogNotifier) Notify(message Message) error {
+	log.Printf("Notification to %s: %s\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FileNotifier appends every message to a file as one line of JSON.
+type FileNotifier struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+type fileEntry struct {
+	Message
+	SentAt time.Time `json:"sent_at"`
+}
+
+func (f *FileNotifier) Notify(message Message) error {
+	line, err := json.Marshal(fileEntry{Message: message, SentAt: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open notification file: %w", err)
+	}
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
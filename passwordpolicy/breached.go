@@ -0,0 +1,125 @@
+package passwordpolicy
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// PrefixLength is how many hex characters of a SHA-1 hash a range query
+// reveals.
+const PrefixLength = 5
+
+// BreachedList answers k-anonymity range queries like the Pwned Passwords
+// API: given the first five hex characters of a password's SHA-1 hash, it
+// returns the remaining 35 of every breached hash starting with them.
+type BreachedList interface {
+	Range(prefix string) ([]string, error)
+}
+
+// FileBreachedList is a breached list loaded whole from a local file, so no
+// password hash prefix ever leaves the server. It keeps every hash in
+// memory, which suits small lists and test fixtures; use DirBreachedList for
+// the full Pwned Passwords corpus.
+type FileBreachedList struct {
+	ranges map[string][]string
+}
+
+// LoadBreachedList reads a file of SHA-1 password hashes in hex, one per
+// line and optionally followed by ":<count>", the format of the Pwned
+// Passwords downloads. Empty lines and lines starting with # are skipped.
+func LoadBreachedList(path string) (*FileBreachedList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	list := &FileBreachedList{ranges: make(map[string][]string)}
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != 40 || strings.Trim(hash, "0123456789ABCDEF") != "" {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash", path, line)
+		}
+		prefix := hash[:PrefixLength]
+		list.ranges[prefix] = append(list.ranges[prefix], hash[PrefixLength:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (l *FileBreachedList) Range(prefix string) ([]string, error) {
+	return l.ranges[strings.ToUpper(prefix)], nil
+}
+
+// DirBreachedList is a breached list kept in a directory of range files, as
+// written by the Pwned Passwords downloader: one file per prefix, named by
+// the prefix, holding "<SUFFIX>:<count>" lines. Each Range call reads only
+// the file it asks about.
+type DirBreachedList struct {
+	dir string
+}
+
+// OpenBreachedDir checks that dir is a directory and returns a list reading
+// its range files on demand.
+func OpenBreachedDir(dir string) (*DirBreachedList, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s: not a directory", dir)
+	}
+	return &DirBreachedList{dir: dir}, nil
+}
+
+// Range reads the range file of prefix. A prefix without a file has no
+// breached hashes.
+func (l *DirBreachedList) Range(prefix string) ([]string, error) {
+	prefix = strings.ToUpper(prefix)
+	if len(prefix) != PrefixLength || strings.Trim(prefix, "0123456789ABCDEF") != "" {
+		return nil, fmt.Errorf("%q: not a hash prefix", prefix)
+	}
+
+	path := filepath.Join(l.dir, prefix)
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var suffixes []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		suffix, _, _ := strings.Cut(text, ":")
+		suffix = strings.ToUpper(suffix)
+		if len(suffix) != 40-PrefixLength || strings.Trim(suffix, "0123456789ABCDEF") != "" {
+			return nil, fmt.Errorf("%s:%d: not a SHA-1 hash suffix", path, line)
+		}
+		suffixes = append(suffixes, suffix)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return suffixes, nil
+}
@@ -0,0 +1,148 @@
+// Package passwordpolicy checks new passwords against configurable rules and
+// reports every rule a password breaks, so users can fix them all at once.
+package passwordpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Violation codes, stable for clients to match on.
+const (
+	CodeTooShort      = "too_short"
+	CodeMissingUpper  = "missing_uppercase"
+	CodeMissingLower  = "missing_lowercase"
+	CodeMissingDigit  = "missing_digit"
+	CodeMissingSymbol = "missing_symbol"
+	CodePersonalInfo  = "contains_personal_info"
+	CodeReused        = "reused"
+	CodeBreached      = "breached"
+)
+
+// minPersonalInfoPart is the shortest name or email part that counts as
+// personal info.
+const minPersonalInfoPart = 3
+
+// Violation is one rule a password breaks.
+type Violation struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type Policy struct {
+	MinLength     int
+	RequireUpper  bool
+	RequireLower  bool
+	RequireDigit  bool
+	RequireSymbol bool
+	// RejectPersonalInfo refuses passwords containing the user's email
+	// address or names
+	RejectPersonalInfo bool
+	// HistoryDepth is how many of their latest passwords, the current one
+	// included, users can't reuse. Callers check it, since they hold the
+	// history.
+	HistoryDepth int
+	// Breached, when set, is asked whether the password is known from a
+	// breach
+	Breached BreachedList
+}
+
+// Check returns the rules password breaks. personalInfo are the user's email
+// address and names.
+func (p Policy) Check(password string, personalInfo ...string) ([]Violation, error) {
+	violations := []Violation{}
+	if utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{CodeTooShort, fmt.Sprintf("password must be at least %d characters long", p.MinLength)})
+	}
+
+	var upper, lower, digit, symbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsDigit(r):
+			digit = true
+		case !unicode.IsLetter(r):
+			symbol = true
+		}
+	}
+	for _, class := range []struct {
+		required bool
+		present  bool
+		code     string
+		name     string
+	}{
+		{p.RequireUpper, upper, CodeMissingUpper, "an uppercase letter"},
+		{p.RequireLower, lower, CodeMissingLower, "a lowercase letter"},
+		{p.RequireDigit, digit, CodeMissingDigit, "a digit"},
+		{p.RequireSymbol, symbol, CodeMissingSymbol, "a symbol"},
+	} {
+		if class.required && !class.present {
+			violations = append(violations, Violation{class.code, "password must contain " + class.name})
+		}
+	}
+
+	if p.RejectPersonalInfo && containsPersonalInfo(password, personalInfo) {
+		violations = append(violations, Violation{CodePersonalInfo, "password must not contain your email address or name"})
+	}
+
+	if p.Breached != nil {
+		breached, err := isBreached(p.Breached, password)
+		if err != nil {
+			return nil, err
+		}
+		if breached {
+			violations = append(violations, Violation{CodeBreached, "password has appeared in a data breach, choose another one"})
+		}
+	}
+	return violations, nil
+}
+
+// Reused is the violation for passwords found in the user's history.
+func (p Policy) Reused() Violation {
+	return Violation{CodeReused, fmt.Sprintf("password must differ from your last %d passwords", p.HistoryDepth)}
+}
+
+// containsPersonalInfo checks the email address, its local part and every
+// name. Parts too short to be telling are skipped.
+func containsPersonalInfo(password string, personalInfo []string) bool {
+	password = strings.ToLower(password)
+	var parts []string
+	for _, info := range personalInfo {
+		info = strings.ToLower(strings.TrimSpace(info))
+		parts = append(parts, info)
+		if local, _, ok := strings.Cut(info, "@"); ok {
+			parts = append(parts, local)
+		}
+	}
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= minPersonalInfoPart && strings.Contains(password, part) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBreached looks the password up the k-anonymity way: only the first five
+// characters of its SHA-1 hash are asked about.
+func isBreached(list BreachedList, password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := list.Range(hash[:PrefixLength])
+	if err != nil {
+		return false, err
+	}
+	for _, suffix := range suffixes {
+		if suffix == hash[PrefixLength:] {
+			return true, nil
+		}
+	}
+	return false, nil
+}
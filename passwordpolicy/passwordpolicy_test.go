@@ -0,0 +1,222 @@
+package passwordpolicy
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func codes(violations []Violation) []string {
+	codes := []string{}
+	for _, v := range violations {
+		codes = append(codes, v.Code)
+	}
+	return codes
+}
+
+func assertCodes(t *testing.T, p Policy, password string, want []string, personalInfo ...string) {
+	t.Helper()
+	violations, err := p.Check(password, personalInfo...)
+	if err != nil {
+		t.Fatalf("Check(%q): %v", password, err)
+	}
+	if got := codes(violations); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Check(%q) = %v, want %v", password, got, want)
+	}
+}
+
+func TestCheckLength(t *testing.T) {
+	p := Policy{MinLength: 8}
+	assertCodes(t, p, "1234567", []string{CodeTooShort})
+	assertCodes(t, p, "12345678", []string{})
+
+	// Characters are counted, not bytes
+	assertCodes(t, p, "ééééééé", []string{CodeTooShort})
+	assertCodes(t, p, "éééééééé", []string{})
+}
+
+func TestCheckCharacterClasses(t *testing.T) {
+	for _, tt := range []struct {
+		policy   Policy
+		code     string
+		missing  string
+		complete string
+	}{
+		{Policy{RequireUpper: true}, CodeMissingUpper, "lower 1!", "Lower 1!"},
+		{Policy{RequireLower: true}, CodeMissingLower, "UPPER 1!", "UPPEr 1!"},
+		{Policy{RequireDigit: true}, CodeMissingDigit, "Letters!", "Letters1"},
+		{Policy{RequireSymbol: true}, CodeMissingSymbol, "Letters1", "Letters 1"},
+	} {
+		assertCodes(t, tt.policy, tt.missing, []string{tt.code})
+		assertCodes(t, tt.policy, tt.complete, []string{})
+	}
+
+	// Letters of other scripts count as upper and lower case too
+	assertCodes(t, Policy{RequireUpper: true, RequireLower: true}, "Ωμέγα", []string{})
+}
+
+func TestCheckReportsEveryViolation(t *testing.T) {
+	p := Policy{MinLength: 12, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+	assertCodes(t, p, "abc", []string{CodeTooShort, CodeMissingUpper, CodeMissingDigit, CodeMissingSymbol})
+	assertCodes(t, p, "Str0ng!Passw0rd", []string{})
+}
+
+func TestCheckPersonalInfo(t *testing.T) {
+	p := Policy{RejectPersonalInfo: true}
+	info := []string{"Ann.Lee@example.com", "Ann", "Leonhardt"}
+
+	for _, password := range []string{
+		"my ann.lee@example.com!",
+		"ANN.LEE rules",
+		"xxleonhardtxx",
+		"annie123",
+	} {
+		assertCodes(t, p, password, []string{CodePersonalInfo}, info...)
+	}
+	assertCodes(t, p, "correct horse", []string{}, info...)
+
+	// Parts shorter than three characters are too common to refuse
+	assertCodes(t, p, "boat jo", []string{}, "jo@example.com", "Jo")
+
+	// Off, the same passwords are fine
+	assertCodes(t, Policy{}, "annie123", []string{}, info...)
+}
+
+// sha1Hex is the upper case hex SHA-1 of password, as breached lists have
+// them.
+func sha1Hex(password string) string {
+	sum := sha1.Sum([]byte(password))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	content := "# Pwned Passwords sample\n\n" +
+		sha1Hex("password1") + ":2413945\n" +
+		strings.ToLower(sha1Hex("letmein")) + "\n" +
+		"  " + sha1Hex("qwerty") + ":12  \n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := LoadBreachedList(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedList: %v", err)
+	}
+
+	p := Policy{Breached: list}
+	for _, password := range []string{"password1", "letmein", "qwerty"} {
+		assertCodes(t, p, password, []string{CodeBreached})
+	}
+	assertCodes(t, p, "correct horse battery staple", []string{})
+
+	// Ranges are asked for by prefix in any case, and give only suffixes
+	hash := sha1Hex("password1")
+	suffixes, _ := list.Range(strings.ToLower(hash[:PrefixLength]))
+	if len(suffixes) != 1 || suffixes[0] != hash[PrefixLength:] {
+		t.Errorf("Range(%s) = %v, want [%s]", hash[:PrefixLength], suffixes, hash[PrefixLength:])
+	}
+}
+
+func TestLoadBreachedListInvalid(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"short":  sha1Hex("password1")[:39] + "\n",
+		"nothex": strings.Repeat("G", 40) + "\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(sha1Hex("letmein")+"\n"+content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadBreachedList(path)
+		if err == nil || !strings.Contains(err.Error(), name+":2:") {
+			t.Errorf("LoadBreachedList with a %s hash = %v, want an error for line 2", name, err)
+		}
+	}
+
+	if _, err := LoadBreachedList(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("LoadBreachedList of a missing file = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestDirBreachedList(t *testing.T) {
+	dir := t.TempDir()
+	hash := sha1Hex("password1")
+	content := "0018A45C4D1DEF81644B54AB7F969B88D65:1\n" +
+		strings.ToLower(hash[PrefixLength:]) + ":2413945\n\n"
+	if err := os.WriteFile(filepath.Join(dir, hash[:PrefixLength]), []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := OpenBreachedDir(dir)
+	if err != nil {
+		t.Fatalf("OpenBreachedDir: %v", err)
+	}
+	p := Policy{Breached: list}
+	assertCodes(t, p, "password1", []string{CodeBreached})
+	// No range file for its prefix
+	assertCodes(t, p, "correct horse battery staple", []string{})
+
+	suffixes, err := list.Range(strings.ToLower(hash[:PrefixLength]))
+	if err != nil || len(suffixes) != 2 || suffixes[1] != hash[PrefixLength:] {
+		t.Errorf("Range(%s) = (%v, %v), want both suffixes of the file", hash[:PrefixLength], suffixes, err)
+	}
+
+	// Prefixes name files, so anything else is refused
+	for _, prefix := range []string{"../" + hash[:2], hash[:4], "GGGGG"} {
+		if _, err := list.Range(prefix); err == nil {
+			t.Errorf("Range(%q) succeeded, want an error", prefix)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "ABCDE"), []byte(strings.Repeat("G", 35)+":1\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := list.Range("abcde"); err == nil || !strings.Contains(err.Error(), "ABCDE:1:") {
+		t.Errorf("Range of a malformed file = %v, want an error for line 1", err)
+	}
+
+	if _, err := OpenBreachedDir(filepath.Join(dir, "ABCDE")); err == nil {
+		t.Errorf("OpenBreachedDir of a file succeeded, want an error")
+	}
+	if _, err := OpenBreachedDir(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("OpenBreachedDir of a missing directory = %v, want os.ErrNotExist", err)
+	}
+}
+
+// recordingList records the prefixes it is asked about.
+type recordingList struct {
+	prefixes []string
+	err      error
+}
+
+func (l *recordingList) Range(prefix string) ([]string, error) {
+	l.prefixes = append(l.prefixes, prefix)
+	return nil, l.err
+}
+
+func TestCheckBreachedOnlySendsPrefix(t *testing.T) {
+	list := &recordingList{}
+	if _, err := (Policy{Breached: list}).Check("password1"); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.prefixes) != 1 || list.prefixes[0] != sha1Hex("password1")[:PrefixLength] {
+		t.Errorf("Range was asked for %v, want only the 5 character prefix", list.prefixes)
+	}
+
+	list.err = errors.New("list unavailable")
+	if _, err := (Policy{Breached: list}).Check("password1"); !errors.Is(err, list.err) {
+		t.Errorf("Check with a failing list = %v, want its error", err)
+	}
+}
+
+func TestReused(t *testing.T) {
+	v := Policy{HistoryDepth: 5}.Reused()
+	if v.Code != CodeReused || !strings.Contains(v.Message, "5") {
+		t.Errorf("Reused = %+v, want %s naming the depth", v, CodeReused)
+	}
+}
@@ -0,0 +1,52 @@
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// pruneInterval is how often MemoryStore forgets buckets that are full again.
+const pruneInterval = time.Minute
+
+// MemoryStore keeps buckets in process memory. Every replica limits on its
+// own with it, so the effective limit grows with the number of replicas.
+type MemoryStore struct {
+	mu         sync.Mutex
+	buckets    map[string]*memoryBucket
+	lastPruned time.Time
+}
+
+type memoryBucket struct {
+	bucket Bucket
+	// per is how long the bucket takes to fill up, after that it can be
+	// forgotten
+	per time.Duration
+}
+
+func NewMemoryStore() Store {
+	return &MemoryStore{
+		buckets: make(map[string]*memoryBucket),
+	}
+}
+
+func (m *MemoryStore) Take(key string, limit Limit, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if now.Sub(m.lastPruned) >= pruneInterval {
+		for k, b := range m.buckets {
+			if now.Sub(b.bucket.Updated) >= b.per {
+				delete(m.buckets, k)
+			}
+		}
+		m.lastPruned = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &memoryBucket{}
+		m.buckets[key] = b
+	}
+	b.per = limit.Per
+	return b.bucket.Take(limit, now), nil
+}
@@ -0,0 +1,108 @@
+// Package ratelimit implements token bucket rate limits. Every key gets a
+// bucket of Burst tokens that refills over Per, and each request takes one
+// token.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Limit struct {
+	// Burst is how many requests may be made back to back
+	Burst int
+	// Per is how long an empty bucket takes to fill up again
+	Per time.Duration
+}
+
+// ParseLimit parses limits written as "requests/period", like "10/1m". An
+// empty string or "0" is the zero Limit, which turns limiting off.
+func ParseLimit(s string) (Limit, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Limit{}, nil
+	}
+
+	requests, period, ok := strings.Cut(s, "/")
+	if !ok {
+		return Limit{}, fmt.Errorf("invalid rate limit %q, want requests/period", s)
+	}
+	burst, err := strconv.Atoi(requests)
+	if err != nil || burst < 1 {
+		return Limit{}, fmt.Errorf("invalid request count in rate limit %q", s)
+	}
+	per, err := time.ParseDuration(period)
+	if err != nil || per <= 0 {
+		return Limit{}, fmt.Errorf("invalid period in rate limit %q", s)
+	}
+	return Limit{Burst: burst, Per: per}, nil
+}
+
+// Enabled reports whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Per > 0
+}
+
+func (l Limit) String() string {
+	return fmt.Sprintf("%d/%s", l.Burst, l.Per)
+}
+
+// interval is how long it takes to earn back one token.
+func (l Limit) interval() time.Duration {
+	return l.Per / time.Duration(l.Burst)
+}
+
+// Result is what taking a token gave.
+type Result struct {
+	Allowed bool
+	// Remaining is how many tokens are left
+	Remaining int
+	// RetryAfter is how long until the next token is available, zero
+	// while there are tokens left
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Store keeps the buckets. Replicas sharing a Store share their limits.
+type Store interface {
+	// Take takes a token from the key's bucket if there is one.
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Bucket is the state of one key. Stores only have to keep it, the
+// arithmetic is done by Take.
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Take refills the bucket for the time since it was last updated, then
+// takes a token from it if there is one. A zero Bucket is full.
+func (b *Bucket) Take(limit Limit, now time.Time) Result {
+	interval := limit.interval()
+	if b.Updated.IsZero() {
+		b.Tokens = float64(limit.Burst)
+	} else if elapsed := now.Sub(b.Updated); elapsed > 0 {
+		b.Tokens = math.Min(float64(limit.Burst), b.Tokens+float64(elapsed)/float64(interval))
+	}
+	b.Updated = now
+
+	allowed := b.Tokens >= 1
+	if allowed {
+		b.Tokens--
+	}
+
+	result := Result{
+		Allowed:   allowed,
+		Remaining: int(b.Tokens),
+		Reset:     time.Duration((float64(limit.Burst) - b.Tokens) * float64(interval)),
+	}
+	if !allowed {
+		result.RetryAfter = time.Duration((1 - b.Tokens) * float64(interval))
+	}
+	return result
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want Limit
+	}{
+		{"10/1m", Limit{Burst: 10, Per: time.Minute}},
+		{" 600/1h ", Limit{Burst: 600, Per: time.Hour}},
+		{"", Limit{}},
+		{"0", Limit{}},
+	} {
+		got, err := ParseLimit(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseLimit(%q) = (%v, %v), want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"10", "x/1m", "0/1m", "-1/1m", "10/", "10/0s", "10/-1m"} {
+		if _, err := ParseLimit(in); err == nil {
+			t.Errorf("ParseLimit(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestBucketBurst(t *testing.T) {
+	limit := Limit{Burst: 3, Per: 3 * time.Second}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var b Bucket
+
+	for i := 2; i >= 0; i-- {
+		r := b.Take(limit, now)
+		if !r.Allowed || r.Remaining != i || r.RetryAfter != 0 {
+			t.Fatalf("Take %d = %+v, want allowed with %d remaining", 3-i, r, i)
+		}
+	}
+
+	r := b.Take(limit, now)
+	if r.Allowed || r.RetryAfter != time.Second || r.Reset != 3*time.Second {
+		t.Errorf("Take on an empty bucket = %+v, want denied, retry after 1s, reset in 3s", r)
+	}
+}
+
+func TestBucketRefill(t *testing.T) {
+	limit := Limit{Burst: 3, Per: 3 * time.Second}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var b Bucket
+	for i := 0; i < 3; i++ {
+		b.Take(limit, now)
+	}
+
+	// Half a token isn't enough, and it is kept for later
+	r := b.Take(limit, now.Add(500*time.Millisecond))
+	if r.Allowed || r.RetryAfter != 500*time.Millisecond {
+		t.Errorf("Take after half an interval = %+v, want denied, retry after 500ms", r)
+	}
+	r = b.Take(limit, now.Add(time.Second))
+	if !r.Allowed || r.Remaining != 0 {
+		t.Errorf("Take after one interval = %+v, want allowed with 0 remaining", r)
+	}
+
+	// Refills stop at Burst however long the bucket sits
+	b.Take(limit, now.Add(time.Hour))
+	if b.Tokens != 2 {
+		t.Errorf("Tokens after a long pause and a take = %v, want 2", b.Tokens)
+	}
+}
+
+func TestBucketClockGoingBack(t *testing.T) {
+	limit := Limit{Burst: 1, Per: time.Minute}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var b Bucket
+	b.Take(limit, now)
+
+	if r := b.Take(limit, now.Add(-time.Hour)); r.Allowed {
+		t.Errorf("Take with an earlier time = %+v, want denied", r)
+	}
+}
+
+func TestMemoryStoreKeys(t *testing.T) {
+	limit := Limit{Burst: 1, Per: time.Minute}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+
+	if r, _ := store.Take("a", limit, now); !r.Allowed {
+		t.Fatal("first Take of a denied")
+	}
+	if r, _ := store.Take("a", limit, now); r.Allowed {
+		t.Error("second Take of a allowed")
+	}
+	if r, _ := store.Take("b", limit, now); !r.Allowed {
+		t.Error("first Take of b denied, buckets are per key")
+	}
+	if r, _ := store.Take("a", limit, now.Add(time.Minute)); !r.Allowed {
+		t.Error("Take of a after a refill denied")
+	}
+}
+
+func TestMemoryStorePrunesFullBuckets(t *testing.T) {
+	limit := Limit{Burst: 2, Per: time.Minute}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore().(*MemoryStore)
+	store.Take("a", limit, now)
+
+	store.Take("b", limit, now.Add(2*time.Minute))
+	if _, ok := store.buckets["a"]; ok {
+		t.Error("bucket a was kept after it filled up again")
+	}
+	if _, ok := store.buckets["b"]; !ok {
+		t.Error("bucket b was pruned")
+	}
+}
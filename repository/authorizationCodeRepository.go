@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+// AuthorizationCodeStore keeps the codes of the authorization code grant.
+// Like password reset tokens they are stored as hashes and used once.
+type AuthorizationCodeStore interface {
+	// CreateAuthorizationCode stores code under codeHash.
+	CreateAuthorizationCode(code models.AuthorizationCode, codeHash []byte) error
+	// ConsumeAuthorizationCode marks the code as used and returns it. A code
+	// that is unknown, expired or already used gives sql.ErrNoRows.
+	ConsumeAuthorizationCode(codeHash []byte) (models.AuthorizationCode, error)
+}
+
+type AuthorizationCodeRepository struct {
+	DB *DB
+}
+
+func NewAuthorizationCodeRepository(db *DB) AuthorizationCodeStore {
+	return &AuthorizationCodeRepository{
+		DB: db,
+	}
+}
+
+func (r *AuthorizationCodeRepository) CreateAuthorizationCode(code models.AuthorizationCode, codeHash []byte) error {
+	clientBytes, err := uuid.Parse(code.ClientId)
+	if err != nil {
+		log.Printf("Error %s when parsing client_id", err)
+		return err
+	}
+	userBytes, err := uuid.Parse(code.UserId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Codes are short lived, so expired ones are cleared out as new ones
+	// come in
+	now := time.Now().UTC()
+	if _, err := r.DB.ExecContext(ctx, `DELETE FROM oauth_authorization_codes WHERE expires_at < ?`, now); err != nil {
+		log.Printf("Error %s when deleting expired authorization codes", err)
+		return err
+	}
+
+	_, err = r.DB.ExecContext(ctx, `INSERT INTO oauth_authorization_codes (code_hash, client_id, user_id, redirect_uri, code_challenge, scope, nonce, auth_time, expires_at, created_on) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		codeHash, clientBytes[:], userBytes[:], code.RedirectURI, code.CodeChallenge, strings.Join(code.Scopes, " "), code.Nonce, code.AuthTime.UTC(), code.ExpiresAt.UTC(), now)
+	if err != nil {
+		log.Printf("Error %s when inserting authorization code", err)
+		return err
+	}
+	return nil
+}
+
+func (r *AuthorizationCodeRepository) ConsumeAuthorizationCode(codeHash []byte) (models.AuthorizationCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// As with password resets, the conditional update makes the code single
+	// use
+	now := time.Now().UTC()
+	res, err := r.DB.ExecContext(ctx, `UPDATE oauth_authorization_codes SET used_at = ? WHERE code_hash = ? AND used_at IS NULL AND expires_at > ?`, now, codeHash, now)
+	if err != nil {
+		log.Printf("Error %s when consuming authorization code", err)
+		return models.AuthorizationCode{}, err
+	}
+	if err := expectRow(res); err != nil {
+		return models.AuthorizationCode{}, err
+	}
+
+	var code models.AuthorizationCode
+	var rawClientID, rawUserID []byte
+	var scopes string
+	var authTime sql.NullTime
+	err = r.DB.QueryRowContext(ctx, `SELECT client_id, user_id, redirect_uri, code_challenge, scope, nonce, auth_time, expires_at FROM oauth_authorization_codes WHERE code_hash = ?`, codeHash).
+		Scan(&rawClientID, &rawUserID, &code.RedirectURI, &code.CodeChallenge, &scopes, &code.Nonce, &authTime, &code.ExpiresAt)
+	if err != nil {
+		log.Printf("Error %s when getting authorization code", err)
+		return models.AuthorizationCode{}, err
+	}
+
+	clientID, err := uuid.FromBytes(rawClientID)
+	if err != nil {
+		return models.AuthorizationCode{}, err
+	}
+	userID, err := uuid.FromBytes(rawUserID)
+	if err != nil {
+		return models.AuthorizationCode{}, err
+	}
+	code.ClientId, code.UserId = clientID.String(), userID.String()
+	code.Scopes, code.AuthTime = strings.Fields(scopes), authTime.Time
+	return code, nil
+}
@@ -0,0 +1,195 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect is the SQL flavour of a database. The repositories write their
+// queries with ? placeholders and only branch on the dialect where the
+// databases really disagree, such as upserts.
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	Postgres Dialect = "postgres"
+	SQLite   Dialect = "sqlite"
+)
+
+// ParseDialect maps a DB_DRIVER value to its dialect.
+func ParseDialect(driver string) (Dialect, error) {
+	switch dialect := Dialect(strings.ToLower(driver)); dialect {
+	case MySQL, Postgres, SQLite:
+		return dialect, nil
+	}
+	return "", fmt.Errorf("unknown database driver %q", driver)
+}
+
+// driverName is the name the database/sql driver registered itself under.
+func (d Dialect) driverName() string {
+	if d == SQLite {
+		return "sqlite3"
+	}
+	return string(d)
+}
+
+// Rebind rewrites ? placeholders into the form the dialect expects, $1, $2 and
+// so on for Postgres. Question marks inside string literals are left alone.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	n, quoted := 0, false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+		case r == '?' && !quoted:
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// isDuplicate reports whether err is a unique or primary key violation.
+func (d Dialect) isDuplicate(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1062
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique || sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	return false
+}
+
+// insertIgnore turns an INSERT statement into one that skips rows whose key
+// already exists.
+func (d Dialect) insertIgnore(query string) string {
+	if d == MySQL {
+		return strings.Replace(query, "INSERT INTO", "INSERT IGNORE INTO", 1)
+	}
+	return query + ` ON CONFLICT DO NOTHING`
+}
+
+// DB is a database handle that knows its dialect and rebinds placeholders on
+// the way through, so the repositories can share their queries.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// Open connects to the database described by dsn with the driver of dialect.
+func Open(dialect Dialect, dsn string) (*DB, error) {
+	db, err := sql.Open(dialect.driverName(), dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite has a single writer anyway, and a second connection to an in
+	// memory database would see a different, empty database
+	if dialect == SQLite {
+		db.SetMaxOpenConns(1)
+	}
+	return &DB{DB: db, Dialect: dialect}, nil
+}
+
+func (db *DB) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return db.DB.ExecContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return db.DB.QueryContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return db.DB.QueryRowContext(ctx, db.Dialect.Rebind(query), args...)
+}
+
+func (db *DB) BeginTx(ctx context.Context, opts *sql.TxOptions) (*Tx, error) {
+	tx, err := db.DB.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{Tx: tx, dialect: db.Dialect}, nil
+}
+
+// Tx is a transaction that rebinds placeholders like DB does.
+type Tx struct {
+	*sql.Tx
+	dialect Dialect
+}
+
+func (tx *Tx) ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return tx.Tx.ExecContext(ctx, tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return tx.Tx.QueryContext(ctx, tx.dialect.Rebind(query), args...)
+}
+
+func (tx *Tx) QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row {
+	return tx.Tx.QueryRowContext(ctx, tx.dialect.Rebind(query), args...)
+}
+
+// Stores are the SQL backed implementations of every store, sharing one
+// database.
+type Stores struct {
+	Users                UserRepository
+	Roles                RoleStore
+	Organizations        OrganizationStore
+	Revocations          RevocationStore
+	PasswordResets       PasswordResetStore
+	Verifications        EmailVerificationStore
+	MFA                  MFAStore
+	LoginAttempts        LoginAttemptStore
+	PasswordHistory      PasswordHistoryStore
+	PersonalAccessTokens PersonalAccessTokenStore
+	ServiceAccounts      ServiceAccountStore
+	OAuthClients         OAuthClientStore
+	AuthorizationCodes   AuthorizationCodeStore
+	DeviceCodes          DeviceCodeStore
+	DPoPProofs           DPoPProofStore
+	RefreshTokens        RefreshTokenStore
+}
+
+// NewStores returns the stores for db. The queries adapt to db.Dialect, so
+// this is the one place that has to know which database is in use.
+func NewStores(db *DB) Stores {
+	return Stores{
+		Users:                NewRepository(db),
+		Roles:                NewRoleRepository(db),
+		Organizations:        NewOrganizationRepository(db),
+		Revocations:          NewRevocationRepository(db),
+		PasswordResets:       NewPasswordResetRepository(db),
+		Verifications:        NewEmailVerificationRepository(db),
+		MFA:                  NewMFARepository(db),
+		LoginAttempts:        NewLoginAttemptRepository(db),
+		PasswordHistory:      NewPasswordHistoryRepository(db),
+		PersonalAccessTokens: NewPersonalAccessTokenRepository(db),
+		ServiceAccounts:      NewServiceAccountRepository(db),
+		OAuthClients:         NewOAuthClientRepository(db),
+		AuthorizationCodes:   NewAuthorizationCodeRepository(db),
+		DeviceCodes:          NewDeviceCodeRepository(db),
+		DPoPProofs:           NewDPoPProofRepository(db),
+		RefreshTokens:        NewRefreshTokenRepository(db),
+	}
+}
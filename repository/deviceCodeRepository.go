@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateUserCode is returned by CreateDeviceCode when the user code is
+// taken.
+var ErrDuplicateUserCode = errors.New("a device code with this user code already exists")
+
+// DeviceCodeStore keeps the requests of the device authorization grant
+// (RFC 8628). Device codes are stored as hashes. User codes are stored as
+// they are, users look requests up by them.
+type DeviceCodeStore interface {
+	// CreateDeviceCode stores a pending request under deviceCodeHash, or
+	// returns ErrDuplicateUserCode.
+	CreateDeviceCode(device models.DeviceCode, deviceCodeHash []byte) error
+	// GetDeviceCode returns the pending request with userCode. One that is
+	// unknown, expired or already decided gives sql.ErrNoRows.
+	GetDeviceCode(userCode string) (models.DeviceCode, error)
+	// ApproveDeviceCode and DenyDeviceCode record the user's decision on
+	// the pending request with userCode, with the same errors as
+	// GetDeviceCode.
+	ApproveDeviceCode(userCode string, userId string, authTime time.Time) error
+	DenyDeviceCode(userCode string) error
+	// PollDeviceCode records that the device polled at now, and returns the
+	// request as it was before. Expired requests are returned too, so the
+	// device can be told. Unknown ones give sql.ErrNoRows.
+	PollDeviceCode(deviceCodeHash []byte, now time.Time) (models.DeviceCode, error)
+	// ConsumeDeviceCode deletes an approved request once its tokens are
+	// issued. One that isn't approved, or is already consumed, gives
+	// sql.ErrNoRows.
+	ConsumeDeviceCode(deviceCodeHash []byte) error
+}
+
+type DeviceCodeRepository struct {
+	DB *DB
+}
+
+func NewDeviceCodeRepository(db *DB) DeviceCodeStore {
+	return &DeviceCodeRepository{
+		DB: db,
+	}
+}
+
+func (r *DeviceCodeRepository) CreateDeviceCode(device models.DeviceCode, deviceCodeHash []byte) error {
+	clientBytes, err := uuid.Parse(device.ClientId)
+	if err != nil {
+		log.Printf("Error %s when parsing client_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Like authorization codes, expired requests are cleared out as new ones
+	// come in, which also frees their user codes
+	now := time.Now().UTC()
+	if _, err := r.DB.ExecContext(ctx, `DELETE FROM oauth_device_codes WHERE expires_at < ?`, now); err != nil {
+		log.Printf("Error %s when deleting expired device codes", err)
+		return err
+	}
+
+	_, err = r.DB.ExecContext(ctx, `INSERT INTO oauth_device_codes (device_code_hash, user_code, client_id, scope, status, expires_at, created_on) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		deviceCodeHash, device.UserCode, clientBytes[:], strings.Join(device.Scopes, " "), models.DeviceCodePending, device.ExpiresAt.UTC(), now)
+	if err != nil {
+		log.Printf("Error %s when inserting device code", err)
+		if r.DB.Dialect.isDuplicate(err) {
+			return ErrDuplicateUserCode
+		}
+		return err
+	}
+	return nil
+}
+
+const deviceCodeQuery = `SELECT user_code, client_id, scope, status, user_id, auth_time, last_polled_at, expires_at FROM oauth_device_codes`
+
+func scanDeviceCode(row rowScanner) (models.DeviceCode, error) {
+	var device models.DeviceCode
+	var rawClientID, rawUserID []byte
+	var scopes string
+	var authTime, lastPolledAt sql.NullTime
+	if err := row.Scan(&device.UserCode, &rawClientID, &scopes, &device.Status, &rawUserID, &authTime, &lastPolledAt, &device.ExpiresAt); err != nil {
+		return models.DeviceCode{}, err
+	}
+
+	clientID, err := uuid.FromBytes(rawClientID)
+	if err != nil {
+		return models.DeviceCode{}, err
+	}
+	if rawUserID != nil {
+		userID, err := uuid.FromBytes(rawUserID)
+		if err != nil {
+			return models.DeviceCode{}, err
+		}
+		device.UserId = userID.String()
+	}
+	if lastPolledAt.Valid {
+		device.LastPolledAt = &lastPolledAt.Time
+	}
+
+	device.ClientId, device.Scopes, device.AuthTime = clientID.String(), strings.Fields(scopes), authTime.Time
+	return device, nil
+}
+
+func (r *DeviceCodeRepository) GetDeviceCode(userCode string) (models.DeviceCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	device, err := scanDeviceCode(r.DB.QueryRowContext(ctx, deviceCodeQuery+` WHERE user_code = ? AND status = ? AND expires_at > ?`,
+		userCode, models.DeviceCodePending, time.Now().UTC()))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error %s when getting device code", err)
+		}
+		return models.DeviceCode{}, err
+	}
+	return device, nil
+}
+
+func (r *DeviceCodeRepository) ApproveDeviceCode(userCode string, userId string, authTime time.Time) error {
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE oauth_device_codes SET status = ?, user_id = ?, auth_time = ? WHERE user_code = ? AND status = ? AND expires_at > ?`,
+		models.DeviceCodeApproved, userBytes[:], authTime.UTC(), userCode, models.DeviceCodePending, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error %s when approving device code", err)
+		return err
+	}
+	return expectRow(res)
+}
+
+func (r *DeviceCodeRepository) DenyDeviceCode(userCode string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE oauth_device_codes SET status = ? WHERE user_code = ? AND status = ? AND expires_at > ?`,
+		models.DeviceCodeDenied, userCode, models.DeviceCodePending, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error %s when denying device code", err)
+		return err
+	}
+	return expectRow(res)
+}
+
+func (r *DeviceCodeRepository) PollDeviceCode(deviceCodeHash []byte, now time.Time) (models.DeviceCode, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	device, err := scanDeviceCode(r.DB.QueryRowContext(ctx, deviceCodeQuery+` WHERE device_code_hash = ?`, deviceCodeHash))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error %s when getting device code", err)
+		}
+		return models.DeviceCode{}, err
+	}
+
+	if _, err := r.DB.ExecContext(ctx, `UPDATE oauth_device_codes SET last_polled_at = ? WHERE device_code_hash = ?`, now.UTC(), deviceCodeHash); err != nil {
+		log.Printf("Error %s when recording device code poll", err)
+		return models.DeviceCode{}, err
+	}
+	return device, nil
+}
+
+func (r *DeviceCodeRepository) ConsumeDeviceCode(deviceCodeHash []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The conditional delete makes sure only one poll gets the tokens
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM oauth_device_codes WHERE device_code_hash = ? AND status = ?`, deviceCodeHash, models.DeviceCodeApproved)
+	if err != nil {
+		log.Printf("Error %s when consuming device code", err)
+		return err
+	}
+	return expectRow(res)
+}
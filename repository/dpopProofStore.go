@@ -0,0 +1,85 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+)
+
+// DPoPProofStore remembers the jti of every DPoP proof (RFC 9449) for as long
+// as the proof would be accepted, so a proof can't be replayed.
+type DPoPProofStore interface {
+	// UseDPoPProof records jti and reports whether it was new. Entries can
+	// be forgotten after expiresAt, when the proof is too old to be
+	// accepted anyway.
+	UseDPoPProof(jti string, expiresAt time.Time) (bool, error)
+}
+
+type DPoPProofRepository struct {
+	DB *DB
+
+	mu         sync.Mutex
+	lastPruned time.Time
+}
+
+func NewDPoPProofRepository(db *DB) DPoPProofStore {
+	return &DPoPProofRepository{
+		DB: db,
+	}
+}
+
+func (r *DPoPProofRepository) UseDPoPProof(jti string, expiresAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Every request may carry a proof, so expired ones are only swept now
+	// and then
+	now := time.Now().UTC()
+	if r.startPrune(now) {
+		_, err := r.DB.ExecContext(ctx, `DELETE FROM dpop_proofs WHERE expires_at < ?`, now)
+		if err != nil {
+			log.Printf("Error %s when pruning DPoP proofs", err)
+			return false, err
+		}
+	}
+
+	res, err := r.DB.ExecContext(ctx, r.DB.Dialect.insertIgnore(`INSERT INTO dpop_proofs (jti, expires_at) VALUES (?, ?)`), jti, expiresAt.UTC())
+	if err != nil {
+		log.Printf("Error %s when recording DPoP proof", err)
+		return false, err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error %s when recording DPoP proof", err)
+		return false, err
+	}
+	if inserted == 1 {
+		return true, nil
+	}
+
+	// A jti that has expired but wasn't swept yet counts as new again
+	res, err = r.DB.ExecContext(ctx, `UPDATE dpop_proofs SET expires_at = ? WHERE jti = ? AND expires_at < ?`, expiresAt.UTC(), jti, now)
+	if err != nil {
+		log.Printf("Error %s when recording DPoP proof", err)
+		return false, err
+	}
+	renewed, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error %s when recording DPoP proof", err)
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+// startPrune reports whether a minute has passed since the last sweep, and
+// if so counts this one as the last.
+func (r *DPoPProofRepository) startPrune(now time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.lastPruned) < time.Minute {
+		return false
+	}
+	r.lastPruned = now
+	return true
+}
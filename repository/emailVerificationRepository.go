@@ -0,0 +1,102 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailVerificationStore keeps the tokens sent to confirm email addresses.
+// Like password reset tokens, only their hashes are stored.
+type EmailVerificationStore interface {
+	// CreateEmailVerification stores a new token for the user, replacing
+	// any token they were sent before.
+	CreateEmailVerification(userId string, tokenHash []byte, expiresAt time.Time) error
+	// ConsumeEmailVerification deletes the token and returns its user. A
+	// token that is unknown or expired gives sql.ErrNoRows.
+	ConsumeEmailVerification(tokenHash []byte) (string, error)
+}
+
+type EmailVerificationRepository struct {
+	DB *DB
+}
+
+func NewEmailVerificationRepository(db *DB) EmailVerificationStore {
+	return &EmailVerificationRepository{
+		DB: db,
+	}
+}
+
+func (r *EmailVerificationRepository) CreateEmailVerification(userId string, tokenHash []byte, expiresAt time.Time) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM email_verifications WHERE user_id = ?`, idBytes[:]); err != nil {
+		log.Printf("Error %s when deleting email verifications", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO email_verifications (token_hash, user_id, expires_at) VALUES (?, ?, ?)`, tokenHash, idBytes[:], expiresAt.UTC())
+	if err != nil {
+		log.Printf("Error %s when inserting email verification", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *EmailVerificationRepository) ConsumeEmailVerification(tokenHash []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return "", err
+	}
+	defer tx.Rollback()
+
+	var rawUserID []byte
+	err = tx.QueryRowContext(ctx, `SELECT user_id FROM email_verifications WHERE token_hash = ? AND expires_at > ?`, tokenHash, time.Now().UTC()).Scan(&rawUserID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error %s when getting email verification", err)
+		}
+		return "", err
+	}
+
+	// Only one of two concurrent verifications gets to delete the row
+	res, err := tx.ExecContext(ctx, `DELETE FROM email_verifications WHERE token_hash = ?`, tokenHash)
+	if err != nil {
+		log.Printf("Error %s when deleting email verification", err)
+		return "", err
+	}
+	if err := expectRow(res); err != nil {
+		return "", err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error %s when committing email verification", err)
+		return "", err
+	}
+
+	userID, err := uuid.FromBytes(rawUserID)
+	if err != nil {
+		return "", err
+	}
+	return userID.String(), nil
+}
@@ -0,0 +1,112 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+)
+
+// LoginAttemptStore counts failed logins per key, an account or a client
+// IP. Failures are forgotten once they are old enough.
+type LoginAttemptStore interface {
+	// GetLoginFailures returns the failures recorded for key, or the zero
+	// value if there are none.
+	GetLoginFailures(key string) (models.LoginFailures, error)
+	// RecordLoginFailure counts a failure at the given time and returns the
+	// updated failures. Failures from before resetBefore are forgotten
+	// first.
+	RecordLoginFailure(key string, at time.Time, resetBefore time.Time) (models.LoginFailures, error)
+	// ClearLoginFailures forgets the failures recorded for key.
+	ClearLoginFailures(key string) error
+}
+
+type LoginAttemptRepository struct {
+	DB *DB
+}
+
+func NewLoginAttemptRepository(db *DB) LoginAttemptStore {
+	return &LoginAttemptRepository{
+		DB: db,
+	}
+}
+
+func (r *LoginAttemptRepository) GetLoginFailures(key string) (models.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var failures models.LoginFailures
+	err := r.DB.QueryRowContext(ctx, `SELECT failures, last_failure_at FROM login_failures WHERE attempt_key = ?`, key).
+		Scan(&failures.Count, &failures.LastFailureAt)
+	if err == sql.ErrNoRows {
+		return models.LoginFailures{}, nil
+	}
+	if err != nil {
+		log.Printf("Error %s when getting login failures", err)
+		return models.LoginFailures{}, err
+	}
+	return failures, nil
+}
+
+func (r *LoginAttemptRepository) RecordLoginFailure(key string, at time.Time, resetBefore time.Time) (models.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return models.LoginFailures{}, err
+	}
+	defer tx.Rollback()
+
+	// Every update moves last_failure_at or the count, so MySQL reports the
+	// row as affected whenever it exists
+	res, err := tx.ExecContext(ctx, `UPDATE login_failures SET failures = CASE WHEN last_failure_at < ? THEN 1 ELSE failures + 1 END, last_failure_at = ? WHERE attempt_key = ?`,
+		resetBefore.UTC(), at.UTC(), key)
+	if err != nil {
+		log.Printf("Error %s when recording login failure", err)
+		return models.LoginFailures{}, err
+	}
+	if err := expectRow(res); err == sql.ErrNoRows {
+		_, err = tx.ExecContext(ctx, `INSERT INTO login_failures (attempt_key, failures, last_failure_at) VALUES (?, 1, ?)`, key, at.UTC())
+		if err != nil {
+			log.Printf("Error %s when inserting login failure", err)
+			return models.LoginFailures{}, err
+		}
+	} else if err != nil {
+		return models.LoginFailures{}, err
+	}
+
+	var failures models.LoginFailures
+	err = tx.QueryRowContext(ctx, `SELECT failures, last_failure_at FROM login_failures WHERE attempt_key = ?`, key).
+		Scan(&failures.Count, &failures.LastFailureAt)
+	if err != nil {
+		log.Printf("Error %s when getting login failures", err)
+		return models.LoginFailures{}, err
+	}
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error %s when committing login failure", err)
+		return models.LoginFailures{}, err
+	}
+
+	// Keys nobody failed with for a while would only pile up
+	_, err = r.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE last_failure_at < ?`, resetBefore.UTC())
+	if err != nil {
+		log.Printf("Error %s when pruning login failures", err)
+	}
+	return failures, nil
+}
+
+func (r *LoginAttemptRepository) ClearLoginFailures(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `DELETE FROM login_failures WHERE attempt_key = ?`, key)
+	if err != nil {
+		log.Printf("Error %s when clearing login failures", err)
+		return err
+	}
+	return nil
+}
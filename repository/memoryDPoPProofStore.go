@@ -0,0 +1,44 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryDPoPProofStore keeps DPoP proofs in process memory. Like
+// MemoryRevocationStore it only suits a single replica, a proof could be
+// replayed against any other.
+type MemoryDPoPProofStore struct {
+	mu         sync.Mutex
+	proofs     map[string]time.Time
+	lastPruned time.Time
+}
+
+func NewMemoryDPoPProofStore() DPoPProofStore {
+	return &MemoryDPoPProofStore{
+		proofs: make(map[string]time.Time),
+	}
+}
+
+func (m *MemoryDPoPProofStore) UseDPoPProof(jti string, expiresAt time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Every request may carry a proof, so expired ones are only swept now
+	// and then
+	now := time.Now()
+	if now.Sub(m.lastPruned) >= time.Minute {
+		for id, exp := range m.proofs {
+			if exp.Before(now) {
+				delete(m.proofs, id)
+			}
+		}
+		m.lastPruned = now
+	}
+
+	if exp, ok := m.proofs[jti]; ok && !exp.Before(now) {
+		return false, nil
+	}
+	m.proofs[jti] = expiresAt
+	return true, nil
+}
@@ -0,0 +1,54 @@
+package repository
+
+import (
+	"sync"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+)
+
+// MemoryLoginAttemptStore keeps failed logins in process memory. Like
+// MemoryRevocationStore it only suits a single replica, every other replica
+// counts on its own.
+type MemoryLoginAttemptStore struct {
+	mu       sync.Mutex
+	failures map[string]models.LoginFailures
+}
+
+func NewMemoryLoginAttemptStore() LoginAttemptStore {
+	return &MemoryLoginAttemptStore{
+		failures: make(map[string]models.LoginFailures),
+	}
+}
+
+func (m *MemoryLoginAttemptStore) GetLoginFailures(key string) (models.LoginFailures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.failures[key], nil
+}
+
+func (m *MemoryLoginAttemptStore) RecordLoginFailure(key string, at time.Time, resetBefore time.Time) (models.LoginFailures, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for k, failures := range m.failures {
+		if failures.LastFailureAt.Before(resetBefore) {
+			delete(m.failures, k)
+		}
+	}
+
+	failures := m.failures[key]
+	failures.Count++
+	failures.LastFailureAt = at
+	m.failures[key] = failures
+	return failures, nil
+}
+
+func (m *MemoryLoginAttemptStore) ClearLoginFailures(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failures, key)
+	return nil
+}
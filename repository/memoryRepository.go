@@ -0,0 +1,1179 @@
+package repository
+
+import (
+	"database/sql"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+// MemoryRepository keeps users and organizations in process memory. It
+// implements UserRepository, OrganizationStore, PasswordResetStore,
+// EmailVerificationStore, MFAStore, PasswordHistoryStore,
+// PersonalAccessTokenStore, ServiceAccountStore, OAuthClientStore,
+// AuthorizationCodeStore, DeviceCodeStore and RefreshTokenStore, since they
+// all refer to the same users and organizations, and is meant for tests and
+// local runs.
+type MemoryRepository struct {
+	mu      sync.RWMutex
+	seq     int64
+	users   map[string]*memoryUser
+	emails  map[string]string
+	orgs    map[string]models.Organization
+	members map[string]map[string]memoryMember
+	resets  map[string]memoryReset
+	// invitations are by organization, then user
+	invitations map[string]map[string]memoryInvitation
+	// verifications are email verification tokens, which expire like
+	// resets but are deleted once used
+	verifications map[string]memoryReset
+	mfa           map[string]*memoryMFA
+	// history holds earlier password hashes, oldest first
+	history map[string][]string
+	// tokens are personal access tokens by hash
+	tokens map[string]memoryToken
+	// accounts are service accounts by client ID
+	accounts map[string]memoryAccount
+	// clients are OAuth clients by client ID
+	clients map[string]memoryClient
+	// codes are authorization codes by hash
+	codes map[string]memoryCode
+	// devices are device authorization requests by device code hash
+	devices map[string]models.DeviceCode
+	// families are refresh token families by family ID
+	families map[string]memoryFamily
+}
+
+type memoryUser struct {
+	user models.User
+	seq  int64
+}
+
+type memoryMember struct {
+	role string
+	seq  int64
+}
+
+type memoryInvitation struct {
+	role      string
+	expiresAt time.Time
+	seq       int64
+}
+
+type memoryMFA struct {
+	mfa           models.MFA
+	recoveryCodes map[string]bool
+}
+
+type memoryToken struct {
+	token models.PersonalAccessToken
+	seq   int64
+}
+
+type memoryAccount struct {
+	account models.ServiceAccount
+	seq     int64
+}
+
+type memoryClient struct {
+	client models.OAuthClient
+	seq    int64
+}
+
+type memoryCode struct {
+	code models.AuthorizationCode
+	used bool
+}
+
+type memoryFamily struct {
+	userId    string
+	tokenHash string
+	expiresAt time.Time
+}
+
+type memoryReset struct {
+	userId    string
+	expiresAt time.Time
+	used      bool
+}
+
+func NewMemoryRepository() *MemoryRepository {
+	return &MemoryRepository{
+		users:   make(map[string]*memoryUser),
+		emails:  make(map[string]string),
+		orgs:    make(map[string]models.Organization),
+		members: make(map[string]map[string]memoryMember),
+		resets:  make(map[string]memoryReset),
+
+		invitations:   make(map[string]map[string]memoryInvitation),
+		verifications: make(map[string]memoryReset),
+		mfa:           make(map[string]*memoryMFA),
+		history:       make(map[string][]string),
+		tokens:        make(map[string]memoryToken),
+		accounts:      make(map[string]memoryAccount),
+		clients:       make(map[string]memoryClient),
+		codes:         make(map[string]memoryCode),
+		devices:       make(map[string]models.DeviceCode),
+		families:      make(map[string]memoryFamily),
+	}
+}
+
+// copyUser makes sure callers never share pointers with the stored user.
+func copyUser(user models.User) models.User {
+	clone := func(value *string) *string {
+		if value == nil {
+			return nil
+		}
+		v := *value
+		return &v
+	}
+
+	user.FirstName = clone(user.FirstName)
+	user.LastName = clone(user.LastName)
+	user.Password = clone(user.Password)
+	user.Email = clone(user.Email)
+	user.Phone = clone(user.Phone)
+	user.Token = clone(user.Token)
+	user.UserType = clone(user.UserType)
+	user.RefreshToken = clone(user.RefreshToken)
+	if user.EmailVerifiedAt != nil {
+		verifiedAt := *user.EmailVerifiedAt
+		user.EmailVerifiedAt = &verifiedAt
+	}
+	return user
+}
+
+func (m *MemoryRepository) nextSeq() int64 {
+	m.seq++
+	return m.seq
+}
+
+func (m *MemoryRepository) GetUser(userid string) (models.User, error) {
+	if _, err := uuid.Parse(userid); err != nil {
+		return models.User{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.users[userid]
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
+	return copyUser(stored.user), nil
+}
+
+func (m *MemoryRepository) GetUserByEmail(email string) (models.User, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	userId, ok := m.emails[strings.ToLower(email)]
+	if !ok {
+		return models.User{}, sql.ErrNoRows
+	}
+	return copyUser(m.users[userId].user), nil
+}
+
+func (m *MemoryRepository) CreateUser(user models.User) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Emails are unique regardless of case, like with MySQL's collation
+	email := ""
+	if user.Email != nil {
+		email = strings.ToLower(*user.Email)
+	}
+	if _, ok := m.emails[email]; ok {
+		return "", ErrDuplicateEmail
+	}
+
+	stored := copyUser(user)
+	stored.UserId = uuid.NewString()
+	stored.Token, stored.RefreshToken, stored.EmailVerifiedAt = nil, nil, nil
+	if stored.UserType == nil {
+		userType := RoleUser
+		stored.UserType = &userType
+	}
+
+	m.users[stored.UserId] = &memoryUser{user: stored, seq: m.nextSeq()}
+	m.emails[email] = stored.UserId
+	return stored.UserId, nil
+}
+
+func (m *MemoryRepository) CreateRefreshTokenFamily(familyId string, userId string, tokenHash []byte, expiresAt time.Time) error {
+	if _, err := uuid.Parse(familyId); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(userId); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, family := range m.families {
+		if family.expiresAt.Before(now) {
+			delete(m.families, id)
+		}
+	}
+	if _, ok := m.users[userId]; !ok {
+		return sql.ErrNoRows
+	}
+	m.families[familyId] = memoryFamily{userId: userId, tokenHash: string(tokenHash), expiresAt: expiresAt}
+	return nil
+}
+
+func (m *MemoryRepository) RotateRefreshToken(familyId string, previousHash []byte, tokenHash []byte, expiresAt time.Time) (bool, error) {
+	if _, err := uuid.Parse(familyId); err != nil {
+		return false, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	family, ok := m.families[familyId]
+	if !ok || family.tokenHash != string(previousHash) || !time.Now().Before(family.expiresAt) {
+		return false, nil
+	}
+	family.tokenHash, family.expiresAt = string(tokenHash), expiresAt
+	m.families[familyId] = family
+	return true, nil
+}
+
+func (m *MemoryRepository) IsCurrentRefreshToken(familyId string, tokenHash []byte) (bool, error) {
+	if _, err := uuid.Parse(familyId); err != nil {
+		return false, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	family, ok := m.families[familyId]
+	return ok && family.tokenHash == string(tokenHash) && time.Now().Before(family.expiresAt), nil
+}
+
+func (m *MemoryRepository) DeleteRefreshTokenFamily(familyId string) error {
+	if _, err := uuid.Parse(familyId); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.families, familyId)
+	return nil
+}
+
+func (m *MemoryRepository) DeleteUserRefreshTokenFamilies(userId string) error {
+	if _, err := uuid.Parse(userId); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, family := range m.families {
+		if family.userId == userId {
+			delete(m.families, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepository) UpdatePassword(userId string, passwordHash string) error {
+	if _, err := uuid.Parse(userId); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userId]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	stored.user.Password = &passwordHash
+	return nil
+}
+
+func (m *MemoryRepository) CreatePasswordReset(userId string, tokenHash []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userId]; !ok {
+		return sql.ErrNoRows
+	}
+	for hash, reset := range m.resets {
+		if reset.userId == userId {
+			delete(m.resets, hash)
+		}
+	}
+	m.resets[string(tokenHash)] = memoryReset{userId: userId, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *MemoryRepository) GetPasswordReset(tokenHash []byte) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	reset, ok := m.resets[string(tokenHash)]
+	if !ok || reset.used || !time.Now().Before(reset.expiresAt) {
+		return "", sql.ErrNoRows
+	}
+	return reset.userId, nil
+}
+
+func (m *MemoryRepository) ConsumePasswordReset(tokenHash []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reset, ok := m.resets[string(tokenHash)]
+	if !ok || reset.used || !time.Now().Before(reset.expiresAt) {
+		return "", sql.ErrNoRows
+	}
+	reset.used = true
+	m.resets[string(tokenHash)] = reset
+	return reset.userId, nil
+}
+
+func (m *MemoryRepository) MarkEmailVerified(userId string) error {
+	if _, err := uuid.Parse(userId); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.users[userId]
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if stored.user.EmailVerifiedAt == nil {
+		now := time.Now()
+		stored.user.EmailVerifiedAt = &now
+	}
+	return nil
+}
+
+func (m *MemoryRepository) CreateEmailVerification(userId string, tokenHash []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userId]; !ok {
+		return sql.ErrNoRows
+	}
+	for hash, verification := range m.verifications {
+		if verification.userId == userId {
+			delete(m.verifications, hash)
+		}
+	}
+	m.verifications[string(tokenHash)] = memoryReset{userId: userId, expiresAt: expiresAt}
+	return nil
+}
+
+func (m *MemoryRepository) ConsumeEmailVerification(tokenHash []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	verification, ok := m.verifications[string(tokenHash)]
+	if !ok || !time.Now().Before(verification.expiresAt) {
+		return "", sql.ErrNoRows
+	}
+	delete(m.verifications, string(tokenHash))
+	return verification.userId, nil
+}
+
+func (m *MemoryRepository) GetMFA(userId string) (models.MFA, error) {
+	if _, err := uuid.Parse(userId); err != nil {
+		return models.MFA{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.mfa[userId]
+	if !ok {
+		return models.MFA{}, sql.ErrNoRows
+	}
+	mfa := stored.mfa
+	if mfa.ConfirmedAt != nil {
+		confirmedAt := *mfa.ConfirmedAt
+		mfa.ConfirmedAt = &confirmedAt
+	}
+	return mfa, nil
+}
+
+func (m *MemoryRepository) EnrollMFA(userId string, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userId]; !ok {
+		return sql.ErrNoRows
+	}
+	m.mfa[userId] = &memoryMFA{mfa: models.MFA{Secret: secret}, recoveryCodes: map[string]bool{}}
+	return nil
+}
+
+func (m *MemoryRepository) ConfirmMFA(userId string, recoveryCodeHashes [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.mfa[userId]
+	if !ok || stored.mfa.ConfirmedAt != nil {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	stored.mfa.ConfirmedAt = &now
+	stored.recoveryCodes = map[string]bool{}
+	for _, codeHash := range recoveryCodeHashes {
+		stored.recoveryCodes[string(codeHash)] = true
+	}
+	return nil
+}
+
+func (m *MemoryRepository) UseTOTPStep(userId string, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.mfa[userId]
+	if !ok || stored.mfa.LastUsedStep >= step {
+		return false, nil
+	}
+	stored.mfa.LastUsedStep = step
+	return true, nil
+}
+
+func (m *MemoryRepository) UseRecoveryCode(userId string, codeHash []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.mfa[userId]
+	if !ok || !stored.recoveryCodes[string(codeHash)] {
+		return false, nil
+	}
+	delete(stored.recoveryCodes, string(codeHash))
+	return true, nil
+}
+
+func (m *MemoryRepository) DeleteMFA(userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.mfa, userId)
+	return nil
+}
+
+func (m *MemoryRepository) AddPasswordHistory(userId string, passwordHash string, keep int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[userId]; !ok {
+		return sql.ErrNoRows
+	}
+	history := append(m.history[userId], passwordHash)
+	if len(history) > keep {
+		history = history[len(history)-keep:]
+	}
+	m.history[userId] = history
+	return nil
+}
+
+func (m *MemoryRepository) GetPasswordHistory(userId string, limit int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hashes := []string{}
+	history := m.history[userId]
+	for i := len(history) - 1; i >= 0 && len(hashes) < limit; i-- {
+		hashes = append(hashes, history[i])
+	}
+	return hashes, nil
+}
+
+// copyToken makes sure callers never share the scopes or times of the
+// stored token.
+func copyToken(token models.PersonalAccessToken) models.PersonalAccessToken {
+	clone := func(value *time.Time) *time.Time {
+		if value == nil {
+			return nil
+		}
+		v := *value
+		return &v
+	}
+
+	token.Scopes = append([]string{}, token.Scopes...)
+	token.ExpiresAt = clone(token.ExpiresAt)
+	token.LastUsedAt = clone(token.LastUsedAt)
+	return token
+}
+
+func (m *MemoryRepository) CreatePersonalAccessToken(token models.PersonalAccessToken, tokenHash []byte) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[token.UserId]; !ok {
+		return "", sql.ErrNoRows
+	}
+	token = copyToken(token)
+	token.TokenId = uuid.NewString()
+	token.LastUsedAt = nil
+	m.tokens[string(tokenHash)] = memoryToken{token: token, seq: m.nextSeq()}
+	return token.TokenId, nil
+}
+
+func (m *MemoryRepository) GetPersonalAccessToken(tokenHash []byte) (models.PersonalAccessToken, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.tokens[string(tokenHash)]
+	if !ok {
+		return models.PersonalAccessToken{}, sql.ErrNoRows
+	}
+	return copyToken(stored.token), nil
+}
+
+func (m *MemoryRepository) GetPersonalAccessTokens(userId string) ([]models.PersonalAccessToken, error) {
+	if _, err := uuid.Parse(userId); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var stored []memoryToken
+	for _, token := range m.tokens {
+		if token.token.UserId == userId {
+			stored = append(stored, token)
+		}
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].seq > stored[j].seq })
+
+	tokens := []models.PersonalAccessToken{}
+	for _, token := range stored {
+		tokens = append(tokens, copyToken(token.token))
+	}
+	return tokens, nil
+}
+
+func (m *MemoryRepository) DeletePersonalAccessToken(userId string, tokenId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, token := range m.tokens {
+		if token.token.TokenId == tokenId && token.token.UserId == userId {
+			delete(m.tokens, hash)
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func (m *MemoryRepository) TouchPersonalAccessToken(tokenId string, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for hash, token := range m.tokens {
+		if token.token.TokenId == tokenId {
+			token.token.LastUsedAt = &usedAt
+			m.tokens[hash] = token
+			return nil
+		}
+	}
+	return nil
+}
+
+// copyAccount makes sure callers never share the scopes or secret hash of
+// the stored account.
+func copyAccount(account models.ServiceAccount) models.ServiceAccount {
+	account.Scopes = append([]string{}, account.Scopes...)
+	account.SecretHash = append([]byte{}, account.SecretHash...)
+	return account
+}
+
+func (m *MemoryRepository) CreateServiceAccount(account models.ServiceAccount) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if account.OrgId != "" {
+		if _, ok := m.orgs[account.OrgId]; !ok {
+			return "", sql.ErrNoRows
+		}
+	}
+	if account.CertificateIdentity != "" {
+		// Like the unique index, which doesn't compare the NULL org_id of
+		// accounts without an organization
+		for _, stored := range m.accounts {
+			if account.OrgId != "" && stored.account.OrgId == account.OrgId && stored.account.CertificateIdentity == account.CertificateIdentity {
+				return "", ErrDuplicateCertificateIdentity
+			}
+		}
+	}
+	account = copyAccount(account)
+	account.ClientId = uuid.NewString()
+	m.accounts[account.ClientId] = memoryAccount{account: account, seq: m.nextSeq()}
+	return account.ClientId, nil
+}
+
+func (m *MemoryRepository) GetServiceAccount(clientId string) (models.ServiceAccount, error) {
+	if _, err := uuid.Parse(clientId); err != nil {
+		return models.ServiceAccount{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.accounts[clientId]
+	if !ok {
+		return models.ServiceAccount{}, sql.ErrNoRows
+	}
+	return copyAccount(stored.account), nil
+}
+
+func (m *MemoryRepository) GetServiceAccountsByCertificate(identity string) ([]models.ServiceAccount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var stored []memoryAccount
+	for _, account := range m.accounts {
+		if identity != "" && account.account.CertificateIdentity == identity {
+			stored = append(stored, account)
+		}
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].seq < stored[j].seq })
+
+	accounts := []models.ServiceAccount{}
+	for _, account := range stored {
+		accounts = append(accounts, copyAccount(account.account))
+	}
+	return accounts, nil
+}
+
+func (m *MemoryRepository) GetServiceAccounts(orgId string) ([]models.ServiceAccount, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var stored []memoryAccount
+	for _, account := range m.accounts {
+		if account.account.OrgId == orgId {
+			stored = append(stored, account)
+		}
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].seq < stored[j].seq })
+
+	accounts := []models.ServiceAccount{}
+	for _, account := range stored {
+		accounts = append(accounts, copyAccount(account.account))
+	}
+	return accounts, nil
+}
+
+func (m *MemoryRepository) DeleteServiceAccount(orgId string, clientId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.accounts[clientId]
+	if !ok || stored.account.OrgId != orgId {
+		return sql.ErrNoRows
+	}
+	delete(m.accounts, clientId)
+	return nil
+}
+
+// copyClient makes sure callers never share the redirect URIs or secret hash
+// of the stored client.
+func copyClient(client models.OAuthClient) models.OAuthClient {
+	client.RedirectURIs = append([]string{}, client.RedirectURIs...)
+	if client.Public {
+		client.SecretHash = nil
+	} else {
+		client.SecretHash = append([]byte{}, client.SecretHash...)
+	}
+	return client
+}
+
+func (m *MemoryRepository) CreateOAuthClient(client models.OAuthClient) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	client = copyClient(client)
+	client.ClientId = uuid.NewString()
+	m.clients[client.ClientId] = memoryClient{client: client, seq: m.nextSeq()}
+	return client.ClientId, nil
+}
+
+func (m *MemoryRepository) GetOAuthClient(clientId string) (models.OAuthClient, error) {
+	if _, err := uuid.Parse(clientId); err != nil {
+		return models.OAuthClient{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	stored, ok := m.clients[clientId]
+	if !ok {
+		return models.OAuthClient{}, sql.ErrNoRows
+	}
+	return copyClient(stored.client), nil
+}
+
+func (m *MemoryRepository) GetOAuthClients() ([]models.OAuthClient, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var stored []memoryClient
+	for _, client := range m.clients {
+		stored = append(stored, client)
+	}
+	sort.Slice(stored, func(i, j int) bool { return stored[i].seq < stored[j].seq })
+
+	clients := []models.OAuthClient{}
+	for _, client := range stored {
+		clients = append(clients, copyClient(client.client))
+	}
+	return clients, nil
+}
+
+func (m *MemoryRepository) DeleteOAuthClient(clientId string) error {
+	if _, err := uuid.Parse(clientId); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.clients[clientId]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.clients, clientId)
+	for hash, code := range m.codes {
+		if code.code.ClientId == clientId {
+			delete(m.codes, hash)
+		}
+	}
+	for hash, device := range m.devices {
+		if device.ClientId == clientId {
+			delete(m.devices, hash)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryRepository) CreateAuthorizationCode(code models.AuthorizationCode, codeHash []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.clients[code.ClientId]; !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := m.users[code.UserId]; !ok {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	for hash, stored := range m.codes {
+		if stored.code.ExpiresAt.Before(now) {
+			delete(m.codes, hash)
+		}
+	}
+	code.Scopes = append([]string{}, code.Scopes...)
+	m.codes[string(codeHash)] = memoryCode{code: code}
+	return nil
+}
+
+func (m *MemoryRepository) ConsumeAuthorizationCode(codeHash []byte) (models.AuthorizationCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.codes[string(codeHash)]
+	if !ok || stored.used || !time.Now().Before(stored.code.ExpiresAt) {
+		return models.AuthorizationCode{}, sql.ErrNoRows
+	}
+	stored.used = true
+	m.codes[string(codeHash)] = stored
+	return stored.code, nil
+}
+
+// copyDeviceCode makes sure callers never share the scopes or poll time of
+// the stored request.
+func copyDeviceCode(device models.DeviceCode) models.DeviceCode {
+	device.Scopes = append([]string{}, device.Scopes...)
+	if device.LastPolledAt != nil {
+		polledAt := *device.LastPolledAt
+		device.LastPolledAt = &polledAt
+	}
+	return device
+}
+
+func (m *MemoryRepository) CreateDeviceCode(device models.DeviceCode, deviceCodeHash []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.clients[device.ClientId]; !ok {
+		return sql.ErrNoRows
+	}
+	now := time.Now()
+	for hash, stored := range m.devices {
+		if stored.ExpiresAt.Before(now) {
+			delete(m.devices, hash)
+		}
+	}
+	for _, stored := range m.devices {
+		if stored.UserCode == device.UserCode {
+			return ErrDuplicateUserCode
+		}
+	}
+
+	device = copyDeviceCode(device)
+	device.Status, device.UserId, device.AuthTime, device.LastPolledAt = models.DeviceCodePending, "", time.Time{}, nil
+	m.devices[string(deviceCodeHash)] = device
+	return nil
+}
+
+// pendingDevice returns the hash of the pending request with userCode. The
+// caller holds the lock.
+func (m *MemoryRepository) pendingDevice(userCode string) (string, bool) {
+	now := time.Now()
+	for hash, device := range m.devices {
+		if device.UserCode == userCode && device.Status == models.DeviceCodePending && now.Before(device.ExpiresAt) {
+			return hash, true
+		}
+	}
+	return "", false
+}
+
+func (m *MemoryRepository) GetDeviceCode(userCode string) (models.DeviceCode, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	hash, ok := m.pendingDevice(userCode)
+	if !ok {
+		return models.DeviceCode{}, sql.ErrNoRows
+	}
+	return copyDeviceCode(m.devices[hash]), nil
+}
+
+func (m *MemoryRepository) ApproveDeviceCode(userCode string, userId string, authTime time.Time) error {
+	if _, err := uuid.Parse(userId); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash, ok := m.pendingDevice(userCode)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := m.users[userId]; !ok {
+		return sql.ErrNoRows
+	}
+	device := m.devices[hash]
+	device.Status, device.UserId, device.AuthTime = models.DeviceCodeApproved, userId, authTime
+	m.devices[hash] = device
+	return nil
+}
+
+func (m *MemoryRepository) DenyDeviceCode(userCode string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	hash, ok := m.pendingDevice(userCode)
+	if !ok {
+		return sql.ErrNoRows
+	}
+	device := m.devices[hash]
+	device.Status = models.DeviceCodeDenied
+	m.devices[hash] = device
+	return nil
+}
+
+func (m *MemoryRepository) PollDeviceCode(deviceCodeHash []byte, now time.Time) (models.DeviceCode, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	device, ok := m.devices[string(deviceCodeHash)]
+	if !ok {
+		return models.DeviceCode{}, sql.ErrNoRows
+	}
+	polled := copyDeviceCode(device)
+	device.LastPolledAt = &now
+	m.devices[string(deviceCodeHash)] = device
+	return polled, nil
+}
+
+func (m *MemoryRepository) ConsumeDeviceCode(deviceCodeHash []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	device, ok := m.devices[string(deviceCodeHash)]
+	if !ok || device.Status != models.DeviceCodeApproved {
+		return sql.ErrNoRows
+	}
+	delete(m.devices, string(deviceCodeHash))
+	return nil
+}
+
+func (m *MemoryRepository) InTenant(orgId string) TenantUserRepository {
+	return &memoryTenant{m: m, orgId: orgId}
+}
+
+type memoryTenant struct {
+	m     *MemoryRepository
+	orgId string
+}
+
+func (t *memoryTenant) GetUser(userid string) (models.User, error) {
+	if _, err := uuid.Parse(userid); err != nil {
+		return models.User{}, err
+	}
+
+	t.m.mu.RLock()
+	defer t.m.mu.RUnlock()
+
+	stored, ok := t.m.users[userid]
+	if _, member := t.m.members[t.orgId][userid]; !ok || !member {
+		return models.User{}, sql.ErrNoRows
+	}
+	return copyUser(stored.user), nil
+}
+
+func (t *memoryTenant) GetUsers(limit, offset int) ([]models.User, error) {
+	t.m.mu.RLock()
+	defer t.m.mu.RUnlock()
+
+	var found []*memoryUser
+	for userId := range t.m.members[t.orgId] {
+		found = append(found, t.m.users[userId])
+	}
+	sort.Slice(found, func(i, j int) bool {
+		return found[i].seq < found[j].seq
+	})
+
+	users := []models.User{}
+	for i := offset; i < len(found) && len(users) < limit; i++ {
+		users = append(users, copyUser(found[i].user))
+	}
+	return users, nil
+}
+
+func (m *MemoryRepository) CreateOrganization(org models.Organization, ownerId string, ownerRole string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[ownerId]; !ok {
+		return "", sql.ErrNoRows
+	}
+
+	org.OrgId = uuid.NewString()
+	m.orgs[org.OrgId] = org
+	m.members[org.OrgId] = map[string]memoryMember{
+		ownerId: {role: ownerRole, seq: m.nextSeq()},
+	}
+	return org.OrgId, nil
+}
+
+func (m *MemoryRepository) GetOrganization(orgId string) (models.Organization, error) {
+	if _, err := uuid.Parse(orgId); err != nil {
+		return models.Organization{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	org, ok := m.orgs[orgId]
+	if !ok {
+		return models.Organization{}, sql.ErrNoRows
+	}
+	return org, nil
+}
+
+func (m *MemoryRepository) GetMembership(orgId string, userId string) (models.Membership, error) {
+	if _, err := uuid.Parse(orgId); err != nil {
+		return models.Membership{}, err
+	}
+	if _, err := uuid.Parse(userId); err != nil {
+		return models.Membership{}, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	member, ok := m.members[orgId][userId]
+	if !ok {
+		return models.Membership{}, sql.ErrNoRows
+	}
+	return models.Membership{OrgId: orgId, Name: m.orgs[orgId].Name, UserId: userId, Role: member.role}, nil
+}
+
+func (m *MemoryRepository) GetUserMemberships(userId string) ([]models.Membership, error) {
+	if _, err := uuid.Parse(userId); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type found struct {
+		membership models.Membership
+		seq        int64
+	}
+	var all []found
+	for orgId, members := range m.members {
+		if member, ok := members[userId]; ok {
+			membership := models.Membership{OrgId: orgId, Name: m.orgs[orgId].Name, UserId: userId, Role: member.role}
+			all = append(all, found{membership: membership, seq: member.seq})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].seq < all[j].seq
+	})
+
+	memberships := []models.Membership{}
+	for _, f := range all {
+		memberships = append(memberships, f.membership)
+	}
+	return memberships, nil
+}
+
+func (m *MemoryRepository) CountMembers(orgId string, role string) (int, error) {
+	if _, err := uuid.Parse(orgId); err != nil {
+		return 0, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	count := 0
+	for _, member := range m.members[orgId] {
+		if member.role == role {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *MemoryRepository) AddMember(orgId string, userId string, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	members, ok := m.members[orgId]
+	if _, exists := m.users[userId]; !ok || !exists {
+		return sql.ErrNoRows
+	}
+
+	if member, ok := members[userId]; ok {
+		member.role = role
+		members[userId] = member
+		return nil
+	}
+	members[userId] = memoryMember{role: role, seq: m.nextSeq()}
+	return nil
+}
+
+func (m *MemoryRepository) RemoveMember(orgId string, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.members[orgId][userId]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.members[orgId], userId)
+	return nil
+}
+
+func (m *MemoryRepository) InviteMember(invitation models.Invitation) error {
+	if _, err := uuid.Parse(invitation.OrgId); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(invitation.UserId); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.orgs[invitation.OrgId]; !ok {
+		return sql.ErrNoRows
+	}
+	if _, ok := m.users[invitation.UserId]; !ok {
+		return sql.ErrNoRows
+	}
+
+	if m.invitations[invitation.OrgId] == nil {
+		m.invitations[invitation.OrgId] = make(map[string]memoryInvitation)
+	}
+	m.invitations[invitation.OrgId][invitation.UserId] = memoryInvitation{role: invitation.Role, expiresAt: invitation.ExpiresAt, seq: m.nextSeq()}
+	return nil
+}
+
+func (m *MemoryRepository) GetUserInvitations(userId string) ([]models.Invitation, error) {
+	if _, err := uuid.Parse(userId); err != nil {
+		return nil, err
+	}
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	type found struct {
+		invitation models.Invitation
+		seq        int64
+	}
+	var all []found
+	now := time.Now()
+	for orgId, invitations := range m.invitations {
+		if stored, ok := invitations[userId]; ok && now.Before(stored.expiresAt) {
+			invitation := models.Invitation{OrgId: orgId, Name: m.orgs[orgId].Name, UserId: userId, Role: stored.role, ExpiresAt: stored.expiresAt}
+			all = append(all, found{invitation: invitation, seq: stored.seq})
+		}
+	}
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].seq < all[j].seq
+	})
+
+	invitations := []models.Invitation{}
+	for _, f := range all {
+		invitations = append(invitations, f.invitation)
+	}
+	return invitations, nil
+}
+
+func (m *MemoryRepository) AcceptInvitation(orgId string, userId string) error {
+	if _, err := uuid.Parse(orgId); err != nil {
+		return err
+	}
+	if _, err := uuid.Parse(userId); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stored, ok := m.invitations[orgId][userId]
+	if !ok || !time.Now().Before(stored.expiresAt) {
+		return sql.ErrNoRows
+	}
+	delete(m.invitations[orgId], userId)
+
+	if member, ok := m.members[orgId][userId]; ok {
+		member.role = stored.role
+		m.members[orgId][userId] = member
+		return nil
+	}
+	m.members[orgId][userId] = memoryMember{role: stored.role, seq: m.nextSeq()}
+	return nil
+}
+
+func (m *MemoryRepository) DeleteInvitation(orgId string, userId string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.invitations[orgId][userId]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.invitations[orgId], userId)
+	return nil
+}
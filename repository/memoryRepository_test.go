@@ -0,0 +1,15 @@
+package repository_test
+
+import (
+	"testing"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository/repositorytest"
+)
+
+func TestMemoryRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repositorytest.Stores {
+		m := repository.NewMemoryRepository()
+		return repositorytest.Stores{Users: m, Revocations: repository.NewMemoryRevocationStore(), Organizations: m, PasswordResets: m, Verifications: m, MFA: m, LoginAttempts: repository.NewMemoryLoginAttemptStore(), PasswordHistory: m, PersonalAccessTokens: m, ServiceAccounts: m, OAuthClients: m, AuthorizationCodes: m, DeviceCodes: m, DPoPProofs: repository.NewMemoryDPoPProofStore(), RefreshTokens: m}
+	})
+}
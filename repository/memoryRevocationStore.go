@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"sync"
+	"time"
+)
+
+// MemoryRevocationStore keeps revocations in process memory. It is only
+// suitable for a single replica, since other replicas never see its entries
+// and everything is forgotten on restart.
+type MemoryRevocationStore struct {
+	mu            sync.RWMutex
+	tokens        map[string]time.Time
+	revokedBefore map[string]time.Time
+	// memberRevokedBefore is keyed by org ID and user ID
+	memberRevokedBefore map[[2]string]time.Time
+}
+
+func NewMemoryRevocationStore() RevocationStore {
+	return &MemoryRevocationStore{
+		tokens:              make(map[string]time.Time),
+		revokedBefore:       make(map[string]time.Time),
+		memberRevokedBefore: make(map[[2]string]time.Time),
+	}
+}
+
+func (m *MemoryRevocationStore) RevokeToken(jti string, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, exp := range m.tokens {
+		if exp.Before(now) {
+			delete(m.tokens, id)
+		}
+	}
+	m.tokens[jti] = expiresAt
+	return nil
+}
+
+func (m *MemoryRevocationStore) RevokeUserTokens(userId string, issuedBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	issuedBefore = issuedBefore.Truncate(time.Microsecond)
+	if current, ok := m.revokedBefore[userId]; !ok || issuedBefore.After(current) {
+		m.revokedBefore[userId] = issuedBefore
+	}
+	return nil
+}
+
+func (m *MemoryRevocationStore) IsRevoked(jti string, userId string, issuedAt time.Time) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if _, ok := m.tokens[jti]; ok && jti != "" {
+		return true, nil
+	}
+	if revokedBefore, ok := m.revokedBefore[userId]; ok && issuedAt.Before(revokedBefore) {
+		return true, nil
+	}
+	return false, nil
+}
+
+func (m *MemoryRevocationStore) RevokeMemberTokens(orgId string, userId string, issuedBefore time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := [2]string{orgId, userId}
+	issuedBefore = issuedBefore.Truncate(time.Microsecond)
+	if current, ok := m.memberRevokedBefore[key]; !ok || issuedBefore.After(current) {
+		m.memberRevokedBefore[key] = issuedBefore
+	}
+	return nil
+}
+
+func (m *MemoryRevocationStore) IsMemberRevoked(orgId string, userId string, issuedAt time.Time) (bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	revokedBefore, ok := m.memberRevokedBefore[[2]string{orgId, userId}]
+	return ok && issuedAt.Before(revokedBefore), nil
+}
@@ -0,0 +1,218 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+// MFAStore keeps TOTP enrollments and recovery codes. Recovery codes are
+// stored hashed and each can be used once.
+type MFAStore interface {
+	// GetMFA returns the user's enrollment, or sql.ErrNoRows if they have
+	// none.
+	GetMFA(userId string) (models.MFA, error)
+	// EnrollMFA starts a new, unconfirmed enrollment, replacing any
+	// enrollment the user has.
+	EnrollMFA(userId string, secret string) error
+	// ConfirmMFA turns a pending enrollment on and replaces the recovery
+	// codes. Without a pending enrollment it returns sql.ErrNoRows.
+	ConfirmMFA(userId string, recoveryCodeHashes [][]byte) error
+	// UseTOTPStep records step as used, unless it or a later step already
+	// was. It reports whether the step was recorded.
+	UseTOTPStep(userId string, step int64) (bool, error)
+	// UseRecoveryCode consumes the recovery code and reports whether it was
+	// still available.
+	UseRecoveryCode(userId string, codeHash []byte) (bool, error)
+	// DeleteMFA removes the enrollment and the recovery codes.
+	DeleteMFA(userId string) error
+}
+
+type MFARepository struct {
+	DB *DB
+}
+
+func NewMFARepository(db *DB) MFAStore {
+	return &MFARepository{
+		DB: db,
+	}
+}
+
+func (r *MFARepository) GetMFA(userId string) (models.MFA, error) {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return models.MFA{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var mfa models.MFA
+	err = r.DB.QueryRowContext(ctx, `SELECT secret, confirmed_at, last_used_step FROM user_mfa WHERE user_id = ?`, idBytes[:]).
+		Scan(&mfa.Secret, &mfa.ConfirmedAt, &mfa.LastUsedStep)
+	if err != nil {
+		// Most users have no enrollment, that is not worth logging
+		if err != sql.ErrNoRows {
+			log.Printf("Error %s when getting MFA enrollment", err)
+		}
+		return models.MFA{}, err
+	}
+	return mfa, nil
+}
+
+func (r *MFARepository) EnrollMFA(userId string, secret string) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteMFA(ctx, tx, idBytes[:]); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO user_mfa (user_id, secret) VALUES (?, ?)`, idBytes[:], secret)
+	if err != nil {
+		log.Printf("Error %s when inserting MFA enrollment", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *MFARepository) ConfirmMFA(userId string, recoveryCodeHashes [][]byte) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, `UPDATE user_mfa SET confirmed_at = ? WHERE user_id = ? AND confirmed_at IS NULL`, time.Now().UTC(), idBytes[:])
+	if err != nil {
+		log.Printf("Error %s when confirming MFA enrollment", err)
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = ?`, idBytes[:]); err != nil {
+		log.Printf("Error %s when deleting recovery codes", err)
+		return err
+	}
+	for _, codeHash := range recoveryCodeHashes {
+		_, err := tx.ExecContext(ctx, `INSERT INTO mfa_recovery_codes (user_id, code_hash) VALUES (?, ?)`, idBytes[:], codeHash)
+		if err != nil {
+			log.Printf("Error %s when inserting recovery code", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *MFARepository) UseTOTPStep(userId string, step int64) (bool, error) {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE user_mfa SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?`, step, idBytes[:], step)
+	if err != nil {
+		log.Printf("Error %s when recording TOTP step", err)
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error %s when getting rows affected", err)
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (r *MFARepository) UseRecoveryCode(userId string, codeHash []byte) (bool, error) {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = ? AND code_hash = ?`, idBytes[:], codeHash)
+	if err != nil {
+		log.Printf("Error %s when using recovery code", err)
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error %s when getting rows affected", err)
+		return false, err
+	}
+	return rows == 1, nil
+}
+
+func (r *MFARepository) DeleteMFA(userId string) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := deleteMFA(ctx, tx, idBytes[:]); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func deleteMFA(ctx context.Context, tx *Tx, userId []byte) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = ?`, userId); err != nil {
+		log.Printf("Error %s when deleting recovery codes", err)
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM user_mfa WHERE user_id = ?`, userId); err != nil {
+		log.Printf("Error %s when deleting MFA enrollment", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+// OAuthClientStore keeps the apps registered to sign users in through the
+// authorization endpoint.
+type OAuthClientStore interface {
+	// CreateOAuthClient stores client, including the SecretHash of a
+	// confidential client, and returns the ClientId it was given.
+	CreateOAuthClient(client models.OAuthClient) (string, error)
+	// GetOAuthClient returns the client with its SecretHash, or
+	// sql.ErrNoRows.
+	GetOAuthClient(clientId string) (models.OAuthClient, error)
+	// GetOAuthClients lists every client, oldest first.
+	GetOAuthClients() ([]models.OAuthClient, error)
+	// DeleteOAuthClient removes the client and its outstanding codes.
+	DeleteOAuthClient(clientId string) error
+}
+
+type OAuthClientRepository struct {
+	DB *DB
+}
+
+func NewOAuthClientRepository(db *DB) OAuthClientStore {
+	return &OAuthClientRepository{
+		DB: db,
+	}
+}
+
+func (r *OAuthClientRepository) CreateOAuthClient(client models.OAuthClient) (string, error) {
+	// Public clients have no secret at all, rather than an empty one
+	var secretHash interface{}
+	if !client.Public {
+		secretHash = client.SecretHash
+	}
+	clientId := uuid.New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO oauth_clients (client_id, secret_hash, name, redirect_uris, created_on) VALUES (?, ?, ?, ?, ?)`,
+		clientId[:], secretHash, client.Name, strings.Join(client.RedirectURIs, " "), client.CreatedAt.UTC())
+	if err != nil {
+		log.Printf("Error %s when inserting oauth client", err)
+		return "", err
+	}
+	return clientId.String(), nil
+}
+
+const oauthClientQuery = `SELECT client_id, secret_hash, name, redirect_uris, created_on FROM oauth_clients`
+
+func scanOAuthClient(row rowScanner) (models.OAuthClient, error) {
+	var client models.OAuthClient
+	var rawClientID []byte
+	var redirectURIs string
+	if err := row.Scan(&rawClientID, &client.SecretHash, &client.Name, &redirectURIs, &client.CreatedAt); err != nil {
+		return models.OAuthClient{}, err
+	}
+
+	clientID, err := uuid.FromBytes(rawClientID)
+	if err != nil {
+		return models.OAuthClient{}, err
+	}
+
+	client.ClientId, client.RedirectURIs = clientID.String(), strings.Fields(redirectURIs)
+	client.Public = client.SecretHash == nil
+	return client, nil
+}
+
+func (r *OAuthClientRepository) GetOAuthClient(clientId string) (models.OAuthClient, error) {
+	idBytes, err := uuid.Parse(clientId)
+	if err != nil {
+		log.Printf("Error %s when parsing client_id", err)
+		return models.OAuthClient{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	client, err := scanOAuthClient(r.DB.QueryRowContext(ctx, oauthClientQuery+` WHERE client_id = ?`, idBytes[:]))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error %s when getting oauth client", err)
+		}
+		return models.OAuthClient{}, err
+	}
+	return client, nil
+}
+
+func (r *OAuthClientRepository) GetOAuthClients() ([]models.OAuthClient, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, oauthClientQuery+` ORDER BY created_on`)
+	if err != nil {
+		log.Printf("Error %s when getting oauth clients", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	clients := []models.OAuthClient{}
+	for rows.Next() {
+		client, err := scanOAuthClient(rows)
+		if err != nil {
+			log.Printf("Error %s when scanning oauth client", err)
+			return nil, err
+		}
+		clients = append(clients, client)
+	}
+	return clients, rows.Err()
+}
+
+func (r *OAuthClientRepository) DeleteOAuthClient(clientId string) error {
+	idBytes, err := uuid.Parse(clientId)
+	if err != nil {
+		log.Printf("Error %s when parsing client_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM oauth_clients WHERE client_id = ?`, idBytes[:])
+	if err != nil {
+		log.Printf("Error %s when deleting oauth client", err)
+		return err
+	}
+	return expectRow(res)
+}
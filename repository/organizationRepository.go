@@ -0,0 +1,418 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+type OrganizationStore interface {
+	// CreateOrganization creates the organization with ownerId as its first
+	// member, holding ownerRole.
+	CreateOrganization(org models.Organization, ownerId string, ownerRole string) (string, error)
+	GetOrganization(orgId string) (models.Organization, error)
+	GetMembership(orgId string, userId string) (models.Membership, error)
+	GetUserMemberships(userId string) ([]models.Membership, error)
+	// CountMembers counts the members of the organization holding role.
+	CountMembers(orgId string, role string) (int, error)
+	// AddMember adds the user to the organization, or changes their role if
+	// they already belong to it. Users are only added once they accepted an
+	// invitation, see AcceptInvitation.
+	AddMember(orgId string, userId string, role string) error
+	RemoveMember(orgId string, userId string) error
+	// InviteMember offers the user a role in the organization until the
+	// invitation expires. Inviting them again replaces the earlier one.
+	InviteMember(invitation models.Invitation) error
+	// GetUserInvitations lists the user's invitations that haven't expired,
+	// oldest first.
+	GetUserInvitations(userId string) ([]models.Invitation, error)
+	// AcceptInvitation makes the user a member with the role they were
+	// invited to. Unknown and expired invitations give sql.ErrNoRows.
+	AcceptInvitation(orgId string, userId string) error
+	// DeleteInvitation declines or withdraws an invitation. Unknown ones
+	// give sql.ErrNoRows.
+	DeleteInvitation(orgId string, userId string) error
+}
+
+type OrganizationRepository struct {
+	DB *DB
+}
+
+func NewOrganizationRepository(db *DB) OrganizationStore {
+	return &OrganizationRepository{
+		DB: db,
+	}
+}
+
+func (r *OrganizationRepository) CreateOrganization(org models.Organization, ownerId string, ownerRole string) (string, error) {
+	ownerBytes, err := uuid.Parse(ownerId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return "", err
+	}
+	orgId := uuid.New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO organizations (org_id, name) VALUES (?, ?)`, orgId[:], org.Name)
+	if err != nil {
+		log.Printf("Error %s when inserting organization", err)
+		return "", err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO organization_members (org_id, user_id, role) VALUES (?, ?, ?)`, orgId[:], ownerBytes[:], ownerRole)
+	if err != nil {
+		log.Printf("Error %s when inserting organization owner", err)
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error %s when committing organization", err)
+		return "", err
+	}
+	return orgId.String(), nil
+}
+
+func (r *OrganizationRepository) GetOrganization(orgId string) (models.Organization, error) {
+	idBytes, err := uuid.Parse(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return models.Organization{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	org := models.Organization{OrgId: orgId}
+	err = r.DB.QueryRowContext(ctx, `SELECT name FROM organizations WHERE org_id = ?`, idBytes[:]).Scan(&org.Name)
+	if err != nil {
+		log.Printf("Error %s when getting organization", err)
+		return models.Organization{}, err
+	}
+	return org, nil
+}
+
+const membershipQuery = `SELECT m.org_id, o.name, m.user_id, m.role FROM organization_members m
+	JOIN organizations o ON o.org_id = m.org_id`
+
+func scanMembership(row rowScanner) (models.Membership, error) {
+	var membership models.Membership
+	var rawOrgID, rawUserID []byte
+	if err := row.Scan(&rawOrgID, &membership.Name, &rawUserID, &membership.Role); err != nil {
+		return models.Membership{}, err
+	}
+
+	orgID, err := uuid.FromBytes(rawOrgID)
+	if err != nil {
+		return models.Membership{}, err
+	}
+	userID, err := uuid.FromBytes(rawUserID)
+	if err != nil {
+		return models.Membership{}, err
+	}
+
+	membership.OrgId, membership.UserId = orgID.String(), userID.String()
+	return membership, nil
+}
+
+func (r *OrganizationRepository) GetMembership(orgId string, userId string) (models.Membership, error) {
+	orgBytes, err := uuid.Parse(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return models.Membership{}, err
+	}
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return models.Membership{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := membershipQuery + ` WHERE m.org_id = ? AND m.user_id = ?`
+	membership, err := scanMembership(r.DB.QueryRowContext(ctx, query, orgBytes[:], userBytes[:]))
+	if err != nil {
+		log.Printf("Error %s when getting membership", err)
+		return models.Membership{}, err
+	}
+	return membership, nil
+}
+
+// GetUserMemberships lists the user's organizations, oldest membership first.
+func (r *OrganizationRepository) GetUserMemberships(userId string) ([]models.Membership, error) {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := membershipQuery + ` WHERE m.user_id = ? ORDER BY m.created_on, m.org_id`
+	rows, err := r.DB.QueryContext(ctx, query, idBytes[:])
+	if err != nil {
+		log.Printf("Error %s when getting memberships", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	memberships := []models.Membership{}
+	for rows.Next() {
+		membership, err := scanMembership(rows)
+		if err != nil {
+			log.Printf("Error %s when scanning membership", err)
+			return nil, err
+		}
+		memberships = append(memberships, membership)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error %s when closing rows", err)
+		return nil, err
+	}
+	return memberships, nil
+}
+
+func (r *OrganizationRepository) CountMembers(orgId string, role string) (int, error) {
+	idBytes, err := uuid.Parse(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return 0, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var count int
+	err = r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM organization_members WHERE org_id = ? AND role = ?`, idBytes[:], role).Scan(&count)
+	if err != nil {
+		log.Printf("Error %s when counting members", err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// upsertMember is the query AddMember and AcceptInvitation add members with.
+func (r *OrganizationRepository) upsertMember() string {
+	query := `INSERT INTO organization_members (org_id, user_id, role) VALUES (?, ?, ?)`
+	if r.DB.Dialect == MySQL {
+		return query + ` ON DUPLICATE KEY UPDATE role = VALUES(role)`
+	}
+	return query + ` ON CONFLICT (org_id, user_id) DO UPDATE SET role = excluded.role`
+}
+
+func (r *OrganizationRepository) AddMember(orgId string, userId string, role string) error {
+	orgBytes, err := uuid.Parse(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return err
+	}
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = r.DB.ExecContext(ctx, r.upsertMember(), orgBytes[:], userBytes[:], role)
+	if err != nil {
+		log.Printf("Error %s when adding member", err)
+		return err
+	}
+	return nil
+}
+
+func (r *OrganizationRepository) RemoveMember(orgId string, userId string) error {
+	orgBytes, err := uuid.Parse(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return err
+	}
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM organization_members WHERE org_id = ? AND user_id = ?`, orgBytes[:], userBytes[:])
+	if err != nil {
+		log.Printf("Error %s when removing member", err)
+		return err
+	}
+	return expectRow(res)
+}
+
+func (r *OrganizationRepository) InviteMember(invitation models.Invitation) error {
+	orgBytes, err := uuid.Parse(invitation.OrgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return err
+	}
+	userBytes, err := uuid.Parse(invitation.UserId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Expired invitations are cleared out as new ones are made
+	now := time.Now().UTC()
+	if _, err := r.DB.ExecContext(ctx, `DELETE FROM organization_invitations WHERE expires_at < ?`, now); err != nil {
+		log.Printf("Error %s when deleting expired invitations", err)
+		return err
+	}
+
+	query := `INSERT INTO organization_invitations (org_id, user_id, role, expires_at, created_on) VALUES (?, ?, ?, ?, ?)`
+	if r.DB.Dialect == MySQL {
+		query += ` ON DUPLICATE KEY UPDATE role = VALUES(role), expires_at = VALUES(expires_at), created_on = VALUES(created_on)`
+	} else {
+		query += ` ON CONFLICT (org_id, user_id) DO UPDATE SET role = excluded.role, expires_at = excluded.expires_at, created_on = excluded.created_on`
+	}
+	_, err = r.DB.ExecContext(ctx, query, orgBytes[:], userBytes[:], invitation.Role, invitation.ExpiresAt.UTC(), now)
+	if err != nil {
+		log.Printf("Error %s when inserting invitation", err)
+		return err
+	}
+	return nil
+}
+
+func (r *OrganizationRepository) GetUserInvitations(userId string) ([]models.Invitation, error) {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query := `SELECT i.org_id, o.name, i.role, i.expires_at FROM organization_invitations i
+		JOIN organizations o ON o.org_id = i.org_id
+		WHERE i.user_id = ? AND i.expires_at > ? ORDER BY i.created_on, i.org_id`
+	rows, err := r.DB.QueryContext(ctx, query, idBytes[:], time.Now().UTC())
+	if err != nil {
+		log.Printf("Error %s when getting invitations", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.Invitation{}
+	for rows.Next() {
+		invitation := models.Invitation{UserId: userId}
+		var rawOrgID []byte
+		if err := rows.Scan(&rawOrgID, &invitation.Name, &invitation.Role, &invitation.ExpiresAt); err != nil {
+			log.Printf("Error %s when scanning invitation", err)
+			return nil, err
+		}
+		orgID, err := uuid.FromBytes(rawOrgID)
+		if err != nil {
+			return nil, err
+		}
+		invitation.OrgId = orgID.String()
+		invitations = append(invitations, invitation)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error %s when closing rows", err)
+		return nil, err
+	}
+	return invitations, nil
+}
+
+func (r *OrganizationRepository) AcceptInvitation(orgId string, userId string) error {
+	orgBytes, err := uuid.Parse(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return err
+	}
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var role string
+	err = tx.QueryRowContext(ctx, `SELECT role FROM organization_invitations WHERE org_id = ? AND user_id = ? AND expires_at > ?`,
+		orgBytes[:], userBytes[:], time.Now().UTC()).Scan(&role)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error %s when getting invitation", err)
+		}
+		return err
+	}
+
+	// Deleting first means only one of two concurrent accepts goes through
+	res, err := tx.ExecContext(ctx, `DELETE FROM organization_invitations WHERE org_id = ? AND user_id = ?`, orgBytes[:], userBytes[:])
+	if err != nil {
+		log.Printf("Error %s when deleting invitation", err)
+		return err
+	}
+	if err := expectRow(res); err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, r.upsertMember(), orgBytes[:], userBytes[:], role); err != nil {
+		log.Printf("Error %s when adding member", err)
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Printf("Error %s when committing invitation", err)
+		return err
+	}
+	return nil
+}
+
+func (r *OrganizationRepository) DeleteInvitation(orgId string, userId string) error {
+	orgBytes, err := uuid.Parse(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return err
+	}
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM organization_invitations WHERE org_id = ? AND user_id = ?`, orgBytes[:], userBytes[:])
+	if err != nil {
+		log.Printf("Error %s when deleting invitation", err)
+		return err
+	}
+	return expectRow(res)
+}
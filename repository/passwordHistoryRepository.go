@@ -0,0 +1,100 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordHistoryStore remembers the password hashes users had before their
+// current one, so the password policy can refuse going back to them.
+type PasswordHistoryStore interface {
+	// AddPasswordHistory records a hash the user no longer uses and forgets
+	// all but the newest keep hashes.
+	AddPasswordHistory(userId string, passwordHash string, keep int) error
+	// GetPasswordHistory returns up to limit of the user's earlier hashes,
+	// newest first.
+	GetPasswordHistory(userId string, limit int) ([]string, error)
+}
+
+type PasswordHistoryRepository struct {
+	DB *DB
+}
+
+func NewPasswordHistoryRepository(db *DB) PasswordHistoryStore {
+	return &PasswordHistoryRepository{
+		DB: db,
+	}
+}
+
+func (r *PasswordHistoryRepository) AddPasswordHistory(userId string, passwordHash string, keep int) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO password_history (user_id, password_hash) VALUES (?, ?)`, idBytes[:], passwordHash)
+	if err != nil {
+		log.Printf("Error %s when inserting password history", err)
+		return err
+	}
+
+	// Everything from the first entry past keep on goes. MySQL can't LIMIT
+	// a subquery, so the cut-off is looked up first.
+	var cutoff int64
+	err = tx.QueryRowContext(ctx, `SELECT id FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT 1 OFFSET ?`, idBytes[:], keep).Scan(&cutoff)
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error %s when getting password history", err)
+		return err
+	}
+	if err == nil {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM password_history WHERE user_id = ? AND id <= ?`, idBytes[:], cutoff); err != nil {
+			log.Printf("Error %s when pruning password history", err)
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *PasswordHistoryRepository) GetPasswordHistory(userId string, limit int) ([]string, error) {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT password_hash FROM password_history WHERE user_id = ? ORDER BY id DESC LIMIT ?`, idBytes[:], limit)
+	if err != nil {
+		log.Printf("Error %s when getting password history", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	hashes := []string{}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			log.Printf("Error %s when scanning password history", err)
+			return nil, err
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, rows.Err()
+}
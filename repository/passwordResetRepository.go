@@ -0,0 +1,114 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PasswordResetStore keeps outstanding password reset tokens. Only a hash of
+// each token is stored, so a leaked table can't be used to take over accounts.
+type PasswordResetStore interface {
+	// CreatePasswordReset stores a new token for the user, replacing any
+	// token they were sent before.
+	CreatePasswordReset(userId string, tokenHash []byte, expiresAt time.Time) error
+	// GetPasswordReset returns the user of a token that could still be
+	// consumed, without using it up. Other tokens give sql.ErrNoRows.
+	GetPasswordReset(tokenHash []byte) (string, error)
+	// ConsumePasswordReset marks the token as used and returns its user. A
+	// token that is unknown, expired or already used gives sql.ErrNoRows.
+	ConsumePasswordReset(tokenHash []byte) (string, error)
+}
+
+type PasswordResetRepository struct {
+	DB *DB
+}
+
+func NewPasswordResetRepository(db *DB) PasswordResetStore {
+	return &PasswordResetRepository{
+		DB: db,
+	}
+}
+
+func (r *PasswordResetRepository) CreatePasswordReset(userId string, tokenHash []byte, expiresAt time.Time) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM password_resets WHERE user_id = ?`, idBytes[:]); err != nil {
+		log.Printf("Error %s when deleting password resets", err)
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO password_resets (token_hash, user_id, expires_at) VALUES (?, ?, ?)`, tokenHash, idBytes[:], expiresAt.UTC())
+	if err != nil {
+		log.Printf("Error %s when inserting password reset", err)
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *PasswordResetRepository) GetPasswordReset(tokenHash []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var rawUserID []byte
+	err := r.DB.QueryRowContext(ctx, `SELECT user_id FROM password_resets WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`, tokenHash, time.Now().UTC()).Scan(&rawUserID)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error %s when getting password reset", err)
+		}
+		return "", err
+	}
+
+	userID, err := uuid.FromBytes(rawUserID)
+	if err != nil {
+		return "", err
+	}
+	return userID.String(), nil
+}
+
+func (r *PasswordResetRepository) ConsumePasswordReset(tokenHash []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// The conditional update is what makes a token single use, only one of
+	// two concurrent resets can flip used_at
+	now := time.Now().UTC()
+	res, err := r.DB.ExecContext(ctx, `UPDATE password_resets SET used_at = ? WHERE token_hash = ? AND used_at IS NULL AND expires_at > ?`, now, tokenHash, now)
+	if err != nil {
+		log.Printf("Error %s when consuming password reset", err)
+		return "", err
+	}
+	if err := expectRow(res); err != nil {
+		return "", err
+	}
+
+	var rawUserID []byte
+	err = r.DB.QueryRowContext(ctx, `SELECT user_id FROM password_resets WHERE token_hash = ?`, tokenHash).Scan(&rawUserID)
+	if err != nil {
+		log.Printf("Error %s when getting password reset", err)
+		return "", err
+	}
+
+	userID, err := uuid.FromBytes(rawUserID)
+	if err != nil {
+		return "", err
+	}
+	return userID.String(), nil
+}
@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+// PersonalAccessTokenStore keeps personal access tokens. Like password reset
+// tokens they are stored as hashes.
+type PersonalAccessTokenStore interface {
+	// CreatePersonalAccessToken stores token under tokenHash and returns the
+	// TokenId it was given.
+	CreatePersonalAccessToken(token models.PersonalAccessToken, tokenHash []byte) (string, error)
+	// GetPersonalAccessToken returns the token with tokenHash, expired or
+	// not, or sql.ErrNoRows.
+	GetPersonalAccessToken(tokenHash []byte) (models.PersonalAccessToken, error)
+	// GetPersonalAccessTokens lists the user's tokens, newest first.
+	GetPersonalAccessTokens(userId string) ([]models.PersonalAccessToken, error)
+	// DeletePersonalAccessToken revokes one of the user's tokens. Tokens that
+	// don't exist or belong to someone else give sql.ErrNoRows.
+	DeletePersonalAccessToken(userId string, tokenId string) error
+	// TouchPersonalAccessToken records when the token was last used.
+	TouchPersonalAccessToken(tokenId string, usedAt time.Time) error
+}
+
+type PersonalAccessTokenRepository struct {
+	DB *DB
+}
+
+func NewPersonalAccessTokenRepository(db *DB) PersonalAccessTokenStore {
+	return &PersonalAccessTokenRepository{
+		DB: db,
+	}
+}
+
+func (r *PersonalAccessTokenRepository) CreatePersonalAccessToken(token models.PersonalAccessToken, tokenHash []byte) (string, error) {
+	userBytes, err := uuid.Parse(token.UserId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return "", err
+	}
+	orgBytes, err := nullableID(token.OrgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return "", err
+	}
+	var expiresAt *time.Time
+	if token.ExpiresAt != nil {
+		utc := token.ExpiresAt.UTC()
+		expiresAt = &utc
+	}
+	tokenId := uuid.New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = r.DB.ExecContext(ctx, `INSERT INTO personal_access_tokens (token_id, token_hash, user_id, org_id, name, scopes, expires_at, created_on) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		tokenId[:], tokenHash, userBytes[:], orgBytes, token.Name, strings.Join(token.Scopes, " "), expiresAt, token.CreatedAt.UTC())
+	if err != nil {
+		log.Printf("Error %s when inserting personal access token", err)
+		return "", err
+	}
+	return tokenId.String(), nil
+}
+
+const personalAccessTokenQuery = `SELECT token_id, user_id, org_id, name, scopes, expires_at, last_used_at, created_on FROM personal_access_tokens`
+
+func scanPersonalAccessToken(row rowScanner) (models.PersonalAccessToken, error) {
+	var token models.PersonalAccessToken
+	var rawTokenID, rawUserID, rawOrgID []byte
+	var scopes string
+	if err := row.Scan(&rawTokenID, &rawUserID, &rawOrgID, &token.Name, &scopes, &token.ExpiresAt, &token.LastUsedAt, &token.CreatedAt); err != nil {
+		return models.PersonalAccessToken{}, err
+	}
+
+	tokenID, err := uuid.FromBytes(rawTokenID)
+	if err != nil {
+		return models.PersonalAccessToken{}, err
+	}
+	userID, err := uuid.FromBytes(rawUserID)
+	if err != nil {
+		return models.PersonalAccessToken{}, err
+	}
+	if rawOrgID != nil {
+		orgID, err := uuid.FromBytes(rawOrgID)
+		if err != nil {
+			return models.PersonalAccessToken{}, err
+		}
+		token.OrgId = orgID.String()
+	}
+
+	token.TokenId, token.UserId, token.Scopes = tokenID.String(), userID.String(), strings.Fields(scopes)
+	return token, nil
+}
+
+func (r *PersonalAccessTokenRepository) GetPersonalAccessToken(tokenHash []byte) (models.PersonalAccessToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	token, err := scanPersonalAccessToken(r.DB.QueryRowContext(ctx, personalAccessTokenQuery+` WHERE token_hash = ?`, tokenHash))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error %s when getting personal access token", err)
+		}
+		return models.PersonalAccessToken{}, err
+	}
+	return token, nil
+}
+
+func (r *PersonalAccessTokenRepository) GetPersonalAccessTokens(userId string) ([]models.PersonalAccessToken, error) {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, personalAccessTokenQuery+` WHERE user_id = ? ORDER BY created_on DESC`, idBytes[:])
+	if err != nil {
+		log.Printf("Error %s when getting personal access tokens", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []models.PersonalAccessToken{}
+	for rows.Next() {
+		token, err := scanPersonalAccessToken(rows)
+		if err != nil {
+			log.Printf("Error %s when scanning personal access token", err)
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+func (r *PersonalAccessTokenRepository) DeletePersonalAccessToken(userId string, tokenId string) error {
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+	tokenBytes, err := uuid.Parse(tokenId)
+	if err != nil {
+		log.Printf("Error %s when parsing token_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM personal_access_tokens WHERE token_id = ? AND user_id = ?`, tokenBytes[:], userBytes[:])
+	if err != nil {
+		log.Printf("Error %s when deleting personal access token", err)
+		return err
+	}
+	return expectRow(res)
+}
+
+func (r *PersonalAccessTokenRepository) TouchPersonalAccessToken(tokenId string, usedAt time.Time) error {
+	idBytes, err := uuid.Parse(tokenId)
+	if err != nil {
+		log.Printf("Error %s when parsing token_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = r.DB.ExecContext(ctx, `UPDATE personal_access_tokens SET last_used_at = ? WHERE token_id = ?`, usedAt.UTC(), idBytes[:])
+	if err != nil {
+		log.Printf("Error %s when updating personal access token", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package repository
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RefreshTokenStore keeps the refresh token families of users. Every login
+// starts a family, and each refresh replaces its token with the next one, so
+// only the newest token of a family is good. Tokens are stored as hashes.
+type RefreshTokenStore interface {
+	// CreateRefreshTokenFamily starts a family of the user with its first
+	// refresh token.
+	CreateRefreshTokenFamily(familyId string, userId string, tokenHash []byte, expiresAt time.Time) error
+	// RotateRefreshToken replaces the family's token with the next one, only
+	// while previousHash is still its current token, so the same token can't
+	// be refreshed twice. It reports whether it did. Unknown and expired
+	// families report false.
+	RotateRefreshToken(familyId string, previousHash []byte, tokenHash []byte, expiresAt time.Time) (bool, error)
+	// IsCurrentRefreshToken reports whether tokenHash is the family's
+	// current token, the one RotateRefreshToken would accept.
+	IsCurrentRefreshToken(familyId string, tokenHash []byte) (bool, error)
+	// DeleteRefreshTokenFamily ends a family. Ending one that doesn't exist
+	// is not an error.
+	DeleteRefreshTokenFamily(familyId string) error
+	// DeleteUserRefreshTokenFamilies ends every family of the user.
+	DeleteUserRefreshTokenFamilies(userId string) error
+}
+
+type RefreshTokenRepository struct {
+	DB *DB
+}
+
+func NewRefreshTokenRepository(db *DB) RefreshTokenStore {
+	return &RefreshTokenRepository{
+		DB: db,
+	}
+}
+
+func (r *RefreshTokenRepository) CreateRefreshTokenFamily(familyId string, userId string, tokenHash []byte, expiresAt time.Time) error {
+	familyBytes, err := uuid.Parse(familyId)
+	if err != nil {
+		log.Printf("Error %s when parsing family_id", err)
+		return err
+	}
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Families nobody refreshed before their last token expired are over,
+	// they are cleared out as new ones start
+	now := time.Now().UTC()
+	if _, err := r.DB.ExecContext(ctx, `DELETE FROM refresh_token_families WHERE expires_at < ?`, now); err != nil {
+		log.Printf("Error %s when deleting expired refresh token families", err)
+		return err
+	}
+
+	_, err = r.DB.ExecContext(ctx, `INSERT INTO refresh_token_families (family_id, user_id, token_hash, expires_at, created_on) VALUES (?, ?, ?, ?, ?)`,
+		familyBytes[:], userBytes[:], tokenHash, expiresAt.UTC(), now)
+	if err != nil {
+		log.Printf("Error %s when inserting refresh token family", err)
+		return err
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) RotateRefreshToken(familyId string, previousHash []byte, tokenHash []byte, expiresAt time.Time) (bool, error) {
+	idBytes, err := uuid.Parse(familyId)
+	if err != nil {
+		log.Printf("Error %s when parsing family_id", err)
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `UPDATE refresh_token_families SET token_hash = ?, expires_at = ? WHERE family_id = ? AND token_hash = ? AND expires_at > ?`,
+		tokenHash, expiresAt.UTC(), idBytes[:], previousHash, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error %s when rotating refresh token", err)
+		return false, err
+	}
+
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error %s when getting rows affected", err)
+		return false, err
+	}
+	return rows > 0, nil
+}
+
+func (r *RefreshTokenRepository) IsCurrentRefreshToken(familyId string, tokenHash []byte) (bool, error) {
+	idBytes, err := uuid.Parse(familyId)
+	if err != nil {
+		log.Printf("Error %s when parsing family_id", err)
+		return false, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var count int
+	err = r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM refresh_token_families WHERE family_id = ? AND token_hash = ? AND expires_at > ?`,
+		idBytes[:], tokenHash, time.Now().UTC()).Scan(&count)
+	if err != nil {
+		log.Printf("Error %s when checking refresh token", err)
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *RefreshTokenRepository) DeleteRefreshTokenFamily(familyId string) error {
+	idBytes, err := uuid.Parse(familyId)
+	if err != nil {
+		log.Printf("Error %s when parsing family_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := r.DB.ExecContext(ctx, `DELETE FROM refresh_token_families WHERE family_id = ?`, idBytes[:]); err != nil {
+		log.Printf("Error %s when deleting refresh token family", err)
+		return err
+	}
+	return nil
+}
+
+func (r *RefreshTokenRepository) DeleteUserRefreshTokenFamilies(userId string) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := r.DB.ExecContext(ctx, `DELETE FROM refresh_token_families WHERE user_id = ?`, idBytes[:]); err != nil {
+		log.Printf("Error %s when deleting refresh token families", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,1334 @@
+// Package repositorytest holds a conformance suite every backend of the user
+// related stores runs, so they all behave the same way.
+package repositorytest
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+	"github.com/google/uuid"
+)
+
+// Stores are the repositories of one backend, sharing the same data.
+type Stores struct {
+	Users                repository.UserRepository
+	Revocations          repository.RevocationStore
+	Organizations        repository.OrganizationStore
+	PasswordResets       repository.PasswordResetStore
+	Verifications        repository.EmailVerificationStore
+	MFA                  repository.MFAStore
+	LoginAttempts        repository.LoginAttemptStore
+	PasswordHistory      repository.PasswordHistoryStore
+	PersonalAccessTokens repository.PersonalAccessTokenStore
+	ServiceAccounts      repository.ServiceAccountStore
+	OAuthClients         repository.OAuthClientStore
+	AuthorizationCodes   repository.AuthorizationCodeStore
+	DeviceCodes          repository.DeviceCodeStore
+	DPoPProofs           repository.DPoPProofStore
+	RefreshTokens        repository.RefreshTokenStore
+
+	// Roles may be nil for backends without a role store, which skips its
+	// tests
+	Roles repository.RoleStore
+}
+
+// Factory returns stores with no data in them. It is called once per test.
+type Factory func(t *testing.T) Stores
+
+// Run runs the whole suite against the backend built by factory.
+func Run(t *testing.T, factory Factory) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, stores Stores)
+	}{
+		{"CreateAndGetUser", testCreateAndGetUser},
+		{"DuplicateEmail", testDuplicateEmail},
+		{"MissingUser", testMissingUser},
+		{"RefreshTokenFamilies", testRefreshTokenFamilies},
+		{"Revocations", testRevocations},
+		{"Roles", testRoles},
+		{"UpdatePassword", testUpdatePassword},
+		{"PasswordResets", testPasswordResets},
+		{"EmailVerification", testEmailVerification},
+		{"MFA", testMFA},
+		{"LoginFailures", testLoginFailures},
+		{"PasswordHistory", testPasswordHistory},
+		{"PersonalAccessTokens", testPersonalAccessTokens},
+		{"ServiceAccounts", testServiceAccounts},
+		{"OAuthClients", testOAuthClients},
+		{"AuthorizationCodes", testAuthorizationCodes},
+		{"DeviceCodes", testDeviceCodes},
+		{"DPoPProofs", testDPoPProofs},
+		{"Memberships", testMemberships},
+		{"Invitations", testInvitations},
+		{"TenantIsolation", testTenantIsolation},
+		{"Pagination", testPagination},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.test(t, factory(t))
+		})
+	}
+}
+
+func newUser(n int) models.User {
+	firstName, lastName := fmt.Sprintf("First%d", n), fmt.Sprintf("Last%d", n)
+	email := fmt.Sprintf("user%d@example.com", n)
+	phone := fmt.Sprintf("%010d", n)
+	password := "hashed-password"
+	userType := repository.RoleUser
+	return models.User{
+		FirstName: &firstName,
+		LastName:  &lastName,
+		Email:     &email,
+		Phone:     &phone,
+		Password:  &password,
+		UserType:  &userType,
+	}
+}
+
+func createUser(t *testing.T, stores Stores, n int) string {
+	t.Helper()
+	userId, err := stores.Users.CreateUser(newUser(n))
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	return userId
+}
+
+func createOrganization(t *testing.T, stores Stores, name string, ownerId string) string {
+	t.Helper()
+	orgId, err := stores.Organizations.CreateOrganization(models.Organization{Name: name}, ownerId, repository.RoleOrgAdmin)
+	if err != nil {
+		t.Fatalf("CreateOrganization: %v", err)
+	}
+	return orgId
+}
+
+func testCreateAndGetUser(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	if _, err := uuid.Parse(userId); err != nil {
+		t.Fatalf("CreateUser returned %q, not a UUID", userId)
+	}
+
+	want := newUser(1)
+	for name, get := range map[string]func() (models.User, error){
+		"GetUser":        func() (models.User, error) { return stores.Users.GetUser(userId) },
+		"GetUserByEmail": func() (models.User, error) { return stores.Users.GetUserByEmail(*want.Email) },
+	} {
+		user, err := get()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if user.UserId != userId || *user.Email != *want.Email || *user.FirstName != *want.FirstName ||
+			*user.LastName != *want.LastName || *user.Phone != *want.Phone || *user.Password != *want.Password ||
+			*user.UserType != *want.UserType {
+			t.Errorf("%s returned %+v, want the created user", name, user)
+		}
+		if user.Token != nil || user.RefreshToken != nil {
+			t.Errorf("%s: lookups should never return tokens", name)
+		}
+		if user.EmailVerifiedAt != nil {
+			t.Errorf("%s: a new user should not be verified", name)
+		}
+	}
+}
+
+func testDuplicateEmail(t *testing.T, stores Stores) {
+	createUser(t, stores, 1)
+
+	duplicate := newUser(2)
+	duplicate.Email = newUser(1).Email
+	if _, err := stores.Users.CreateUser(duplicate); !errors.Is(err, repository.ErrDuplicateEmail) {
+		t.Fatalf("CreateUser with a taken email returned %v, want ErrDuplicateEmail", err)
+	}
+}
+
+func testMissingUser(t *testing.T, stores Stores) {
+	if _, err := stores.Users.GetUser(uuid.NewString()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUser of an unknown user returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.Users.GetUser("not-a-uuid"); err == nil {
+		t.Errorf("GetUser with an invalid ID succeeded")
+	}
+	if _, err := stores.Users.GetUserByEmail("nobody@example.com"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUserByEmail of an unknown email returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testRefreshTokenFamilies(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	otherId := createUser(t, stores, 2)
+	expiresAt := time.Now().Add(time.Hour)
+
+	laptop, phone, other := uuid.NewString(), uuid.NewString(), uuid.NewString()
+	for _, family := range []struct{ id, userId string }{{laptop, userId}, {phone, userId}, {other, otherId}} {
+		if err := stores.RefreshTokens.CreateRefreshTokenFamily(family.id, family.userId, []byte("refresh-1"), expiresAt); err != nil {
+			t.Fatalf("CreateRefreshTokenFamily: %v", err)
+		}
+	}
+
+	rotated, err := stores.RefreshTokens.RotateRefreshToken(laptop, []byte("refresh-1"), []byte("refresh-2"), expiresAt)
+	if err != nil || !rotated {
+		t.Fatalf("RotateRefreshToken with the current token returned (%v, %v), want (true, nil)", rotated, err)
+	}
+	// The old token has been rotated out of its family, but not out of the
+	// others, even though it hashes the same
+	if rotated, err := stores.RefreshTokens.RotateRefreshToken(laptop, []byte("refresh-1"), []byte("refresh-3"), expiresAt); err != nil || rotated {
+		t.Errorf("RotateRefreshToken with a stale token returned (%v, %v), want (false, nil)", rotated, err)
+	}
+	if rotated, err := stores.RefreshTokens.RotateRefreshToken(phone, []byte("refresh-1"), []byte("refresh-2"), expiresAt); err != nil || !rotated {
+		t.Errorf("RotateRefreshToken in another family returned (%v, %v), want (true, nil)", rotated, err)
+	}
+	if rotated, err := stores.RefreshTokens.RotateRefreshToken(uuid.NewString(), []byte("refresh-1"), []byte("refresh-2"), expiresAt); err != nil || rotated {
+		t.Errorf("RotateRefreshToken of an unknown family returned (%v, %v), want (false, nil)", rotated, err)
+	}
+	for _, tt := range []struct {
+		name     string
+		familyId string
+		hash     string
+		want     bool
+	}{
+		{"the current token", laptop, "refresh-2", true},
+		{"a rotated token", laptop, "refresh-1", false},
+		{"another family's token", other, "refresh-2", false},
+		{"an unknown family", uuid.NewString(), "refresh-1", false},
+	} {
+		if current, err := stores.RefreshTokens.IsCurrentRefreshToken(tt.familyId, []byte(tt.hash)); err != nil || current != tt.want {
+			t.Errorf("IsCurrentRefreshToken of %s returned (%v, %v), want %v", tt.name, current, err, tt.want)
+		}
+	}
+
+	if err := stores.RefreshTokens.DeleteRefreshTokenFamily(laptop); err != nil {
+		t.Fatalf("DeleteRefreshTokenFamily: %v", err)
+	}
+	if rotated, err := stores.RefreshTokens.RotateRefreshToken(laptop, []byte("refresh-2"), []byte("refresh-3"), expiresAt); err != nil || rotated {
+		t.Errorf("RotateRefreshToken of a deleted family returned (%v, %v), want (false, nil)", rotated, err)
+	}
+	if err := stores.RefreshTokens.DeleteRefreshTokenFamily(laptop); err != nil {
+		t.Errorf("DeleteRefreshTokenFamily twice: %v", err)
+	}
+
+	if err := stores.RefreshTokens.DeleteUserRefreshTokenFamilies(userId); err != nil {
+		t.Fatalf("DeleteUserRefreshTokenFamilies: %v", err)
+	}
+	if rotated, err := stores.RefreshTokens.RotateRefreshToken(phone, []byte("refresh-2"), []byte("refresh-3"), expiresAt); err != nil || rotated {
+		t.Errorf("RotateRefreshToken after DeleteUserRefreshTokenFamilies returned (%v, %v), want (false, nil)", rotated, err)
+	}
+	if rotated, err := stores.RefreshTokens.RotateRefreshToken(other, []byte("refresh-1"), []byte("refresh-2"), expiresAt); err != nil || !rotated {
+		t.Errorf("RotateRefreshToken of another user's family returned (%v, %v), want (true, nil)", rotated, err)
+	}
+
+	expired := uuid.NewString()
+	if err := stores.RefreshTokens.CreateRefreshTokenFamily(expired, userId, []byte("refresh-1"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("CreateRefreshTokenFamily: %v", err)
+	}
+	if rotated, err := stores.RefreshTokens.RotateRefreshToken(expired, []byte("refresh-1"), []byte("refresh-2"), expiresAt); err != nil || rotated {
+		t.Errorf("RotateRefreshToken of an expired family returned (%v, %v), want (false, nil)", rotated, err)
+	}
+	if current, err := stores.RefreshTokens.IsCurrentRefreshToken(expired, []byte("refresh-1")); err != nil || current {
+		t.Errorf("IsCurrentRefreshToken of an expired family returned (%v, %v), want (false, nil)", current, err)
+	}
+}
+
+func testRevocations(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	otherId := createUser(t, stores, 2)
+
+	if err := stores.Revocations.RevokeToken("token-1", time.Now().Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeToken: %v", err)
+	}
+	if err := stores.Revocations.RevokeToken("token-1", time.Now().Add(time.Hour)); err != nil {
+		t.Errorf("RevokeToken twice: %v", err)
+	}
+	if revoked, err := stores.Revocations.IsRevoked("token-1", "", time.Time{}); err != nil || !revoked {
+		t.Errorf("IsRevoked of a revoked token returned (%v, %v), want true", revoked, err)
+	}
+	if revoked, err := stores.Revocations.IsRevoked("token-2", userId, time.Now()); err != nil || revoked {
+		t.Errorf("IsRevoked of another token returned (%v, %v), want false", revoked, err)
+	}
+
+	// Cutoffs are kept to the microsecond, like iat_us in tokens
+	cutoff := time.Now().Truncate(time.Microsecond)
+	if err := stores.Revocations.RevokeUserTokens(userId, cutoff); err != nil {
+		t.Fatalf("RevokeUserTokens: %v", err)
+	}
+	for _, tt := range []struct {
+		name     string
+		userId   string
+		issuedAt time.Time
+		want     bool
+	}{
+		{"a token issued before", userId, cutoff.Add(-time.Second), true},
+		{"a token from before iat", userId, time.Time{}, true},
+		{"a token from earlier in the same second", userId, cutoff.Add(-time.Microsecond), true},
+		{"a token issued at the cutoff", userId, cutoff, false},
+		{"a token issued after", userId, cutoff.Add(time.Microsecond), false},
+		{"another user's token", otherId, cutoff.Add(-time.Second), false},
+	} {
+		if revoked, err := stores.Revocations.IsRevoked(uuid.NewString(), tt.userId, tt.issuedAt); err != nil || revoked != tt.want {
+			t.Errorf("IsRevoked of %s returned (%v, %v), want %v", tt.name, revoked, err, tt.want)
+		}
+	}
+
+	// An earlier cutoff doesn't bring tokens back
+	if err := stores.Revocations.RevokeUserTokens(userId, cutoff.Add(-time.Hour)); err != nil {
+		t.Fatalf("RevokeUserTokens: %v", err)
+	}
+	if revoked, err := stores.Revocations.IsRevoked(uuid.NewString(), userId, cutoff.Add(-time.Microsecond)); err != nil || !revoked {
+		t.Errorf("IsRevoked after an earlier cutoff returned (%v, %v), want true", revoked, err)
+	}
+	if err := stores.Revocations.RevokeUserTokens(userId, cutoff.Add(time.Hour)); err != nil {
+		t.Fatalf("RevokeUserTokens: %v", err)
+	}
+	if revoked, err := stores.Revocations.IsRevoked(uuid.NewString(), userId, cutoff.Add(time.Second)); err != nil || !revoked {
+		t.Errorf("IsRevoked after a later cutoff returned (%v, %v), want true", revoked, err)
+	}
+
+	// Member cutoffs only cover the tokens of their organization
+	orgId, otherOrgId := uuid.NewString(), uuid.NewString()
+	if err := stores.Revocations.RevokeMemberTokens(orgId, otherId, cutoff); err != nil {
+		t.Fatalf("RevokeMemberTokens: %v", err)
+	}
+	if err := stores.Revocations.RevokeMemberTokens(orgId, otherId, cutoff.Add(-time.Hour)); err != nil {
+		t.Fatalf("RevokeMemberTokens with an earlier cutoff: %v", err)
+	}
+	for _, tt := range []struct {
+		name     string
+		orgId    string
+		userId   string
+		issuedAt time.Time
+		want     bool
+	}{
+		{"a token issued before", orgId, otherId, cutoff.Add(-time.Microsecond), true},
+		{"a token issued at the cutoff", orgId, otherId, cutoff, false},
+		{"a token for another organization", otherOrgId, otherId, cutoff.Add(-time.Second), false},
+		{"another member's token", orgId, userId, cutoff.Add(-time.Second), false},
+	} {
+		if revoked, err := stores.Revocations.IsMemberRevoked(tt.orgId, tt.userId, tt.issuedAt); err != nil || revoked != tt.want {
+			t.Errorf("IsMemberRevoked of %s returned (%v, %v), want %v", tt.name, revoked, err, tt.want)
+		}
+	}
+	if revoked, err := stores.Revocations.IsRevoked(uuid.NewString(), otherId, cutoff.Add(-time.Second)); err != nil || revoked {
+		t.Errorf("IsRevoked after RevokeMemberTokens returned (%v, %v), want false", revoked, err)
+	}
+}
+
+func testRoles(t *testing.T, stores Stores) {
+	if stores.Roles == nil {
+		t.Skip("no role store")
+	}
+	userId := createUser(t, stores, 1)
+	otherId := createUser(t, stores, 2)
+
+	admin, err := stores.Roles.GetRole(repository.RoleAdmin)
+	if err != nil || !contains(admin.Permissions, repository.PermissionUsersRead) {
+		t.Fatalf("GetRole of ADMIN returned (%+v, %v), want it to hold %s", admin, err, repository.PermissionUsersRead)
+	}
+	if _, err := stores.Roles.GetRole("test_missing"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetRole of an unknown role returned %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.Roles.CreatePermission(models.Permission{Name: "test:read", Description: "Read tests"}); err != nil {
+		t.Fatalf("CreatePermission: %v", err)
+	}
+	if permissions, err := stores.Roles.GetPermissions(); err != nil || !containsPermission(permissions, "test:read") {
+		t.Errorf("GetPermissions returned (%+v, %v), want test:read among them", permissions, err)
+	}
+
+	auditor := models.Role{Name: "test_auditor", Description: "Auditors", Permissions: []string{"test:read"}}
+	if err := stores.Roles.CreateRole(auditor); err != nil {
+		t.Fatalf("CreateRole: %v", err)
+	}
+	if err := stores.Roles.CreateRole(auditor); err == nil {
+		t.Errorf("CreateRole of an existing role succeeded")
+	}
+	if role, err := stores.Roles.GetRole("test_auditor"); err != nil || role.Description != "Auditors" || fmt.Sprint(role.Permissions) != "[test:read]" {
+		t.Errorf("GetRole returned (%+v, %v), want the created role", role, err)
+	}
+
+	if err := stores.Roles.SetRolePermissions("test_auditor", []string{repository.PermissionUsersRead, "test:read"}); err != nil {
+		t.Fatalf("SetRolePermissions: %v", err)
+	}
+	if role, err := stores.Roles.GetRole("test_auditor"); err != nil || fmt.Sprint(role.Permissions) != "[test:read users:read]" {
+		t.Errorf("GetRole after SetRolePermissions returned (%+v, %v)", role, err)
+	}
+	if err := stores.Roles.SetRolePermissions("test_missing", nil); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("SetRolePermissions of an unknown role returned %v, want sql.ErrNoRows", err)
+	}
+	roles, err := stores.Roles.GetRoles()
+	if err != nil {
+		t.Fatalf("GetRoles: %v", err)
+	}
+	found := map[string]bool{}
+	for _, role := range roles {
+		found[role.Name] = true
+	}
+	if !found[repository.RoleAdmin] || !found[repository.RoleUser] || !found["test_auditor"] {
+		t.Errorf("GetRoles returned %+v, want the built-in roles and test_auditor", roles)
+	}
+
+	if err := stores.Roles.AssignRole(userId, "test_auditor"); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if err := stores.Roles.AssignRole(userId, "test_auditor"); err != nil {
+		t.Errorf("AssignRole twice: %v", err)
+	}
+	if roles, err := stores.Roles.GetUserRoles(userId); err != nil || fmt.Sprint(roles) != "[test_auditor]" {
+		t.Errorf("GetUserRoles returned (%v, %v), want [test_auditor]", roles, err)
+	}
+	if permissions, err := stores.Roles.GetUserPermissions(userId, nil); err != nil || fmt.Sprint(permissions) != "[test:read users:read]" {
+		t.Errorf("GetUserPermissions returned (%v, %v), want the permissions of test_auditor", permissions, err)
+	}
+	// Implicit roles add theirs, without duplicates
+	if permissions, err := stores.Roles.GetUserPermissions(userId, []string{repository.RoleOrgAdmin}); err != nil ||
+		!contains(permissions, repository.PermissionUsersWrite) || !contains(permissions, "test:read") || len(permissions) != len(uniqueStrings(permissions)) {
+		t.Errorf("GetUserPermissions with ORG_ADMIN returned (%v, %v)", permissions, err)
+	}
+	if permissions, err := stores.Roles.GetUserPermissions(otherId, []string{repository.RoleUser}); err != nil || contains(permissions, "test:read") {
+		t.Errorf("GetUserPermissions of another user returned (%v, %v), want no test:read", permissions, err)
+	}
+
+	if err := stores.Roles.UnassignRole(userId, "test_auditor"); err != nil {
+		t.Fatalf("UnassignRole: %v", err)
+	}
+	if err := stores.Roles.UnassignRole(userId, "test_auditor"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UnassignRole of an unassigned role returned %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.Roles.DeleteRole(repository.RoleAdmin); !errors.Is(err, repository.ErrBuiltInRole) {
+		t.Errorf("DeleteRole of ADMIN returned %v, want ErrBuiltInRole", err)
+	}
+	if err := stores.Roles.AssignRole(userId, "test_auditor"); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if err := stores.Roles.DeleteRole("test_auditor"); err != nil {
+		t.Fatalf("DeleteRole: %v", err)
+	}
+	if err := stores.Roles.DeleteRole("test_auditor"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteRole twice returned %v, want sql.ErrNoRows", err)
+	}
+	if roles, err := stores.Roles.GetUserRoles(userId); err != nil || len(roles) != 0 {
+		t.Errorf("GetUserRoles after DeleteRole returned (%v, %v), want none", roles, err)
+	}
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueStrings(values []string) map[string]bool {
+	unique := map[string]bool{}
+	for _, v := range values {
+		unique[v] = true
+	}
+	return unique
+}
+
+func containsPermission(permissions []models.Permission, name string) bool {
+	for _, permission := range permissions {
+		if permission.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func testUpdatePassword(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+
+	if err := stores.Users.UpdatePassword(userId, "new-hash"); err != nil {
+		t.Fatalf("UpdatePassword: %v", err)
+	}
+	user, err := stores.Users.GetUser(userId)
+	if err != nil || *user.Password != "new-hash" {
+		t.Errorf("GetUser after UpdatePassword returned (%+v, %v)", user, err)
+	}
+
+	if err := stores.Users.UpdatePassword(uuid.NewString(), "new-hash"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("UpdatePassword of an unknown user returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testPasswordResets(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	expiresAt := time.Now().Add(time.Hour)
+
+	if err := stores.PasswordResets.CreatePasswordReset(userId, []byte("first-hash"), expiresAt); err != nil {
+		t.Fatalf("CreatePasswordReset: %v", err)
+	}
+	if err := stores.PasswordResets.CreatePasswordReset(userId, []byte("second-hash"), expiresAt); err != nil {
+		t.Fatalf("CreatePasswordReset: %v", err)
+	}
+
+	// A new token replaces the one sent before
+	if _, err := stores.PasswordResets.ConsumePasswordReset([]byte("first-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumePasswordReset of a replaced token returned %v, want sql.ErrNoRows", err)
+	}
+
+	// Looking a token up leaves it usable
+	for i := 0; i < 2; i++ {
+		if resetBy, err := stores.PasswordResets.GetPasswordReset([]byte("second-hash")); err != nil || resetBy != userId {
+			t.Fatalf("GetPasswordReset returned (%q, %v), want the user", resetBy, err)
+		}
+	}
+
+	consumedBy, err := stores.PasswordResets.ConsumePasswordReset([]byte("second-hash"))
+	if err != nil || consumedBy != userId {
+		t.Fatalf("ConsumePasswordReset returned (%q, %v), want the user", consumedBy, err)
+	}
+	if _, err := stores.PasswordResets.ConsumePasswordReset([]byte("second-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumePasswordReset of a used token returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.PasswordResets.GetPasswordReset([]byte("second-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetPasswordReset of a used token returned %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.PasswordResets.CreatePasswordReset(userId, []byte("expired-hash"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("CreatePasswordReset: %v", err)
+	}
+	if _, err := stores.PasswordResets.ConsumePasswordReset([]byte("expired-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumePasswordReset of an expired token returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.PasswordResets.GetPasswordReset([]byte("expired-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetPasswordReset of an expired token returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.PasswordResets.ConsumePasswordReset([]byte("unknown-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumePasswordReset of an unknown token returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testEmailVerification(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	expiresAt := time.Now().Add(time.Hour)
+
+	if err := stores.Verifications.CreateEmailVerification(userId, []byte("first-hash"), expiresAt); err != nil {
+		t.Fatalf("CreateEmailVerification: %v", err)
+	}
+	if err := stores.Verifications.CreateEmailVerification(userId, []byte("second-hash"), expiresAt); err != nil {
+		t.Fatalf("CreateEmailVerification: %v", err)
+	}
+	if _, err := stores.Verifications.ConsumeEmailVerification([]byte("first-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeEmailVerification of a replaced token returned %v, want sql.ErrNoRows", err)
+	}
+
+	consumedBy, err := stores.Verifications.ConsumeEmailVerification([]byte("second-hash"))
+	if err != nil || consumedBy != userId {
+		t.Fatalf("ConsumeEmailVerification returned (%q, %v), want the user", consumedBy, err)
+	}
+	if _, err := stores.Verifications.ConsumeEmailVerification([]byte("second-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeEmailVerification of a used token returned %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.Verifications.CreateEmailVerification(userId, []byte("expired-hash"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatalf("CreateEmailVerification: %v", err)
+	}
+	if _, err := stores.Verifications.ConsumeEmailVerification([]byte("expired-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeEmailVerification of an expired token returned %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.Users.MarkEmailVerified(userId); err != nil {
+		t.Fatalf("MarkEmailVerified: %v", err)
+	}
+	user, err := stores.Users.GetUser(userId)
+	if err != nil || user.EmailVerifiedAt == nil {
+		t.Fatalf("GetUser after MarkEmailVerified returned (%+v, %v), want a verified user", user, err)
+	}
+
+	// Verifying again keeps the first time
+	verifiedAt := *user.EmailVerifiedAt
+	if err := stores.Users.MarkEmailVerified(userId); err != nil {
+		t.Fatalf("MarkEmailVerified: %v", err)
+	}
+	if user, err := stores.Users.GetUser(userId); err != nil || !user.EmailVerifiedAt.Equal(verifiedAt) {
+		t.Errorf("MarkEmailVerified twice changed the verification time")
+	}
+	if err := stores.Users.MarkEmailVerified(uuid.NewString()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("MarkEmailVerified of an unknown user returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testMFA(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	if _, err := stores.MFA.GetMFA(userId); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetMFA without an enrollment returned %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.MFA.EnrollMFA(userId, "SECRET"); err != nil {
+		t.Fatalf("EnrollMFA: %v", err)
+	}
+	mfa, err := stores.MFA.GetMFA(userId)
+	if err != nil || mfa.Secret != "SECRET" || mfa.ConfirmedAt != nil {
+		t.Fatalf("GetMFA after EnrollMFA returned (%+v, %v), want an unconfirmed enrollment", mfa, err)
+	}
+
+	if err := stores.MFA.ConfirmMFA(userId, [][]byte{[]byte("code-1"), []byte("code-2")}); err != nil {
+		t.Fatalf("ConfirmMFA: %v", err)
+	}
+	if mfa, err := stores.MFA.GetMFA(userId); err != nil || mfa.ConfirmedAt == nil {
+		t.Fatalf("GetMFA after ConfirmMFA returned (%+v, %v), want a confirmed enrollment", mfa, err)
+	}
+	if err := stores.MFA.ConfirmMFA(userId, nil); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConfirmMFA of a confirmed enrollment returned %v, want sql.ErrNoRows", err)
+	}
+
+	for _, tt := range []struct {
+		step int64
+		want bool
+	}{{100, true}, {100, false}, {99, false}, {101, true}} {
+		if used, err := stores.MFA.UseTOTPStep(userId, tt.step); err != nil || used != tt.want {
+			t.Errorf("UseTOTPStep(%d) returned (%v, %v), want %v", tt.step, used, err, tt.want)
+		}
+	}
+
+	if used, err := stores.MFA.UseRecoveryCode(userId, []byte("code-1")); err != nil || !used {
+		t.Errorf("UseRecoveryCode returned (%v, %v), want true", used, err)
+	}
+	if used, err := stores.MFA.UseRecoveryCode(userId, []byte("code-1")); err != nil || used {
+		t.Errorf("UseRecoveryCode of a used code returned (%v, %v), want false", used, err)
+	}
+
+	if err := stores.MFA.DeleteMFA(userId); err != nil {
+		t.Fatalf("DeleteMFA: %v", err)
+	}
+	if _, err := stores.MFA.GetMFA(userId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetMFA after DeleteMFA returned %v, want sql.ErrNoRows", err)
+	}
+	if used, err := stores.MFA.UseRecoveryCode(userId, []byte("code-2")); err != nil || used {
+		t.Errorf("UseRecoveryCode after DeleteMFA returned (%v, %v), want false", used, err)
+	}
+}
+
+func testLoginFailures(t *testing.T, stores Stores) {
+	assertFailures := func(key string, count int, last time.Time) {
+		t.Helper()
+		failures, err := stores.LoginAttempts.GetLoginFailures(key)
+		if err != nil {
+			t.Fatalf("GetLoginFailures: %v", err)
+		}
+		if failures.Count != count || (count > 0 && !failures.LastFailureAt.Equal(last)) {
+			t.Errorf("GetLoginFailures(%q) returned %+v, want %d failures, the last at %s", key, failures, count, last)
+		}
+	}
+
+	assertFailures("account:user@example.com", 0, time.Time{})
+
+	start := time.Now().UTC().Truncate(time.Second)
+	for i := 1; i <= 3; i++ {
+		at := start.Add(time.Duration(i) * time.Second)
+		failures, err := stores.LoginAttempts.RecordLoginFailure("account:user@example.com", at, start.Add(-time.Hour))
+		if err != nil || failures.Count != i {
+			t.Fatalf("RecordLoginFailure #%d returned (%+v, %v), want %d failures", i, failures, err, i)
+		}
+	}
+	assertFailures("account:user@example.com", 3, start.Add(3*time.Second))
+	assertFailures("ip:192.0.2.1", 0, time.Time{})
+
+	// Failures from before resetBefore start the count over
+	later := start.Add(2 * time.Hour)
+	if failures, err := stores.LoginAttempts.RecordLoginFailure("account:user@example.com", later, start.Add(time.Hour)); err != nil || failures.Count != 1 {
+		t.Fatalf("RecordLoginFailure after resetBefore returned (%+v, %v), want 1 failure", failures, err)
+	}
+	assertFailures("account:user@example.com", 1, later)
+
+	if err := stores.LoginAttempts.ClearLoginFailures("account:user@example.com"); err != nil {
+		t.Fatalf("ClearLoginFailures: %v", err)
+	}
+	assertFailures("account:user@example.com", 0, time.Time{})
+}
+
+func testPasswordHistory(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	otherId := createUser(t, stores, 2)
+
+	if hashes, err := stores.PasswordHistory.GetPasswordHistory(userId, 5); err != nil || len(hashes) != 0 {
+		t.Fatalf("GetPasswordHistory without history returned (%v, %v), want none", hashes, err)
+	}
+
+	for _, hash := range []string{"hash-1", "hash-2", "hash-3", "hash-4"} {
+		if err := stores.PasswordHistory.AddPasswordHistory(userId, hash, 3); err != nil {
+			t.Fatalf("AddPasswordHistory(%q): %v", hash, err)
+		}
+	}
+	if err := stores.PasswordHistory.AddPasswordHistory(otherId, "other-hash", 3); err != nil {
+		t.Fatalf("AddPasswordHistory: %v", err)
+	}
+
+	hashes, err := stores.PasswordHistory.GetPasswordHistory(userId, 5)
+	if err != nil {
+		t.Fatalf("GetPasswordHistory: %v", err)
+	}
+	if want := []string{"hash-4", "hash-3", "hash-2"}; fmt.Sprint(hashes) != fmt.Sprint(want) {
+		t.Errorf("GetPasswordHistory returned %v, want %v", hashes, want)
+	}
+
+	hashes, err = stores.PasswordHistory.GetPasswordHistory(userId, 1)
+	if err != nil || len(hashes) != 1 || hashes[0] != "hash-4" {
+		t.Errorf("GetPasswordHistory with limit 1 returned (%v, %v), want [hash-4]", hashes, err)
+	}
+}
+
+func testPersonalAccessTokens(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	otherId := createUser(t, stores, 2)
+	orgId := createOrganization(t, stores, "Acme", userId)
+
+	if _, err := stores.PersonalAccessTokens.GetPersonalAccessToken([]byte("hash-1")); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetPersonalAccessToken of an unknown token returned %v, want sql.ErrNoRows", err)
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	expiresAt := createdAt.Add(24 * time.Hour)
+	ciId, err := stores.PersonalAccessTokens.CreatePersonalAccessToken(models.PersonalAccessToken{
+		UserId:    userId,
+		OrgId:     orgId,
+		Name:      "ci",
+		Scopes:    []string{repository.PermissionUsersRead, repository.PermissionRolesRead},
+		ExpiresAt: &expiresAt,
+		CreatedAt: createdAt,
+	}, []byte("hash-1"))
+	if err != nil {
+		t.Fatalf("CreatePersonalAccessToken: %v", err)
+	}
+	scriptId, err := stores.PersonalAccessTokens.CreatePersonalAccessToken(models.PersonalAccessToken{
+		UserId:    userId,
+		Name:      "script",
+		Scopes:    []string{repository.PermissionUsersRead},
+		CreatedAt: createdAt.Add(time.Second),
+	}, []byte("hash-2"))
+	if err != nil {
+		t.Fatalf("CreatePersonalAccessToken without org and expiry: %v", err)
+	}
+
+	token, err := stores.PersonalAccessTokens.GetPersonalAccessToken([]byte("hash-1"))
+	if err != nil {
+		t.Fatalf("GetPersonalAccessToken: %v", err)
+	}
+	if token.TokenId != ciId || token.UserId != userId || token.OrgId != orgId || token.Name != "ci" ||
+		fmt.Sprint(token.Scopes) != fmt.Sprint([]string{repository.PermissionUsersRead, repository.PermissionRolesRead}) ||
+		token.ExpiresAt == nil || !token.ExpiresAt.Equal(expiresAt) || token.LastUsedAt != nil || !token.CreatedAt.Equal(createdAt) {
+		t.Errorf("GetPersonalAccessToken returned %+v", token)
+	}
+	token, err = stores.PersonalAccessTokens.GetPersonalAccessToken([]byte("hash-2"))
+	if err != nil || token.TokenId != scriptId || token.OrgId != "" || token.ExpiresAt != nil {
+		t.Errorf("GetPersonalAccessToken without org and expiry returned (%+v, %v)", token, err)
+	}
+
+	usedAt := createdAt.Add(time.Minute)
+	if err := stores.PersonalAccessTokens.TouchPersonalAccessToken(ciId, usedAt); err != nil {
+		t.Fatalf("TouchPersonalAccessToken: %v", err)
+	}
+	if token, err := stores.PersonalAccessTokens.GetPersonalAccessToken([]byte("hash-1")); err != nil || token.LastUsedAt == nil || !token.LastUsedAt.Equal(usedAt) {
+		t.Errorf("GetPersonalAccessToken after TouchPersonalAccessToken returned (%+v, %v), want last use at %s", token, err, usedAt)
+	}
+
+	tokens, err := stores.PersonalAccessTokens.GetPersonalAccessTokens(userId)
+	if err != nil || len(tokens) != 2 || tokens[0].TokenId != scriptId || tokens[1].TokenId != ciId {
+		t.Errorf("GetPersonalAccessTokens returned (%+v, %v), want script, then ci", tokens, err)
+	}
+	if tokens, err := stores.PersonalAccessTokens.GetPersonalAccessTokens(otherId); err != nil || len(tokens) != 0 {
+		t.Errorf("GetPersonalAccessTokens of another user returned (%+v, %v), want none", tokens, err)
+	}
+
+	if err := stores.PersonalAccessTokens.DeletePersonalAccessToken(otherId, ciId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeletePersonalAccessToken of another user's token returned %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.PersonalAccessTokens.DeletePersonalAccessToken(userId, ciId); err != nil {
+		t.Fatalf("DeletePersonalAccessToken: %v", err)
+	}
+	if _, err := stores.PersonalAccessTokens.GetPersonalAccessToken([]byte("hash-1")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetPersonalAccessToken after DeletePersonalAccessToken returned %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.PersonalAccessTokens.DeletePersonalAccessToken(userId, ciId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeletePersonalAccessToken of a deleted token returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testServiceAccounts(t *testing.T, stores Stores) {
+	ownerId := createUser(t, stores, 1)
+	orgId := createOrganization(t, stores, "Acme", ownerId)
+	otherOrgId := createOrganization(t, stores, "Globex", ownerId)
+
+	if _, err := stores.ServiceAccounts.GetServiceAccount(uuid.NewString()); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetServiceAccount of an unknown account returned %v, want sql.ErrNoRows", err)
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	billingId, err := stores.ServiceAccounts.CreateServiceAccount(models.ServiceAccount{
+		SecretHash: []byte("secret-hash"),
+		OrgId:      orgId,
+		Name:       "billing",
+		Scopes:     []string{repository.PermissionUsersRead, repository.PermissionUsersWrite},
+		Audience:   "https://billing.example.com",
+		CreatedAt:  createdAt,
+
+		CertificateIdentity: "CN=billing,O=Example",
+	})
+	if err != nil {
+		t.Fatalf("CreateServiceAccount: %v", err)
+	}
+	reportsId, err := stores.ServiceAccounts.CreateServiceAccount(models.ServiceAccount{
+		SecretHash: []byte("other-hash"),
+		OrgId:      orgId,
+		Name:       "reports",
+		Scopes:     []string{repository.PermissionUsersRead},
+		CreatedAt:  createdAt.Add(time.Second),
+	})
+	if err != nil {
+		t.Fatalf("CreateServiceAccount: %v", err)
+	}
+	globalId, err := stores.ServiceAccounts.CreateServiceAccount(models.ServiceAccount{
+		SecretHash: []byte("global-hash"),
+		Name:       "global",
+		Scopes:     []string{repository.PermissionRolesRead},
+		CreatedAt:  createdAt,
+	})
+	if err != nil {
+		t.Fatalf("CreateServiceAccount without an organization: %v", err)
+	}
+
+	account, err := stores.ServiceAccounts.GetServiceAccount(billingId)
+	if err != nil {
+		t.Fatalf("GetServiceAccount: %v", err)
+	}
+	if account.ClientId != billingId || string(account.SecretHash) != "secret-hash" || account.OrgId != orgId || account.Name != "billing" ||
+		fmt.Sprint(account.Scopes) != fmt.Sprint([]string{repository.PermissionUsersRead, repository.PermissionUsersWrite}) ||
+		account.Audience != "https://billing.example.com" || account.CertificateIdentity != "CN=billing,O=Example" || !account.CreatedAt.Equal(createdAt) {
+		t.Errorf("GetServiceAccount returned %+v", account)
+	}
+	if account, err := stores.ServiceAccounts.GetServiceAccount(globalId); err != nil || account.OrgId != "" || account.CertificateIdentity != "" {
+		t.Errorf("GetServiceAccount without an organization returned (%+v, %v)", account, err)
+	}
+
+	if accounts, err := stores.ServiceAccounts.GetServiceAccountsByCertificate("CN=billing,O=Example"); err != nil || len(accounts) != 1 || accounts[0].ClientId != billingId {
+		t.Errorf("GetServiceAccountsByCertificate returned (%+v, %v), want billing", accounts, err)
+	}
+	if accounts, err := stores.ServiceAccounts.GetServiceAccountsByCertificate("dns:billing.example.com"); err != nil || len(accounts) != 0 {
+		t.Errorf("GetServiceAccountsByCertificate of an unknown identity returned (%+v, %v), want none", accounts, err)
+	}
+	_, err = stores.ServiceAccounts.CreateServiceAccount(models.ServiceAccount{
+		SecretHash: []byte("copy-hash"),
+		OrgId:      orgId,
+		Name:       "copy",
+		Scopes:     []string{repository.PermissionUsersRead},
+		CreatedAt:  createdAt,
+
+		CertificateIdentity: "CN=billing,O=Example",
+	})
+	if !errors.Is(err, repository.ErrDuplicateCertificateIdentity) {
+		t.Errorf("CreateServiceAccount with a certificate identity taken in the organization returned %v, want ErrDuplicateCertificateIdentity", err)
+	}
+
+	// Other organizations are free to use the identity too
+	otherBillingId, err := stores.ServiceAccounts.CreateServiceAccount(models.ServiceAccount{
+		SecretHash: []byte("other-billing-hash"),
+		OrgId:      otherOrgId,
+		Name:       "billing",
+		Scopes:     []string{repository.PermissionUsersRead},
+		CreatedAt:  createdAt.Add(2 * time.Second),
+
+		CertificateIdentity: "CN=billing,O=Example",
+	})
+	if err != nil {
+		t.Fatalf("CreateServiceAccount with a certificate identity of another organization: %v", err)
+	}
+	if accounts, err := stores.ServiceAccounts.GetServiceAccountsByCertificate("CN=billing,O=Example"); err != nil || len(accounts) != 2 || accounts[0].ClientId != billingId || accounts[1].ClientId != otherBillingId {
+		t.Errorf("GetServiceAccountsByCertificate returned (%+v, %v), want both billing accounts", accounts, err)
+	}
+
+	accounts, err := stores.ServiceAccounts.GetServiceAccounts(orgId)
+	if err != nil || len(accounts) != 2 || accounts[0].ClientId != billingId || accounts[1].ClientId != reportsId {
+		t.Errorf("GetServiceAccounts returned (%+v, %v), want billing, then reports", accounts, err)
+	}
+	if accounts, err := stores.ServiceAccounts.GetServiceAccounts(""); err != nil || len(accounts) != 1 || accounts[0].ClientId != globalId {
+		t.Errorf("GetServiceAccounts without an organization returned (%+v, %v), want global", accounts, err)
+	}
+
+	if err := stores.ServiceAccounts.DeleteServiceAccount(otherOrgId, billingId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteServiceAccount from another organization returned %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.ServiceAccounts.DeleteServiceAccount("", billingId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteServiceAccount without an organization returned %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.ServiceAccounts.DeleteServiceAccount(orgId, billingId); err != nil {
+		t.Fatalf("DeleteServiceAccount: %v", err)
+	}
+	if _, err := stores.ServiceAccounts.GetServiceAccount(billingId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetServiceAccount after DeleteServiceAccount returned %v, want sql.ErrNoRows", err)
+	}
+	if accounts, err := stores.ServiceAccounts.GetServiceAccountsByCertificate("CN=billing,O=Example"); err != nil || len(accounts) != 1 || accounts[0].ClientId != otherBillingId {
+		t.Errorf("GetServiceAccountsByCertificate after DeleteServiceAccount returned (%+v, %v), want the other billing account", accounts, err)
+	}
+}
+
+func testOAuthClients(t *testing.T, stores Stores) {
+	if _, err := stores.OAuthClients.GetOAuthClient(uuid.NewString()); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("GetOAuthClient of an unknown client returned %v, want sql.ErrNoRows", err)
+	}
+
+	createdAt := time.Now().UTC().Truncate(time.Second)
+	webId, err := stores.OAuthClients.CreateOAuthClient(models.OAuthClient{
+		SecretHash:   []byte("secret-hash"),
+		Name:         "web",
+		RedirectURIs: []string{"https://app.example.com/callback", "https://staging.example.com/callback"},
+		CreatedAt:    createdAt,
+	})
+	if err != nil {
+		t.Fatalf("CreateOAuthClient: %v", err)
+	}
+	mobileId, err := stores.OAuthClients.CreateOAuthClient(models.OAuthClient{
+		Name:         "mobile",
+		RedirectURIs: []string{"com.example.app:/callback"},
+		Public:       true,
+		CreatedAt:    createdAt.Add(time.Second),
+	})
+	if err != nil {
+		t.Fatalf("CreateOAuthClient of a public client: %v", err)
+	}
+
+	client, err := stores.OAuthClients.GetOAuthClient(webId)
+	if err != nil {
+		t.Fatalf("GetOAuthClient: %v", err)
+	}
+	if client.ClientId != webId || string(client.SecretHash) != "secret-hash" || client.Name != "web" || client.Public ||
+		fmt.Sprint(client.RedirectURIs) != fmt.Sprint([]string{"https://app.example.com/callback", "https://staging.example.com/callback"}) ||
+		!client.CreatedAt.Equal(createdAt) {
+		t.Errorf("GetOAuthClient returned %+v", client)
+	}
+	if client, err := stores.OAuthClients.GetOAuthClient(mobileId); err != nil || !client.Public || client.SecretHash != nil {
+		t.Errorf("GetOAuthClient of a public client returned (%+v, %v), want it public without a secret", client, err)
+	}
+
+	clients, err := stores.OAuthClients.GetOAuthClients()
+	if err != nil || len(clients) != 2 || clients[0].ClientId != webId || clients[1].ClientId != mobileId {
+		t.Errorf("GetOAuthClients returned (%+v, %v), want web, then mobile", clients, err)
+	}
+
+	if err := stores.OAuthClients.DeleteOAuthClient(webId); err != nil {
+		t.Fatalf("DeleteOAuthClient: %v", err)
+	}
+	if _, err := stores.OAuthClients.GetOAuthClient(webId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetOAuthClient after DeleteOAuthClient returned %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.OAuthClients.DeleteOAuthClient(webId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteOAuthClient twice returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testAuthorizationCodes(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	clientId, err := stores.OAuthClients.CreateOAuthClient(models.OAuthClient{
+		Name:         "mobile",
+		RedirectURIs: []string{"com.example.app:/callback"},
+		Public:       true,
+		CreatedAt:    time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("CreateOAuthClient: %v", err)
+	}
+
+	code := models.AuthorizationCode{
+		ClientId:      clientId,
+		UserId:        userId,
+		RedirectURI:   "com.example.app:/callback",
+		CodeChallenge: "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+		Scopes:        []string{"openid", "email"},
+		Nonce:         "n-0S6_WzA2Mj",
+		AuthTime:      time.Now().UTC().Truncate(time.Second),
+		ExpiresAt:     time.Now().UTC().Truncate(time.Second).Add(time.Minute),
+	}
+	if err := stores.AuthorizationCodes.CreateAuthorizationCode(code, []byte("code-hash")); err != nil {
+		t.Fatalf("CreateAuthorizationCode: %v", err)
+	}
+	expired := code
+	expired.ExpiresAt = time.Now().Add(-time.Minute)
+	if err := stores.AuthorizationCodes.CreateAuthorizationCode(expired, []byte("expired-hash")); err != nil {
+		t.Fatalf("CreateAuthorizationCode of an expired code: %v", err)
+	}
+
+	consumed, err := stores.AuthorizationCodes.ConsumeAuthorizationCode([]byte("code-hash"))
+	if err != nil {
+		t.Fatalf("ConsumeAuthorizationCode: %v", err)
+	}
+	if consumed.ClientId != clientId || consumed.UserId != userId || consumed.RedirectURI != code.RedirectURI ||
+		consumed.CodeChallenge != code.CodeChallenge || fmt.Sprint(consumed.Scopes) != fmt.Sprint(code.Scopes) ||
+		consumed.Nonce != code.Nonce || !consumed.AuthTime.Equal(code.AuthTime) || !consumed.ExpiresAt.Equal(code.ExpiresAt) {
+		t.Errorf("ConsumeAuthorizationCode returned %+v, want %+v", consumed, code)
+	}
+	if _, err := stores.AuthorizationCodes.ConsumeAuthorizationCode([]byte("code-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeAuthorizationCode twice returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.AuthorizationCodes.ConsumeAuthorizationCode([]byte("expired-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeAuthorizationCode of an expired code returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.AuthorizationCodes.ConsumeAuthorizationCode([]byte("unknown-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeAuthorizationCode of an unknown code returned %v, want sql.ErrNoRows", err)
+	}
+
+	// Deleting the client takes its codes with it
+	if err := stores.AuthorizationCodes.CreateAuthorizationCode(code, []byte("other-hash")); err != nil {
+		t.Fatalf("CreateAuthorizationCode: %v", err)
+	}
+	if err := stores.OAuthClients.DeleteOAuthClient(clientId); err != nil {
+		t.Fatalf("DeleteOAuthClient: %v", err)
+	}
+	if _, err := stores.AuthorizationCodes.ConsumeAuthorizationCode([]byte("other-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeAuthorizationCode after DeleteOAuthClient returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testDeviceCodes(t *testing.T, stores Stores) {
+	userId := createUser(t, stores, 1)
+	clientId, err := stores.OAuthClients.CreateOAuthClient(models.OAuthClient{
+		Name:      "cli",
+		Public:    true,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("CreateOAuthClient: %v", err)
+	}
+
+	device := models.DeviceCode{
+		ClientId:  clientId,
+		UserCode:  "BCDFGHJK",
+		Scopes:    []string{"openid", "profile"},
+		ExpiresAt: time.Now().UTC().Truncate(time.Second).Add(10 * time.Minute),
+	}
+	if err := stores.DeviceCodes.CreateDeviceCode(device, []byte("device-hash")); err != nil {
+		t.Fatalf("CreateDeviceCode: %v", err)
+	}
+	if err := stores.DeviceCodes.CreateDeviceCode(device, []byte("other-hash")); !errors.Is(err, repository.ErrDuplicateUserCode) {
+		t.Errorf("CreateDeviceCode with a taken user code returned %v, want ErrDuplicateUserCode", err)
+	}
+	expired := device
+	expired.UserCode, expired.ExpiresAt = "LMNPQRST", time.Now().Add(-time.Minute)
+	if err := stores.DeviceCodes.CreateDeviceCode(expired, []byte("expired-hash")); err != nil {
+		t.Fatalf("CreateDeviceCode of an expired request: %v", err)
+	}
+
+	pending, err := stores.DeviceCodes.GetDeviceCode("BCDFGHJK")
+	if err != nil {
+		t.Fatalf("GetDeviceCode: %v", err)
+	}
+	if pending.ClientId != clientId || pending.UserCode != device.UserCode || fmt.Sprint(pending.Scopes) != fmt.Sprint(device.Scopes) ||
+		pending.Status != models.DeviceCodePending || pending.UserId != "" || pending.LastPolledAt != nil || !pending.ExpiresAt.Equal(device.ExpiresAt) {
+		t.Errorf("GetDeviceCode returned %+v, want %+v pending", pending, device)
+	}
+	for _, userCode := range []string{"LMNPQRST", "VWXZBCDF"} {
+		if _, err := stores.DeviceCodes.GetDeviceCode(userCode); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetDeviceCode(%s) returned %v, want sql.ErrNoRows", userCode, err)
+		}
+	}
+
+	// Polls return the request as it was, and remember when they came
+	polledAt := time.Now().UTC().Truncate(time.Second)
+	polled, err := stores.DeviceCodes.PollDeviceCode([]byte("device-hash"), polledAt)
+	if err != nil || polled.Status != models.DeviceCodePending || polled.LastPolledAt != nil {
+		t.Errorf("first PollDeviceCode returned (%+v, %v), want it pending and never polled", polled, err)
+	}
+	polled, err = stores.DeviceCodes.PollDeviceCode([]byte("device-hash"), polledAt.Add(5*time.Second))
+	if err != nil || polled.LastPolledAt == nil || !polled.LastPolledAt.Equal(polledAt) {
+		t.Errorf("second PollDeviceCode returned (%+v, %v), want it polled at %v", polled, err, polledAt)
+	}
+	if polled, err := stores.DeviceCodes.PollDeviceCode([]byte("expired-hash"), polledAt); err != nil || polled.ExpiresAt.After(time.Now()) {
+		t.Errorf("PollDeviceCode of an expired request returned (%+v, %v), want it expired", polled, err)
+	}
+	if _, err := stores.DeviceCodes.PollDeviceCode([]byte("unknown-hash"), polledAt); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("PollDeviceCode of an unknown request returned %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.DeviceCodes.ConsumeDeviceCode([]byte("device-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeDeviceCode of a pending request returned %v, want sql.ErrNoRows", err)
+	}
+
+	authTime := time.Now().UTC().Truncate(time.Second)
+	if err := stores.DeviceCodes.ApproveDeviceCode("BCDFGHJK", userId, authTime); err != nil {
+		t.Fatalf("ApproveDeviceCode: %v", err)
+	}
+	if err := stores.DeviceCodes.ApproveDeviceCode("BCDFGHJK", userId, authTime); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ApproveDeviceCode twice returned %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.DeviceCodes.DenyDeviceCode("BCDFGHJK"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DenyDeviceCode of an approved request returned %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.DeviceCodes.ApproveDeviceCode("LMNPQRST", userId, authTime); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ApproveDeviceCode of an expired request returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.DeviceCodes.GetDeviceCode("BCDFGHJK"); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetDeviceCode of an approved request returned %v, want sql.ErrNoRows", err)
+	}
+
+	approved, err := stores.DeviceCodes.PollDeviceCode([]byte("device-hash"), time.Now())
+	if err != nil || approved.Status != models.DeviceCodeApproved || approved.UserId != userId || !approved.AuthTime.Equal(authTime) {
+		t.Errorf("PollDeviceCode of an approved request returned (%+v, %v), want it approved by %s", approved, err, userId)
+	}
+	if err := stores.DeviceCodes.ConsumeDeviceCode([]byte("device-hash")); err != nil {
+		t.Fatalf("ConsumeDeviceCode: %v", err)
+	}
+	if err := stores.DeviceCodes.ConsumeDeviceCode([]byte("device-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeDeviceCode twice returned %v, want sql.ErrNoRows", err)
+	}
+	if _, err := stores.DeviceCodes.PollDeviceCode([]byte("device-hash"), time.Now()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("PollDeviceCode after ConsumeDeviceCode returned %v, want sql.ErrNoRows", err)
+	}
+
+	// Denied requests stay around, so the device learns of it
+	denied := device
+	denied.UserCode = "ZXWVTSRQ"
+	if err := stores.DeviceCodes.CreateDeviceCode(denied, []byte("denied-hash")); err != nil {
+		t.Fatalf("CreateDeviceCode: %v", err)
+	}
+	if err := stores.DeviceCodes.DenyDeviceCode("ZXWVTSRQ"); err != nil {
+		t.Fatalf("DenyDeviceCode: %v", err)
+	}
+	if polled, err := stores.DeviceCodes.PollDeviceCode([]byte("denied-hash"), time.Now()); err != nil || polled.Status != models.DeviceCodeDenied {
+		t.Errorf("PollDeviceCode of a denied request returned (%+v, %v), want it denied", polled, err)
+	}
+	if err := stores.DeviceCodes.ConsumeDeviceCode([]byte("denied-hash")); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("ConsumeDeviceCode of a denied request returned %v, want sql.ErrNoRows", err)
+	}
+
+	// Deleting the client takes its requests with it
+	if err := stores.OAuthClients.DeleteOAuthClient(clientId); err != nil {
+		t.Fatalf("DeleteOAuthClient: %v", err)
+	}
+	if _, err := stores.DeviceCodes.PollDeviceCode([]byte("denied-hash"), time.Now()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("PollDeviceCode after DeleteOAuthClient returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testDPoPProofs(t *testing.T, stores Stores) {
+	expiresAt := time.Now().Add(5 * time.Minute)
+	if used, err := stores.DPoPProofs.UseDPoPProof("proof-1", expiresAt); err != nil || !used {
+		t.Fatalf("UseDPoPProof returned (%v, %v), want true", used, err)
+	}
+	if used, err := stores.DPoPProofs.UseDPoPProof("proof-1", expiresAt); err != nil || used {
+		t.Errorf("UseDPoPProof twice returned (%v, %v), want false", used, err)
+	}
+	if used, err := stores.DPoPProofs.UseDPoPProof("proof-2", expiresAt); err != nil || !used {
+		t.Errorf("UseDPoPProof of another proof returned (%v, %v), want true", used, err)
+	}
+
+	// Proofs are only remembered until they expire
+	if used, err := stores.DPoPProofs.UseDPoPProof("proof-3", time.Now().Add(-time.Minute)); err != nil || !used {
+		t.Fatalf("UseDPoPProof of an expired proof returned (%v, %v), want true", used, err)
+	}
+	if used, err := stores.DPoPProofs.UseDPoPProof("proof-3", expiresAt); err != nil || !used {
+		t.Errorf("UseDPoPProof after the proof expired returned (%v, %v), want true", used, err)
+	}
+}
+
+func testMemberships(t *testing.T, stores Stores) {
+	ownerId := createUser(t, stores, 1)
+	memberId := createUser(t, stores, 2)
+	orgId := createOrganization(t, stores, "Acme", ownerId)
+
+	org, err := stores.Organizations.GetOrganization(orgId)
+	if err != nil || org.Name != "Acme" || org.OrgId != orgId {
+		t.Fatalf("GetOrganization returned (%+v, %v)", org, err)
+	}
+	if _, err := stores.Organizations.GetOrganization(uuid.NewString()); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetOrganization of an unknown organization returned %v, want sql.ErrNoRows", err)
+	}
+
+	membership, err := stores.Organizations.GetMembership(orgId, ownerId)
+	if err != nil || membership.Role != repository.RoleOrgAdmin || membership.Name != "Acme" {
+		t.Fatalf("GetMembership of the owner returned (%+v, %v)", membership, err)
+	}
+	if _, err := stores.Organizations.GetMembership(orgId, memberId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetMembership of a non-member returned %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.Organizations.AddMember(orgId, memberId, repository.RoleUser); err != nil {
+		t.Fatalf("AddMember: %v", err)
+	}
+	if err := stores.Organizations.AddMember(orgId, memberId, repository.RoleOrgAdmin); err != nil {
+		t.Fatalf("AddMember of an existing member: %v", err)
+	}
+	if membership, err := stores.Organizations.GetMembership(orgId, memberId); err != nil || membership.Role != repository.RoleOrgAdmin {
+		t.Errorf("AddMember of an existing member should change the role, got (%+v, %v)", membership, err)
+	}
+	if err := stores.Organizations.AddMember(orgId, uuid.NewString(), repository.RoleUser); err == nil {
+		t.Errorf("AddMember of an unknown user succeeded")
+	}
+	if count, err := stores.Organizations.CountMembers(orgId, repository.RoleOrgAdmin); err != nil || count != 2 {
+		t.Errorf("CountMembers of ORG_ADMIN returned (%d, %v), want 2", count, err)
+	}
+	if count, err := stores.Organizations.CountMembers(orgId, repository.RoleUser); err != nil || count != 0 {
+		t.Errorf("CountMembers of USER returned (%d, %v), want 0", count, err)
+	}
+
+	otherOrgId := createOrganization(t, stores, "Globex", memberId)
+	memberships, err := stores.Organizations.GetUserMemberships(memberId)
+	if err != nil || len(memberships) != 2 {
+		t.Fatalf("GetUserMemberships returned (%+v, %v), want two memberships", memberships, err)
+	}
+	orgIds := map[string]bool{memberships[0].OrgId: true, memberships[1].OrgId: true}
+	if !orgIds[orgId] || !orgIds[otherOrgId] {
+		t.Errorf("GetUserMemberships returned %+v, want Acme and Globex", memberships)
+	}
+
+	if err := stores.Organizations.RemoveMember(orgId, memberId); err != nil {
+		t.Fatalf("RemoveMember: %v", err)
+	}
+	if err := stores.Organizations.RemoveMember(orgId, memberId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("RemoveMember of a non-member returned %v, want sql.ErrNoRows", err)
+	}
+	if memberships, err := stores.Organizations.GetUserMemberships(memberId); err != nil || len(memberships) != 1 {
+		t.Errorf("GetUserMemberships after RemoveMember returned (%+v, %v)", memberships, err)
+	}
+}
+
+func testInvitations(t *testing.T, stores Stores) {
+	ownerId := createUser(t, stores, 1)
+	inviteeId := createUser(t, stores, 2)
+	acmeId := createOrganization(t, stores, "Acme", ownerId)
+	globexId := createOrganization(t, stores, "Globex", ownerId)
+	expiresAt := time.Now().Add(time.Hour)
+
+	for _, orgId := range []string{acmeId, globexId} {
+		err := stores.Organizations.InviteMember(models.Invitation{OrgId: orgId, UserId: inviteeId, Role: repository.RoleUser, ExpiresAt: expiresAt})
+		if err != nil {
+			t.Fatalf("InviteMember: %v", err)
+		}
+	}
+	// Inviting again replaces the role
+	err := stores.Organizations.InviteMember(models.Invitation{OrgId: acmeId, UserId: inviteeId, Role: repository.RoleOrgAdmin, ExpiresAt: expiresAt})
+	if err != nil {
+		t.Fatalf("InviteMember again: %v", err)
+	}
+
+	invitations, err := stores.Organizations.GetUserInvitations(inviteeId)
+	if err != nil || len(invitations) != 2 {
+		t.Fatalf("GetUserInvitations returned (%+v, %v), want two invitations", invitations, err)
+	}
+	for _, invitation := range invitations {
+		if invitation.OrgId == acmeId && (invitation.Name != "Acme" || invitation.Role != repository.RoleOrgAdmin) {
+			t.Errorf("GetUserInvitations returned %+v for Acme, want the ORG_ADMIN role", invitation)
+		}
+	}
+	// An invitation is no membership yet
+	if _, err := stores.Organizations.GetMembership(acmeId, inviteeId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetMembership of an invitee returned %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.Organizations.AcceptInvitation(acmeId, inviteeId); err != nil {
+		t.Fatalf("AcceptInvitation: %v", err)
+	}
+	if membership, err := stores.Organizations.GetMembership(acmeId, inviteeId); err != nil || membership.Role != repository.RoleOrgAdmin {
+		t.Errorf("GetMembership after AcceptInvitation returned (%+v, %v)", membership, err)
+	}
+	if err := stores.Organizations.AcceptInvitation(acmeId, inviteeId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("AcceptInvitation twice returned %v, want sql.ErrNoRows", err)
+	}
+
+	if err := stores.Organizations.DeleteInvitation(globexId, inviteeId); err != nil {
+		t.Fatalf("DeleteInvitation: %v", err)
+	}
+	if err := stores.Organizations.DeleteInvitation(globexId, inviteeId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("DeleteInvitation twice returned %v, want sql.ErrNoRows", err)
+	}
+	if err := stores.Organizations.AcceptInvitation(globexId, inviteeId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("AcceptInvitation of a declined invitation returned %v, want sql.ErrNoRows", err)
+	}
+
+	err = stores.Organizations.InviteMember(models.Invitation{OrgId: globexId, UserId: inviteeId, Role: repository.RoleUser, ExpiresAt: time.Now().Add(-time.Minute)})
+	if err != nil {
+		t.Fatalf("InviteMember: %v", err)
+	}
+	if invitations, err := stores.Organizations.GetUserInvitations(inviteeId); err != nil || len(invitations) != 0 {
+		t.Errorf("GetUserInvitations returned (%+v, %v), want no expired invitations", invitations, err)
+	}
+	if err := stores.Organizations.AcceptInvitation(globexId, inviteeId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("AcceptInvitation of an expired invitation returned %v, want sql.ErrNoRows", err)
+	}
+}
+
+func testTenantIsolation(t *testing.T, stores Stores) {
+	aliceId := createUser(t, stores, 1)
+	bobId := createUser(t, stores, 2)
+	loneId := createUser(t, stores, 3)
+	acmeId := createOrganization(t, stores, "Acme", aliceId)
+	createOrganization(t, stores, "Globex", bobId)
+
+	users, err := stores.Users.InTenant(acmeId).GetUsers(10, 0)
+	if err != nil {
+		t.Fatalf("GetUsers: %v", err)
+	}
+	if len(users) != 1 || users[0].UserId != aliceId {
+		t.Errorf("GetUsers in Acme returned %d users, want only Alice", len(users))
+	}
+
+	if _, err := stores.Users.InTenant(acmeId).GetUser(bobId); !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("GetUser of another tenant's user returned %v, want sql.ErrNoRows", err)
+	}
+	if user, err := stores.Users.InTenant(acmeId).GetUser(aliceId); err != nil || user.UserId != aliceId {
+		t.Errorf("GetUser of a member returned (%+v, %v)", user, err)
+	}
+
+	// Without a tenant nothing is visible
+	for _, orgId := range []string{"", uuid.NewString()} {
+		users, err := stores.Users.InTenant(orgId).GetUsers(10, 0)
+		if err != nil || len(users) != 0 {
+			t.Errorf("GetUsers in tenant %q returned (%d users, %v), want none", orgId, len(users), err)
+		}
+		if _, err := stores.Users.InTenant(orgId).GetUser(loneId); !errors.Is(err, sql.ErrNoRows) {
+			t.Errorf("GetUser in tenant %q returned %v, want sql.ErrNoRows", orgId, err)
+		}
+	}
+}
+
+func testPagination(t *testing.T, stores Stores) {
+	ownerId := createUser(t, stores, 0)
+	orgId := createOrganization(t, stores, "Acme", ownerId)
+
+	want := map[string]bool{ownerId: true}
+	for n := 1; n < 7; n++ {
+		userId := createUser(t, stores, n)
+		if err := stores.Organizations.AddMember(orgId, userId, repository.RoleUser); err != nil {
+			t.Fatalf("AddMember: %v", err)
+		}
+		want[userId] = true
+	}
+	createUser(t, stores, 100)
+
+	seen := map[string]bool{}
+	for offset := 0; offset < 9; offset += 3 {
+		page, err := stores.Users.InTenant(orgId).GetUsers(3, offset)
+		if err != nil {
+			t.Fatalf("GetUsers(3, %d): %v", offset, err)
+		}
+
+		wantLen := 3
+		if offset == 6 {
+			wantLen = 1
+		}
+		if len(page) != wantLen {
+			t.Fatalf("GetUsers(3, %d) returned %d users, want %d", offset, len(page), wantLen)
+		}
+
+		for _, user := range page {
+			if seen[user.UserId] {
+				t.Errorf("user %s is on more than one page", user.UserId)
+			}
+			if !want[user.UserId] {
+				t.Errorf("user %s is not a member", user.UserId)
+			}
+			seen[user.UserId] = true
+		}
+	}
+
+	if len(seen) != len(want) {
+		t.Errorf("the pages hold %d users, want %d", len(seen), len(want))
+	}
+}
@@ -0,0 +1,178 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RevocationStore records access and refresh tokens that must no longer be
+// accepted even though their signature and expiry are still valid.
+type RevocationStore interface {
+	// RevokeToken revokes a single token by its jti. The entry can be
+	// forgotten once the token has expired on its own.
+	RevokeToken(jti string, expiresAt time.Time) error
+	// RevokeUserTokens revokes every token issued to the user before
+	// issuedBefore, to the microsecond. Tokens issued right after, like the
+	// login that follows a password reset, are still accepted.
+	RevokeUserTokens(userId string, issuedBefore time.Time) error
+	IsRevoked(jti string, userId string, issuedAt time.Time) (bool, error)
+	// RevokeMemberTokens is RevokeUserTokens for the tokens acting in one
+	// organization, for when the user's membership changes. Their tokens for
+	// other organizations stay good.
+	RevokeMemberTokens(orgId string, userId string, issuedBefore time.Time) error
+	IsMemberRevoked(orgId string, userId string, issuedAt time.Time) (bool, error)
+}
+
+type RevocationRepository struct {
+	DB *DB
+}
+
+func NewRevocationRepository(db *DB) RevocationStore {
+	return &RevocationRepository{
+		DB: db,
+	}
+}
+
+func (r *RevocationRepository) RevokeToken(jti string, expiresAt time.Time) error {
+	query := r.DB.Dialect.insertIgnore(`INSERT INTO revoked_tokens (jti, expires_at) VALUES (?, ?)`)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, query, jti, expiresAt.UTC())
+	if err != nil {
+		log.Printf("Error %s when revoking token", err)
+		return err
+	}
+
+	// Expired tokens are rejected anyway, so there is no point keeping them
+	_, err = r.DB.ExecContext(ctx, `DELETE FROM revoked_tokens WHERE expires_at < ?`, time.Now().UTC())
+	if err != nil {
+		log.Printf("Error %s when pruning revoked tokens", err)
+	}
+	return nil
+}
+
+func (r *RevocationRepository) RevokeUserTokens(userId string, issuedBefore time.Time) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	query := `INSERT INTO user_token_revocations (user_id, revoked_before) VALUES (?, ?)`
+	switch r.DB.Dialect {
+	case MySQL:
+		query += ` ON DUPLICATE KEY UPDATE revoked_before = GREATEST(revoked_before, VALUES(revoked_before))`
+	case Postgres:
+		query += ` ON CONFLICT (user_id) DO UPDATE SET revoked_before = GREATEST(user_token_revocations.revoked_before, excluded.revoked_before)`
+	case SQLite:
+		query += ` ON CONFLICT (user_id) DO UPDATE SET revoked_before = MAX(revoked_before, excluded.revoked_before)`
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = r.DB.ExecContext(ctx, query, idBytes[:], issuedBefore.UTC().Truncate(time.Microsecond))
+	if err != nil {
+		log.Printf("Error %s when revoking user tokens", err)
+		return err
+	}
+	return nil
+}
+
+func (r *RevocationRepository) IsRevoked(jti string, userId string, issuedAt time.Time) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if jti != "" {
+		var count int
+		err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM revoked_tokens WHERE jti = ?`, jti).Scan(&count)
+		if err != nil {
+			log.Printf("Error %s when checking revoked token", err)
+			return false, err
+		}
+		if count > 0 {
+			return true, nil
+		}
+	}
+
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		// Not a user token, so there is no per-user cutoff to check
+		return false, nil
+	}
+
+	var revokedBefore time.Time
+	err = r.DB.QueryRowContext(ctx, `SELECT revoked_before FROM user_token_revocations WHERE user_id = ?`, idBytes[:]).Scan(&revokedBefore)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("Error %s when checking user token revocation", err)
+		return false, err
+	}
+
+	return issuedAt.Before(revokedBefore), nil
+}
+
+func (r *RevocationRepository) RevokeMemberTokens(orgId string, userId string, issuedBefore time.Time) error {
+	orgBytes, err := uuid.Parse(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return err
+	}
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	query := `INSERT INTO member_token_revocations (org_id, user_id, revoked_before) VALUES (?, ?, ?)`
+	switch r.DB.Dialect {
+	case MySQL:
+		query += ` ON DUPLICATE KEY UPDATE revoked_before = GREATEST(revoked_before, VALUES(revoked_before))`
+	case Postgres:
+		query += ` ON CONFLICT (org_id, user_id) DO UPDATE SET revoked_before = GREATEST(member_token_revocations.revoked_before, excluded.revoked_before)`
+	case SQLite:
+		query += ` ON CONFLICT (org_id, user_id) DO UPDATE SET revoked_before = MAX(revoked_before, excluded.revoked_before)`
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = r.DB.ExecContext(ctx, query, orgBytes[:], userBytes[:], issuedBefore.UTC().Truncate(time.Microsecond))
+	if err != nil {
+		log.Printf("Error %s when revoking member tokens", err)
+		return err
+	}
+	return nil
+}
+
+func (r *RevocationRepository) IsMemberRevoked(orgId string, userId string, issuedAt time.Time) (bool, error) {
+	orgBytes, err := uuid.Parse(orgId)
+	if err != nil {
+		return false, nil
+	}
+	userBytes, err := uuid.Parse(userId)
+	if err != nil {
+		// Client IDs are never members
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var revokedBefore time.Time
+	err = r.DB.QueryRowContext(ctx, `SELECT revoked_before FROM member_token_revocations WHERE org_id = ? AND user_id = ?`, orgBytes[:], userBytes[:]).Scan(&revokedBefore)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		log.Printf("Error %s when checking member token revocation", err)
+		return false, err
+	}
+
+	return issuedAt.Before(revokedBefore), nil
+}
@@ -0,0 +1,379 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+// Permissions known out of the box. More can be created at runtime.
+const (
+	PermissionUsersRead         = "users:read"
+	PermissionUsersWrite        = "users:write"
+	PermissionRolesRead         = "roles:read"
+	PermissionRolesWrite        = "roles:write"
+	PermissionClientsRead       = "clients:read"
+	PermissionClientsWrite      = "clients:write"
+	PermissionOAuthClientsRead  = "oauth_clients:read"
+	PermissionOAuthClientsWrite = "oauth_clients:write"
+	PermissionTokensIntrospect  = "tokens:introspect"
+)
+
+// Every user implicitly holds USER, on top of the roles assigned explicitly.
+// ADMIN is only ever assigned, by another admin or with the admins command.
+// ORG_ADMIN is what the creator of an organization holds inside it.
+const (
+	RoleAdmin    = "ADMIN"
+	RoleUser     = "USER"
+	RoleOrgAdmin = "ORG_ADMIN"
+)
+
+var ErrBuiltInRole = errors.New("built-in roles can't be deleted")
+
+type RoleStore interface {
+	CreateRole(role models.Role) error
+	GetRole(name string) (models.Role, error)
+	GetRoles() ([]models.Role, error)
+	DeleteRole(name string) error
+	SetRolePermissions(name string, permissions []string) error
+	CreatePermission(permission models.Permission) error
+	GetPermissions() ([]models.Permission, error)
+	AssignRole(userId string, role string) error
+	UnassignRole(userId string, role string) error
+	GetUserRoles(userId string) ([]string, error)
+	GetUserPermissions(userId string, implicitRoles []string) ([]string, error)
+}
+
+type RoleRepository struct {
+	DB *DB
+}
+
+func NewRoleRepository(db *DB) RoleStore {
+	return &RoleRepository{
+		DB: db,
+	}
+}
+
+func (r *RoleRepository) CreateRole(role models.Role) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO roles (name, description) VALUES (?, ?)`, role.Name, role.Description)
+	if err != nil {
+		log.Printf("Error %s when inserting role", err)
+		return err
+	}
+
+	if err := insertRolePermissions(ctx, tx, role.Name, role.Permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func insertRolePermissions(ctx context.Context, tx *Tx, role string, permissions []string) error {
+	for _, permission := range permissions {
+		_, err := tx.ExecContext(ctx, `INSERT INTO role_permissions (role, permission) VALUES (?, ?)`, role, permission)
+		if err != nil {
+			log.Printf("Error %s when granting permission %s", err, permission)
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *RoleRepository) GetRole(name string) (models.Role, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	role := models.Role{Permissions: []string{}}
+	err := r.DB.QueryRowContext(ctx, `SELECT name, description FROM roles WHERE name = ?`, name).Scan(&role.Name, &role.Description)
+	if err != nil {
+		log.Printf("Error %s when getting role", err)
+		return models.Role{}, err
+	}
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT permission FROM role_permissions WHERE role = ? ORDER BY permission`, name)
+	if err != nil {
+		log.Printf("Error %s when getting role permissions", err)
+		return models.Role{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var permission string
+		if err := rows.Scan(&permission); err != nil {
+			log.Printf("Error %s when scanning permission", err)
+			return models.Role{}, err
+		}
+		role.Permissions = append(role.Permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error %s when closing rows", err)
+		return models.Role{}, err
+	}
+	return role, nil
+}
+
+func (r *RoleRepository) GetRoles() ([]models.Role, error) {
+	query := `SELECT r.name, r.description, rp.permission FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role = r.name
+		ORDER BY r.name, rp.permission`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query)
+	if err != nil {
+		log.Printf("Error %s when getting roles", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []models.Role{}
+	for rows.Next() {
+		var name, description string
+		var permission sql.NullString
+		if err := rows.Scan(&name, &description, &permission); err != nil {
+			log.Printf("Error %s when scanning role", err)
+			return nil, err
+		}
+
+		if len(roles) == 0 || roles[len(roles)-1].Name != name {
+			roles = append(roles, models.Role{Name: name, Description: description, Permissions: []string{}})
+		}
+		if permission.Valid {
+			last := &roles[len(roles)-1]
+			last.Permissions = append(last.Permissions, permission.String)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error %s when closing rows", err)
+		return nil, err
+	}
+	return roles, nil
+}
+
+func (r *RoleRepository) DeleteRole(name string) error {
+	if name == RoleAdmin || name == RoleUser || name == RoleOrgAdmin {
+		return ErrBuiltInRole
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM roles WHERE name = ?`, name)
+	if err != nil {
+		log.Printf("Error %s when deleting role", err)
+		return err
+	}
+	return expectRow(res)
+}
+
+// SetRolePermissions replaces every permission granted by the role.
+func (r *RoleRepository) SetRolePermissions(name string, permissions []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Printf("Error %s when starting transaction", err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	if err := tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM roles WHERE name = ?`, name).Scan(&exists); err != nil {
+		log.Printf("Error %s when getting role", err)
+		return err
+	}
+	if exists == 0 {
+		return sql.ErrNoRows
+	}
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM role_permissions WHERE role = ?`, name); err != nil {
+		log.Printf("Error %s when clearing role permissions", err)
+		return err
+	}
+	if err := insertRolePermissions(ctx, tx, name, permissions); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *RoleRepository) CreatePermission(permission models.Permission) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err := r.DB.ExecContext(ctx, `INSERT INTO permissions (name, description) VALUES (?, ?)`, permission.Name, permission.Description)
+	if err != nil {
+		log.Printf("Error %s when inserting permission", err)
+		return err
+	}
+	return nil
+}
+
+func (r *RoleRepository) GetPermissions() ([]models.Permission, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT name, description FROM permissions ORDER BY name`)
+	if err != nil {
+		log.Printf("Error %s when getting permissions", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	permissions := []models.Permission{}
+	for rows.Next() {
+		var permission models.Permission
+		if err := rows.Scan(&permission.Name, &permission.Description); err != nil {
+			log.Printf("Error %s when scanning permission", err)
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error %s when closing rows", err)
+		return nil, err
+	}
+	return permissions, nil
+}
+
+func (r *RoleRepository) AssignRole(userId string, role string) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	_, err = r.DB.ExecContext(ctx, r.DB.Dialect.insertIgnore(`INSERT INTO user_roles (user_id, role) VALUES (?, ?)`), idBytes[:], role)
+	if err != nil {
+		log.Printf("Error %s when assigning role", err)
+		return err
+	}
+	return nil
+}
+
+func (r *RoleRepository) UnassignRole(userId string, role string) error {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, `DELETE FROM user_roles WHERE user_id = ? AND role = ?`, idBytes[:], role)
+	if err != nil {
+		log.Printf("Error %s when unassigning role", err)
+		return err
+	}
+	return expectRow(res)
+}
+
+func (r *RoleRepository) GetUserRoles(userId string) ([]string, error) {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return nil, err
+	}
+
+	return r.queryStrings(`SELECT role FROM user_roles WHERE user_id = ? ORDER BY role`, idBytes[:])
+}
+
+// GetUserPermissions returns the permissions granted by the user's explicit
+// roles together with implicitRoles, such as USER and their role in the
+// current organization.
+func (r *RoleRepository) GetUserPermissions(userId string, implicitRoles []string) ([]string, error) {
+	idBytes, err := uuid.Parse(userId)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return nil, err
+	}
+
+	args := []any{idBytes[:]}
+	condition := `role IN (SELECT role FROM user_roles WHERE user_id = ?)`
+	if len(implicitRoles) > 0 {
+		condition += ` OR role IN (?` + strings.Repeat(`, ?`, len(implicitRoles)-1) + `)`
+		for _, role := range implicitRoles {
+			args = append(args, role)
+		}
+	}
+
+	query := `SELECT DISTINCT permission FROM role_permissions WHERE ` + condition + ` ORDER BY permission`
+	return r.queryStrings(query, args...)
+}
+
+func (r *RoleRepository) queryStrings(query string, args ...any) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error %s when querying", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			log.Printf("Error %s when scanning", err)
+			return nil, err
+		}
+		values = append(values, value)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error %s when closing rows", err)
+		return nil, err
+	}
+	return values, nil
+}
+
+// expectRow turns a statement that touched nothing into sql.ErrNoRows.
+func expectRow(res sql.Result) error {
+	rows, err := res.RowsAffected()
+	if err != nil {
+		log.Printf("Error %s when getting rows affected", err)
+		return err
+	}
+	if rows == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// nullableID turns an optional ID into its bytes, or NULL when it is empty.
+// A nil []byte isn't NULL to every driver.
+func nullableID(id string) (interface{}, error) {
+	if id == "" {
+		return nil, nil
+	}
+	idBytes, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	return idBytes[:], nil
+}
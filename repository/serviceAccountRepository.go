@@ -0,0 +1,201 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+// ErrDuplicateCertificateIdentity is returned by CreateServiceAccount when
+// another account of the organization has the certificate identity.
+var ErrDuplicateCertificateIdentity = errors.New("a service account of the organization with this certificate identity already exists")
+
+// ServiceAccountStore keeps the service accounts that get tokens with the
+// client credentials grant. Accounts belong to an organization, or to none
+// when orgId is empty.
+type ServiceAccountStore interface {
+	// CreateServiceAccount stores account, including its SecretHash, and
+	// returns the ClientId it was given, or ErrDuplicateCertificateIdentity.
+	CreateServiceAccount(account models.ServiceAccount) (string, error)
+	// GetServiceAccount returns the account with its SecretHash, or
+	// sql.ErrNoRows.
+	GetServiceAccount(clientId string) (models.ServiceAccount, error)
+	// GetServiceAccountsByCertificate lists the accounts with the
+	// certificate identity, oldest first. Identities are only unique within
+	// an organization, so accounts of different organizations may share one.
+	GetServiceAccountsByCertificate(identity string) ([]models.ServiceAccount, error)
+	// GetServiceAccounts lists the organization's accounts, oldest first.
+	GetServiceAccounts(orgId string) ([]models.ServiceAccount, error)
+	// DeleteServiceAccount removes one of the organization's accounts.
+	// Accounts of other organizations give sql.ErrNoRows.
+	DeleteServiceAccount(orgId string, clientId string) error
+}
+
+type ServiceAccountRepository struct {
+	DB *DB
+}
+
+func NewServiceAccountRepository(db *DB) ServiceAccountStore {
+	return &ServiceAccountRepository{
+		DB: db,
+	}
+}
+
+func (r *ServiceAccountRepository) CreateServiceAccount(account models.ServiceAccount) (string, error) {
+	orgBytes, err := nullableID(account.OrgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return "", err
+	}
+	clientId := uuid.New()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Accounts without one are NULL, which the unique index on the
+	// organization and identity lets repeat
+	var identity interface{}
+	if account.CertificateIdentity != "" {
+		identity = account.CertificateIdentity
+	}
+
+	_, err = r.DB.ExecContext(ctx, `INSERT INTO service_accounts (client_id, secret_hash, org_id, name, scopes, audience, certificate_identity, created_on) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		clientId[:], account.SecretHash, orgBytes, account.Name, strings.Join(account.Scopes, " "), account.Audience, identity, account.CreatedAt.UTC())
+	if err != nil {
+		log.Printf("Error %s when inserting service account", err)
+		if r.DB.Dialect.isDuplicate(err) {
+			return "", ErrDuplicateCertificateIdentity
+		}
+		return "", err
+	}
+	return clientId.String(), nil
+}
+
+const serviceAccountQuery = `SELECT client_id, secret_hash, org_id, name, scopes, audience, certificate_identity, created_on FROM service_accounts`
+
+func scanServiceAccount(row rowScanner) (models.ServiceAccount, error) {
+	var account models.ServiceAccount
+	var rawClientID, rawOrgID []byte
+	var scopes string
+	var identity sql.NullString
+	if err := row.Scan(&rawClientID, &account.SecretHash, &rawOrgID, &account.Name, &scopes, &account.Audience, &identity, &account.CreatedAt); err != nil {
+		return models.ServiceAccount{}, err
+	}
+
+	clientID, err := uuid.FromBytes(rawClientID)
+	if err != nil {
+		return models.ServiceAccount{}, err
+	}
+	if rawOrgID != nil {
+		orgID, err := uuid.FromBytes(rawOrgID)
+		if err != nil {
+			return models.ServiceAccount{}, err
+		}
+		account.OrgId = orgID.String()
+	}
+
+	account.ClientId, account.Scopes, account.CertificateIdentity = clientID.String(), strings.Fields(scopes), identity.String
+	return account, nil
+}
+
+func (r *ServiceAccountRepository) GetServiceAccount(clientId string) (models.ServiceAccount, error) {
+	idBytes, err := uuid.Parse(clientId)
+	if err != nil {
+		log.Printf("Error %s when parsing client_id", err)
+		return models.ServiceAccount{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	account, err := scanServiceAccount(r.DB.QueryRowContext(ctx, serviceAccountQuery+` WHERE client_id = ?`, idBytes[:]))
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Error %s when getting service account", err)
+		}
+		return models.ServiceAccount{}, err
+	}
+	return account, nil
+}
+
+func (r *ServiceAccountRepository) GetServiceAccountsByCertificate(identity string) ([]models.ServiceAccount, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, serviceAccountQuery+` WHERE certificate_identity = ? ORDER BY created_on`, identity)
+	if err != nil {
+		log.Printf("Error %s when getting service accounts by certificate", err)
+		return nil, err
+	}
+	defer rows.Close()
+	return scanServiceAccounts(rows)
+}
+
+func scanServiceAccounts(rows *sql.Rows) ([]models.ServiceAccount, error) {
+	accounts := []models.ServiceAccount{}
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			log.Printf("Error %s when scanning service account", err)
+			return nil, err
+		}
+		accounts = append(accounts, account)
+	}
+	return accounts, rows.Err()
+}
+
+func (r *ServiceAccountRepository) GetServiceAccounts(orgId string) ([]models.ServiceAccount, error) {
+	orgBytes, err := nullableID(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query, args := serviceAccountQuery+` WHERE org_id IS NULL ORDER BY created_on`, []any{}
+	if orgBytes != nil {
+		query, args = serviceAccountQuery+` WHERE org_id = ? ORDER BY created_on`, []any{orgBytes}
+	}
+	rows, err := r.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error %s when getting service accounts", err)
+		return nil, err
+	}
+	defer rows.Close()
+	return scanServiceAccounts(rows)
+}
+
+func (r *ServiceAccountRepository) DeleteServiceAccount(orgId string, clientId string) error {
+	orgBytes, err := nullableID(orgId)
+	if err != nil {
+		log.Printf("Error %s when parsing org_id", err)
+		return err
+	}
+	idBytes, err := uuid.Parse(clientId)
+	if err != nil {
+		log.Printf("Error %s when parsing client_id", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	query, args := `DELETE FROM service_accounts WHERE client_id = ? AND org_id IS NULL`, []any{idBytes[:]}
+	if orgBytes != nil {
+		query, args = `DELETE FROM service_accounts WHERE client_id = ? AND org_id = ?`, []any{idBytes[:], orgBytes}
+	}
+	res, err := r.DB.ExecContext(ctx, query, args...)
+	if err != nil {
+		log.Printf("Error %s when deleting service account", err)
+		return err
+	}
+	return expectRow(res)
+}
@@ -0,0 +1,95 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/models"
+	"github.com/google/uuid"
+)
+
+// TenantUserRepository only sees the users that belong to one organization.
+// Listing users is only possible through it, so a listing can't leak users
+// of another tenant.
+type TenantUserRepository interface {
+	GetUser(userid string) (models.User, error)
+	GetUsers(limit, offset int) ([]models.User, error)
+}
+
+type tenantRepository struct {
+	DB    *DB
+	orgId uuid.UUID
+	err   error
+}
+
+func (r *Repository) InTenant(orgId string) TenantUserRepository {
+	id, err := uuid.Parse(orgId)
+	return &tenantRepository{DB: r.DB, orgId: id, err: err}
+}
+
+const tenantScope = ` user_id IN (SELECT user_id FROM organization_members WHERE org_id = ?)`
+
+func (t *tenantRepository) GetUser(userid string) (models.User, error) {
+	// Callers without a tenant see nobody
+	if t.err != nil {
+		return models.User{}, sql.ErrNoRows
+	}
+
+	idBytes, err := uuid.Parse(userid)
+	if err != nil {
+		log.Printf("Error %s when parsing user_id", err)
+		return models.User{}, err
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE user_id = ? AND` + tenantScope
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	user, err := scanUser(t.DB.QueryRowContext(ctx, query, idBytes[:], t.orgId[:]))
+	if err != nil {
+		log.Printf("Error %s when querying user by ID", err)
+		return models.User{}, err
+	}
+
+	return user, nil
+}
+
+func (t *tenantRepository) GetUsers(limit, offset int) ([]models.User, error) {
+	users := []models.User{}
+	if t.err != nil {
+		return users, nil
+	}
+
+	query := `SELECT ` + userColumns + ` FROM users WHERE` + tenantScope + ` ORDER BY created_on, user_id LIMIT ? OFFSET ?`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	rows, err := t.DB.QueryContext(ctx, query, t.orgId[:], limit, offset)
+	if err != nil {
+		log.Printf("Error %s when getting users", err)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			log.Printf("Error %s when scanning user", err)
+			return nil, err
+		}
+
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		log.Printf("Error %s when closing rows", err)
+		return nil, err
+	}
+
+	return users, nil
+}
@@ -3,6 +3,7 @@ package repository
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"log"
 	"time"
 
@@ -10,59 +11,54 @@ import (
 	"github.com/google/uuid"
 )
 
+// ErrDuplicateEmail is returned by CreateUser when the email is taken.
+var ErrDuplicateEmail = errors.New("a user with this email already exists")
+
 type UserRepository interface {
 	GetUser(userid string) (models.User, error)
-	GetUsers(limit, offset int) ([]models.User, error)
-	CreateTable() error
+	// InTenant narrows lookups to the members of one organization
+	InTenant(orgId string) TenantUserRepository
 	CreateUser(user models.User) (string, error)
-	UpdateUserToken(token string, refreshToken string, userId string) error
+	UpdatePassword(userId string, passwordHash string) error
+	// MarkEmailVerified records that the user confirmed their email address.
+	// Verifying again keeps the original time.
+	MarkEmailVerified(userId string) error
 	GetUserByEmail(email string) (models.User, error)
 }
 
-type Repository struct {
-	DB *sql.DB
-}
+// userColumns leave out the token columns. Tokens are never read back from
+// users, so lookups can't hand them out.
+const userColumns = `user_id, first_name, last_name, user_type, email, phone, password, email_verified_at`
 
-func NewRepository(db *sql.DB) UserRepository {
-	return &Repository{
-		DB: db,
-	}
+type rowScanner interface {
+	Scan(dest ...any) error
 }
 
-func (r *Repository) CreateTable() error {
-	query := `
-		CREATE TABLE IF NOT EXISTS users (
-			user_id binary(16) NOT NULL DEFAULT (UUID_TO_BIN(UUID())),
-			first_name varchar(32) NOT NULL,
-			last_name varchar(32) DEFAULT NULL,
-			user_type enum('ADMIN','USER') NOT NULL DEFAULT 'USER',
-			email varchar(64) NOT NULL,
-			phone varchar(10) NOT NULL,
-			password VARCHAR(100) DEFAULT NULL,
-			token VARCHAR(500) DEFAULT NULL,
-			refresh_token VARCHAR(500) DEFAULT NULL,
-			created_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_on datetime NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			PRIMARY KEY (user_id),
-			UNIQUE KEY username (email)
-		);
-	`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	res, err := r.DB.ExecContext(ctx, query)
+func scanUser(row rowScanner) (models.User, error) {
+	var user models.User
+	var rawUserID []byte
+	err := row.Scan(&rawUserID, &user.FirstName, &user.LastName, &user.UserType, &user.Email, &user.Phone, &user.Password, &user.EmailVerifiedAt)
 	if err != nil {
-		log.Printf("Error %s when creating product table", err)
-		return err
+		return models.User{}, err
 	}
-	rows, err := res.RowsAffected()
+
+	userID, err := uuid.FromBytes(rawUserID)
 	if err != nil {
-		log.Printf("Error %s when getting rows affected", err)
-		return err
+		return models.User{}, err
+	}
+	user.UserId = userID.String()
+
+	return user, nil
+}
+
+type Repository struct {
+	DB *DB
+}
+
+func NewRepository(db *DB) UserRepository {
+	return &Repository{
+		DB: db,
 	}
-	log.Printf("Rows affected when creating table: %d", rows)
-	return nil
 }
 
 func (r *Repository) GetUser(userid string) (models.User, error) {
@@ -72,15 +68,12 @@ func (r *Repository) GetUser(userid string) (models.User, error) {
 		return models.User{}, err
 	}
 
-	query := `SELECT user_id, username, first_name, last_name, user_type, email, phone, password, created_on, updated_on FROM users WHERE user_id = ?`
+	query := `SELECT ` + userColumns + ` FROM users WHERE user_id = ?`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var user models.User
-	err = r.DB.QueryRowContext(ctx, query, idBytes[:]).Scan(
-		&user.FirstName, &user.LastName, &user.UserType, &user.Email, &user.Phone,
-	)
+	user, err := scanUser(r.DB.QueryRowContext(ctx, query, idBytes[:]))
 	if err != nil {
 		log.Printf("Error %s when querying user by ID", err)
 		return models.User{}, err
@@ -89,100 +82,94 @@ func (r *Repository) GetUser(userid string) (models.User, error) {
 	return user, nil
 }
 
-func (r *Repository) GetUsers(limit, offset int) ([]models.User, error) {
-	query := `SELECT user_id, username, first_name, last_name, user_type, email, phone, password, created_on, updated_on FROM users;`
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	rows, err := r.DB.QueryContext(ctx, query, limit, offset)
-	if err != nil {
-		log.Printf("Error %s when getting users", err)
-		return nil, err
-	}
-
-	defer rows.Close()
-
-	var users []models.User
-	for rows.Next() {
-		var user models.User
-		if err := rows.Scan(&user.UserId, &user.FirstName, &user.LastName, &user.UserType, &user.Email, &user.Phone, &user.Password); err != nil {
-			log.Printf("Error %s when scanning user", err)
-			return nil, err
-		}
-
-		users = append(users, user)
-	}
-
-	if err := rows.Err(); err != nil {
-		log.Printf("Error %s when closing rows", err)
-		return nil, err
-	}
-
-	return users, nil
-}
-
 func (r *Repository) CreateUser(user models.User) (string, error) {
+	// Generated here rather than by the database, not every database can
+	userID := uuid.New()
+	userType := RoleUser
+	if user.UserType != nil {
+		userType = *user.UserType
+	}
 
-	query := `INSERT INTO users (first_name, last_name, user_type, email, phone) VALUES ( ?, ?, ?, ?, ?)`
+	query := `INSERT INTO users (user_id, first_name, last_name, user_type, email, phone, password) VALUES (?, ?, ?, ?, ?, ?, ?)`
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err := r.DB.ExecContext(ctx, query, user.FirstName, user.LastName, user.UserType, user.Email, user.Phone)
+	_, err := r.DB.ExecContext(ctx, query, userID[:], user.FirstName, user.LastName, userType, user.Email, user.Phone, user.Password)
 	if err != nil {
 		log.Printf("Error %s when inserting user", err)
+		if r.DB.Dialect.isDuplicate(err) {
+			return "", ErrDuplicateEmail
+		}
 		return "", err
 	}
 
-	getQuery := `SELECT user_id FROM users WHERE email = ?`
-	row := r.DB.QueryRowContext(ctx, getQuery, user.Email)
+	return userID.String(), nil
+}
 
-	var rawUserID []byte
-	err = row.Scan(&rawUserID)
+func (r *Repository) UpdatePassword(userId string, passwordHash string) error {
+	idBytes, err := uuid.Parse(userId)
 	if err != nil {
-		log.Printf("Error %s when fetching user_id", err)
-		return "", err
+		log.Printf("Error %s when parsing user_id", err)
+		return err
 	}
 
-	userID, err := uuid.FromBytes(rawUserID)
+	query := `UPDATE users SET password = ?, updated_on = ? WHERE user_id = ?`
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	res, err := r.DB.ExecContext(ctx, query, passwordHash, time.Now().UTC(), idBytes[:])
 	if err != nil {
-		log.Printf("Error %s when converting user_id to UUID", err)
-		return "", err
+		log.Printf("Error %s when updating password", err)
+		return err
 	}
-
-	// Return the fetched user_id
-	return userID.String(), nil
+	return expectRow(res)
 }
 
-func (r *Repository) UpdateUserToken(token string, refreshToken string, userId string) error {
+func (r *Repository) MarkEmailVerified(userId string) error {
 	idBytes, err := uuid.Parse(userId)
 	if err != nil {
 		log.Printf("Error %s when parsing user_id", err)
 		return err
 	}
 
-	query := `UPDATE users SET token = ?, refresh_token = ? WHERE user_id = ?`
+	query := `UPDATE users SET email_verified_at = ? WHERE user_id = ? AND email_verified_at IS NULL`
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	_, err = r.DB.ExecContext(ctx, query, token, refreshToken, idBytes[:])
+	res, err := r.DB.ExecContext(ctx, query, time.Now().UTC(), idBytes[:])
 	if err != nil {
-		log.Printf("Error %s when updating user token", err)
+		log.Printf("Error %s when marking email verified", err)
+		return err
+	}
+	if rows, err := res.RowsAffected(); err != nil || rows == 1 {
+		return err
+	}
+
+	// Nothing changed, either because the user was verified already or
+	// because there is no such user
+	var exists int
+	if err := r.DB.QueryRowContext(ctx, `SELECT COUNT(*) FROM users WHERE user_id = ?`, idBytes[:]).Scan(&exists); err != nil {
+		log.Printf("Error %s when getting user", err)
 		return err
 	}
+	if exists == 0 {
+		return sql.ErrNoRows
+	}
 	return nil
 }
 
 func (r *Repository) GetUserByEmail(email string) (models.User, error) {
-	query := `SELECT user_id, username, first_name, last_name, user_type, email, phone, password, created_on, updated_on FROM users WHERE email = ?`
+	// MySQL and SQLite compare emails case-insensitively through the column
+	// collation, Postgres through the unique index on lower(email)
+	query := `SELECT ` + userColumns + ` FROM users WHERE email = ?`
+	if r.DB.Dialect == Postgres {
+		query = `SELECT ` + userColumns + ` FROM users WHERE lower(email) = lower(?)`
+	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	row := r.DB.QueryRowContext(ctx, query, email)
-
-	var user models.User
-	err := row.Scan(&user.UserId, &user.FirstName, &user.LastName, &user.UserType, &user.Email, &user.Phone, &user.Password)
+	user, err := scanUser(r.DB.QueryRowContext(ctx, query, email))
 	if err != nil {
 		log.Printf("Error %s when getting user", err)
 		return user, err
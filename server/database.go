@@ -0,0 +1,60 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"github.com/MoulieshN/Go-JWT-Project.git/repository"
+)
+
+// OpenDatabase connects to the database selected by DB_DRIVER and checks that
+// it is reachable.
+func OpenDatabase(config config.ApplicationConfig) (*repository.DB, error) {
+	dialect, err := repository.ParseDialect(config.DBDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	db, err := repository.Open(dialect, dataSourceName(dialect, config))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.PingContext(dbCtx); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func dataSourceName(dialect repository.Dialect, config config.ApplicationConfig) string {
+	switch dialect {
+	case repository.Postgres:
+		dsn := url.URL{
+			Scheme:   "postgres",
+			User:     url.UserPassword(config.Postgres.Username, config.Postgres.Password),
+			Host:     fmt.Sprintf("%v:%v", config.Postgres.Hostname, config.Postgres.Port),
+			Path:     config.Postgres.DBName,
+			RawQuery: url.Values{"sslmode": {config.Postgres.SSLMode}}.Encode(),
+		}
+		return dsn.String()
+	case repository.SQLite:
+		// Foreign keys are off by default in SQLite
+		return fmt.Sprintf("file:%v?_foreign_keys=on&_busy_timeout=5000", config.SQLite.Path)
+	default:
+		return fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?parseTime=%v",
+			config.MySQL.Username,
+			config.MySQL.Password,
+			config.MySQL.Hostname,
+			config.MySQL.Port,
+			config.MySQL.DBName,
+			config.MySQL.ParseTime,
+		)
+	}
+}
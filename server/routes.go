@@ -2,29 +2,169 @@ package server
 
 import (
 	"context"
+	"log"
 
+	"github.com/MoulieshN/Go-JWT-Project.git/config"
 	controllers "github.com/MoulieshN/Go-JWT-Project.git/controllers"
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/lockout"
+	"github.com/MoulieshN/Go-JWT-Project.git/middleware"
+	"github.com/MoulieshN/Go-JWT-Project.git/notifier"
+	"github.com/MoulieshN/Go-JWT-Project.git/passwordpolicy"
+	"github.com/MoulieshN/Go-JWT-Project.git/ratelimit"
 	"github.com/MoulieshN/Go-JWT-Project.git/repository"
 	"github.com/gin-gonic/gin"
 )
 
-func NewRoutes(c context.Context, repo repository.UserRepository) *gin.Engine {
+func NewRoutes(c context.Context, config config.ApplicationConfig, stores repository.Stores, notify notifier.Notifier, limiter ratelimit.Store, passwordPolicy passwordpolicy.Policy) *gin.Engine {
+	repo, roles, orgs, revocations := stores.Users, stores.Roles, stores.Organizations, stores.Revocations
+
+	// Rate limits, each with its own buckets in the limiter
+	limits := config.RateLimits
+	signupLimit := middleware.RateLimit(limiter, "signup-ip", limits.SignupIP, middleware.KeyByIP)
+	loginIPLimit := middleware.RateLimit(limiter, "login-ip", limits.LoginIP, middleware.KeyByIP)
+	loginEmailLimit := middleware.RateLimit(limiter, "login-email", limits.LoginEmail, middleware.KeyByEmail)
+	// Form sign-ins share the email buckets of the JSON login
+	formLoginEmailLimit := middleware.RateLimit(limiter, "login-email", limits.LoginEmail, middleware.KeyByFormEmail)
+	notifyIPLimit := middleware.RateLimit(limiter, "notify-ip", limits.NotifyIP, middleware.KeyByIP)
+	notifyEmailLimit := middleware.RateLimit(limiter, "notify-email", limits.NotifyEmail, middleware.KeyByEmail)
+	tokenLimit := middleware.RateLimit(limiter, "token-ip", limits.TokenIP, middleware.KeyByIP)
+	apiLimit := middleware.RateLimit(limiter, "api-user", limits.APIUser, middleware.KeyByUser)
+
+	// Proofs of possession for DPoP-bound tokens, checked wherever tokens
+	// are presented or issued
+	dpop := helpers.NewDPoPVerifier(stores.DPoPProofs, config.PublicURL)
+
 	router := gin.New()
-	router.Use(gin.Logger())
+	router.Use(gin.Logger(), gin.Recovery())
+
+	// X-Forwarded-For is only believed from the configured proxies, anyone
+	// could send it otherwise and pick the IP their limits are counted for
+	if err := router.SetTrustedProxies(config.TrustedProxies); err != nil {
+		log.Fatalf("TRUSTED_PROXIES: %s", err)
+	}
 
 	// User-related routes
 	authorized := router.Group("/api/v1/auth")
-	UserController := controllers.NewUserController(repo)
+	EmailVerificationController := controllers.NewEmailVerificationController(repo, stores.Verifications, notify, config.EmailVerificationTTL, config.PublicURL)
+	LoginGuard := controllers.NewLoginGuard(stores.LoginAttempts, lockoutPolicy(config.AccountLockout), lockoutPolicy(config.IPLockout))
+	PasswordRules := controllers.NewPasswordRules(passwordPolicy, stores.PasswordHistory)
+	UserController := controllers.NewUserController(repo, roles, orgs, revocations, stores.RefreshTokens, dpop, stores.MFA, EmailVerificationController, LoginGuard, PasswordRules, controllers.LoginSettings{
+		UnverifiedLogin: config.UnverifiedLogin,
+		MFAPendingTTL:   config.MFAPendingTTL,
+	})
 
-	authorized.POST("user/signup", UserController.SignUp())
-	authorized.POST("user/login", UserController.Login())
+	authorized.POST("user/signup", signupLimit, UserController.SignUp())
+	authorized.POST("user/login", loginIPLimit, loginEmailLimit, UserController.Login())
+	authorized.POST("token/refresh", tokenLimit, UserController.RefreshToken())
+	authorized.POST("logout", middleware.Authenticate(revocations, dpop), UserController.Logout())
+	authorized.POST("logout-all", middleware.Authenticate(revocations, dpop), UserController.LogoutAll())
+	authorized.POST("org/switch", middleware.Authenticate(revocations, dpop), UserController.SwitchOrganization())
 
-	// Add authentication middleware only to internal routes
+	PasswordController := controllers.NewPasswordController(repo, stores.PasswordResets, revocations, stores.RefreshTokens, notify, PasswordRules, config.PasswordResetTTL)
+	authorized.POST("password/forgot", notifyIPLimit, notifyEmailLimit, PasswordController.ForgotPassword())
+	authorized.POST("password/reset", tokenLimit, PasswordController.ResetPassword())
+	authorized.POST("password/change", middleware.Authenticate(revocations, dpop), apiLimit, PasswordController.ChangePassword())
+	authorized.GET("verify-email", tokenLimit, EmailVerificationController.VerifyEmail())
+	authorized.POST("verify-email/resend", notifyIPLimit, notifyEmailLimit, EmailVerificationController.ResendVerification())
+
+	MFAController := controllers.NewMFAController(repo, stores.MFA, LoginGuard, config.MFAIssuer)
+	authorized.POST("mfa/verify", loginIPLimit, UserController.VerifyMFA())
+	authorized.POST("mfa/enroll", middleware.Authenticate(revocations, dpop), middleware.RejectRestricted(), MFAController.Enroll())
+	authorized.POST("mfa/confirm", middleware.Authenticate(revocations, dpop), middleware.RejectRestricted(), MFAController.Confirm())
+	authorized.POST("mfa/disable", middleware.Authenticate(revocations, dpop), middleware.RejectRestricted(), MFAController.Disable())
+
+	// Add authentication middleware only to internal routes. Restricted
+	// tokens only work on the auth routes above. Personal access tokens work
+	// on the internal routes, except for managing them.
+	PersonalAccessTokenController := controllers.NewPersonalAccessTokenController(repo, stores.PersonalAccessTokens, roles, orgs, revocations)
+	authenticate := middleware.AuthenticateWithPersonalTokens(revocations, dpop, PersonalAccessTokenController)
 
 	internal := router.Group("/api/v1/users")
-	// router.Use(middleware.Authenticate())
-	internal.GET("", UserController.GetUsers())
-	internal.GET("/:id", UserController.GetUser())
+	internal.Use(authenticate, apiLimit, middleware.RejectRestricted())
+	internal.GET("", middleware.RequirePermission(repository.PermissionUsersRead), UserController.GetUsers())
+	internal.GET("/:id", middleware.RequireSelfOrPermission("id", repository.PermissionUsersRead), UserController.GetUser())
+	internal.POST("/:id/unlock", middleware.RequirePermission(repository.PermissionUsersWrite), UserController.UnlockUser())
+
+	// Role management
+	RoleController := controllers.NewRoleController(roles)
+	internal.GET("/:id/roles", middleware.RequireSelfOrPermission("id", repository.PermissionRolesRead), RoleController.GetUserRoles())
+	internal.POST("/:id/roles", middleware.RequirePermission(repository.PermissionRolesWrite), RoleController.AssignRole())
+	internal.DELETE("/:id/roles/:role", middleware.RequirePermission(repository.PermissionRolesWrite), RoleController.UnassignRole())
+
+	api := router.Group("/api/v1")
+	api.Use(authenticate, apiLimit, middleware.RejectRestricted())
+	api.GET("/roles", middleware.RequirePermission(repository.PermissionRolesRead), RoleController.GetRoles())
+	api.POST("/roles", middleware.RequirePermission(repository.PermissionRolesWrite), RoleController.CreateRole())
+	api.GET("/roles/:name", middleware.RequirePermission(repository.PermissionRolesRead), RoleController.GetRole())
+	api.DELETE("/roles/:name", middleware.RequirePermission(repository.PermissionRolesWrite), RoleController.DeleteRole())
+	api.PUT("/roles/:name/permissions", middleware.RequirePermission(repository.PermissionRolesWrite), RoleController.SetRolePermissions())
+	api.GET("/permissions", middleware.RequirePermission(repository.PermissionRolesRead), RoleController.GetPermissions())
+	api.POST("/permissions", middleware.RequirePermission(repository.PermissionRolesWrite), RoleController.CreatePermission())
+
+	// Organizations. Member management always applies to the organization
+	// the caller's token acts in.
+	OrganizationController := controllers.NewOrganizationController(orgs, roles, repo, revocations, notify, config.InvitationTTL)
+	api.GET("/orgs", OrganizationController.GetOrganizations())
+	api.POST("/orgs", OrganizationController.CreateOrganization())
+	api.GET("/orgs/current", OrganizationController.GetCurrentOrganization())
+	api.POST("/orgs/current/members", middleware.RequirePermission(repository.PermissionUsersWrite), OrganizationController.AddMember())
+	api.DELETE("/orgs/current/members/:id", middleware.RequirePermission(repository.PermissionUsersWrite), OrganizationController.RemoveMember())
+	api.DELETE("/orgs/current/invitations/:id", middleware.RequirePermission(repository.PermissionUsersWrite), OrganizationController.WithdrawInvitation())
+
+	// Invitations to organizations, which users decide on themselves
+	api.GET("/invitations", OrganizationController.GetInvitations())
+	api.POST("/invitations/:org_id/accept", OrganizationController.AcceptInvitation())
+	api.DELETE("/invitations/:org_id", OrganizationController.DeclineInvitation())
+
+	// Service accounts, which belong to the organization the caller's token
+	// acts in
+	ServiceAccountController := controllers.NewServiceAccountController(stores.ServiceAccounts, revocations)
+	api.GET("/service-accounts", middleware.RequirePermission(repository.PermissionClientsRead), ServiceAccountController.GetServiceAccounts())
+	api.POST("/service-accounts", middleware.RequirePermission(repository.PermissionClientsWrite), ServiceAccountController.CreateServiceAccount())
+	api.DELETE("/service-accounts/:id", middleware.RequirePermission(repository.PermissionClientsWrite), ServiceAccountController.DeleteServiceAccount())
+
+	// OAuth clients sign in users of every organization, so only admins
+	// hold these permissions
+	OAuthClientController := controllers.NewOAuthClientController(stores.OAuthClients)
+	api.GET("/oauth-clients", middleware.RequirePermission(repository.PermissionOAuthClientsRead), OAuthClientController.GetClients())
+	api.POST("/oauth-clients", middleware.RequirePermission(repository.PermissionOAuthClientsWrite), OAuthClientController.CreateClient())
+	api.DELETE("/oauth-clients/:id", middleware.RequirePermission(repository.PermissionOAuthClientsWrite), OAuthClientController.DeleteClient())
+
+	// A leaked personal access token can't be used to mint more
+	tokens := router.Group("/api/v1/tokens")
+	tokens.Use(middleware.Authenticate(revocations, dpop), apiLimit, middleware.RejectRestricted())
+	tokens.GET("", PersonalAccessTokenController.GetTokens())
+	tokens.POST("", PersonalAccessTokenController.CreateToken())
+	tokens.DELETE("/:id", PersonalAccessTokenController.RevokeToken())
+
+	// OAuth 2.0. Users sign in to OAuth clients on the authorization
+	// endpoint's own page, or on the device verification page for clients
+	// on devices without a browser. Looking up user codes is rate limited,
+	// so they can't be guessed.
+	OAuthController := controllers.NewOAuthController(stores.ServiceAccounts, stores.OAuthClients, stores.AuthorizationCodes, stores.DeviceCodes, PersonalAccessTokenController, &UserController, controllers.OAuthSettings{
+		ClientTokenTTL:       config.ClientTokenTTL,
+		AuthorizationCodeTTL: config.AuthorizationCodeTTL,
+		DeviceCodeTTL:        config.DeviceCodeTTL,
+		DevicePollInterval:   config.DevicePollInterval,
+		VerificationURI:      config.PublicURL + "/oauth/device",
+	})
+	oauth := router.Group("/oauth")
+	oauth.GET("/authorize", OAuthController.Authorize())
+	oauth.POST("/authorize", loginIPLimit, formLoginEmailLimit, OAuthController.AuthorizeLogin())
+	oauth.POST("/token", tokenLimit, OAuthController.Token())
+	oauth.POST("/device_authorization", tokenLimit, OAuthController.DeviceAuthorization())
+	oauth.GET("/device", tokenLimit, OAuthController.Device())
+	oauth.POST("/device", loginIPLimit, formLoginEmailLimit, OAuthController.DeviceLogin())
+	oauth.POST("/introspect", tokenLimit, OAuthController.Introspect())
+	oauth.POST("/revoke", tokenLimit, OAuthController.Revoke())
+
+	// OpenID Connect, on top of the OAuth 2.0 endpoints
+	OpenIDController := controllers.NewOpenIDController(repo, config.JWT.Issuer, config.TLS.ClientCAFile != "")
+	router.GET("/.well-known/openid-configuration", OpenIDController.Discovery())
+	router.GET("/userinfo", middleware.Authenticate(revocations, dpop), apiLimit, OpenIDController.UserInfo())
+
+	router.GET("/.well-known/jwks.json", controllers.JWKS())
 
 	router.GET("/", func(c *gin.Context) {
 		c.JSON(200, gin.H{
@@ -34,3 +174,12 @@ func NewRoutes(c context.Context, repo repository.UserRepository) *gin.Engine {
 
 	return router
 }
+
+func lockoutPolicy(cfg *config.LockoutConfig) lockout.Policy {
+	return lockout.Policy{
+		BackoffAfter:    cfg.BackoffAfter,
+		BaseDelay:       cfg.BaseDelay,
+		LockoutAfter:    cfg.LockoutAfter,
+		LockoutDuration: cfg.LockoutDuration,
+	}
+}
@@ -2,55 +2,174 @@ package server
 
 import (
 	"context"
-	"database/sql"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"log"
-	"time"
+	"net/http"
+	"os"
 
 	"github.com/MoulieshN/Go-JWT-Project.git/config"
+	"github.com/MoulieshN/Go-JWT-Project.git/controllers"
+	"github.com/MoulieshN/Go-JWT-Project.git/helpers"
+	"github.com/MoulieshN/Go-JWT-Project.git/migrations"
+	"github.com/MoulieshN/Go-JWT-Project.git/notifier"
+	"github.com/MoulieshN/Go-JWT-Project.git/passwordpolicy"
+	"github.com/MoulieshN/Go-JWT-Project.git/ratelimit"
 	"github.com/MoulieshN/Go-JWT-Project.git/repository"
-	_ "github.com/go-sql-driver/mysql"
 )
 
 func Init(logCtx context.Context, port string) {
 	config := config.GetConfig()
 
-	dbCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	db, err := sql.Open(
-		"mysql",
-		fmt.Sprintf("%v:%v@tcp(%v:%v)/%v?parseTime=%v",
-			config.MySQL.Username,
-			config.MySQL.Password,
-			config.MySQL.Hostname,
-			config.MySQL.Port,
-			config.MySQL.DBName,
-			config.MySQL.ParseTime,
-		),
-	)
+	if err := helpers.InitSigning(config.JWT); err != nil {
+		log.Fatal(err)
+		return
+	}
+
+	if err := helpers.InitPasswordHashing(config.Password); err != nil {
+		log.Fatal(err)
+		return
+	}
 
+	tlsConfig, err := newTLSConfig(config.TLS)
 	if err != nil {
 		log.Fatal(err)
 		return
 	}
 
-	if err := db.PingContext(dbCtx); err != nil {
+	db, err := OpenDatabase(config)
+	if err != nil {
 		log.Fatal(err)
 		return
 	}
 
 	defer db.Close()
 
-	repo := repository.NewRepository(db)
+	// Every replica may run this at boot, the migrator serializes them
+	if config.MigrateOnStart {
+		migrator, err := migrations.New(db)
+		if err != nil {
+			log.Fatal(err)
+			return
+		}
+		if err := migrator.Up(context.Background()); err != nil {
+			log.Fatal(err)
+			return
+		}
+	}
+
+	stores := repository.NewStores(db)
+
+	switch config.RevocationStore {
+	case "memory":
+		stores.Revocations = repository.NewMemoryRevocationStore()
+	case "database", "mysql":
+	default:
+		log.Fatalf("unknown revocation store %q", config.RevocationStore)
+	}
+
+	switch config.LoginAttemptStore {
+	case "memory":
+		stores.LoginAttempts = repository.NewMemoryLoginAttemptStore()
+	case "database":
+	default:
+		log.Fatalf("unknown login attempt store %q", config.LoginAttemptStore)
+	}
+
+	switch config.DPoPProofStore {
+	case "memory":
+		stores.DPoPProofs = repository.NewMemoryDPoPProofStore()
+	case "database":
+	default:
+		log.Fatalf("unknown DPoP proof store %q", config.DPoPProofStore)
+	}
+
+	switch config.UnverifiedLogin {
+	case controllers.UnverifiedLoginAllow, controllers.UnverifiedLoginDeny, controllers.UnverifiedLoginRestrict:
+	default:
+		log.Fatalf("unknown UNVERIFIED_LOGIN %q", config.UnverifiedLogin)
+	}
+
+	notify, err := notifier.New(config.Notifier.Kind, config.Notifier.File)
+	if err != nil {
+		log.Fatal(err)
+		return
+	}
 
-	// Creating a table
-	// But it should be handled properly using goose-migrator or gorm
-	err = repo.CreateTable()
+	passwordPolicy, err := newPasswordPolicy(config.PasswordPolicy)
 	if err != nil {
-		panic(err)
+		log.Fatal(err)
+		return
+	}
+
+	// Limits are per replica, a shared ratelimit.Store would make them global
+	r := NewRoutes(logCtx, config, stores, notify, ratelimit.NewMemoryStore(), passwordPolicy)
+
+	if tlsConfig == nil {
+		r.Run(":" + port)
+		return
+	}
+	server := &http.Server{Addr: ":" + port, Handler: r, TLSConfig: tlsConfig}
+	log.Fatal(server.ListenAndServeTLS(config.TLS.CertFile, config.TLS.KeyFile))
+}
+
+// newTLSConfig returns the TLS settings to serve HTTPS with, or nil for
+// plain HTTP. With client CAs, clients are asked for a certificate signed by
+// one of them. Those without one are still served, they have passwords and
+// secrets to authenticate with.
+func newTLSConfig(cfg *config.TLSConfig) (*tls.Config, error) {
+	if cfg.CertFile == "" && cfg.KeyFile == "" {
+		if cfg.ClientCAFile != "" {
+			return nil, errors.New("TLS_CLIENT_CA_FILE needs TLS_CERT_FILE and TLS_KEY_FILE")
+		}
+		return nil, nil
+	}
+	if cfg.CertFile == "" || cfg.KeyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
 	}
 
-	r := NewRoutes(logCtx, repo)
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if cfg.ClientCAFile == "" {
+		return tlsConfig, nil
+	}
+	pem, err := os.ReadFile(cfg.ClientCAFile)
+	if err != nil {
+		return nil, fmt.Errorf("TLS_CLIENT_CA_FILE: %w", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("TLS_CLIENT_CA_FILE: no certificates in %s", cfg.ClientCAFile)
+	}
+	tlsConfig.ClientCAs = clientCAs
+	tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	return tlsConfig, nil
+}
 
-	r.Run(":" + port)
+func newPasswordPolicy(cfg *config.PasswordPolicyConfig) (passwordpolicy.Policy, error) {
+	policy := passwordpolicy.Policy{
+		MinLength:          cfg.MinLength,
+		RequireUpper:       cfg.RequireUpper,
+		RequireLower:       cfg.RequireLower,
+		RequireDigit:       cfg.RequireDigit,
+		RequireSymbol:      cfg.RequireSymbol,
+		RejectPersonalInfo: cfg.RejectPersonalInfo,
+		HistoryDepth:       cfg.HistoryDepth,
+	}
+	switch {
+	case cfg.BreachedDir != "":
+		list, err := passwordpolicy.OpenBreachedDir(cfg.BreachedDir)
+		if err != nil {
+			return passwordpolicy.Policy{}, err
+		}
+		policy.Breached = list
+	case cfg.BreachedFile != "":
+		list, err := passwordpolicy.LoadBreachedList(cfg.BreachedFile)
+		if err != nil {
+			return passwordpolicy.Policy{}, err
+		}
+		policy.Breached = list
+	}
+	return policy, nil
 }
@@ -0,0 +1,79 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 seed of RFC 6238 Appendix B, "12345678901234567890"
+// in ASCII, base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists eight digit codes, six digit ones are their last six
+	for _, tt := range []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	} {
+		code, err := Code(rfcSecret, Step(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("Code at %d: %v", tt.unix, err)
+		}
+		if code != tt.want {
+			t.Errorf("Code at %d = %s, want %s", tt.unix, code, tt.want)
+		}
+	}
+}
+
+func TestCodeLowerCaseSecret(t *testing.T) {
+	code, err := Code("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", Step(time.Unix(59, 0)))
+	if err != nil || code != "287082" {
+		t.Errorf("Code with a lower case secret = (%s, %v), want 287082", code, err)
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	if _, err := Code("not base32!", 1); err == nil {
+		t.Error("Code with an invalid secret succeeded")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Step(now)
+
+	for _, tt := range []struct {
+		name string
+		step int64
+		ok   bool
+	}{
+		{"the current step", current, true},
+		{"the step before", current - 1, true},
+		{"the step after", current + 1, true},
+		{"two steps before", current - 2, false},
+		{"two steps after", current + 2, false},
+	} {
+		code, err := Code(rfcSecret, tt.step)
+		if err != nil {
+			t.Fatal(err)
+		}
+		step, ok := Validate(rfcSecret, code, now)
+		if ok != tt.ok || (ok && step != tt.step) {
+			t.Errorf("Validate of the code of %s = (%d, %v), want (%d, %v)", tt.name, step, ok, tt.step, tt.ok)
+		}
+	}
+
+	if _, ok := Validate(rfcSecret, "50471", now); ok {
+		t.Error("Validate accepted a code with too few digits")
+	}
+	if _, ok := Validate(rfcSecret, " 050471 ", now); !ok {
+		t.Error("Validate should ignore surrounding spaces")
+	}
+}